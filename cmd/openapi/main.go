@@ -0,0 +1,48 @@
+// Command openapi keeps api/openapi.json in sync with the REST handlers.
+// By default it exits non-zero if the committed document differs from the
+// one generated from the handler types, so CI fails when the API contract
+// changes without the document being updated. With -w it rewrites the file.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dirshaye/GoMetrics/internal/rest"
+)
+
+func main() {
+	path := flag.String("file", "api/openapi.json", "path of the committed OpenAPI document")
+	write := flag.Bool("w", false, "write the generated document instead of checking it")
+	flag.Parse()
+
+	generated, err := json.MarshalIndent(rest.OpenAPI(), "", "  ")
+	if err != nil {
+		fail("Failed to encode OpenAPI document: %v", err)
+	}
+	generated = append(generated, '\n')
+
+	if *write {
+		if err := os.WriteFile(*path, generated, 0o644); err != nil {
+			fail("Failed to write OpenAPI document: %v", err)
+		}
+		return
+	}
+
+	committed, err := os.ReadFile(*path)
+	if err != nil {
+		fail("Failed to read OpenAPI document: %v", err)
+	}
+	if !bytes.Equal(committed, generated) {
+		fail("%s is out of date with the REST handlers; review the API change and run: go run ./cmd/openapi -w", *path)
+	}
+}
+
+// fail prints an error and exits with status 1
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
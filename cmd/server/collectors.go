@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/config"
+)
+
+// collector is implemented by every collector in the collect package
+type collector interface {
+	Start(ctx context.Context)
+}
+
+// collectorStopTimeout bounds how long a reload or shutdown waits for a
+// collector to return, e.g. one stuck in a syscall on a dead NFS mount
+const collectorStopTimeout = 5 * time.Second
+
+// runningCollector tracks a started collector and the config it was built from
+type runningCollector struct {
+	cfg    any
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// collectorSpec is the desired state of one collector
+type collectorSpec struct {
+	name    string // Also the collector's metric type
+	cfg     any
+	enabled bool
+	create  func() collector
+}
+
+// collectorManager starts collectors and, on reload, restarts only the
+// ones whose configuration changed
+type collectorManager struct {
+	ctx    context.Context
+	output chan<- collect.Metric
+
+	applyMu sync.Mutex // Serializes apply and stop
+
+	mu      sync.Mutex // Guards running; never held while waiting for a collector
+	running map[string]*runningCollector
+}
+
+// newCollectorManager creates a manager whose collectors send to output
+func newCollectorManager(ctx context.Context, output chan<- collect.Metric) *collectorManager {
+	return &collectorManager{
+		ctx:     ctx,
+		output:  output,
+		running: make(map[string]*runningCollector),
+	}
+}
+
+// apply starts, restarts or stops collectors to match cfg
+func (m *collectorManager) apply(cfg config.CollectorsConfig) {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	specs := []collectorSpec{
+		{"cpu", cfg.CPU, cfg.CPU.Enabled, func() collector {
+			return collect.NewCPUCollector(time.Duration(cfg.CPU.Interval), collect.CPUOptions{
+				PerCore:      cfg.CPU.PerCore,
+				SampleWindow: time.Duration(cfg.CPU.SampleWindow),
+			}, m.output)
+		}},
+		{"memory", cfg.Memory, cfg.Memory.Enabled, func() collector {
+			return collect.NewMemoryCollector(time.Duration(cfg.Memory.Interval), m.output)
+		}},
+		{"disk", cfg.Disk, cfg.Disk.Enabled, func() collector {
+			return collect.NewDiskCollector(time.Duration(cfg.Disk.Interval), collect.DiskOptions{
+				Mountpoints: cfg.Disk.Mountpoints,
+			}, m.output)
+		}},
+		{"network", cfg.Network, cfg.Network.Enabled, func() collector {
+			return collect.NewNetworkCollector(time.Duration(cfg.Network.Interval), collect.NetworkOptions{
+				Interfaces:   cfg.Network.Interfaces,
+				PerInterface: cfg.Network.PerInterface,
+			}, m.output)
+		}},
+	}
+
+	// Stop collectors that are disabled or whose configuration changed
+	var start []collectorSpec
+	stopped := make(map[string]*runningCollector)
+	m.mu.Lock()
+	for _, spec := range specs {
+		if cur, ok := m.running[spec.name]; ok {
+			if spec.enabled && reflect.DeepEqual(cur.cfg, spec.cfg) {
+				continue // Unchanged
+			}
+			cur.cancel()
+			stopped[spec.name] = cur
+			delete(m.running, spec.name)
+		}
+		if spec.enabled {
+			start = append(start, spec)
+		}
+	}
+	m.mu.Unlock()
+
+	m.wait(stopped)
+
+	for _, spec := range specs {
+		if _, ok := stopped[spec.name]; !ok || spec.enabled {
+			continue
+		}
+		// A metric without data tells the aggregator to drop the section
+		select {
+		case m.output <- collect.Metric{Type: spec.name, Timestamp: time.Now()}:
+		case <-m.ctx.Done():
+		}
+		logger.Info("Stopped collector", "collector", spec.name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, spec := range start {
+		if _, ok := stopped[spec.name]; ok {
+			logger.Info("Restarting collector with new configuration", "collector", spec.name)
+		}
+
+		ctx, cancel := context.WithCancel(m.ctx)
+		done := make(chan struct{})
+		c := spec.create()
+		go func() {
+			defer close(done)
+			c.Start(ctx)
+		}()
+
+		m.running[spec.name] = &runningCollector{cfg: spec.cfg, cancel: cancel, done: done}
+	}
+}
+
+// stop stops every collector and waits for them to return
+func (m *collectorManager) stop() {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	stopped := make(map[string]*runningCollector)
+	m.mu.Lock()
+	for name, cur := range m.running {
+		cur.cancel()
+		stopped[name] = cur
+		delete(m.running, name)
+	}
+	m.mu.Unlock()
+
+	m.wait(stopped)
+}
+
+// wait waits up to collectorStopTimeout for cancelled collectors to return.
+// A collector that doesn't is abandoned; its goroutine exits whenever the
+// call it is blocked in returns.
+func (m *collectorManager) wait(stopped map[string]*runningCollector) {
+	deadline := time.NewTimer(collectorStopTimeout)
+	defer deadline.Stop()
+
+	for name, cur := range stopped {
+		select {
+		case <-cur.done:
+		case <-deadline.C:
+			logger.Warn("Collector did not stop in time; abandoning it",
+				"collector", name, "timeout", collectorStopTimeout)
+			// The deadline has passed for the remaining collectors too
+			deadline.Reset(0)
+		}
+	}
+}
+
+// collectorState describes a collector for the admin endpoints
+type collectorState struct {
+	Name    string                 `json:"name"`
+	Running bool                   `json:"running"`
+	Config  any                    `json:"config,omitempty"`
+	Status  *collect.SectionStatus `json:"status,omitempty"` // From the latest sample
+}
+
+// state returns every collector's configuration and latest status
+func (m *collectorManager) state(latest collect.Sample) []collectorState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var states []collectorState
+	for _, name := range []string{"cpu", "memory", "disk", "network"} {
+		st := collectorState{Name: name}
+		if cur, ok := m.running[name]; ok {
+			st.Running = true
+			st.Config = cur.cfg
+		}
+		if status, ok := latest.Status[name]; ok {
+			st.Status = &status
+		}
+		states = append(states, st)
+	}
+	return states
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/config"
+)
+
+func TestCollectorManagerRestartsOnlyChanged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Collect the metrics the collectors and the manager send
+	output := make(chan collect.Metric, 16)
+	var mu sync.Mutex
+	var dropped []string
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case metric := <-output:
+				if metric.Data == nil && metric.Error == "" {
+					mu.Lock()
+					dropped = append(dropped, metric.Type)
+					mu.Unlock()
+				}
+			}
+		}
+	}()
+
+	cfg := config.Default().Collectors
+	cfg.CPU.Interval = config.Duration(time.Hour)
+	cfg.Memory.Interval = config.Duration(time.Hour)
+	cfg.Disk.Interval = config.Duration(time.Hour)
+	cfg.Network.Interval = config.Duration(time.Hour)
+
+	m := newCollectorManager(ctx, output)
+	m.apply(cfg)
+	running := func() map[string]*runningCollector {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		copied := make(map[string]*runningCollector, len(m.running))
+		for name, rc := range m.running {
+			copied[name] = rc
+		}
+		return copied
+	}
+	before := running()
+	if len(before) != 4 {
+		t.Fatalf("%d collectors running, want 4", len(before))
+	}
+
+	// Unchanged configuration restarts nothing
+	m.apply(cfg)
+	for name, rc := range running() {
+		if rc != before[name] {
+			t.Errorf("%s restarted although its configuration is unchanged", name)
+		}
+	}
+
+	// Change the disk interval and disable the network collector
+	cfg.Disk.Interval = config.Duration(2 * time.Hour)
+	cfg.Network.Enabled = false
+	m.apply(cfg)
+	after := running()
+	for _, name := range []string{"cpu", "memory"} {
+		if after[name] != before[name] {
+			t.Errorf("%s restarted although its configuration is unchanged", name)
+		}
+	}
+	if after["disk"] == nil || after["disk"] == before["disk"] {
+		t.Error("disk not restarted after its interval changed")
+	}
+	if _, ok := after["network"]; ok {
+		t.Error("network still running after being disabled")
+	}
+	select {
+	case <-before["disk"].done:
+	default:
+		t.Error("previous disk collector still running")
+	}
+
+	m.stop()
+	if n := len(running()); n != 0 {
+		t.Errorf("%d collectors running after stop", n)
+	}
+
+	// The drop metric was sent before apply returned
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		got := append([]string(nil), dropped...)
+		mu.Unlock()
+		if len(got) == 1 && got[0] == "network" {
+			break
+		}
+		if len(got) > 1 || time.Now().After(deadline) {
+			t.Fatalf("dropped sections %v, want [network]", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
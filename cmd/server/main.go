@@ -1,139 +1,617 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"log"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/dirshaye/GoMetrics/internal/admin"
 	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/alert"
+	"github.com/dirshaye/GoMetrics/internal/anomaly"
+	"github.com/dirshaye/GoMetrics/internal/auth"
 	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/config"
+	"github.com/dirshaye/GoMetrics/internal/forecast"
+	"github.com/dirshaye/GoMetrics/internal/hub"
+	"github.com/dirshaye/GoMetrics/internal/limit"
+	"github.com/dirshaye/GoMetrics/internal/logging"
+	"github.com/dirshaye/GoMetrics/internal/prom"
+	"github.com/dirshaye/GoMetrics/internal/push"
 	"github.com/dirshaye/GoMetrics/internal/rest"
+	"github.com/dirshaye/GoMetrics/internal/store"
+	"github.com/dirshaye/GoMetrics/internal/tlsconf"
 )
 
+var logger = logging.For("server")
+
 func main() {
-	// Configuration from environment variables
-	port := getEnv("PORT", "8080")
-	collectorInterval := getEnvDuration("COLLECTOR_INTERVAL", 5*time.Second)
-	sampleInterval := getEnvDuration("SAMPLE_INTERVAL", 250*time.Millisecond)
-	bufferSize := getEnvInt("BUFFER_SIZE", 100)
-
-	log.Printf("Starting GoMetrics server...")
-	log.Printf("Port: %s", port)
-	log.Printf("Collector interval: %v", collectorInterval)
-	log.Printf("Sample interval: %v", sampleInterval)
-
-	// Create context for graceful shutdown
+	// Configuration file (optional) with environment variable overrides
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to YAML configuration file")
+	hashPassword := flag.Bool("hash-password", false, "read a password from stdin, print its bcrypt hash and exit")
+	flag.Parse()
+
+	if *hashPassword {
+		printPasswordHash()
+		return
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	if err := logging.Configure(loggingConfig(cfg.Logging)); err != nil {
+		fatal("Failed to configure logging", err)
+	}
+
+	logger.Info("Starting GoMetrics server",
+		"config_file", *configPath,
+		"port", cfg.Server.Port,
+		"sample_interval", cfg.Server.SampleInterval)
+
+	// Create context for graceful shutdown; background goroutines that
+	// stop with it are tracked by wg
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	var wg sync.WaitGroup
 
 	// Create aggregator
-	aggregator := agg.NewAggregator(sampleInterval, bufferSize)
+	aggregator := agg.NewAggregator(time.Duration(cfg.Server.SampleInterval), cfg.Server.BufferSize)
 	metricsChan := aggregator.GetMetricsChan()
 
-	// Create collectors
-	cpuCollector := collect.NewCPUCollector(collectorInterval, metricsChan)
-	memoryCollector := collect.NewMemoryCollector(collectorInterval, metricsChan)
-	diskCollector := collect.NewDiskCollector(collectorInterval, metricsChan)
-	networkCollector := collect.NewNetworkCollector(collectorInterval, metricsChan)
+	// Rollup tiers for long-term history
+	var tiers []agg.RollupTier
+	for _, tier := range cfg.History.RollupTiers {
+		tiers = append(tiers, agg.RollupTier{
+			Resolution: time.Duration(tier.Resolution),
+			Retention:  time.Duration(tier.Retention),
+		})
+	}
+	aggregator.SetRollupTiers(tiers)
+
+	// Sliding-window statistics, optionally exported to Prometheus
+	var windows []time.Duration
+	for _, window := range cfg.History.Stats.Windows {
+		windows = append(windows, time.Duration(window))
+	}
+	aggregator.SetWindows(windows, cfg.History.Stats.Fields)
+	if cfg.History.Stats.Prometheus && aggregator.GetWindows() != nil {
+		prometheus.MustRegister(aggregator.GetWindows())
+	}
+
+	// Prometheus output
+	aggregator.AddSink(prom.NewMetrics(), cfg.Server.SinkQueueSize)
+
+	// Optional on-disk sample store for history across restarts
+	var history rest.HistoryReader
+	var sampleStore *store.Store
+	if storeCfg := cfg.History.Store; storeCfg.Dir != "" {
+		sampleStore, err = store.Open(store.Config{
+			Dir:             storeCfg.Dir,
+			SegmentDuration: time.Duration(storeCfg.SegmentDuration),
+			Retention:       time.Duration(storeCfg.Retention),
+			MaxBytes:        int64(storeCfg.MaxMB) << 20,
+		})
+		if err != nil {
+			fatal("Failed to open sample store", err)
+		}
+		aggregator.AddSink(sampleStore, cfg.Server.SinkQueueSize)
+		history = sampleStore
+
+		// Rebuild the rollups from the stored samples in the background, so
+		// forecasts and rollup history don't start empty after a restart
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seedRollups(ctx, aggregator.GetRollups(), sampleStore)
+		}()
+	}
+
+	// Optional outputs (StatsD, InfluxDB)
+	sinks := newSinkManager(aggregator, cfg.Server.SinkQueueSize)
+	if err := sinks.apply(cfg.Sinks); err != nil {
+		fatal("Failed to start sinks", err)
+	}
 
 	// Start aggregator
-	go aggregator.Start(ctx)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		aggregator.Start(ctx)
+	}()
 
 	// Start all collectors
-	go cpuCollector.Start(ctx)
-	go memoryCollector.Start(ctx)
-	go diskCollector.Start(ctx)
-	go networkCollector.Start(ctx)
+	collectors := newCollectorManager(ctx, metricsChan)
+	collectors.apply(cfg.Collectors)
 
 	// Create REST handlers
-	handlers := rest.NewHandlers(aggregator)
+	handlers := rest.NewHandlers(aggregator, history)
+
+	// Optional anomaly detection on the local samples
+	if anomalyCfg := cfg.Analysis.Anomaly; anomalyCfg.Enabled {
+		detector := anomaly.NewDetector(anomaly.Options{
+			Fields:    anomalyCfg.Fields,
+			HalfLife:  time.Duration(anomalyCfg.HalfLife),
+			Threshold: anomalyCfg.Threshold,
+			Warmup:    time.Duration(anomalyCfg.Warmup),
+			Seasonal:  anomalyCfg.Seasonal,
+			MaxEvents: anomalyCfg.MaxEvents,
+			Expiry:    time.Duration(anomalyCfg.Expiry),
+		})
+		aggregator.AddSink(detector, cfg.Server.SinkQueueSize)
+		handlers.SetAnomalyDetector(detector)
+	}
+
+	// Optional time-until-full forecasts from the rollups
+	if forecastCfg := cfg.Analysis.Forecast; forecastCfg.Enabled {
+		forecaster := forecast.New(aggregator.GetRollups(), forecast.Options{
+			Fields:     forecastCfg.Fields,
+			Method:     forecastCfg.Method,
+			Lookback:   time.Duration(forecastCfg.Lookback),
+			MinHistory: time.Duration(forecastCfg.MinHistory),
+			Interval:   time.Duration(forecastCfg.Interval),
+		})
+		prometheus.MustRegister(forecaster)
+		handlers.SetForecaster(forecaster)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forecaster.Run(ctx)
+		}()
+	}
+
+	// Alert rules, evaluated against the same samples as /api/v1/query
+	alerts := alert.New(handlers.QuerySource(), time.Duration(cfg.Alerts.Interval))
+	if err := alerts.SetRules(alertRules(cfg.Alerts)); err != nil {
+		fatal("Failed to load alert rules", err)
+	}
+	prometheus.MustRegister(alerts)
+	handlers.SetAlertEvaluator(alerts)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		alerts.Run(ctx)
+	}()
+
+	// Hub mode: keep samples pushed by or pulled from other agents
+	var federation *hub.Hub
+	if hubCfg := cfg.Hub; hubCfg.Enabled {
+		federation = hub.New(hub.Options{
+			DownAfter:   time.Duration(hubCfg.DownAfter),
+			HistorySize: hubCfg.HistorySize,
+			MaxHosts:    hubCfg.MaxHosts,
+		})
+		handlers.SetHub(federation)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			federation.Run(ctx)
+		}()
+
+		if len(hubCfg.Targets) > 0 {
+			targets := make([]hub.Target, len(hubCfg.Targets))
+			for i, t := range hubCfg.Targets {
+				targets[i] = hub.Target{ID: t.ID, URL: t.URL, Token: t.Token, Labels: t.Labels}
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				federation.Pull(ctx, targets, time.Duration(hubCfg.PullInterval))
+			}()
+		}
+		logger.Info("Hub mode enabled", "targets", len(hubCfg.Targets), "down_after", hubCfg.DownAfter.String())
+	}
+
+	// Authentication for everything except health probes
+	authn, err := auth.New(authConfig(cfg.Auth))
+	if err != nil {
+		fatal("Failed to set up authentication", err)
+	}
+	if !authn.Enabled() {
+		logger.Warn("No auth credentials configured; the API is open to anyone")
+	}
 
 	// Create HTTP router using chi
 	r := chi.NewRouter()
 
 	// Add middleware (functions that run before your handlers)
-	r.Use(middleware.Logger)    // Logs every HTTP request
-	r.Use(middleware.Recoverer) // Recovers from panics and returns 500
+	r.Use(middleware.RequestID)                       // Tags each request with an ID for the logs
+	r.Use(logging.RequestLogger(logging.For("http"))) // Logs every HTTP request
+	r.Use(middleware.Recoverer)                       // Recovers from panics and returns 500
 
 	// Health endpoints (required for Kubernetes)
 	r.Get("/healthz", handlers.HealthzHandler) // Liveness probe
 	r.Get("/readyz", handlers.ReadyzHandler)   // Readiness probe
 
-	// Metrics endpoints
-	r.Get("/metrics/latest", handlers.MetricsLatestHandler) // JSON metrics
-	r.Handle("/metrics", handlers.PrometheusHandler())      // Prometheus metrics
+	// Metrics endpoints, rate limited per client before authentication so
+	// password guessing is limited too
+	r.Group(func(r chi.Router) {
+		if limits := cfg.Server.Limits; limits.Rate > 0 {
+			r.Use(limit.NewRateLimiter(limits.Rate, limits.Burst).Middleware)
+		}
+		historyLimit := limit.Concurrency(cfg.Server.Limits.HistoryConcurrency)
+		streamLimit := limit.Concurrency(cfg.Server.Limits.MaxStreams)
+
+		read := authn.Require(auth.ScopeRead)
 
-	addr := ":" + port
+		// Versioned API: JSON and MessagePack responses are wrapped in an
+		// envelope, see api/openapi.json
+		handlers.RouteV1(r, rest.V1Middleware{
+			Read:         read,
+			Push:         authn.Require(auth.ScopePush),
+			HistoryLimit: historyLimit,
+			StreamLimit:  streamLimit,
+		}, cfg.Hub.Enabled)
+
+		// Unversioned aliases of the API, kept for existing clients
+		r.With(rest.Deprecated("/api/v1/metrics/latest"), read).Get("/metrics/latest", handlers.MetricsLatestHandler)
+		r.With(rest.Deprecated("/api/v1/metrics/history"), read, historyLimit).Get("/metrics/history", handlers.MetricsHistoryHandler)
+		r.With(rest.Deprecated("/api/v1/metrics/stream"), read, streamLimit).Get("/metrics/stream", handlers.MetricsStreamHandler)
+		r.With(rest.Deprecated("/api/v1/schema/gometrics.proto")).Get("/schema/gometrics.proto", handlers.ProtoSchemaHandler)
+
+		r.With(authn.Require(auth.ScopeScrape)).Handle("/metrics", handlers.PrometheusHandler()) // Prometheus metrics
+	})
+
+	addr := ":" + strconv.Itoa(cfg.Server.Port)
 
 	// Create HTTP server
-	server := &http.Server{
-		Addr:    addr,
-		Handler: r,
+	server := newServer(addr, r, cfg.Server)
+	server.RegisterOnShutdown(handlers.CloseStreams) // Shutdown doesn't end streams by itself
+
+	// Serve HTTPS when a certificate is configured; rotated files are reloaded
+	var certs *tlsconf.Reloader
+	if tlsCfg := cfg.Server.TLS; tlsCfg.CertFile != "" {
+		certs, err = tlsconf.New(tlsconf.Config{
+			CertFile:     tlsCfg.CertFile,
+			KeyFile:      tlsCfg.KeyFile,
+			ClientCAFile: tlsCfg.ClientCAFile,
+			ClientAuth:   tlsCfg.ClientAuth,
+			MinVersion:   tlsCfg.MinVersion,
+			CipherSuites: tlsCfg.CipherSuites,
+		})
+		if err != nil {
+			fatal("Failed to load TLS certificates", err)
+		}
+		server.TLSConfig = certs.TLSConfig()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs.Watch(ctx, time.Duration(tlsCfg.ReloadInterval))
+		}()
+	}
+
+	// Optional listener for agents pushing samples to the hub, with the
+	// same TLS settings as the API
+	var pushServer *push.Server
+	if cfg.Hub.Enabled && cfg.Hub.Listen != "" {
+		pushServer = push.NewServer(federation, func(authorization string, state *tls.ConnectionState) error {
+			_, err := authn.Authorize(authorization, state, auth.ScopePush)
+			return err
+		}, server.TLSConfig)
+		listener, err := net.Listen("tcp", cfg.Hub.Listen)
+		if err != nil {
+			fatal("Push listener failed to start", err)
+		}
+		go func() {
+			logger.Info("Starting push listener", "addr", listener.Addr().String(), "tls", certs != nil)
+			if err := pushServer.Serve(listener); err != nil {
+				fatal("Push listener failed", err)
+			}
+		}()
+	}
+
+	// Optional admin listener, never served on the public port
+	var current atomic.Pointer[config.Config] // Read by the admin endpoints
+	effective := cfg
+	current.Store(&effective)
+
+	var adminServer *http.Server
+	if cfg.Admin.Addr != "" {
+		adminRouter := chi.NewRouter()
+		adminRouter.Use(middleware.Recoverer)
+		adminRouter.Use(authn.Require(auth.ScopeAdmin))
+		adminRouter.Mount("/", admin.NewRouter(admin.Sources{
+			Config: func() any { return current.Load().Redacted() },
+			Collectors: func() any {
+				return collectors.state(aggregator.GetLatestSample())
+			},
+		}))
+
+		// CPU profiles and traces stream for as long as requested (?seconds=),
+		// so the admin listener has no write timeout
+		adminServer = newServer(cfg.Admin.Addr, adminRouter, cfg.Server)
+		adminServer.WriteTimeout = 0
+		go func() {
+			logger.Info("Starting admin server", "addr", adminServer.Addr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Admin server failed to start", err)
+			}
+		}()
+	}
+
+	// Optional plain HTTP listener for health probes
+	var healthServer *http.Server
+	if cfg.Server.HealthPort > 0 {
+		health := chi.NewRouter()
+		health.Use(middleware.Recoverer)
+		health.Get("/healthz", handlers.HealthzHandler)
+		health.Get("/readyz", handlers.ReadyzHandler)
+
+		healthServer = newServer(":"+strconv.Itoa(cfg.Server.HealthPort), health, cfg.Server)
+		go func() {
+			logger.Info("Starting health server", "addr", healthServer.Addr)
+			if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Health server failed to start", err)
+			}
+		}()
 	}
 
-	// Channel to listen for interrupt signals
+	// Channel to listen for interrupt and reload signals
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Start server in a goroutine so it doesn't block
 	go func() {
-		log.Printf("Starting server on %s", addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+		var err error
+		if certs != nil {
+			logger.Info("Starting HTTPS server", "addr", addr)
+			err = server.ListenAndServeTLS("", "") // Certificates come from TLSConfig
+		} else {
+			logger.Info("Starting server", "addr", addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Server failed to start", err)
 		}
 	}()
 
-	// Wait for interrupt signal
-	<-sigChan
-	log.Println("Shutdown signal received")
-
-	// Cancel context to stop collectors and aggregator
-	cancel()
+	// Wait for interrupt signal, reloading the configuration on SIGHUP
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		cfg = reload(*configPath, cfg, collectors, sinks, authn, alerts)
+		effective := cfg
+		current.Store(&effective)
+		if certs != nil {
+			if err := certs.Reload(); err != nil {
+				logger.Error("Reload failed", "error", err)
+			}
+		}
+	}
+	logger.Info("Shutdown signal received")
 
-	// Create shutdown context with timeout
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Everything below must finish within the shutdown timeout
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
 	defer shutdownCancel()
 
-	// Gracefully shutdown the server
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// 1. Stop accepting connections, close streams and wait for
+		// in-flight requests
+		for _, srv := range []*http.Server{healthServer, adminServer, server} {
+			if srv == nil {
+				continue
+			}
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				logger.Error("Server did not shut down cleanly", "addr", srv.Addr, "error", err)
+				srv.Close()
+			}
+		}
+		if pushServer != nil {
+			pushServer.Close() // Agents resend unacknowledged samples to the next hub
+		}
+
+		// 2. Stop collectors, so no new metrics arrive
+		collectors.stop()
+
+		// 3. Stop the aggregator, which drains the sink queues, and the
+		// certificate watcher
+		cancel()
+		wg.Wait()
+
+		// 4. Flush and close the optional sinks
+		sinks.stop()
+
+		// 5. Close the store last; the aggregator no longer writes to it
+		if sampleStore != nil {
+			if err := sampleStore.Close(); err != nil {
+				logger.Error("Failed to close sample store", "error", err)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+		logger.Info("Server shutdown complete")
+	case <-shutdownCtx.Done():
+		fatal("Shutdown timed out", shutdownCtx.Err())
 	}
+}
 
-	log.Println("Server shutdown complete")
+// newServer creates an HTTP server with the configured timeouts and limits
+func newServer(addr string, handler http.Handler, cfg config.ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logging.For("http").Handler(), slog.LevelWarn),
+	}
 }
 
-// getEnv - Helper function to get environment variable with default
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// reload loads the configuration again and applies the parts that can
+// change at runtime: collectors, sinks, credentials and alert rules. On
+// error the current configuration stays in effect. Returns the
+// configuration now in effect.
+func reload(path string, current config.Config, collectors *collectorManager, sinks *sinkManager, authn *auth.Authenticator, alerts *alert.Evaluator) config.Config {
+	logger.Info("Reloading configuration")
+
+	next, err := config.Load(path)
+	if err != nil {
+		logger.Error("Reload failed, keeping current configuration", "error", err)
+		return current
+	}
+
+	// Server and history settings are wired into long-lived objects
+	if !reflect.DeepEqual(next.Server, current.Server) {
+		logger.Warn("Server settings changed; restart to apply them")
+		next.Server = current.Server
+	}
+	if !reflect.DeepEqual(next.History, current.History) {
+		logger.Warn("History settings changed; restart to apply them")
+		next.History = current.History
+	}
+	if next.Admin != current.Admin {
+		logger.Warn("Admin settings changed; restart to apply them")
+		next.Admin = current.Admin
+	}
+	if !reflect.DeepEqual(next.Hub, current.Hub) {
+		logger.Warn("Hub settings changed; restart to apply them")
+		next.Hub = current.Hub
+	}
+	if !reflect.DeepEqual(next.Analysis, current.Analysis) {
+		logger.Warn("Analysis settings changed; restart to apply them")
+		next.Analysis = current.Analysis
 	}
-	return defaultValue
+
+	if next.Alerts.Interval != current.Alerts.Interval {
+		logger.Warn("Alert interval changed; restart to apply it")
+		next.Alerts.Interval = current.Alerts.Interval
+	}
+
+	if err := logging.Configure(loggingConfig(next.Logging)); err != nil {
+		logger.Error("Reload failed", "error", err)
+		next.Logging = current.Logging
+	}
+	collectors.apply(next.Collectors)
+	if err := sinks.apply(next.Sinks); err != nil {
+		logger.Error("Reload failed", "error", err)
+		next.Sinks = current.Sinks // Failed sinks keep their previous instance
+	}
+	if err := authn.Update(authConfig(next.Auth)); err != nil {
+		logger.Error("Reload failed", "error", err)
+		next.Auth = current.Auth
+	}
+	if err := alerts.SetRules(alertRules(next.Alerts)); err != nil {
+		logger.Error("Reload failed", "error", err)
+		next.Alerts = current.Alerts
+	}
+
+	logger.Info("Configuration reloaded")
+	return next
+}
+
+// loggingConfig converts the logging section of the configuration
+func loggingConfig(c config.LoggingConfig) logging.Config {
+	return logging.Config{Format: c.Format, Level: c.Level, Levels: c.Levels}
+}
+
+// seedRollups adds the stored samples within the longest tier's retention
+// to rollups, ahead of the samples aggregated since the server started
+func seedRollups(ctx context.Context, rollups *agg.Rollups, samples *store.Store) {
+	start := time.Now()
+	var retention time.Duration
+	for _, tier := range rollups.Tiers() {
+		retention = max(retention, tier.Retention)
+	}
+
+	seeded := agg.NewRollups(rollups.Tiers())
+	n := 0
+	err := samples.Scan(start.Add(-retention), start, func(sample collect.Sample) bool {
+		seeded.Add(sample)
+		n++
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		logger.Error("Failed to rebuild rollups from the sample store", "error", err)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	rollups.Backfill(seeded)
+	logger.Info("Rebuilt rollups from the sample store", "samples", n, "duration_ms", time.Since(start).Milliseconds())
 }
 
-// getEnvDuration - Helper function to get duration from environment variable
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
-			return duration
+// alertRules converts the alert rules of the configuration
+func alertRules(c config.AlertsConfig) []alert.Rule {
+	rules := make([]alert.Rule, len(c.Rules))
+	for i, r := range c.Rules {
+		rules[i] = alert.Rule{
+			Name:        r.Name,
+			Expr:        r.Expr,
+			For:         time.Duration(r.For),
+			Labels:      r.Labels,
+			Annotations: r.Annotations,
 		}
 	}
-	return defaultValue
+	return rules
 }
 
-// getEnvInt - Helper function to get integer from environment variable
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
+// authConfig converts the auth section of the configuration
+func authConfig(c config.AuthConfig) auth.Config {
+	scopes := func(names []string) []auth.Scope {
+		var out []auth.Scope
+		for _, name := range names {
+			out = append(out, auth.Scope(name))
 		}
+		return out
+	}
+
+	var cfg auth.Config
+	for _, t := range c.Tokens {
+		cfg.Tokens = append(cfg.Tokens, auth.Token{Name: t.Name, Token: t.Token, Scopes: scopes(t.Scopes)})
+	}
+	for _, u := range c.Users {
+		cfg.Users = append(cfg.Users, auth.User{Username: u.Username, PasswordHash: u.PasswordHash, Scopes: scopes(u.Scopes)})
+	}
+	for _, cert := range c.ClientCerts {
+		cfg.ClientCerts = append(cfg.ClientCerts, auth.ClientCert{CommonName: cert.CommonName, Scopes: scopes(cert.Scopes)})
 	}
-	return defaultValue
+	return cfg
+}
+
+// printPasswordHash reads a password from stdin and prints its bcrypt hash
+func printPasswordHash() {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fatal("Failed to read password", err)
+	}
+	hash, err := auth.HashPassword(strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		fatal("Failed to hash password", err)
+	}
+	fmt.Println(hash)
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/config"
+	"github.com/dirshaye/GoMetrics/internal/influx"
+	"github.com/dirshaye/GoMetrics/internal/push"
+	"github.com/dirshaye/GoMetrics/internal/statsd"
+)
+
+// runningSink tracks an optional sink and the config it was built from
+type runningSink struct {
+	cfg  any
+	stop func() // Releases resources after the aggregator has removed the sink
+}
+
+// sinkManager adds the optional sinks to the aggregator and, on reload,
+// replaces only the ones whose configuration changed
+type sinkManager struct {
+	aggregator *agg.Aggregator
+	queueSize  int
+	running    map[string]*runningSink
+}
+
+// newSinkManager creates a manager for the aggregator's optional sinks.
+// Sinks run until stop is called so they can flush the last samples after
+// the aggregator has exited.
+func newSinkManager(aggregator *agg.Aggregator, queueSize int) *sinkManager {
+	return &sinkManager{
+		aggregator: aggregator,
+		queueSize:  queueSize,
+		running:    make(map[string]*runningSink),
+	}
+}
+
+// apply adds, replaces or removes sinks to match cfg. A sink that fails to
+// start is reported and any previous instance is left running.
+func (m *sinkManager) apply(cfg config.SinksConfig) error {
+	var errs []error
+
+	err := m.set("statsd", cfg.StatsD, cfg.StatsD.Address != "", func() (agg.Sink, func(), error) {
+		emitter, err := statsd.NewEmitter(statsd.Config{
+			Address:       cfg.StatsD.Address,
+			Prefix:        cfg.StatsD.Prefix,
+			DogStatsD:     cfg.StatsD.DogStatsD,
+			Tags:          cfg.StatsD.Tags,
+			MaxPacketSize: cfg.StatsD.MaxPacketSize,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return emitter, func() { emitter.Close() }, nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	err = m.set("influx", cfg.Influx, cfg.Influx.URL != "", func() (agg.Sink, func(), error) {
+		hostname, _ := os.Hostname()
+		writer, err := influx.NewWriter(influx.WriterConfig{
+			URL:           cfg.Influx.URL,
+			Org:           cfg.Influx.Org,
+			Bucket:        cfg.Influx.Bucket,
+			Token:         cfg.Influx.Token,
+			Tags:          map[string]string{"host": hostname},
+			BatchSize:     cfg.Influx.BatchSize,
+			FlushInterval: time.Duration(cfg.Influx.FlushInterval),
+			MaxRetries:    cfg.Influx.MaxRetries,
+			Gzip:          cfg.Influx.Gzip,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The writer flushes in its own goroutine; stopping it writes what is left
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			writer.Start(ctx)
+		}()
+		return writer, func() { cancel(); <-done }, nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	err = m.set("push", cfg.Push, cfg.Push.Address != "", func() (agg.Sink, func(), error) {
+		var tlsConfig *tls.Config
+		if cfg.Push.TLS {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+			if cfg.Push.CAFile != "" {
+				pem, err := os.ReadFile(cfg.Push.CAFile)
+				if err != nil {
+					return nil, nil, err
+				}
+				tlsConfig.RootCAs = x509.NewCertPool()
+				if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+					return nil, nil, fmt.Errorf("no certificates in %s", cfg.Push.CAFile)
+				}
+			}
+		}
+		agent, err := push.NewAgent(push.AgentConfig{
+			Address:    cfg.Push.Address,
+			HostID:     cfg.Push.HostID,
+			Labels:     cfg.Push.Labels,
+			Token:      cfg.Push.Token,
+			TLS:        tlsConfig,
+			MaxPending: cfg.Push.MaxPending,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Stopping the agent waits briefly for the hub to acknowledge the rest
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			agent.Start(ctx)
+		}()
+		return agent, func() { cancel(); <-done }, nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// set makes the named sink match cfg: created if enabled and not running,
+// replaced if its config changed, removed if disabled
+func (m *sinkManager) set(name string, cfg any, enabled bool, create func() (agg.Sink, func(), error)) error {
+	cur, running := m.running[name]
+	if running && enabled && reflect.DeepEqual(cur.cfg, cfg) {
+		return nil // Unchanged
+	}
+
+	// Create the replacement first so a bad config keeps the old sink
+	var sink agg.Sink
+	var stop func()
+	if enabled {
+		var err error
+		if sink, stop, err = create(); err != nil {
+			return fmt.Errorf("%s sink: %w", name, err)
+		}
+	}
+
+	if running {
+		m.aggregator.RemoveSink(name)
+		cur.stop()
+		delete(m.running, name)
+		logger.Info("Stopped sink", "sink", name)
+	}
+
+	if enabled {
+		m.aggregator.AddSink(sink, m.queueSize)
+		m.running[name] = &runningSink{cfg: cfg, stop: stop}
+		logger.Info("Started sink", "sink", name)
+	}
+	return nil
+}
+
+// stop removes every sink and releases its resources, flushing buffered
+// samples. Call it after the aggregator has exited.
+func (m *sinkManager) stop() {
+	for name, cur := range m.running {
+		m.aggregator.RemoveSink(name)
+		cur.stop()
+		delete(m.running, name)
+	}
+}
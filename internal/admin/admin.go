@@ -0,0 +1,87 @@
+// Package admin serves operational endpoints (profiling, runtime state,
+// effective configuration) on a listener separate from the public API.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Sources provides the state shown by the admin endpoints
+type Sources struct {
+	Config     func() any // Effective configuration, secrets redacted
+	Collectors func() any // State of every collector
+}
+
+// startTime is used to report uptime
+var startTime = time.Now()
+
+// NewRouter returns the admin endpoints:
+//
+//	/debug/pprof/...    net/http/pprof profiles
+//	/debug/goroutines   full goroutine stack dump
+//	/debug/memstats     runtime.MemStats and scheduler counters
+//	/debug/config       effective configuration as YAML
+//	/debug/collectors   collector state as JSON
+func NewRouter(src Sources) chi.Router {
+	r := chi.NewRouter()
+
+	// Registered explicitly so nothing ends up on http.DefaultServeMux
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.Handle("/debug/pprof/{profile}", http.HandlerFunc(pprof.Index)) // heap, goroutine, block, ...
+
+	r.Get("/debug/goroutines", goroutinesHandler)
+	r.Get("/debug/memstats", memStatsHandler)
+	r.Get("/debug/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		enc.Encode(src.Config())
+		enc.Close()
+	})
+	r.Get("/debug/collectors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, src.Collectors())
+	})
+
+	return r
+}
+
+// goroutinesHandler writes the stacks of all goroutines
+func goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2) // 2 = same format as an unrecovered panic
+}
+
+// memStatsHandler reports memory and scheduler statistics
+func memStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	writeJSON(w, map[string]any{
+		"uptime_seconds": time.Since(startTime).Seconds(),
+		"go_version":     runtime.Version(),
+		"goroutines":     runtime.NumGoroutine(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"num_cpu":        runtime.NumCPU(),
+		"memstats":       m,
+	})
+}
+
+// writeJSON sends v as indented JSON
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
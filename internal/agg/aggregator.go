@@ -2,14 +2,17 @@ package agg
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/dirshaye/GoMetrics/internal/collect"
-	"github.com/dirshaye/GoMetrics/internal/prom"
+	"github.com/dirshaye/GoMetrics/internal/logging"
 )
 
+var logger = logging.For("agg")
+
 // Aggregator combines metrics from multiple collectors into periodic samples
 type Aggregator struct {
 	// Channels to receive metrics from collectors
@@ -25,8 +28,26 @@ type Aggregator struct {
 	currentDisk    *collect.DiskMetric
 	currentNetwork *collect.NetworkMetric
 
-	// Prometheus metrics
-	promMetrics *prom.Metrics
+	// Previous disk and network counters, for per-second rates
+	counters counters
+
+	// Last report of every running collector, keyed by metric type
+	sections map[string]*section
+
+	// Downsampled history
+	rollups *Rollups
+
+	// Sliding-window statistics; nil when disabled
+	windows *Windows
+
+	// Most recent samples, for streaming clients
+	recent *Recent
+
+	// Outputs that receive every sample (Prometheus, StatsD, etc.)
+	sinksMu sync.RWMutex
+	sinks   []*sinkRunner
+	sinkWG  sync.WaitGroup
+	ctx     context.Context // Set by Start; sinks added later start immediately
 
 	// Configuration
 	sampleInterval time.Duration
@@ -37,8 +58,97 @@ func NewAggregator(sampleInterval time.Duration, bufferSize int) *Aggregator {
 	return &Aggregator{
 		metricsChan:    make(chan collect.Metric, bufferSize),
 		sampleInterval: sampleInterval,
-		promMetrics:    prom.NewMetrics(),
+		rollups:        NewRollups(DefaultRollupTiers),
+		windows:        NewWindows(DefaultWindows, DefaultWindowFields),
+		recent:         NewRecent(DefaultRecentSize),
+		sections:       make(map[string]*section),
+	}
+}
+
+// AddSink registers a sink that receives every sample through its own
+// queue of queueSize samples. Sink names must be unique.
+func (a *Aggregator) AddSink(sink Sink, queueSize int) {
+	runner := newSinkRunner(sink, queueSize)
+
+	a.sinksMu.Lock()
+	defer a.sinksMu.Unlock()
+
+	a.sinks = append(a.sinks, runner)
+	if a.ctx != nil {
+		a.startSink(runner)
+	}
+}
+
+// RemoveSink stops the named sink after it has processed its queue and
+// deletes its metrics. Returns false if there is no such sink.
+func (a *Aggregator) RemoveSink(name string) bool {
+	a.sinksMu.Lock()
+	var runner *sinkRunner
+	for i, s := range a.sinks {
+		if s.sink.Name() == name {
+			runner = s
+			a.sinks = append(a.sinks[:i:i], a.sinks[i+1:]...)
+			break
+		}
 	}
+	a.sinksMu.Unlock()
+
+	if runner == nil {
+		return false
+	}
+	if runner.cancel != nil {
+		runner.cancel()
+		<-runner.done
+	}
+	runner.forget()
+	return true
+}
+
+// startSink runs a sink in its own goroutine. Caller holds a.sinksMu.
+func (a *Aggregator) startSink(runner *sinkRunner) {
+	ctx, cancel := context.WithCancel(a.ctx)
+	runner.cancel = cancel
+
+	a.sinkWG.Add(1)
+	go func() {
+		defer a.sinkWG.Done()
+		defer close(runner.done)
+		runner.run(ctx)
+	}()
+}
+
+// SetRollupTiers replaces the default rollup tiers. Must be called before Start.
+func (a *Aggregator) SetRollupTiers(tiers []RollupTier) {
+	a.rollups = NewRollups(tiers)
+}
+
+// SetWindows replaces the default sliding windows and the fields they
+// cover. No windows disables them. Must be called before Start.
+func (a *Aggregator) SetWindows(windows []time.Duration, fields []string) {
+	a.windows = nil
+	if len(windows) > 0 {
+		a.windows = NewWindows(windows, fields)
+	}
+}
+
+// GetWindows returns the sliding-window statistics, or nil when disabled
+func (a *Aggregator) GetWindows() *Windows {
+	return a.windows
+}
+
+// GetRollups returns the downsampled history
+func (a *Aggregator) GetRollups() *Rollups {
+	return a.rollups
+}
+
+// GetRecent returns the most recent samples and their subscribers
+func (a *Aggregator) GetRecent() *Recent {
+	return a.recent
+}
+
+// GetSampleInterval returns how often samples are created
+func (a *Aggregator) GetSampleInterval() time.Duration {
+	return a.sampleInterval
 }
 
 // GetMetricsChan returns the channel where collectors should send metrics
@@ -59,12 +169,21 @@ func (a *Aggregator) Start(ctx context.Context) {
 	ticker := time.NewTicker(a.sampleInterval)
 	defer ticker.Stop()
 
-	log.Printf("Aggregator started with %v sample interval", a.sampleInterval)
+	logger.Info("Aggregator started", "sample_interval", a.sampleInterval.String())
+
+	// Start one goroutine per sink
+	a.sinksMu.Lock()
+	a.ctx = ctx
+	for _, sink := range a.sinks {
+		a.startSink(sink)
+	}
+	a.sinksMu.Unlock()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Aggregator stopping...")
+			logger.Info("Aggregator stopping")
+			a.sinkWG.Wait() // Let sinks drain their queues
 			return
 
 		case metric := <-a.metricsChan:
@@ -78,49 +197,108 @@ func (a *Aggregator) Start(ctx context.Context) {
 	}
 }
 
-// storeMetric stores the latest metric of each type
+// StaleIntervals is how many collection intervals may pass without a report
+// before a section is marked stale
+const StaleIntervals = 3
+
+// section is the last report of one collector
+type section struct {
+	collectedAt time.Time
+	interval    time.Duration
+	err         string // Error of the last collection, "" if it succeeded
+	warning     string // What a successful collection could not gather
+}
+
+// storeMetric stores the latest metric of each type. A failed collection
+// clears the section's data; a metric without data or error means its
+// collector stopped, so the section is left out of samples entirely.
 func (a *Aggregator) storeMetric(metric collect.Metric) {
 	switch metric.Type {
 	case "cpu":
+		a.currentCPU = nil
 		if cpuData, ok := metric.Data.(collect.CPUMetric); ok {
 			a.currentCPU = &cpuData
 		}
 	case "memory":
+		a.currentMemory = nil
 		if memData, ok := metric.Data.(collect.MemoryMetric); ok {
 			a.currentMemory = &memData
 		}
 	case "disk":
+		a.currentDisk = nil
 		if diskData, ok := metric.Data.(collect.DiskMetric); ok {
+			diskData.Rates = a.counters.diskRates(&diskData, metric.Timestamp)
 			a.currentDisk = &diskData
 		}
 	case "network":
+		a.currentNetwork = nil
 		if netData, ok := metric.Data.(collect.NetworkMetric); ok {
+			netData.Rates = a.counters.networkRates(&netData, metric.Timestamp)
+			netData.Counters = nil
 			a.currentNetwork = &netData
 		}
 	default:
-		log.Printf("Unknown metric type: %s", metric.Type)
+		logger.Warn("Unknown metric type", "type", metric.Type)
+		return
+	}
+
+	if metric.Data == nil && metric.Error == "" {
+		delete(a.sections, metric.Type)
+		a.counters.forget(metric.Type)
+		return
+	}
+	a.sections[metric.Type] = &section{
+		collectedAt: metric.Timestamp,
+		interval:    metric.Interval,
+		err:         metric.Error,
+		warning:     metric.Warning,
 	}
 }
 
+// sectionStatus works out the status of a section at now
+func sectionStatus(s *section, now time.Time) collect.SectionStatus {
+	status := collect.SectionStatus{Status: collect.StatusOK, CollectedAt: s.collectedAt}
+	switch {
+	case s.err != "":
+		status.Status = collect.StatusError
+		status.Message = s.err
+	case s.interval > 0 && now.Sub(s.collectedAt) > StaleIntervals*s.interval:
+		status.Status = collect.StatusStale
+		status.Message = fmt.Sprintf("no report for %v (interval %v)", now.Sub(s.collectedAt).Round(time.Second), s.interval)
+	default:
+		status.Message = s.warning
+	}
+	return status
+}
+
 // createSample combines current metrics into a sample and stores it
 func (a *Aggregator) createSample() {
-	// Create sample with current timestamp
 	sample := collect.Sample{
 		Timestamp: time.Now(),
 	}
 
-	// Add available metrics (use zero values if not available)
-	if a.currentCPU != nil {
-		sample.CPU = *a.currentCPU
-	}
-	if a.currentMemory != nil {
-		sample.Memory = *a.currentMemory
+	// Only sections whose status is ok carry data. Sections of collectors
+	// that are disabled or have not reported stay nil without a status.
+	// Stored metrics are never modified, so samples can share them.
+	if len(a.sections) > 0 {
+		sample.Status = make(map[string]collect.SectionStatus, len(a.sections))
 	}
-	if a.currentDisk != nil {
-		sample.Disk = *a.currentDisk
-	}
-	if a.currentNetwork != nil {
-		sample.Network = *a.currentNetwork
+	for metricType, s := range a.sections {
+		status := sectionStatus(s, sample.Timestamp)
+		sample.Status[metricType] = status
+		if status.Status != collect.StatusOK {
+			continue
+		}
+		switch metricType {
+		case "cpu":
+			sample.CPU = a.currentCPU
+		case "memory":
+			sample.Memory = a.currentMemory
+		case "disk":
+			sample.Disk = a.currentDisk
+		case "network":
+			sample.Network = a.currentNetwork
+		}
 	}
 
 	// Store the sample (thread-safe)
@@ -128,12 +306,36 @@ func (a *Aggregator) createSample() {
 	a.latestSample = sample
 	a.mu.Unlock()
 
-	// Update Prometheus metrics
-	a.promMetrics.UpdateFromSample(sample)
+	// Fold the sample into the rollup tiers
+	a.rollups.Add(sample)
+
+	// Update the sliding-window statistics
+	if a.windows != nil {
+		a.windows.Add(sample)
+	}
+
+	// Buffer the sample and pass it to streaming clients
+	a.recent.Add(sample)
+
+	// Hand the sample to every sink without blocking
+	a.sinksMu.RLock()
+	for _, sink := range a.sinks {
+		sink.enqueue(sample)
+	}
+	a.sinksMu.RUnlock()
 
-	log.Printf("Sample created at %v (CPU: %.1f%%, Memory: %.1f%%, Disk: %.1f%%)",
-		sample.Timestamp.Format("15:04:05.000"),
-		sample.CPU.OverallPercent,
-		sample.Memory.UsedPercent,
-		sample.Disk.UsedPercent)
+	// One record per sample is only useful when debugging
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		attrs := []any{"timestamp", sample.Timestamp}
+		if sample.CPU != nil {
+			attrs = append(attrs, "cpu_percent", sample.CPU.OverallPercent)
+		}
+		if sample.Memory != nil {
+			attrs = append(attrs, "memory_percent", sample.Memory.UsedPercent)
+		}
+		if sample.Disk != nil {
+			attrs = append(attrs, "disk_percent", sample.Disk.UsedPercent)
+		}
+		logger.Debug("Sample created", attrs...)
+	}
 }
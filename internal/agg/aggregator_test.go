@@ -0,0 +1,174 @@
+package agg
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewAggregatorTwice(t *testing.T) {
+	// Sink metrics are registered once per process, not per aggregator
+	NewAggregator(time.Second, 1)
+	NewAggregator(time.Second, 1)
+}
+
+// testSink records the samples it receives. If block is set, every
+// delivery waits until it is closed; delay slows every delivery down.
+type testSink struct {
+	name  string
+	block chan struct{}
+	delay time.Duration
+
+	mu      sync.Mutex
+	samples []collect.Sample
+}
+
+func (s *testSink) Name() string { return s.name }
+
+func (s *testSink) UpdateFromSample(sample collect.Sample) {
+	if s.block != nil {
+		<-s.block
+	}
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	s.samples = append(s.samples, sample)
+	s.mu.Unlock()
+}
+
+func (s *testSink) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.samples)
+}
+
+// waitReceived waits until the sink has received n samples
+func (s *testSink) waitReceived(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.received() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("sink %s received %d samples, want %d", s.name, s.received(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// start runs the aggregator without ticking, so the test creates every
+// sample, and returns a function stopping it
+func start(a *Aggregator) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Start(ctx)
+	}()
+
+	// Sinks are started once Start has taken the context
+	for {
+		a.sinksMu.RLock()
+		started := a.ctx != nil
+		a.sinksMu.RUnlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestSlowSinkDoesNotBlock(t *testing.T) {
+	a := NewAggregator(time.Hour, 1)
+	slow := &testSink{name: "slow-test", block: make(chan struct{})}
+	fast := &testSink{name: "fast-test"}
+	a.AddSink(slow, 2)
+	a.AddSink(fast, 100)
+	stop := start(a)
+
+	dropped := sinkDroppedTotal.WithLabelValues("slow-test")
+	before := testutil.ToFloat64(dropped)
+
+	created := make(chan struct{})
+	go func() {
+		defer close(created)
+		for i := 0; i < 10; i++ {
+			a.createSample()
+		}
+	}()
+	select {
+	case <-created:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating samples blocked on a stuck sink")
+	}
+	fast.waitReceived(t, 10)
+
+	// At most one sample is being delivered and two are queued
+	n := testutil.ToFloat64(dropped) - before
+	if n < 7 || n > 8 {
+		t.Errorf("dropped %v samples, want 7 or 8", n)
+	}
+
+	close(slow.block)
+	stop()
+	if got := slow.received(); got != 10-int(n) {
+		t.Errorf("slow sink received %d samples, want %d", got, 10-int(n))
+	}
+}
+
+func TestAddRemoveSinkWhileRunning(t *testing.T) {
+	a := NewAggregator(time.Hour, 1)
+	stop := start(a)
+	defer stop()
+
+	sink := &testSink{name: "added-test"}
+	a.AddSink(sink, 10)
+	for i := 0; i < 3; i++ {
+		a.createSample()
+	}
+	sink.waitReceived(t, 3)
+
+	if !a.RemoveSink("added-test") {
+		t.Fatal("RemoveSink of a running sink = false")
+	}
+	a.createSample()
+	if got := sink.received(); got != 3 {
+		t.Errorf("removed sink received %d samples, want 3", got)
+	}
+	if sinkQueueLength.DeleteLabelValues("added-test") {
+		t.Error("queue length of the removed sink still exported")
+	}
+	if a.RemoveSink("added-test") {
+		t.Error("RemoveSink of a removed sink = true")
+	}
+
+	// The name can be used again
+	again := &testSink{name: "added-test"}
+	a.AddSink(again, 10)
+	a.createSample()
+	again.waitReceived(t, 1)
+}
+
+func TestSinksDrainOnCancel(t *testing.T) {
+	a := NewAggregator(time.Hour, 1)
+	sink := &testSink{name: "drain-test", delay: 5 * time.Millisecond}
+	a.AddSink(sink, 10)
+
+	// Queued before the sink runs
+	for i := 0; i < 5; i++ {
+		a.createSample()
+	}
+
+	// Start returns only after the sink delivered everything queued
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Start(ctx)
+	if got := sink.received(); got != 5 {
+		t.Errorf("sink received %d samples before Start returned, want 5", got)
+	}
+}
@@ -0,0 +1,153 @@
+package agg
+
+import (
+	"math"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// counters holds the last successful disk collection and the last
+// network interface counters, which per-second rates are computed against
+type counters struct {
+	disk       *collect.DiskMetric
+	diskAt     time.Time
+	interfaces map[string]collect.InterfaceMetric // Keyed by interface name
+	networkAt  time.Time
+}
+
+// diskRates returns the rates of disk since the previous collection and
+// remembers disk for the next one. It returns nil for the first collection
+// or when the clock did not advance.
+func (c *counters) diskRates(disk *collect.DiskMetric, at time.Time) *collect.DiskRates {
+	prev, seconds := c.disk, at.Sub(c.diskAt).Seconds()
+	c.disk, c.diskAt = disk, at
+	if prev == nil || seconds <= 0 {
+		return nil
+	}
+
+	return &collect.DiskRates{
+		ReadBytes:  counterRate(prev.ReadBytes, disk.ReadBytes, seconds),
+		WriteBytes: counterRate(prev.WriteBytes, disk.WriteBytes, seconds),
+		ReadOps:    counterRate(prev.ReadOps, disk.ReadOps, seconds),
+		WriteOps:   counterRate(prev.WriteOps, disk.WriteOps, seconds),
+	}
+}
+
+// networkRates is diskRates for network counters. Rates are computed per
+// interface over the interfaces present in both collections and summed, so
+// an interface that appears or disappears doesn't look like a jump or a
+// reset of the totals. Per-interface rates are reported for the interfaces
+// in network.Interfaces.
+func (c *counters) networkRates(network *collect.NetworkMetric, at time.Time) *collect.NetworkRates {
+	prev, seconds := c.interfaces, at.Sub(c.networkAt).Seconds()
+	c.interfaces = make(map[string]collect.InterfaceMetric, len(network.Counters))
+	for _, iface := range network.Counters {
+		c.interfaces[iface.Interface] = iface
+	}
+	c.networkAt = at
+	if prev == nil || seconds <= 0 {
+		return nil
+	}
+
+	reported := make(map[string]bool, len(network.Interfaces))
+	for _, iface := range network.Interfaces {
+		reported[iface.Interface] = true
+	}
+
+	var total collect.InterfaceMetric
+	var perInterface []collect.InterfaceRates
+	for _, iface := range network.Counters {
+		p, ok := prev[iface.Interface]
+		if !ok {
+			continue
+		}
+		delta := interfaceDelta(p, iface)
+		total.BytesSent += delta.BytesSent
+		total.BytesRecv += delta.BytesRecv
+		total.PacketsSent += delta.PacketsSent
+		total.PacketsRecv += delta.PacketsRecv
+		total.ErrorsIn += delta.ErrorsIn
+		total.ErrorsOut += delta.ErrorsOut
+		total.DropsIn += delta.DropsIn
+		total.DropsOut += delta.DropsOut
+		if reported[iface.Interface] {
+			perInterface = append(perInterface, interfaceRates(delta, seconds))
+		}
+	}
+
+	rates := interfaceRates(total, seconds)
+	return &collect.NetworkRates{
+		BytesSent:   rates.BytesSent,
+		BytesRecv:   rates.BytesRecv,
+		PacketsSent: rates.PacketsSent,
+		PacketsRecv: rates.PacketsRecv,
+		ErrorsIn:    rates.ErrorsIn,
+		ErrorsOut:   rates.ErrorsOut,
+		DropsIn:     rates.DropsIn,
+		DropsOut:    rates.DropsOut,
+		Interfaces:  perInterface,
+	}
+}
+
+// interfaceDelta returns how much each counter of an interface grew
+func interfaceDelta(prev, cur collect.InterfaceMetric) collect.InterfaceMetric {
+	return collect.InterfaceMetric{
+		Interface:   cur.Interface,
+		BytesSent:   counterDelta(prev.BytesSent, cur.BytesSent),
+		BytesRecv:   counterDelta(prev.BytesRecv, cur.BytesRecv),
+		PacketsSent: counterDelta(prev.PacketsSent, cur.PacketsSent),
+		PacketsRecv: counterDelta(prev.PacketsRecv, cur.PacketsRecv),
+		ErrorsIn:    counterDelta(prev.ErrorsIn, cur.ErrorsIn),
+		ErrorsOut:   counterDelta(prev.ErrorsOut, cur.ErrorsOut),
+		DropsIn:     counterDelta(prev.DropsIn, cur.DropsIn),
+		DropsOut:    counterDelta(prev.DropsOut, cur.DropsOut),
+	}
+}
+
+// interfaceRates divides the deltas of an interface by seconds
+func interfaceRates(delta collect.InterfaceMetric, seconds float64) collect.InterfaceRates {
+	return collect.InterfaceRates{
+		Interface:   delta.Interface,
+		BytesSent:   float64(delta.BytesSent) / seconds,
+		BytesRecv:   float64(delta.BytesRecv) / seconds,
+		PacketsSent: float64(delta.PacketsSent) / seconds,
+		PacketsRecv: float64(delta.PacketsRecv) / seconds,
+		ErrorsIn:    float64(delta.ErrorsIn) / seconds,
+		ErrorsOut:   float64(delta.ErrorsOut) / seconds,
+		DropsIn:     float64(delta.DropsIn) / seconds,
+		DropsOut:    float64(delta.DropsOut) / seconds,
+	}
+}
+
+// forget drops the counters of a stopped collector, so a restarted one
+// doesn't get rates across the pause
+func (c *counters) forget(metricType string) {
+	switch metricType {
+	case "disk":
+		c.disk = nil
+	case "network":
+		c.interfaces = nil
+	}
+}
+
+// counterRate returns how fast a counter grew from prev to cur per second
+func counterRate(prev, cur uint64, seconds float64) float64 {
+	return float64(counterDelta(prev, cur)) / seconds
+}
+
+// counterDelta returns how much a counter grew from prev to cur. A counter
+// that went down either wrapped around, when prev was in the top quarter of
+// the 32 or 64 bit range and cur is in the bottom quarter, or was reset to
+// zero, e.g. by a reboot, and has counted cur since.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur >= prev {
+		return cur - prev
+	}
+	for _, limit := range []uint64{math.MaxUint32, math.MaxUint64} {
+		if prev <= limit && prev > limit-limit/4 && cur <= limit/4 {
+			return limit - prev + cur + 1
+		}
+	}
+	return cur
+}
@@ -0,0 +1,92 @@
+package agg
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur uint64
+		want      uint64
+	}{
+		{"growth", 100, 150, 50},
+		{"unchanged", 100, 100, 0},
+		{"32 bit wrap", math.MaxUint32 - 9, 5, 15},
+		{"64 bit wrap", math.MaxUint64 - 9, 5, 15},
+		{"64 bit wrap above 32 bit range", math.MaxUint64 - 9, math.MaxUint32, math.MaxUint32 + 10},
+		{"reset", 1 << 20, 300, 300},
+		{"reset to zero", 1 << 20, 0, 0},
+		{"reset near 32 bit limit with large cur", math.MaxUint32 - 9, math.MaxUint32 / 2, math.MaxUint32 / 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := counterDelta(tt.prev, tt.cur); got != tt.want {
+				t.Errorf("counterDelta(%d, %d) = %d, want %d", tt.prev, tt.cur, got, tt.want)
+			}
+		})
+	}
+}
+
+// network returns a collection of interfaces with the given bytes sent,
+// reported per interface if perInterface is set
+func network(perInterface bool, bytesSent map[string]uint64) *collect.NetworkMetric {
+	var n collect.NetworkMetric
+	for name, sent := range bytesSent {
+		n.BytesSent += sent
+		n.Counters = append(n.Counters, collect.InterfaceMetric{Interface: name, BytesSent: sent})
+	}
+	if perInterface {
+		n.Interfaces = n.Counters
+	}
+	return &n
+}
+
+func TestNetworkRatesInterfaceChurn(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name         string
+		perInterface bool
+		prev, cur    map[string]uint64
+		want         float64
+		interfaces   int
+	}{
+		{"steady", true, map[string]uint64{"eth0": 1000, "veth1": 500}, map[string]uint64{"eth0": 2000, "veth1": 1500}, 400, 2},
+		{"interface disappears", true, map[string]uint64{"eth0": 1000, "veth1": 1 << 30}, map[string]uint64{"eth0": 2000}, 200, 1},
+		{"interface appears", true, map[string]uint64{"eth0": 1000}, map[string]uint64{"eth0": 2000, "veth1": 1 << 30}, 200, 1},
+		{"interface disappears without per-interface output", false, map[string]uint64{"eth0": 1000, "veth1": 1 << 30}, map[string]uint64{"eth0": 2000}, 200, 0},
+		{"interface reset", true, map[string]uint64{"eth0": 1000, "veth1": 1 << 30}, map[string]uint64{"eth0": 2000, "veth1": 500}, 300, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c counters
+			if rates := c.networkRates(network(tt.perInterface, tt.prev), start); rates != nil {
+				t.Fatalf("first collection got rates %+v, want nil", rates)
+			}
+			rates := c.networkRates(network(tt.perInterface, tt.cur), start.Add(5*time.Second))
+			if rates == nil {
+				t.Fatal("second collection got no rates")
+			}
+			if rates.BytesSent != tt.want {
+				t.Errorf("BytesSent = %v, want %v", rates.BytesSent, tt.want)
+			}
+			if len(rates.Interfaces) != tt.interfaces {
+				t.Errorf("got %d interface rates, want %d", len(rates.Interfaces), tt.interfaces)
+			}
+		})
+	}
+}
+
+func TestNetworkRatesForget(t *testing.T) {
+	var c counters
+	start := time.Unix(1700000000, 0)
+	c.networkRates(network(false, map[string]uint64{"eth0": 1000}), start)
+	c.forget("network")
+	if rates := c.networkRates(network(false, map[string]uint64{"eth0": 2000}), start.Add(time.Second)); rates != nil {
+		t.Errorf("got rates %+v across a forgotten collector, want nil", rates)
+	}
+}
@@ -0,0 +1,117 @@
+package agg
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// DefaultRecentSize is how many samples are kept for stream resumption:
+// ten minutes at the default 250ms sample interval
+const DefaultRecentSize = 2400
+
+// Recent keeps the most recent samples in a ring buffer and passes new
+// samples to subscribers
+type Recent struct {
+	mu      sync.RWMutex
+	samples []collect.Sample // Ring buffer; next is the oldest once full
+	next    int
+	full    bool
+	subs    map[*Subscription]struct{}
+}
+
+// NewRecent creates a buffer of size samples
+func NewRecent(size int) *Recent {
+	if size <= 0 {
+		size = DefaultRecentSize
+	}
+	return &Recent{
+		samples: make([]collect.Sample, size),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives every sample added after Subscribe
+type Subscription struct {
+	C <-chan collect.Sample // Closed by Close, or when the subscriber falls behind
+
+	c      chan collect.Sample
+	recent *Recent
+}
+
+// Subscribe returns a subscription buffering up to buffer samples. A
+// subscriber that lets the buffer fill up is dropped (C is closed) rather
+// than slowing down the aggregator; it can catch up with Since.
+func (r *Recent) Subscribe(buffer int) *Subscription {
+	c := make(chan collect.Sample, buffer)
+	s := &Subscription{C: c, c: c, recent: r}
+
+	r.mu.Lock()
+	r.subs[s] = struct{}{}
+	r.mu.Unlock()
+	return s
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.recent.mu.Lock()
+	defer s.recent.mu.Unlock()
+
+	if _, ok := s.recent.subs[s]; ok {
+		delete(s.recent.subs, s)
+		close(s.c)
+	}
+}
+
+// Add stores a sample and sends it to every subscriber
+func (r *Recent) Add(sample collect.Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+
+	for s := range r.subs {
+		select {
+		case s.c <- sample:
+		default:
+			delete(r.subs, s)
+			close(s.c)
+		}
+	}
+}
+
+// Oldest returns the timestamp of the oldest buffered sample, or the zero
+// time if the buffer is empty
+func (r *Recent) Oldest() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.full {
+		return r.samples[r.next].Timestamp
+	}
+	return r.samples[0].Timestamp
+}
+
+// Since returns the buffered samples newer than t, oldest first
+func (r *Recent) Since(t time.Time) []collect.Sample {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var ordered []collect.Sample
+	if r.full {
+		ordered = append(ordered, r.samples[r.next:]...)
+	}
+	ordered = append(ordered, r.samples[:r.next]...)
+
+	for i, sample := range ordered {
+		if sample.Timestamp.After(t) {
+			return ordered[i:]
+		}
+	}
+	return nil
+}
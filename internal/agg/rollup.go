@@ -0,0 +1,396 @@
+package agg
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// RollupTier configures one downsampling tier
+type RollupTier struct {
+	Resolution time.Duration // Width of each bucket
+	Retention  time.Duration // How long buckets are kept
+}
+
+// DefaultRollupTiers keeps 10s buckets for 6 hours, 1m buckets for 2 days
+// and 1h buckets for 30 days
+var DefaultRollupTiers = []RollupTier{
+	{Resolution: 10 * time.Second, Retention: 6 * time.Hour},
+	{Resolution: time.Minute, Retention: 48 * time.Hour},
+	{Resolution: time.Hour, Retention: 30 * 24 * time.Hour},
+}
+
+// ParseRollupTiers parses tiers written as "resolution:retention" pairs,
+// e.g. "10s:6h,1m:48h,1h:720h"
+func ParseRollupTiers(value string) ([]RollupTier, error) {
+	var tiers []RollupTier
+	for _, part := range strings.Split(value, ",") {
+		res, ret, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("rollup tier %q: expected resolution:retention", part)
+		}
+		resolution, err := time.ParseDuration(res)
+		if err != nil || resolution <= 0 {
+			return nil, fmt.Errorf("rollup tier %q: invalid resolution", part)
+		}
+		retention, err := time.ParseDuration(ret)
+		if err != nil || retention < resolution {
+			return nil, fmt.Errorf("rollup tier %q: retention must be at least the resolution", part)
+		}
+		tiers = append(tiers, RollupTier{Resolution: resolution, Retention: retention})
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].Resolution < tiers[j].Resolution
+	})
+	return tiers, nil
+}
+
+// FieldStats summarizes one field over a rollup bucket
+type FieldStats struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Last  float64 `json:"last"`
+	P95   float64 `json:"p95"`
+	Count uint64  `json:"count"`
+}
+
+// RollupBucket holds stats for every field of the samples in one interval.
+// Fields are keyed by collect.Field.Key(), e.g. `cpu.per_core_percent{core="0"}`.
+type RollupBucket struct {
+	Start  time.Time             `json:"start"`
+	Fields map[string]FieldStats `json:"fields"`
+}
+
+// fieldAccumulator collects values of one field for the open bucket
+type fieldAccumulator struct {
+	min, max, sum, last float64
+	count               uint64
+	sketch              *Sketch
+}
+
+func (f *fieldAccumulator) add(v float64) {
+	if f.count == 0 || v < f.min {
+		f.min = v
+	}
+	if f.count == 0 || v > f.max {
+		f.max = v
+	}
+	f.sum += v
+	f.last = v
+	f.count++
+	f.sketch.Add(v)
+}
+
+// merge adds the values of o, which came before those of f
+func (f *fieldAccumulator) merge(o *fieldAccumulator) {
+	if o.count == 0 {
+		return
+	}
+	if f.count == 0 || o.min < f.min {
+		f.min = o.min
+	}
+	if f.count == 0 || o.max > f.max {
+		f.max = o.max
+	}
+	if f.count == 0 {
+		f.last = o.last
+	}
+	f.sum += o.sum
+	f.count += o.count
+	f.sketch.Merge(o.sketch)
+}
+
+// mergeStats adds the stats of an earlier part of a bucket. Only the sketch
+// of f answers quantiles, so P95 reflects the values added to f.
+func (f *fieldAccumulator) mergeStats(o FieldStats) {
+	if o.Count == 0 {
+		return
+	}
+	if f.count == 0 || o.Min < f.min {
+		f.min = o.Min
+	}
+	if f.count == 0 || o.Max > f.max {
+		f.max = o.Max
+	}
+	if f.count == 0 {
+		f.last = o.Last
+	}
+	f.sum += o.Avg * float64(o.Count)
+	f.count += o.Count
+}
+
+// mergeFieldStats combines the stats of two parts of a bucket, earlier
+// first. P95 is the count-weighted mean of both, an estimate.
+func mergeFieldStats(earlier, later FieldStats) FieldStats {
+	if earlier.Count == 0 {
+		return later
+	}
+	if later.Count == 0 {
+		return earlier
+	}
+	n := float64(earlier.Count + later.Count)
+	weight := func(a, b float64) float64 {
+		return (a*float64(earlier.Count) + b*float64(later.Count)) / n
+	}
+	return FieldStats{
+		Min:   math.Min(earlier.Min, later.Min),
+		Max:   math.Max(earlier.Max, later.Max),
+		Avg:   weight(earlier.Avg, later.Avg),
+		Last:  later.Last,
+		P95:   weight(earlier.P95, later.P95),
+		Count: earlier.Count + later.Count,
+	}
+}
+
+func (f *fieldAccumulator) stats() FieldStats {
+	return FieldStats{
+		Min:   f.min,
+		Max:   f.max,
+		Avg:   f.sum / float64(f.count),
+		Last:  f.last,
+		P95:   math.Min(math.Max(f.sketch.Quantile(0.95), f.min), f.max),
+		Count: f.count,
+	}
+}
+
+// rollupTier holds closed buckets plus the bucket currently being filled
+type rollupTier struct {
+	cfg       RollupTier
+	buckets   []RollupBucket // Closed buckets, oldest first
+	openStart time.Time
+	open      map[string]*fieldAccumulator
+}
+
+// Rollups maintains downsampled history at several resolutions
+type Rollups struct {
+	mu    sync.RWMutex
+	tiers []*rollupTier
+}
+
+// NewRollups creates rollup tiers, finest resolution first
+func NewRollups(tiers []RollupTier) *Rollups {
+	r := &Rollups{}
+	for _, cfg := range tiers {
+		r.tiers = append(r.tiers, &rollupTier{cfg: cfg})
+	}
+	sort.Slice(r.tiers, func(i, j int) bool {
+		return r.tiers[i].cfg.Resolution < r.tiers[j].cfg.Resolution
+	})
+	return r
+}
+
+// Add folds a sample into every tier
+func (r *Rollups) Add(sample collect.Sample) {
+	fields := sample.Fields()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, tier := range r.tiers {
+		start := sample.Timestamp.Truncate(tier.cfg.Resolution)
+
+		// Close the open bucket once samples move past it
+		if tier.open != nil && !start.Equal(tier.openStart) {
+			tier.buckets = append(tier.buckets, tier.closeBucket())
+		}
+		if tier.open == nil {
+			tier.openStart = start
+			tier.open = make(map[string]*fieldAccumulator, len(fields))
+		}
+
+		for _, f := range fields {
+			key := f.Key()
+			acc, ok := tier.open[key]
+			if !ok {
+				acc = &fieldAccumulator{sketch: NewSketch()}
+				tier.open[key] = acc
+			}
+			acc.add(f.Value)
+		}
+
+		// Drop buckets that have aged out
+		tier.dropBefore(sample.Timestamp.Add(-tier.cfg.Retention))
+	}
+}
+
+// Backfill adds the buckets of older, e.g. rebuilt from stored samples,
+// that start before the oldest bucket of each tier. older must have the
+// same tiers. A bucket both have, typically the one the server restarted
+// in, gets the values of both. A tier without buckets takes over older's
+// open bucket, so the samples after it are added to the same bucket.
+// Buckets beyond a tier's retention are dropped.
+func (r *Rollups) Backfill(older *Rollups) {
+	older.mu.RLock()
+	defer older.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, tier := range r.tiers {
+		o := older.tiers[i]
+		if len(tier.buckets) == 0 && tier.open == nil {
+			tier.buckets = append([]RollupBucket(nil), o.buckets...)
+			tier.open, tier.openStart = o.open, o.openStart
+			tier.trim()
+			continue
+		}
+
+		oldest := tier.openStart
+		if len(tier.buckets) > 0 {
+			oldest = tier.buckets[0].Start
+		}
+
+		// Buckets before the oldest one are added; the oldest one may be
+		// shared as a closed or as the open bucket of older
+		var buckets []RollupBucket
+		var shared *RollupBucket
+		var sharedOpen map[string]*fieldAccumulator
+		for _, bucket := range o.buckets {
+			switch {
+			case bucket.Start.Before(oldest):
+				buckets = append(buckets, bucket)
+			case bucket.Start.Equal(oldest):
+				shared = &bucket
+			}
+		}
+		if o.open != nil {
+			switch {
+			case o.openStart.Before(oldest):
+				buckets = append(buckets, o.openBucket())
+			case o.openStart.Equal(oldest):
+				sharedOpen = o.open
+				bucket := o.openBucket()
+				shared = &bucket
+			}
+		}
+
+		if shared != nil {
+			if len(tier.buckets) > 0 {
+				merged := RollupBucket{Start: oldest, Fields: make(map[string]FieldStats, len(tier.buckets[0].Fields))}
+				for key, stats := range shared.Fields {
+					merged.Fields[key] = stats
+				}
+				for key, stats := range tier.buckets[0].Fields {
+					merged.Fields[key] = mergeFieldStats(merged.Fields[key], stats)
+				}
+				tier.buckets[0] = merged
+			} else {
+				for key, stats := range shared.Fields {
+					acc, ok := tier.open[key]
+					if !ok {
+						acc = &fieldAccumulator{sketch: NewSketch()}
+						tier.open[key] = acc
+					}
+					if sharedOpen != nil {
+						acc.merge(sharedOpen[key])
+					} else {
+						acc.mergeStats(stats)
+					}
+				}
+			}
+		}
+
+		tier.buckets = append(buckets, tier.buckets...)
+		tier.trim()
+	}
+}
+
+// trim drops the buckets older than the tier's retention before the
+// newest bucket
+func (t *rollupTier) trim() {
+	newest := t.openStart
+	if t.open == nil {
+		if len(t.buckets) == 0 {
+			return
+		}
+		newest = t.buckets[len(t.buckets)-1].Start
+	}
+	t.dropBefore(newest.Add(-t.cfg.Retention))
+}
+
+// dropBefore drops the buckets that start before cutoff
+func (t *rollupTier) dropBefore(cutoff time.Time) {
+	drop := 0
+	for drop < len(t.buckets) && t.buckets[drop].Start.Before(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		t.buckets = append(t.buckets[:0], t.buckets[drop:]...)
+	}
+}
+
+// closeBucket turns the open accumulators into a bucket
+func (t *rollupTier) closeBucket() RollupBucket {
+	bucket := t.openBucket()
+	t.open = nil
+	return bucket
+}
+
+// openBucket summarizes the bucket currently being filled
+func (t *rollupTier) openBucket() RollupBucket {
+	bucket := RollupBucket{
+		Start:  t.openStart,
+		Fields: make(map[string]FieldStats, len(t.open)),
+	}
+	for key, acc := range t.open {
+		bucket.Fields[key] = acc.stats()
+	}
+	return bucket
+}
+
+// Tiers returns the configured tiers, finest resolution first
+func (r *Rollups) Tiers() []RollupTier {
+	tiers := make([]RollupTier, len(r.tiers))
+	for i, tier := range r.tiers {
+		tiers[i] = tier.cfg
+	}
+	return tiers
+}
+
+// Query returns the buckets of the tier with the given resolution that
+// start within [from, to], including the partially filled current bucket
+func (r *Rollups) Query(resolution time.Duration, from, to time.Time) ([]RollupBucket, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, tier := range r.tiers {
+		if tier.cfg.Resolution != resolution {
+			continue
+		}
+
+		from = from.Truncate(resolution)
+		i := sort.Search(len(tier.buckets), func(i int) bool {
+			return !tier.buckets[i].Start.Before(from)
+		})
+
+		buckets := []RollupBucket{}
+		for ; i < len(tier.buckets) && !tier.buckets[i].Start.After(to); i++ {
+			buckets = append(buckets, tier.buckets[i])
+		}
+		if tier.open != nil && !tier.openStart.Before(from) && !tier.openStart.After(to) {
+			buckets = append(buckets, tier.openBucket())
+		}
+		return buckets, nil
+	}
+	return nil, fmt.Errorf("no rollup tier with resolution %v", resolution)
+}
+
+// ChooseTier picks the finest tier that still holds data back to from and
+// returns at most maxPoints buckets for the range. If no tier satisfies
+// both, the coarsest tier is returned. ok is false if there are no tiers.
+func (r *Rollups) ChooseTier(from, to time.Time, maxPoints int) (tier RollupTier, ok bool) {
+	now := time.Now()
+	for _, t := range r.Tiers() {
+		points := int(math.Ceil(float64(to.Sub(from)) / float64(t.Resolution)))
+		if points <= maxPoints && now.Sub(from) <= t.Retention {
+			return t, true
+		}
+		tier, ok = t, true
+	}
+	return tier, ok
+}
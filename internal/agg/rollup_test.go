@@ -0,0 +1,150 @@
+package agg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+func memorySample(at time.Time, used float64) collect.Sample {
+	return collect.Sample{Timestamp: at, Memory: &collect.MemoryMetric{UsedPercent: used}}
+}
+
+func TestRollupsBackfill(t *testing.T) {
+	tiers := []RollupTier{{Resolution: time.Minute, Retention: time.Hour}}
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	// Stored samples up to the restart, 2.5 minutes in
+	older := NewRollups(tiers)
+	for i := 0; i < 10; i++ {
+		older.Add(memorySample(start.Add(time.Duration(i)*15*time.Second), 10))
+	}
+
+	live := NewRollups(tiers)
+	live.Add(memorySample(start.Add(150*time.Second), 20))
+	live.Add(memorySample(start.Add(195*time.Second), 30))
+	live.Backfill(older)
+
+	buckets, err := live.Query(time.Minute, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		start time.Time
+		count uint64
+		avg   float64
+	}{
+		{start, 4, 10},
+		{start.Add(time.Minute), 4, 10},
+		{start.Add(2 * time.Minute), 3, 40.0 / 3}, // Shared by stored and live samples
+		{start.Add(3 * time.Minute), 1, 30},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %d buckets, want %d", len(buckets), len(want))
+	}
+	for i, w := range want {
+		stats := buckets[i].Fields["memory.used_percent"]
+		if !buckets[i].Start.Equal(w.start) || stats.Count != w.count || stats.Avg != w.avg {
+			t.Errorf("bucket %d = %v count %d avg %v, want %v count %d avg %v",
+				i, buckets[i].Start, stats.Count, stats.Avg, w.start, w.count, w.avg)
+		}
+	}
+}
+
+func TestRollupsBackfillEmpty(t *testing.T) {
+	tiers := []RollupTier{{Resolution: time.Minute, Retention: time.Hour}}
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	older := NewRollups(tiers)
+	older.Add(memorySample(start, 10))
+	older.Add(memorySample(start.Add(30*time.Second), 10))
+
+	// The first live sample falls into the last stored bucket
+	live := NewRollups(tiers)
+	live.Backfill(older)
+	live.Add(memorySample(start.Add(45*time.Second), 40))
+
+	buckets, _ := live.Query(time.Minute, start, start.Add(time.Hour))
+	if len(buckets) != 1 {
+		t.Fatalf("got %d buckets, want 1", len(buckets))
+	}
+	if stats := buckets[0].Fields["memory.used_percent"]; stats.Count != 3 || stats.Avg != 20 {
+		t.Errorf("bucket count %d avg %v, want 3 and 20", stats.Count, stats.Avg)
+	}
+}
+
+func TestRollupsBackfillShared(t *testing.T) {
+	tiers := []RollupTier{{Resolution: time.Minute, Retention: time.Hour}}
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	tests := []struct {
+		name  string
+		live  []collect.Sample
+		count uint64
+		min   float64
+		max   float64
+		avg   float64
+	}{
+		{
+			// The restart bucket is still open in both
+			name:  "open",
+			live:  []collect.Sample{memorySample(start.Add(40*time.Second), 40)},
+			count: 3, min: 10, max: 40, avg: 20,
+		},
+		{
+			// The live tier closed the restart bucket already
+			name: "closed",
+			live: []collect.Sample{
+				memorySample(start.Add(40*time.Second), 40),
+				memorySample(start.Add(70*time.Second), 50),
+			},
+			count: 3, min: 10, max: 40, avg: 20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			older := NewRollups(tiers)
+			older.Add(memorySample(start, 10))
+			older.Add(memorySample(start.Add(20*time.Second), 10))
+
+			live := NewRollups(tiers)
+			for _, sample := range tt.live {
+				live.Add(sample)
+			}
+			live.Backfill(older)
+
+			buckets, _ := live.Query(time.Minute, start, start.Add(time.Hour))
+			if len(buckets) != len(tt.live) {
+				t.Fatalf("got %d buckets, want %d", len(buckets), len(tt.live))
+			}
+			stats := buckets[0].Fields["memory.used_percent"]
+			if !buckets[0].Start.Equal(start) || stats.Count != tt.count || stats.Min != tt.min || stats.Max != tt.max || stats.Avg != tt.avg {
+				t.Errorf("bucket %v = %+v, want count %d min %v max %v avg %v",
+					buckets[0].Start, stats, tt.count, tt.min, tt.max, tt.avg)
+			}
+		})
+	}
+}
+
+func TestRollupsBackfillRetention(t *testing.T) {
+	tiers := []RollupTier{{Resolution: time.Minute, Retention: 10 * time.Minute}}
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	older := NewRollups(tiers)
+	for i := 0; i < 20; i++ {
+		older.Add(memorySample(start.Add(time.Duration(i)*time.Minute), 10))
+	}
+
+	live := NewRollups(tiers)
+	live.Add(memorySample(start.Add(20*time.Minute), 20))
+	live.Backfill(older)
+
+	buckets, _ := live.Query(time.Minute, start, start.Add(time.Hour))
+	if len(buckets) == 0 {
+		t.Fatal("got no buckets")
+	}
+	if first := buckets[0].Start; first.Before(start.Add(10 * time.Minute)) {
+		t.Errorf("oldest bucket at %v, beyond the retention", first.Sub(start))
+	}
+}
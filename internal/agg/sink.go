@@ -0,0 +1,122 @@
+package agg
+
+import (
+	"context"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSinkQueueSize is used when AddSink is given a non-positive queue size
+const DefaultSinkQueueSize = 64
+
+// Per-sink delivery metrics, shared by every aggregator in the process
+var (
+	sinkDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gometrics_sink_dropped_samples_total",
+			Help: "Samples dropped because the sink queue was full",
+		},
+		[]string{"sink"},
+	)
+	sinkUpdateDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gometrics_sink_update_duration_seconds",
+			Help:    "Time taken by a sink to process one sample",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10), // 100µs to ~26s
+		},
+		[]string{"sink"},
+	)
+	sinkQueueLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gometrics_sink_queue_length",
+			Help: "Samples waiting in the sink queue",
+		},
+		[]string{"sink"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(sinkDroppedTotal, sinkUpdateDuration, sinkQueueLength)
+}
+
+// Sink receives every sample created by the aggregator.
+// Prometheus, StatsD, InfluxDB and any future output implement this.
+type Sink interface {
+	Name() string                           // Short name used in logs and metrics labels
+	UpdateFromSample(sample collect.Sample) // Called from the sink's own goroutine
+}
+
+// sinkRunner feeds one sink from a bounded queue in its own goroutine,
+// so a slow sink drops samples instead of blocking the aggregation loop
+type sinkRunner struct {
+	sink  Sink
+	queue chan collect.Sample
+
+	cancel context.CancelFunc // Stops run; set when the runner is started
+	done   chan struct{}      // Closed when run returns
+}
+
+// newSinkRunner creates a runner with the given queue size
+func newSinkRunner(sink Sink, queueSize int) *sinkRunner {
+	if queueSize <= 0 {
+		queueSize = DefaultSinkQueueSize
+	}
+	return &sinkRunner{
+		sink:  sink,
+		queue: make(chan collect.Sample, queueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// enqueue hands a sample to the sink without blocking
+func (s *sinkRunner) enqueue(sample collect.Sample) {
+	select {
+	case s.queue <- sample:
+		// Queued
+	default:
+		// Sink is falling behind - drop this sample
+		sinkDroppedTotal.WithLabelValues(s.sink.Name()).Inc()
+	}
+	sinkQueueLength.WithLabelValues(s.sink.Name()).Set(float64(len(s.queue)))
+}
+
+// run delivers queued samples until the context is cancelled, then
+// delivers whatever is left in the queue before returning
+func (s *sinkRunner) run(ctx context.Context) {
+	logger.Info("Sink started", "sink", s.sink.Name())
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case sample := <-s.queue:
+					s.deliver(sample)
+				default:
+					logger.Info("Sink stopping", "sink", s.sink.Name())
+					return
+				}
+			}
+		case sample := <-s.queue:
+			s.deliver(sample)
+		}
+	}
+}
+
+// deliver passes one sample to the sink and records how long it took
+func (s *sinkRunner) deliver(sample collect.Sample) {
+	start := time.Now()
+	s.sink.UpdateFromSample(sample)
+	sinkUpdateDuration.WithLabelValues(s.sink.Name()).Observe(time.Since(start).Seconds())
+	sinkQueueLength.WithLabelValues(s.sink.Name()).Set(float64(len(s.queue)))
+}
+
+// forget deletes the metrics of a removed sink
+func (s *sinkRunner) forget() {
+	name := s.sink.Name()
+	sinkDroppedTotal.DeleteLabelValues(name)
+	sinkUpdateDuration.DeleteLabelValues(name)
+	sinkQueueLength.DeleteLabelValues(name)
+}
@@ -0,0 +1,125 @@
+package agg
+
+import (
+	"math"
+	"sort"
+)
+
+// sketchRelativeAccuracy bounds the relative error of quantile estimates
+const sketchRelativeAccuracy = 0.01
+
+// Sketch is a mergeable streaming quantile sketch. Values are counted in
+// logarithmically sized bins, so any quantile is estimated within 1% relative
+// error using memory proportional to the range of values rather than their
+// count (the DDSketch approach).
+type Sketch struct {
+	pos   map[int]uint64 // Bins for positive values
+	neg   map[int]uint64 // Bins for negative values (by absolute value)
+	zero  uint64         // Values too close to zero to bin
+	count uint64
+}
+
+var (
+	sketchGamma    = (1 + sketchRelativeAccuracy) / (1 - sketchRelativeAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// sketchMinValue is the smallest absolute value given its own bin
+const sketchMinValue = 1e-9
+
+// NewSketch creates an empty sketch
+func NewSketch() *Sketch {
+	return &Sketch{
+		pos: make(map[int]uint64),
+		neg: make(map[int]uint64),
+	}
+}
+
+// Add records a value
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	s.count++
+	switch {
+	case v > sketchMinValue:
+		s.pos[sketchIndex(v)]++
+	case v < -sketchMinValue:
+		s.neg[sketchIndex(-v)]++
+	default:
+		s.zero++
+	}
+}
+
+// Merge adds all values recorded in o
+func (s *Sketch) Merge(o *Sketch) {
+	for i, n := range o.pos {
+		s.pos[i] += n
+	}
+	for i, n := range o.neg {
+		s.neg[i] += n
+	}
+	s.zero += o.zero
+	s.count += o.count
+}
+
+// Count returns the number of recorded values
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1). Returns 0 for an empty sketch.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.count-1))
+
+	// Negative values, from most negative (largest index) up
+	negIdx := sortedKeys(s.neg)
+	for i := len(negIdx) - 1; i >= 0; i-- {
+		n := s.neg[negIdx[i]]
+		if rank < n {
+			return -sketchValue(negIdx[i])
+		}
+		rank -= n
+	}
+
+	if rank < s.zero {
+		return 0
+	}
+	rank -= s.zero
+
+	posIdx := sortedKeys(s.pos)
+	for _, idx := range posIdx {
+		n := s.pos[idx]
+		if rank < n {
+			return sketchValue(idx)
+		}
+		rank -= n
+	}
+
+	// Rounding: return the largest bin
+	return sketchValue(posIdx[len(posIdx)-1])
+}
+
+// sketchIndex returns the bin for a positive value
+func sketchIndex(v float64) int {
+	return int(math.Ceil(math.Log(v) / sketchLogGamma))
+}
+
+// sketchValue returns the representative value of a bin, which is within
+// the relative accuracy of every value counted in it
+func sketchValue(idx int) float64 {
+	return 2 * math.Pow(sketchGamma, float64(idx)) / (sketchGamma + 1)
+}
+
+// sortedKeys returns the bin indexes in ascending order
+func sortedKeys(bins map[int]uint64) []int {
+	keys := make([]int, 0, len(bins))
+	for k := range bins {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
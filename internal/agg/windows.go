@@ -0,0 +1,278 @@
+package agg
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultWindows are the sliding windows statistics are kept for
+var DefaultWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// DefaultWindowFields are the fields statistics are kept for
+var DefaultWindowFields = []string{
+	"cpu.overall_percent",
+	"memory.used_percent",
+	"disk.used_percent",
+	"disk.rates.read_ops",
+	"disk.rates.write_ops",
+	"network.rates.bytes_recv",
+	"network.rates.bytes_sent",
+}
+
+// windowSlots is how many slots the shortest window is divided into; the
+// windows slide by one slot at a time
+const windowSlots = 12
+
+// WindowStats summarizes one field over a sliding window
+type WindowStats struct {
+	Count  uint64  `json:"count"`
+	Avg    float64 `json:"avg"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Stddev float64 `json:"stddev"` // Population standard deviation
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+}
+
+// windowAccumulator collects the values of one field in one slot. The mean
+// and squared deviations are kept with Welford's method, so slots combine
+// without losing precision on large values such as bytes per second.
+type windowAccumulator struct {
+	count    uint64
+	mean, m2 float64
+	min, max float64
+	sketch   *Sketch
+}
+
+func newWindowAccumulator() *windowAccumulator {
+	return &windowAccumulator{sketch: NewSketch()}
+}
+
+func (w *windowAccumulator) add(v float64) {
+	if w.count == 0 || v < w.min {
+		w.min = v
+	}
+	if w.count == 0 || v > w.max {
+		w.max = v
+	}
+	w.count++
+	delta := v - w.mean
+	w.mean += delta / float64(w.count)
+	w.m2 += delta * (v - w.mean)
+	w.sketch.Add(v)
+}
+
+// merge adds the values collected in o
+func (w *windowAccumulator) merge(o *windowAccumulator) {
+	if o.count == 0 {
+		return
+	}
+	if w.count == 0 || o.min < w.min {
+		w.min = o.min
+	}
+	if w.count == 0 || o.max > w.max {
+		w.max = o.max
+	}
+	n := w.count + o.count
+	delta := o.mean - w.mean
+	w.mean += delta * float64(o.count) / float64(n)
+	w.m2 += o.m2 + delta*delta*float64(w.count)*float64(o.count)/float64(n)
+	w.count = n
+	w.sketch.Merge(o.sketch)
+}
+
+func (w *windowAccumulator) stats() WindowStats {
+	clamp := func(v float64) float64 { return math.Min(math.Max(v, w.min), w.max) }
+	return WindowStats{
+		Count:  w.count,
+		Avg:    w.mean,
+		Min:    w.min,
+		Max:    w.max,
+		Stddev: math.Sqrt(w.m2 / float64(w.count)),
+		P50:    clamp(w.sketch.Quantile(0.50)),
+		P90:    clamp(w.sketch.Quantile(0.90)),
+		P99:    clamp(w.sketch.Quantile(0.99)),
+	}
+}
+
+// windowSlot holds the accumulators of one slot, keyed by field key
+type windowSlot struct {
+	start  time.Time
+	fields map[string]*windowAccumulator
+}
+
+// Windows keeps statistics of selected fields over sliding windows such as
+// the last 1, 5 and 15 minutes. Samples are counted in slots of a twelfth
+// of the shortest window, and a window's statistics merge the slots it
+// covers, so windows slide in steps of one slot.
+type Windows struct {
+	windows []time.Duration // Ascending
+	fields  []string
+	step    time.Duration
+
+	mu        sync.Mutex
+	slots     []windowSlot // Ring buffer covering the longest window
+	next      int
+	collected map[string]time.Time // Last counted collection per section
+}
+
+// NewWindows creates sliding windows for fields. A field selects every
+// field of that name or below it, e.g. "disk.mountpoints" or
+// "cpu.per_core_percent".
+func NewWindows(windows []time.Duration, fields []string) *Windows {
+	sorted := append([]time.Duration(nil), windows...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	step := sorted[0] / windowSlots
+	n := int((sorted[len(sorted)-1] + step - 1) / step)
+	return &Windows{
+		windows:   sorted,
+		fields:    fields,
+		step:      step,
+		slots:     make([]windowSlot, n),
+		collected: make(map[string]time.Time),
+	}
+}
+
+// Windows returns the window durations, shortest first
+func (w *Windows) Windows() []time.Duration {
+	return w.windows
+}
+
+// Add counts the selected fields of a sample. Samples are created more
+// often than collectors report, so only sections with a new collection are
+// counted; samples without section statuses are counted in full.
+func (w *Windows) Add(sample collect.Sample) {
+	start := sample.Timestamp.Truncate(w.step)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current := &w.slots[(w.next+len(w.slots)-1)%len(w.slots)]
+	if current.fields == nil || start.After(current.start) {
+		current = &w.slots[w.next]
+		*current = windowSlot{start: start, fields: make(map[string]*windowAccumulator)}
+		w.next = (w.next + 1) % len(w.slots)
+	}
+
+	fresh := make(map[string]bool)
+	for section, status := range sample.Status {
+		if status.Status == collect.StatusOK && status.CollectedAt.After(w.collected[section]) {
+			fresh[section] = true
+			w.collected[section] = status.CollectedAt
+		}
+	}
+
+	for _, f := range sample.Fields() {
+		if !w.selects(f.Name) {
+			continue
+		}
+		if sample.Status != nil {
+			if section, _, _ := strings.Cut(f.Name, "."); !fresh[section] {
+				continue
+			}
+		}
+		key := f.Key()
+		acc, ok := current.fields[key]
+		if !ok {
+			acc = newWindowAccumulator()
+			current.fields[key] = acc
+		}
+		acc.add(f.Value)
+	}
+}
+
+// selects reports whether a field name is selected
+func (w *Windows) selects(name string) bool {
+	for _, field := range w.fields {
+		if name == field || strings.HasPrefix(name, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns the statistics of every field in every window at now,
+// keyed by field key and then by window. Fields without values in a
+// window are left out of it.
+func (w *Windows) Stats(now time.Time) map[string]map[time.Duration]WindowStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := make(map[string]map[time.Duration]WindowStats)
+	for _, window := range w.windows {
+		from := now.Add(-window)
+		merged := make(map[string]*windowAccumulator)
+		for _, slot := range w.slots {
+			if slot.fields == nil || slot.start.Before(from.Truncate(w.step)) || slot.start.After(now) {
+				continue
+			}
+			for key, acc := range slot.fields {
+				m, ok := merged[key]
+				if !ok {
+					m = newWindowAccumulator()
+					merged[key] = m
+				}
+				m.merge(acc)
+			}
+		}
+		for key, acc := range merged {
+			if stats[key] == nil {
+				stats[key] = make(map[time.Duration]WindowStats)
+			}
+			stats[key][window] = acc.stats()
+		}
+	}
+	return stats
+}
+
+// FormatWindow formats a window duration compactly, e.g. "5m" or "1h30m"
+func FormatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+var (
+	windowAvgDesc      = prometheus.NewDesc("gometrics_window_avg", "Average of a field over a sliding window", []string{"field", "window"}, nil)
+	windowMinDesc      = prometheus.NewDesc("gometrics_window_min", "Minimum of a field over a sliding window", []string{"field", "window"}, nil)
+	windowMaxDesc      = prometheus.NewDesc("gometrics_window_max", "Maximum of a field over a sliding window", []string{"field", "window"}, nil)
+	windowStddevDesc   = prometheus.NewDesc("gometrics_window_stddev", "Standard deviation of a field over a sliding window", []string{"field", "window"}, nil)
+	windowQuantileDesc = prometheus.NewDesc("gometrics_window_quantile", "Estimated quantile of a field over a sliding window", []string{"field", "window", "quantile"}, nil)
+)
+
+// Describe implements prometheus.Collector
+func (w *Windows) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{windowAvgDesc, windowMinDesc, windowMaxDesc, windowStddevDesc, windowQuantileDesc} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector; statistics are computed at
+// scrape time
+func (w *Windows) Collect(ch chan<- prometheus.Metric) {
+	for field, windows := range w.Stats(time.Now()) {
+		for window, s := range windows {
+			name := FormatWindow(window)
+			ch <- prometheus.MustNewConstMetric(windowAvgDesc, prometheus.GaugeValue, s.Avg, field, name)
+			ch <- prometheus.MustNewConstMetric(windowMinDesc, prometheus.GaugeValue, s.Min, field, name)
+			ch <- prometheus.MustNewConstMetric(windowMaxDesc, prometheus.GaugeValue, s.Max, field, name)
+			ch <- prometheus.MustNewConstMetric(windowStddevDesc, prometheus.GaugeValue, s.Stddev, field, name)
+			ch <- prometheus.MustNewConstMetric(windowQuantileDesc, prometheus.GaugeValue, s.P50, field, name, "0.5")
+			ch <- prometheus.MustNewConstMetric(windowQuantileDesc, prometheus.GaugeValue, s.P90, field, name, "0.9")
+			ch <- prometheus.MustNewConstMetric(windowQuantileDesc, prometheus.GaugeValue, s.P99, field, name, "0.99")
+		}
+	}
+}
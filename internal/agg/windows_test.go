@@ -0,0 +1,47 @@
+package agg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+func TestWindowsCountOnlyNewCollections(t *testing.T) {
+	w := NewWindows([]time.Duration{time.Minute}, []string{"memory.used_percent"})
+	start := time.Unix(1700000000, 0)
+
+	// Samples every 250ms, the memory collector every 5s
+	for i := 0; i < 40; i++ {
+		at := start.Add(time.Duration(i) * 250 * time.Millisecond)
+		collected := start.Add(at.Sub(start).Truncate(5 * time.Second))
+		w.Add(collect.Sample{
+			Timestamp: at,
+			Memory:    &collect.MemoryMetric{UsedPercent: float64(collected.Unix() % 100)},
+			Status: map[string]collect.SectionStatus{
+				"memory": {Status: collect.StatusOK, CollectedAt: collected},
+			},
+		})
+	}
+
+	stats := w.Stats(start.Add(10 * time.Second))["memory.used_percent"][time.Minute]
+	if stats.Count != 2 {
+		t.Errorf("Count = %d, want 2 collections", stats.Count)
+	}
+}
+
+func TestWindowsCountSamplesWithoutStatus(t *testing.T) {
+	w := NewWindows([]time.Duration{time.Minute}, []string{"memory.used_percent"})
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		w.Add(collect.Sample{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Memory:    &collect.MemoryMetric{UsedPercent: 50},
+		})
+	}
+
+	stats := w.Stats(start.Add(3 * time.Second))["memory.used_percent"][time.Minute]
+	if stats.Count != 3 {
+		t.Errorf("Count = %d, want 3", stats.Count)
+	}
+}
@@ -0,0 +1,279 @@
+// Package alert evaluates alert rules written in the query language against
+// the local samples. Every element of a rule's result is an alert: it is
+// pending while it has been present for less than the rule's For, then
+// firing until it is no longer in the result. Firing and resolved alerts
+// are logged, listed at /api/v1/alerts and counted in Prometheus metrics.
+package alert
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/logging"
+	"github.com/dirshaye/GoMetrics/internal/query"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	logger = logging.For("alert")
+
+	// warnings rate limits rules that fail on every evaluation
+	warnings = logging.NewLimited(logger, time.Minute)
+)
+
+// Alert states
+const (
+	StatePending = "pending" // In the result for less than the rule's For
+	StateFiring  = "firing"  // In the result for at least the rule's For
+)
+
+// AlertNameLabel holds the rule name in the labels of every alert
+const AlertNameLabel = "alertname"
+
+// evaluationFailures counts rule evaluations that returned an error
+var evaluationFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gometrics_alert_evaluation_failures_total",
+		Help: "Alert rule evaluations that failed",
+	},
+	[]string{"rule"},
+)
+
+func init() {
+	prometheus.MustRegister(evaluationFailures)
+}
+
+// Rule is one alert rule
+type Rule struct {
+	Name        string            // Also the alertname label
+	Expr        string            // Query; every element of its result is an alert
+	For         time.Duration     // How long an element must be present before firing
+	Labels      map[string]string // Added to every alert of the rule
+	Annotations map[string]string // Free text, e.g. a summary
+}
+
+// Alert is one element of a rule's result
+type Alert struct {
+	Rule        string            `json:"rule"`
+	State       string            `json:"state"`                 // StatePending or StateFiring
+	Labels      map[string]string `json:"labels"`                // Element labels, rule labels and alertname
+	Annotations map[string]string `json:"annotations,omitempty"` // From the rule
+	Value       float64           `json:"value"`                 // Value at the last evaluation
+	ActiveAt    time.Time         `json:"active_at"`             // First evaluation the element was present
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`    // When it started firing
+}
+
+// rule is a parsed rule and its alerts, keyed by element labels
+type rule struct {
+	Rule
+	query  *query.Query
+	alerts map[string]*Alert
+}
+
+// Evaluator evaluates rules against a query source every interval. It
+// implements prometheus.Collector.
+type Evaluator struct {
+	src      query.Source
+	interval time.Duration
+
+	mu        sync.RWMutex
+	rules     []*rule
+	evaluated time.Time
+}
+
+// New creates an evaluator without rules
+func New(src query.Source, interval time.Duration) *Evaluator {
+	return &Evaluator{src: src, interval: interval}
+}
+
+// SetRules replaces the rules. Alerts of a rule whose name and expression
+// are unchanged are kept, so a reload doesn't reset how long they have been
+// pending. On error the current rules stay in effect.
+func (e *Evaluator) SetRules(rules []Rule) error {
+	parsed := make([]*rule, len(rules))
+	for i, r := range rules {
+		q, err := query.Parse(r.Expr)
+		if err != nil {
+			return fmt.Errorf("alert: rule %s: %w", r.Name, err)
+		}
+		parsed[i] = &rule{Rule: r, query: q, alerts: make(map[string]*Alert)}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := make(map[string]*rule, len(e.rules))
+	for _, r := range e.rules {
+		current[r.Name] = r
+	}
+	for _, r := range parsed {
+		if old, ok := current[r.Name]; ok && old.Expr == r.Expr {
+			for key, a := range old.alerts {
+				a.Labels = alertLabels(r.Rule, withoutRuleLabels(a.Labels, old.Rule))
+				a.Annotations = r.Annotations
+				r.alerts[key] = a
+			}
+		}
+	}
+	e.rules = parsed
+	return nil
+}
+
+// Run evaluates the rules every interval until ctx is done
+func (e *Evaluator) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Evaluate(time.Now())
+		}
+	}
+}
+
+// Evaluate evaluates every rule at now. A rule that fails keeps its alerts
+// as they are, so a transient error neither fires nor resolves anything.
+func (e *Evaluator) Evaluate(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, r := range e.rules {
+		elements, err := r.evaluate(e.src, now)
+		if err != nil {
+			evaluationFailures.WithLabelValues(r.Name).Inc()
+			warnings.Error("Error evaluating alert rule", "rule", r.Name, "error", err)
+			continue
+		}
+		r.update(elements, now)
+	}
+	e.evaluated = now
+}
+
+// evaluate returns the elements of the rule's result. A scalar result is
+// one element without labels if it is non-zero, e.g. for "1 > 0".
+func (r *rule) evaluate(src query.Source, now time.Time) ([]query.Element, error) {
+	result, err := r.query.Instant(src, now)
+	if err != nil {
+		return nil, err
+	}
+	if result.Scalar != nil {
+		if *result.Scalar == 0 {
+			return nil, nil
+		}
+		return []query.Element{{Value: *result.Scalar}}, nil
+	}
+	return result.Vector, nil
+}
+
+// update moves the rule's alerts to match the elements of its result
+func (r *rule) update(elements []query.Element, now time.Time) {
+	present := make(map[string]bool, len(elements))
+	for _, el := range elements {
+		key := collect.Field{Labels: el.Labels}.Key()
+		present[key] = true
+
+		a, ok := r.alerts[key]
+		if !ok {
+			a = &Alert{
+				Rule:        r.Name,
+				State:       StatePending,
+				Labels:      alertLabels(r.Rule, el.Labels),
+				Annotations: r.Annotations,
+				ActiveAt:    now,
+			}
+			r.alerts[key] = a
+		}
+		a.Value = el.Value
+
+		if a.State == StatePending && now.Sub(a.ActiveAt) >= r.For {
+			fired := now
+			a.State, a.FiredAt = StateFiring, &fired
+			logger.Warn("Alert firing", "rule", r.Name, "labels", a.Labels, "value", a.Value)
+		}
+	}
+
+	for key, a := range r.alerts {
+		if present[key] {
+			continue
+		}
+		if a.State == StateFiring {
+			logger.Info("Alert resolved", "rule", r.Name, "labels", a.Labels)
+		}
+		delete(r.alerts, key)
+	}
+}
+
+// alertLabels merges the labels of an element with those of its rule,
+// which win, and the alertname label
+func alertLabels(r Rule, element map[string]string) map[string]string {
+	labels := make(map[string]string, len(element)+len(r.Labels)+1)
+	for k, v := range element {
+		labels[k] = v
+	}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	labels[AlertNameLabel] = r.Name
+	return labels
+}
+
+// withoutRuleLabels returns the element labels of an alert of r
+func withoutRuleLabels(labels map[string]string, r Rule) map[string]string {
+	element := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if _, ok := r.Labels[k]; !ok && k != AlertNameLabel {
+			element[k] = v
+		}
+	}
+	return element
+}
+
+// Alerts returns copies of the pending and firing alerts, sorted by rule
+// and labels, and when the rules were last evaluated
+func (e *Evaluator) Alerts() ([]Alert, time.Time) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var alerts []Alert
+	for _, r := range e.rules {
+		keys := make([]string, 0, len(r.alerts))
+		for key := range r.alerts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			alerts = append(alerts, *r.alerts[key])
+		}
+	}
+	return alerts, e.evaluated
+}
+
+var alertsDesc = prometheus.NewDesc("gometrics_alerts", "Pending and firing alerts per rule", []string{"rule", "state"}, nil)
+
+// Describe implements prometheus.Collector
+func (e *Evaluator) Describe(ch chan<- *prometheus.Desc) {
+	ch <- alertsDesc
+}
+
+// Collect implements prometheus.Collector. Every rule reports both states,
+// so a rule without alerts is 0 rather than absent.
+func (e *Evaluator) Collect(ch chan<- prometheus.Metric) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, r := range e.rules {
+		counts := map[string]int{StatePending: 0, StateFiring: 0}
+		for _, a := range r.alerts {
+			counts[a.State]++
+		}
+		for state, n := range counts {
+			ch <- prometheus.MustNewConstMetric(alertsDesc, prometheus.GaugeValue, float64(n), r.Name, state)
+		}
+	}
+}
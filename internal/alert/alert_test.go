@@ -0,0 +1,209 @@
+package alert
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+// fakeSource serves one sample, replaced by tests between evaluations
+type fakeSource struct {
+	sample collect.Sample
+	err    error
+}
+
+func (s *fakeSource) Scan(from, to time.Time, fn func(collect.Sample) bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !s.sample.Timestamp.Before(from) && !s.sample.Timestamp.After(to) {
+		fn(s.sample)
+	}
+	return nil
+}
+
+// set makes the source return one sample at ts with the given usage per
+// mountpoint
+func (s *fakeSource) set(ts time.Time, usage map[string]float64) {
+	var mountpoints []collect.MountpointUsage
+	for mp, percent := range usage {
+		mountpoints = append(mountpoints, collect.MountpointUsage{Mountpoint: mp, UsedPercent: percent})
+	}
+	s.sample = collect.Sample{Timestamp: ts, Disk: &collect.DiskMetric{Mountpoints: mountpoints}}
+}
+
+var diskRule = Rule{
+	Name:        "DiskAlmostFull",
+	Expr:        "disk.mountpoints.used_percent > 90",
+	For:         time.Minute,
+	Labels:      map[string]string{"severity": "page"},
+	Annotations: map[string]string{"summary": "A disk is almost full"},
+}
+
+func newEvaluator(t *testing.T, src *fakeSource, rules ...Rule) *Evaluator {
+	t.Helper()
+	e := New(src, time.Second)
+	if err := e.SetRules(rules); err != nil {
+		t.Fatalf("SetRules() error = %v", err)
+	}
+	return e
+}
+
+// states returns the state of every alert keyed by mountpoint
+func states(e *Evaluator) map[string]string {
+	alerts, _ := e.Alerts()
+	got := make(map[string]string)
+	for _, a := range alerts {
+		got[a.Labels["mountpoint"]] = a.State
+	}
+	return got
+}
+
+func TestPendingThenFiring(t *testing.T) {
+	src := &fakeSource{}
+	e := newEvaluator(t, src, diskRule)
+
+	src.set(epoch, map[string]float64{"/": 95, "/data": 50})
+	e.Evaluate(epoch)
+	if got, want := states(e), map[string]string{"/": StatePending}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after first evaluation = %v, want %v", got, want)
+	}
+
+	src.set(epoch.Add(30*time.Second), map[string]float64{"/": 96, "/data": 50})
+	e.Evaluate(epoch.Add(30 * time.Second))
+	if got := states(e)["/"]; got != StatePending {
+		t.Fatalf("before For = %s, want pending", got)
+	}
+
+	src.set(epoch.Add(time.Minute), map[string]float64{"/": 97, "/data": 50})
+	e.Evaluate(epoch.Add(time.Minute))
+	alerts, evaluated := e.Alerts()
+	if len(alerts) != 1 || alerts[0].State != StateFiring {
+		t.Fatalf("after For = %+v, want one firing alert", alerts)
+	}
+	a := alerts[0]
+	wantLabels := map[string]string{"alertname": "DiskAlmostFull", "mountpoint": "/", "severity": "page"}
+	if !reflect.DeepEqual(a.Labels, wantLabels) {
+		t.Errorf("Labels = %v, want %v", a.Labels, wantLabels)
+	}
+	if a.Value != 97 || !a.ActiveAt.Equal(epoch) || a.FiredAt == nil || !a.FiredAt.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("Alert = %+v, want value 97, active at epoch and fired a minute later", a)
+	}
+	if a.Annotations["summary"] != "A disk is almost full" {
+		t.Errorf("Annotations = %v", a.Annotations)
+	}
+	if !evaluated.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("evaluated = %v, want %v", evaluated, epoch.Add(time.Minute))
+	}
+
+	// Resolved once no longer in the result
+	src.set(epoch.Add(2*time.Minute), map[string]float64{"/": 80, "/data": 50})
+	e.Evaluate(epoch.Add(2 * time.Minute))
+	if got := states(e); len(got) != 0 {
+		t.Errorf("after resolving = %v, want none", got)
+	}
+}
+
+func TestPendingResetsWhenAbsent(t *testing.T) {
+	src := &fakeSource{}
+	e := newEvaluator(t, src, diskRule)
+
+	src.set(epoch, map[string]float64{"/": 95})
+	e.Evaluate(epoch)
+	src.set(epoch.Add(30*time.Second), map[string]float64{"/": 85})
+	e.Evaluate(epoch.Add(30 * time.Second))
+	src.set(epoch.Add(time.Minute), map[string]float64{"/": 95})
+	e.Evaluate(epoch.Add(time.Minute))
+
+	// Present again for less than For
+	if got := states(e)["/"]; got != StatePending {
+		t.Errorf("state = %s, want pending", got)
+	}
+}
+
+func TestZeroForFiresImmediately(t *testing.T) {
+	src := &fakeSource{}
+	rule := diskRule
+	rule.For = 0
+	e := newEvaluator(t, src, rule)
+
+	src.set(epoch, map[string]float64{"/": 95})
+	e.Evaluate(epoch)
+	if got := states(e)["/"]; got != StateFiring {
+		t.Errorf("state = %s, want firing", got)
+	}
+}
+
+func TestScalarRule(t *testing.T) {
+	src := &fakeSource{}
+	e := newEvaluator(t, src,
+		Rule{Name: "Always", Expr: "1 > 0"},
+		Rule{Name: "Never", Expr: "1 < 0"},
+	)
+	e.Evaluate(epoch)
+	alerts, _ := e.Alerts()
+	if len(alerts) != 1 || alerts[0].Rule != "Always" || alerts[0].State != StateFiring {
+		t.Errorf("Alerts() = %+v, want Always firing", alerts)
+	}
+}
+
+func TestErrorKeepsAlerts(t *testing.T) {
+	src := &fakeSource{}
+	e := newEvaluator(t, src, diskRule)
+
+	src.set(epoch, map[string]float64{"/": 95})
+	e.Evaluate(epoch)
+	src.err = errors.New("disk gone")
+	e.Evaluate(epoch.Add(30 * time.Second))
+	if got := states(e)["/"]; got != StatePending {
+		t.Fatalf("after error = %s, want pending", got)
+	}
+
+	src.err = nil
+	src.set(epoch.Add(time.Minute), map[string]float64{"/": 95})
+	e.Evaluate(epoch.Add(time.Minute))
+	if got := states(e)["/"]; got != StateFiring {
+		t.Errorf("after recovering = %s, want firing", got)
+	}
+}
+
+func TestSetRulesKeepsState(t *testing.T) {
+	src := &fakeSource{}
+	e := newEvaluator(t, src, diskRule)
+	src.set(epoch, map[string]float64{"/": 95})
+	e.Evaluate(epoch)
+
+	// New labels apply to kept alerts
+	relabelled := diskRule
+	relabelled.Labels = map[string]string{"severity": "ticket"}
+	if err := e.SetRules([]Rule{relabelled}); err != nil {
+		t.Fatal(err)
+	}
+	alerts, _ := e.Alerts()
+	if len(alerts) != 1 || !alerts[0].ActiveAt.Equal(epoch) || alerts[0].Labels["severity"] != "ticket" {
+		t.Fatalf("after relabelling = %+v, want the alert kept with severity ticket", alerts)
+	}
+
+	// A changed expression starts over
+	changed := diskRule
+	changed.Expr = "disk.mountpoints.used_percent > 80"
+	if err := e.SetRules([]Rule{changed}); err != nil {
+		t.Fatal(err)
+	}
+	if alerts, _ := e.Alerts(); len(alerts) != 0 {
+		t.Errorf("after changing the expression = %+v, want none", alerts)
+	}
+
+	// Invalid rules leave the current ones in place
+	if err := e.SetRules([]Rule{{Name: "Bad", Expr: "sum("}}); err == nil {
+		t.Error("SetRules() error = nil for an invalid expression")
+	}
+	if len(e.rules) != 1 || e.rules[0].Expr != changed.Expr {
+		t.Errorf("rules after a failed SetRules = %+v", e.rules)
+	}
+}
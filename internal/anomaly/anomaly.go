@@ -0,0 +1,266 @@
+// Package anomaly learns a baseline for numeric fields of the samples and
+// flags values far outside it, e.g. sudden memory growth or a burst of
+// network errors.
+//
+// Each field has two baselines: an exponentially weighted mean and
+// standard deviation following the last minutes, and one per hour of day
+// following the last days. A value's score is its distance from the
+// baseline in standard deviations; once the hour of day has a baseline the
+// lower of the two scores counts, so a batch job running every night at
+// the same hour stops being flagged after a couple of days.
+package anomaly
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var logger = logging.For("anomaly")
+
+// Directions of an anomaly
+const (
+	DirectionUp   = "up"   // Above the baseline
+	DirectionDown = "down" // Below the baseline
+)
+
+var (
+	// anomalyScore is the latest score of every field
+	anomalyScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gometrics_anomaly_score",
+			Help: "Distance of a field's latest value from its baseline in standard deviations (0 while learning)",
+		},
+		[]string{"field"},
+	)
+
+	// anomaliesTotal counts anomalies by field name
+	anomaliesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gometrics_anomalies_total",
+			Help: "Anomalies detected, by field name",
+		},
+		[]string{"field"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(anomalyScore, anomaliesTotal)
+}
+
+// Options configures a Detector
+type Options struct {
+	Fields    []string      // Fields or sections to watch, e.g. "memory" or "network.rates.errors_in"
+	HalfLife  time.Duration // How fast the short-term baseline forgets
+	Threshold float64       // Score at which a value is anomalous
+	Warmup    time.Duration // A field is scored once its baseline is this old
+	Seasonal  bool          // Also keep hour-of-day baselines
+	MaxEvents int           // Events kept, oldest dropped first
+	Expiry    time.Duration // Series not observed for this long are dropped; 0 keeps them
+}
+
+// Event is one anomaly of one field, from the first value above the
+// threshold to the first one below it again
+type Event struct {
+	Field     string     `json:"field"`         // Field key, e.g. `disk.mountpoints.used_percent{mountpoint="/"}`
+	Start     time.Time  `json:"start"`         // Collection time of the first anomalous value
+	End       *time.Time `json:"end,omitempty"` // Collection time of the first normal value; unset while ongoing
+	Direction string     `json:"direction"`     // DirectionUp or DirectionDown
+	Value     float64    `json:"value"`         // First anomalous value
+	Expected  float64    `json:"expected"`      // Baseline mean at the start
+	Stddev    float64    `json:"stddev"`        // Baseline standard deviation at the start
+	Score     float64    `json:"score"`         // Score at the start
+	PeakScore float64    `json:"peak_score"`    // Highest score during the anomaly
+	Seasonal  bool       `json:"seasonal"`      // Scored against the hour-of-day baseline
+}
+
+// expireInterval is how often series are checked for expiry
+const expireInterval = time.Minute
+
+// series is the state of one field
+type series struct {
+	recent   ewma
+	seasonal seasonal
+	event    *Event    // Ongoing anomaly, nil if none
+	seen     time.Time // Last observation
+}
+
+// Detector watches the fields of every sample it is given. It implements
+// agg.Sink.
+type Detector struct {
+	opts Options
+
+	mu        sync.Mutex
+	series    map[string]*series
+	collected map[string]time.Time // Last collection seen per section
+	events    []*Event             // Oldest first
+	expired   time.Time            // Last check for expired series
+}
+
+// NewDetector creates a detector
+func NewDetector(opts Options) *Detector {
+	return &Detector{
+		opts:      opts,
+		series:    make(map[string]*series),
+		collected: make(map[string]time.Time),
+	}
+}
+
+// Name implements agg.Sink
+func (d *Detector) Name() string {
+	return "anomaly"
+}
+
+// UpdateFromSample implements agg.Sink. Samples are created more often
+// than collectors report, so only sections with a new collection are
+// looked at; samples without section statuses are looked at in full.
+func (d *Detector) UpdateFromSample(sample collect.Sample) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fresh := make(map[string]time.Time)
+	for section, status := range sample.Status {
+		if status.Status == collect.StatusOK && status.CollectedAt.After(d.collected[section]) {
+			fresh[section] = status.CollectedAt
+			d.collected[section] = status.CollectedAt
+		}
+	}
+
+	for _, f := range sample.Fields() {
+		at := sample.Timestamp
+		if sample.Status != nil {
+			section, _, _ := strings.Cut(f.Name, ".")
+			var ok bool
+			if at, ok = fresh[section]; !ok {
+				continue
+			}
+		}
+		if !d.selects(f.Name) || math.IsNaN(f.Value) || math.IsInf(f.Value, 0) {
+			continue
+		}
+		d.observe(f, at)
+	}
+
+	if d.opts.Expiry > 0 && sample.Timestamp.Sub(d.expired) >= expireInterval {
+		d.expire(sample.Timestamp)
+		d.expired = sample.Timestamp
+	}
+}
+
+// expire drops the series not observed within Expiry before now, e.g. of
+// an interface or mountpoint that is gone, together with their scores. An
+// ongoing anomaly of such a series ends at its last observation.
+func (d *Detector) expire(now time.Time) {
+	for key, s := range d.series {
+		if now.Sub(s.seen) < d.opts.Expiry {
+			continue
+		}
+		if s.event != nil {
+			end := s.seen
+			s.event.End = &end
+			logger.Info("Anomaly ended; field is no longer reported", "field", key)
+		}
+		delete(d.series, key)
+		anomalyScore.DeleteLabelValues(key)
+	}
+}
+
+// selects reports whether a field name is watched
+func (d *Detector) selects(name string) bool {
+	for _, field := range d.opts.Fields {
+		if name == field || strings.HasPrefix(name, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// observe scores a value against the baselines of its field, opens or
+// closes an event, and then learns the value
+func (d *Detector) observe(f collect.Field, at time.Time) {
+	key := f.Key()
+	s, ok := d.series[key]
+	if !ok {
+		s = &series{}
+		d.series[key] = s
+	}
+	s.seen = at
+
+	var value float64
+	if !s.recent.last.IsZero() && at.Sub(s.recent.since) >= d.opts.Warmup {
+		mean, stddev := s.recent.mean, math.Sqrt(s.recent.variance)
+		value = score(f.Value, mean, stddev)
+		seasonal := false
+		if d.opts.Seasonal {
+			if m, sd, ok := s.seasonal.baseline(at); ok {
+				if v := score(f.Value, m, sd); v < value {
+					value, mean, stddev, seasonal = v, m, sd, true
+				}
+			}
+		}
+
+		switch {
+		case value >= d.opts.Threshold && s.event == nil:
+			s.event = &Event{
+				Field:     key,
+				Start:     at,
+				Direction: DirectionUp,
+				Value:     f.Value,
+				Expected:  mean,
+				Stddev:    stddev,
+				Score:     value,
+				PeakScore: value,
+				Seasonal:  seasonal,
+			}
+			if f.Value < mean {
+				s.event.Direction = DirectionDown
+			}
+			d.record(s.event)
+			anomaliesTotal.WithLabelValues(f.Name).Inc()
+			logger.Warn("Anomaly detected", "field", key, "value", f.Value, "expected", mean, "score", value)
+		case value >= d.opts.Threshold:
+			s.event.PeakScore = math.Max(s.event.PeakScore, value)
+		case s.event != nil:
+			end := at
+			s.event.End = &end
+			s.event = nil
+			logger.Info("Anomaly ended", "field", key, "value", f.Value)
+		}
+	}
+	anomalyScore.WithLabelValues(key).Set(value)
+
+	s.recent.add(f.Value, at, d.opts.HalfLife)
+	if d.opts.Seasonal {
+		s.seasonal.add(f.Value, at)
+	}
+}
+
+// record keeps an event, dropping the oldest beyond MaxEvents
+func (d *Detector) record(e *Event) {
+	if len(d.events) >= d.opts.MaxEvents {
+		copy(d.events, d.events[1:])
+		d.events = d.events[:len(d.events)-1]
+	}
+	d.events = append(d.events, e)
+}
+
+// Events returns copies of the kept events, newest first
+func (d *Detector) Events() []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	events := make([]Event, len(d.events))
+	for i, e := range d.events {
+		events[len(events)-1-i] = *e
+		if e.End != nil {
+			end := *e.End
+			events[len(events)-1-i].End = &end
+		}
+	}
+	return events
+}
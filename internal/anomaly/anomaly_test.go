@@ -0,0 +1,102 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+const usedPercent = "memory.used_percent"
+
+func newTestDetector() *Detector {
+	return NewDetector(Options{
+		Fields:    []string{usedPercent},
+		HalfLife:  10 * time.Minute,
+		Threshold: 4,
+		MaxEvents: 10,
+		Expiry:    time.Hour,
+	})
+}
+
+// memorySample returns a sample without section statuses, so every field
+// is looked at
+func memorySample(at time.Time, used float64) collect.Sample {
+	return collect.Sample{Timestamp: at, Memory: &collect.MemoryMetric{UsedPercent: used}}
+}
+
+func TestEventOpenAndClose(t *testing.T) {
+	d := newTestDetector()
+	start := time.Unix(1700000000, 0)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * 5 * time.Second) }
+
+	for i := 0; i < 20; i++ {
+		d.UpdateFromSample(memorySample(at(i), 50))
+	}
+	if events := d.Events(); len(events) != 0 {
+		t.Fatalf("got %d events for a steady value, want none", len(events))
+	}
+
+	d.UpdateFromSample(memorySample(at(20), 60))
+	events := d.Events()
+	if len(events) != 1 {
+		t.Fatalf("got %d events after a jump, want 1", len(events))
+	}
+	e := events[0]
+	if e.Field != usedPercent || e.Direction != DirectionUp || e.Value != 60 || e.Expected != 50 || e.End != nil {
+		t.Errorf("event = %+v, want an ongoing upward anomaly of %s at 60, expected 50", e, usedPercent)
+	}
+
+	d.UpdateFromSample(memorySample(at(21), 80))
+	d.UpdateFromSample(memorySample(at(22), 50))
+	events = d.Events()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want the same one", len(events))
+	}
+	e = events[0]
+	if e.PeakScore <= e.Score {
+		t.Errorf("peak score %v not above start score %v", e.PeakScore, e.Score)
+	}
+	if e.End == nil || !e.End.Equal(at(22)) {
+		t.Errorf("event end = %v, want %v", e.End, at(22))
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	d := newTestDetector()
+	d.opts.Warmup = time.Minute
+	start := time.Unix(1700000000, 0)
+
+	d.UpdateFromSample(memorySample(start, 50))
+	d.UpdateFromSample(memorySample(start.Add(30*time.Second), 90))
+	if events := d.Events(); len(events) != 0 {
+		t.Errorf("got %d events during warmup, want none", len(events))
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	d := newTestDetector()
+	start := time.Unix(1700000000, 0)
+
+	d.UpdateFromSample(memorySample(start, 50))
+	d.UpdateFromSample(memorySample(start.Add(5*time.Second), 50))
+	d.UpdateFromSample(memorySample(start.Add(10*time.Second), 90))
+
+	// The field is no longer reported
+	d.UpdateFromSample(collect.Sample{Timestamp: start.Add(30 * time.Minute)})
+	if len(d.series) != 1 {
+		t.Fatalf("got %d series before expiry, want 1", len(d.series))
+	}
+	d.UpdateFromSample(collect.Sample{Timestamp: start.Add(time.Hour + time.Minute)})
+	if len(d.series) != 0 {
+		t.Errorf("got %d series after expiry, want none", len(d.series))
+	}
+	if anomalyScore.DeleteLabelValues(usedPercent) {
+		t.Error("score of an expired field still exported")
+	}
+
+	events := d.Events()
+	if len(events) != 1 || events[0].End == nil || !events[0].End.Equal(start.Add(10*time.Second)) {
+		t.Errorf("events = %+v, want one ended at the last observation", events)
+	}
+}
@@ -0,0 +1,106 @@
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+// seasonalMinDays is how many days an hour of day must have been seen on
+// before its baseline is used
+const seasonalMinDays = 2
+
+// seasonalAlpha weights each day in the hour-of-day baselines, so they
+// follow roughly the last week
+const seasonalAlpha = 0.25
+
+// ewma is an exponentially weighted mean and variance
+type ewma struct {
+	mean, variance float64
+	last           time.Time // Last observation; zero before the first
+	since          time.Time // First observation
+}
+
+// add observes v at t. Older observations lose half their weight every
+// halfLife.
+func (e *ewma) add(v float64, t time.Time, halfLife time.Duration) {
+	if e.last.IsZero() {
+		e.mean, e.variance, e.last, e.since = v, 0, t, t
+		return
+	}
+	elapsed := t.Sub(e.last)
+	if elapsed <= 0 {
+		return
+	}
+	alpha := 1 - math.Exp2(-float64(elapsed)/float64(halfLife))
+	delta := v - e.mean
+	e.mean += alpha * delta
+	e.variance = (1 - alpha) * (e.variance + alpha*delta*delta)
+	e.last = t
+}
+
+// seasonal keeps one baseline per hour of day. The values of the current
+// hour are accumulated and folded into that hour's baseline when the hour
+// is over, so a baseline only describes previous days.
+type seasonal struct {
+	hours [24]hourBaseline
+
+	current     time.Time // Start of the hour being accumulated
+	count       int
+	mean, m2    float64 // Welford's method over the current hour
+	currentHour int
+}
+
+// hourBaseline is the baseline of one hour of day
+type hourBaseline struct {
+	days           int
+	mean, variance float64
+}
+
+// add observes v at t
+func (s *seasonal) add(v float64, t time.Time) {
+	start := t.Truncate(time.Hour)
+	if !start.Equal(s.current) {
+		s.fold()
+		s.current, s.currentHour, s.count, s.mean, s.m2 = start, t.Local().Hour(), 0, 0, 0
+	}
+	s.count++
+	delta := v - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (v - s.mean)
+}
+
+// fold merges the accumulated hour into its baseline. Hours with fewer
+// than two values, e.g. right after a restart, are left out.
+func (s *seasonal) fold() {
+	if s.count < 2 {
+		return
+	}
+	h := &s.hours[s.currentHour]
+	variance := s.m2 / float64(s.count)
+	if h.days == 0 {
+		h.mean, h.variance = s.mean, variance
+	} else {
+		delta := s.mean - h.mean
+		h.mean += seasonalAlpha * delta
+		h.variance = (1-seasonalAlpha)*h.variance + seasonalAlpha*(variance+(1-seasonalAlpha)*delta*delta)
+	}
+	h.days++
+}
+
+// baseline returns the baseline of the hour of day of t, if it has been
+// seen on enough days
+func (s *seasonal) baseline(t time.Time) (mean, stddev float64, ok bool) {
+	h := s.hours[t.Local().Hour()]
+	if h.days < seasonalMinDays {
+		return 0, 0, false
+	}
+	return h.mean, math.Sqrt(h.variance), true
+}
+
+// score returns how many deviations v is from mean. The deviation is at
+// least 1% of the mean, so fields that barely moved while the baseline was
+// learned don't flag tiny changes.
+func score(v, mean, stddev float64) float64 {
+	deviation := math.Max(stddev, math.Max(0.01*math.Abs(mean), 1e-6))
+	return math.Abs(v-mean) / deviation
+}
@@ -0,0 +1,89 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEWMA(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var e ewma
+	e.add(10, start, time.Minute)
+	if e.mean != 10 || e.variance != 0 {
+		t.Fatalf("after first value mean, variance = %v, %v, want 10, 0", e.mean, e.variance)
+	}
+
+	// After one half-life the new value weighs as much as the baseline
+	e.add(20, start.Add(time.Minute), time.Minute)
+	if e.mean != 15 || e.variance != 25 {
+		t.Errorf("after one half-life mean, variance = %v, %v, want 15, 25", e.mean, e.variance)
+	}
+
+	// A value that is not newer is ignored
+	e.add(1000, start.Add(time.Minute), time.Minute)
+	if e.mean != 15 {
+		t.Errorf("value at the same time changed mean to %v", e.mean)
+	}
+	if !e.since.Equal(start) {
+		t.Errorf("since = %v, want %v", e.since, start)
+	}
+}
+
+func TestSeasonalFolding(t *testing.T) {
+	day := time.Date(2026, 1, 5, 2, 0, 0, 0, time.Local)
+	at := func(days int, hour, minute int) time.Time {
+		return day.AddDate(0, 0, days).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+
+	var s seasonal
+	s.add(10, at(0, 0, 10))
+	s.add(12, at(0, 0, 20))
+	if _, _, ok := s.baseline(at(0, 0, 30)); ok {
+		t.Fatal("baseline of the current hour used before it was folded")
+	}
+
+	// The next hour folds 2am; one value in it is not enough to be folded
+	s.add(99, at(0, 1, 10))
+	if h := s.hours[at(0, 0, 0).Hour()]; h.days != 1 || h.mean != 11 || h.variance != 1 {
+		t.Errorf("2am after one day = %+v, want 1 day, mean 11, variance 1", h)
+	}
+	if _, _, ok := s.baseline(at(1, 0, 10)); ok {
+		t.Error("baseline used after one day, want two")
+	}
+
+	s.add(20, at(1, 0, 10))
+	s.add(22, at(1, 0, 20))
+	s.add(99, at(1, 1, 10))
+	if h := s.hours[at(0, 1, 0).Hour()]; h.days != 0 {
+		t.Errorf("3am folded from single values on %d days", h.days)
+	}
+
+	mean, stddev, ok := s.baseline(at(2, 0, 10))
+	if !ok {
+		t.Fatal("no baseline after two days")
+	}
+	if mean != 13.5 || math.Abs(stddev-math.Sqrt(19.75)) > 1e-9 {
+		t.Errorf("baseline = %v ± %v, want 13.5 ± %v", mean, stddev, math.Sqrt(19.75))
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name            string
+		v, mean, stddev float64
+		want            float64
+	}{
+		{"within stddev", 11, 10, 2, 0.5},
+		{"below", 4, 10, 2, 3},
+		{"flat baseline uses 1% of mean", 101, 100, 0, 1},
+		{"flat zero baseline", 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := score(tt.v, tt.mean, tt.stddev); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("score(%v, %v, %v) = %v, want %v", tt.v, tt.mean, tt.stddev, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+// Package auth authenticates HTTP API requests and checks per-route scopes.
+//
+// A request is authenticated by a static bearer token, HTTP basic auth
+// against a bcrypt hash, or a verified TLS client certificate matched by
+// common name. Each credential grants a set of scopes; routes require one.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scope names a group of routes
+type Scope string
+
+// Scopes
+const (
+	ScopeRead   Scope = "read"   // JSON metrics API
+	ScopeScrape Scope = "scrape" // Prometheus /metrics
+	ScopePush   Scope = "push"   // Agents pushing samples to a hub
+	ScopeAdmin  Scope = "admin"  // Admin endpoints; also grants every other scope
+)
+
+// Token is a static bearer token
+type Token struct {
+	Name   string // Identifies the token in logs
+	Token  string
+	Scopes []Scope
+}
+
+// User is an HTTP basic auth user
+type User struct {
+	Username     string
+	PasswordHash string // bcrypt hash
+	Scopes       []Scope
+}
+
+// ClientCert matches verified TLS client certificates by common name
+type ClientCert struct {
+	CommonName string
+	Scopes     []Scope
+}
+
+// Config lists every accepted credential
+type Config struct {
+	Tokens      []Token
+	Users       []User
+	ClientCerts []ClientCert
+}
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string // Token name, username or certificate common name
+	Method string // "bearer", "basic" or "mtls"
+	Scopes []Scope
+}
+
+// Has reports whether the principal was granted scope
+func (p Principal) Has(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// credentials is an immutable, indexed copy of a Config
+type credentials struct {
+	tokens map[[sha256.Size]byte]Token // Keyed by the token's hash
+	users  map[string]User
+	certs  map[string]ClientCert
+}
+
+// Authenticator checks requests against the configured credentials.
+// With no credentials configured every request is allowed.
+type Authenticator struct {
+	creds atomic.Pointer[credentials]
+
+	// Successful basic auth checks, so bcrypt runs once per password
+	verified sync.Map // sha256(username, password) -> PasswordHash
+}
+
+// New creates an authenticator for cfg
+func New(cfg Config) (*Authenticator, error) {
+	a := &Authenticator{}
+	if err := a.Update(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Update replaces the accepted credentials. On error the current ones stay.
+func (a *Authenticator) Update(cfg Config) error {
+	creds, err := index(cfg)
+	if err != nil {
+		return err
+	}
+	a.creds.Store(creds)
+	a.verified.Clear()
+	return nil
+}
+
+// Enabled reports whether any credential is configured
+func (a *Authenticator) Enabled() bool {
+	c := a.creds.Load()
+	return len(c.tokens)+len(c.users)+len(c.certs) > 0
+}
+
+// index validates cfg and builds lookup maps
+func index(cfg Config) (*credentials, error) {
+	c := &credentials{
+		tokens: make(map[[sha256.Size]byte]Token),
+		users:  make(map[string]User),
+		certs:  make(map[string]ClientCert),
+	}
+
+	for i, t := range cfg.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("auth: token %d (%s): token is empty", i, t.Name)
+		}
+		if err := checkScopes(t.Scopes); err != nil {
+			return nil, fmt.Errorf("auth: token %d (%s): %w", i, t.Name, err)
+		}
+		key := sha256.Sum256([]byte(t.Token))
+		if _, dup := c.tokens[key]; dup {
+			return nil, fmt.Errorf("auth: token %d (%s): duplicate token", i, t.Name)
+		}
+		c.tokens[key] = t
+	}
+
+	for _, u := range cfg.Users {
+		if u.Username == "" {
+			return nil, errors.New("auth: user without username")
+		}
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("auth: user %s: password hash: %w", u.Username, err)
+		}
+		if err := checkScopes(u.Scopes); err != nil {
+			return nil, fmt.Errorf("auth: user %s: %w", u.Username, err)
+		}
+		if _, dup := c.users[u.Username]; dup {
+			return nil, fmt.Errorf("auth: user %s: duplicate username", u.Username)
+		}
+		c.users[u.Username] = u
+	}
+
+	for _, cert := range cfg.ClientCerts {
+		if cert.CommonName == "" {
+			return nil, errors.New("auth: client certificate without common name")
+		}
+		if err := checkScopes(cert.Scopes); err != nil {
+			return nil, fmt.Errorf("auth: client certificate %s: %w", cert.CommonName, err)
+		}
+		if _, dup := c.certs[cert.CommonName]; dup {
+			return nil, fmt.Errorf("auth: client certificate %s: duplicate common name", cert.CommonName)
+		}
+		c.certs[cert.CommonName] = cert
+	}
+
+	return c, nil
+}
+
+// checkScopes rejects empty or unknown scope lists
+func checkScopes(scopes []Scope) error {
+	if len(scopes) == 0 {
+		return errors.New("no scopes")
+	}
+	for _, s := range scopes {
+		if !ValidScope(s) {
+			return fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	return nil
+}
+
+// ValidScope reports whether s is a known scope
+func ValidScope(s Scope) bool {
+	switch s {
+	case ScopeRead, ScopeScrape, ScopePush, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// dummyHash is compared for unknown usernames, so they take as long to
+// reject as wrong passwords and response times don't reveal which users
+// exist
+const dummyHash = "$2a$10$Zi4JhSkHCx8UR5dQOrpyFO.eX8e3vEY9OXS3LxxS.4dArvZEBUg0."
+
+// errUnauthenticated means credentials were missing or wrong
+var errUnauthenticated = errors.New("authentication required")
+
+// Authenticate identifies the caller of r. Credentials in the
+// Authorization header are checked first, then the TLS client certificate.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	c := a.creds.Load()
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, _ := strings.Cut(header, " ")
+		switch strings.ToLower(scheme) {
+		case "bearer":
+			key := sha256.Sum256([]byte(strings.TrimSpace(value)))
+			if t, ok := c.tokens[key]; ok {
+				return Principal{Name: t.Name, Method: "bearer", Scopes: t.Scopes}, nil
+			}
+		case "basic":
+			if username, password, ok := r.BasicAuth(); ok {
+				u, ok := c.users[username]
+				if !ok {
+					bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+				} else if a.checkPassword(u, password) {
+					return Principal{Name: u.Username, Method: "basic", Scopes: u.Scopes}, nil
+				}
+			}
+		}
+		return Principal{}, errUnauthenticated
+	}
+
+	// Only certificates verified by the TLS server's client CA count
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if cert, ok := c.certs[cn]; ok {
+			return Principal{Name: cn, Method: "mtls", Scopes: cert.Scopes}, nil
+		}
+	}
+
+	return Principal{}, errUnauthenticated
+}
+
+// checkPassword compares a password with the user's bcrypt hash,
+// remembering successful checks
+func (a *Authenticator) checkPassword(u User, password string) bool {
+	key := sha256.Sum256([]byte(u.Username + "\x00" + password))
+	if hash, ok := a.verified.Load(key); ok {
+		return subtle.ConstantTimeCompare([]byte(hash.(string)), []byte(u.PasswordHash)) == 1
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return false
+	}
+	a.verified.Store(key, u.PasswordHash)
+	return true
+}
+
+// Require returns middleware that only lets through callers granted scope.
+// Missing or wrong credentials get 401, insufficient scopes 403.
+func (a *Authenticator) Require(scope Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, err := a.Authenticate(r)
+			if err != nil {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="gometrics"`)
+				w.Header().Add("WWW-Authenticate", `Basic realm="gometrics", charset="UTF-8"`)
+				writeError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+				return
+			}
+			if !principal.Has(scope) {
+				writeError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("%s scope required", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+		})
+	}
+}
+
+// Authorize checks the credentials of a connection that is not HTTP, such
+// as an agent pushing samples: an Authorization header value (e.g.
+// "Bearer <token>") and the TLS connection state, which may be nil. Like
+// Require, it lets everyone through when no credentials are configured.
+func (a *Authenticator) Authorize(authorization string, state *tls.ConnectionState, scope Scope) (Principal, error) {
+	if !a.Enabled() {
+		return Principal{}, nil
+	}
+
+	r := &http.Request{Header: make(http.Header), TLS: state}
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	principal, err := a.Authenticate(r)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !principal.Has(scope) {
+		return Principal{}, fmt.Errorf("%s scope required", scope)
+	}
+	return principal, nil
+}
+
+// principalKey is the context key of the authenticated Principal
+type principalKey struct{}
+
+// FromContext returns the principal authenticated by Require
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// HashPassword returns a bcrypt hash for a basic auth user
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// writeError sends a JSON error response like the REST handlers do
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}{msg, code})
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hash returns a cheap bcrypt hash of password
+func hash(t *testing.T, password string) string {
+	t.Helper()
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+// verified returns the connection state of a verified client certificate
+func verified(cn string) *tls.ConnectionState {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func newAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	a, err := New(Config{
+		Tokens: []Token{
+			{Name: "grafana", Token: "read-token", Scopes: []Scope{ScopeRead}},
+			{Name: "ops", Token: "admin-token", Scopes: []Scope{ScopeAdmin}},
+		},
+		Users:       []User{{Username: "alice", PasswordHash: hash(t, "wonderland"), Scopes: []Scope{ScopeScrape}}},
+		ClientCerts: []ClientCert{{CommonName: "agent-1", Scopes: []Scope{ScopePush}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newAuthenticator(t)
+
+	tests := []struct {
+		name   string
+		header string
+		basic  []string // Username and password
+		tls    *tls.ConnectionState
+		want   Principal
+		ok     bool
+	}{
+		{"bearer", "Bearer read-token", nil, nil, Principal{Name: "grafana", Method: "bearer"}, true},
+		{"bearer scheme case", "bearer read-token", nil, nil, Principal{Name: "grafana", Method: "bearer"}, true},
+		{"wrong token", "Bearer nope", nil, nil, Principal{}, false},
+		{"basic", "", []string{"alice", "wonderland"}, nil, Principal{Name: "alice", Method: "basic"}, true},
+		{"wrong password", "", []string{"alice", "looking-glass"}, nil, Principal{}, false},
+		{"unknown user", "", []string{"bob", "wonderland"}, nil, Principal{}, false},
+		{"mtls", "", nil, verified("agent-1"), Principal{Name: "agent-1", Method: "mtls"}, true},
+		{"unknown common name", "", nil, verified("agent-2"), Principal{}, false},
+		{"unverified certificate", "", nil, &tls.ConnectionState{PeerCertificates: verified("agent-1").PeerCertificates}, Principal{}, false},
+		{"wrong header over a valid certificate", "Bearer nope", nil, verified("agent-1"), Principal{}, false},
+		{"unknown scheme", "Digest foo", nil, nil, Principal{}, false},
+		{"nothing", "", nil, nil, Principal{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.basic != nil {
+				r.SetBasicAuth(tt.basic[0], tt.basic[1])
+			}
+			r.TLS = tt.tls
+
+			p, err := a.Authenticate(r)
+			if ok := err == nil; ok != tt.ok {
+				t.Fatalf("Authenticate error = %v, want ok %v", err, tt.ok)
+			}
+			if p.Name != tt.want.Name || p.Method != tt.want.Method {
+				t.Errorf("principal = %s by %s, want %s by %s", p.Name, p.Method, tt.want.Name, tt.want.Method)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	a := newAuthenticator(t)
+	var reached Principal
+	handler := a.Require(ScopeRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached, _ = FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"granted", "Bearer read-token", http.StatusOK},
+		{"admin grants every scope", "Bearer admin-token", http.StatusOK},
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong", "Bearer nope", http.StatusUnauthorized},
+		{"other scope", "Basic " + basic("alice", "wonderland"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = Principal{}
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/metrics/latest", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			switch tt.status {
+			case http.StatusOK:
+				if reached.Name == "" {
+					t.Error("principal not in the handler's context")
+				}
+			case http.StatusUnauthorized:
+				if len(w.Header().Values("WWW-Authenticate")) != 2 {
+					t.Errorf("WWW-Authenticate = %v, want bearer and basic challenges", w.Header().Values("WWW-Authenticate"))
+				}
+			}
+			if tt.status != http.StatusOK && reached.Name != "" {
+				t.Error("handler reached")
+			}
+		})
+	}
+}
+
+func TestRequireDisabled(t *testing.T) {
+	a, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reached := false
+	handler := a.Require(ScopeAdmin)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { reached = true }))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || !reached {
+		t.Errorf("status = %d, reached = %v without credentials configured", w.Code, reached)
+	}
+}
+
+// basic returns the credentials of a basic Authorization header
+func basic(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+func TestAuthorize(t *testing.T) {
+	a := newAuthenticator(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		tls           *tls.ConnectionState
+		scope         Scope
+		ok            bool
+	}{
+		{"token with scope", "Bearer read-token", nil, ScopeRead, true},
+		{"token without scope", "Bearer read-token", nil, ScopePush, false},
+		{"admin token", "Bearer admin-token", nil, ScopePush, true},
+		{"certificate with scope", "", verified("agent-1"), ScopePush, true},
+		{"certificate without scope", "", verified("agent-1"), ScopeRead, false},
+		{"nothing", "", nil, ScopePush, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Authorize(tt.authorization, tt.tls, tt.scope)
+			if ok := err == nil; ok != tt.ok {
+				t.Errorf("Authorize error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPasswordCache(t *testing.T) {
+	a := newAuthenticator(t)
+	u := a.creds.Load().users["alice"]
+
+	if !a.checkPassword(u, "wonderland") {
+		t.Fatal("password rejected")
+	}
+	if !a.checkPassword(u, "wonderland") {
+		t.Fatal("cached password rejected")
+	}
+
+	// A cached check is only good for the hash it was made against
+	changed := u
+	changed.PasswordHash = hash(t, "looking-glass")
+	if a.checkPassword(changed, "wonderland") {
+		t.Error("old password accepted against a changed hash")
+	}
+	if !a.checkPassword(changed, "looking-glass") {
+		t.Error("new password rejected")
+	}
+
+	// Updating the credentials takes effect for requests
+	if err := a.Update(Config{Users: []User{changed}}); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("alice", "wonderland")
+	if _, err := a.Authenticate(r); err == nil {
+		t.Error("old password accepted after the update")
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	// Unknown usernames cost a comparison as expensive as HashPassword's
+	if cost, err := bcrypt.Cost([]byte(dummyHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost = %d, %v, want %d", cost, err, bcrypt.DefaultCost)
+	}
+}
@@ -0,0 +1,443 @@
+// Package codec implements a compact binary encoding of collect.Sample.
+//
+// An encoded sample is a version byte, the timestamp in Unix nanoseconds as a
+// varint, and then a sequence of sections. Each section is a one-byte id and
+// a uvarint payload length followed by the payload, so decoders skip sections
+// they don't know about. Fields inside a section are only ever appended, and
+// a decoder stops reading a section once its payload is exhausted, leaving
+// newer fields at their zero value. Sections missing from the sample (nil)
+// are not written and decode as nil.
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// Version is written as the first byte of every encoded sample
+const Version = 1
+
+// Section ids
+const (
+	sectionCPU     = 1
+	sectionMemory  = 2
+	sectionDisk    = 3
+	sectionNetwork = 4
+	sectionStatus  = 5
+)
+
+// ErrShortBuffer is returned when an encoded sample is truncated
+var ErrShortBuffer = errors.New("codec: short buffer")
+
+// AppendSample appends the binary encoding of sample to b
+func AppendSample(b []byte, sample collect.Sample) []byte {
+	b = append(b, Version)
+	b = binary.AppendVarint(b, sample.Timestamp.UnixNano())
+
+	var sec []byte
+	if cpu := sample.CPU; cpu != nil {
+		sec = appendFloat(sec[:0], cpu.OverallPercent)
+		sec = appendFloats(sec, cpu.PerCorePercent)
+		sec = appendFloats(sec, cpu.LoadAverage)
+		b = appendSection(b, sectionCPU, sec)
+	}
+
+	if mem := sample.Memory; mem != nil {
+		sec = binary.AppendUvarint(sec[:0], mem.TotalBytes)
+		sec = binary.AppendUvarint(sec, mem.AvailableBytes)
+		sec = binary.AppendUvarint(sec, mem.UsedBytes)
+		sec = appendFloat(sec, mem.UsedPercent)
+		sec = binary.AppendUvarint(sec, mem.SwapTotalBytes)
+		sec = binary.AppendUvarint(sec, mem.SwapUsedBytes)
+		sec = appendFloat(sec, mem.SwapUsedPercent)
+		b = appendSection(b, sectionMemory, sec)
+	}
+
+	if disk := sample.Disk; disk != nil {
+		sec = binary.AppendUvarint(sec[:0], disk.TotalBytes)
+		sec = binary.AppendUvarint(sec, disk.FreeBytes)
+		sec = binary.AppendUvarint(sec, disk.UsedBytes)
+		sec = appendFloat(sec, disk.UsedPercent)
+		sec = binary.AppendUvarint(sec, disk.ReadBytes)
+		sec = binary.AppendUvarint(sec, disk.WriteBytes)
+		sec = binary.AppendUvarint(sec, disk.ReadOps)
+		sec = binary.AppendUvarint(sec, disk.WriteOps)
+		sec = binary.AppendUvarint(sec, uint64(len(disk.Mountpoints)))
+		for _, mp := range disk.Mountpoints {
+			sec = appendString(sec, mp.Mountpoint)
+			sec = binary.AppendUvarint(sec, mp.TotalBytes)
+			sec = binary.AppendUvarint(sec, mp.FreeBytes)
+			sec = binary.AppendUvarint(sec, mp.UsedBytes)
+			sec = appendFloat(sec, mp.UsedPercent)
+		}
+		if rates := disk.Rates; rates != nil {
+			sec = append(sec, 1)
+			sec = appendFloat(sec, rates.ReadBytes)
+			sec = appendFloat(sec, rates.WriteBytes)
+			sec = appendFloat(sec, rates.ReadOps)
+			sec = appendFloat(sec, rates.WriteOps)
+		} else {
+			sec = append(sec, 0)
+		}
+		b = appendSection(b, sectionDisk, sec)
+	}
+
+	if network := sample.Network; network != nil {
+		sec = binary.AppendUvarint(sec[:0], network.BytesSent)
+		sec = binary.AppendUvarint(sec, network.BytesRecv)
+		sec = binary.AppendUvarint(sec, network.PacketsSent)
+		sec = binary.AppendUvarint(sec, network.PacketsRecv)
+		sec = binary.AppendUvarint(sec, network.ErrorsIn)
+		sec = binary.AppendUvarint(sec, network.ErrorsOut)
+		sec = binary.AppendUvarint(sec, network.DropsIn)
+		sec = binary.AppendUvarint(sec, network.DropsOut)
+		sec = binary.AppendUvarint(sec, uint64(len(network.Interfaces)))
+		for _, iface := range network.Interfaces {
+			sec = appendString(sec, iface.Interface)
+			sec = binary.AppendUvarint(sec, iface.BytesSent)
+			sec = binary.AppendUvarint(sec, iface.BytesRecv)
+			sec = binary.AppendUvarint(sec, iface.PacketsSent)
+			sec = binary.AppendUvarint(sec, iface.PacketsRecv)
+			sec = binary.AppendUvarint(sec, iface.ErrorsIn)
+			sec = binary.AppendUvarint(sec, iface.ErrorsOut)
+			sec = binary.AppendUvarint(sec, iface.DropsIn)
+			sec = binary.AppendUvarint(sec, iface.DropsOut)
+		}
+		if rates := network.Rates; rates != nil {
+			sec = append(sec, 1)
+			sec = appendFloat(sec, rates.BytesSent)
+			sec = appendFloat(sec, rates.BytesRecv)
+			sec = appendFloat(sec, rates.PacketsSent)
+			sec = appendFloat(sec, rates.PacketsRecv)
+			sec = appendFloat(sec, rates.ErrorsIn)
+			sec = appendFloat(sec, rates.ErrorsOut)
+			sec = appendFloat(sec, rates.DropsIn)
+			sec = appendFloat(sec, rates.DropsOut)
+			sec = binary.AppendUvarint(sec, uint64(len(rates.Interfaces)))
+			for _, iface := range rates.Interfaces {
+				sec = appendString(sec, iface.Interface)
+				sec = appendFloat(sec, iface.BytesSent)
+				sec = appendFloat(sec, iface.BytesRecv)
+				sec = appendFloat(sec, iface.PacketsSent)
+				sec = appendFloat(sec, iface.PacketsRecv)
+				sec = appendFloat(sec, iface.ErrorsIn)
+				sec = appendFloat(sec, iface.ErrorsOut)
+				sec = appendFloat(sec, iface.DropsIn)
+				sec = appendFloat(sec, iface.DropsOut)
+			}
+		} else {
+			sec = append(sec, 0)
+		}
+		b = appendSection(b, sectionNetwork, sec)
+	}
+
+	if len(sample.Status) > 0 {
+		names := make([]string, 0, len(sample.Status))
+		for name := range sample.Status {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		sec = binary.AppendUvarint(sec[:0], uint64(len(names)))
+		for _, name := range names {
+			status := sample.Status[name]
+			sec = appendString(sec, name)
+			sec = appendString(sec, status.Status)
+			sec = binary.AppendVarint(sec, status.CollectedAt.UnixNano())
+			sec = appendString(sec, status.Message)
+		}
+		b = appendSection(b, sectionStatus, sec)
+	}
+
+	return b
+}
+
+// UnmarshalSample decodes a sample produced by AppendSample
+func UnmarshalSample(data []byte) (collect.Sample, error) {
+	var sample collect.Sample
+
+	if len(data) == 0 {
+		return sample, ErrShortBuffer
+	}
+	if data[0] != Version {
+		return sample, fmt.Errorf("codec: unsupported version %d", data[0])
+	}
+
+	d := decoder{buf: data[1:]}
+	sample.Timestamp = time.Unix(0, d.varint())
+
+	for d.err == nil && len(d.buf) > 0 {
+		id := d.byte()
+		payload := d.bytes(int(d.uvarint()))
+		if d.err != nil {
+			break
+		}
+
+		s := decoder{buf: payload}
+		switch id {
+		case sectionCPU:
+			cpu := &collect.CPUMetric{}
+			cpu.OverallPercent = s.float()
+			cpu.PerCorePercent = s.floats()
+			cpu.LoadAverage = s.floats()
+			sample.CPU = cpu
+		case sectionMemory:
+			mem := &collect.MemoryMetric{}
+			mem.TotalBytes = s.uvarint()
+			mem.AvailableBytes = s.uvarint()
+			mem.UsedBytes = s.uvarint()
+			mem.UsedPercent = s.float()
+			mem.SwapTotalBytes = s.uvarint()
+			mem.SwapUsedBytes = s.uvarint()
+			mem.SwapUsedPercent = s.float()
+			sample.Memory = mem
+		case sectionDisk:
+			disk := &collect.DiskMetric{}
+			disk.TotalBytes = s.uvarint()
+			disk.FreeBytes = s.uvarint()
+			disk.UsedBytes = s.uvarint()
+			disk.UsedPercent = s.float()
+			disk.ReadBytes = s.uvarint()
+			disk.WriteBytes = s.uvarint()
+			disk.ReadOps = s.uvarint()
+			disk.WriteOps = s.uvarint()
+			for n := s.count(); n > 0; n-- {
+				disk.Mountpoints = append(disk.Mountpoints, collect.MountpointUsage{
+					Mountpoint:  s.string(),
+					TotalBytes:  s.uvarint(),
+					FreeBytes:   s.uvarint(),
+					UsedBytes:   s.uvarint(),
+					UsedPercent: s.float(),
+				})
+			}
+			if s.flag() {
+				disk.Rates = &collect.DiskRates{
+					ReadBytes:  s.float(),
+					WriteBytes: s.float(),
+					ReadOps:    s.float(),
+					WriteOps:   s.float(),
+				}
+			}
+			sample.Disk = disk
+		case sectionNetwork:
+			network := &collect.NetworkMetric{}
+			network.BytesSent = s.uvarint()
+			network.BytesRecv = s.uvarint()
+			network.PacketsSent = s.uvarint()
+			network.PacketsRecv = s.uvarint()
+			network.ErrorsIn = s.uvarint()
+			network.ErrorsOut = s.uvarint()
+			network.DropsIn = s.uvarint()
+			network.DropsOut = s.uvarint()
+			for n := s.count(); n > 0; n-- {
+				network.Interfaces = append(network.Interfaces, collect.InterfaceMetric{
+					Interface:   s.string(),
+					BytesSent:   s.uvarint(),
+					BytesRecv:   s.uvarint(),
+					PacketsSent: s.uvarint(),
+					PacketsRecv: s.uvarint(),
+					ErrorsIn:    s.uvarint(),
+					ErrorsOut:   s.uvarint(),
+					DropsIn:     s.uvarint(),
+					DropsOut:    s.uvarint(),
+				})
+			}
+			if s.flag() {
+				rates := &collect.NetworkRates{
+					BytesSent:   s.float(),
+					BytesRecv:   s.float(),
+					PacketsSent: s.float(),
+					PacketsRecv: s.float(),
+					ErrorsIn:    s.float(),
+					ErrorsOut:   s.float(),
+					DropsIn:     s.float(),
+					DropsOut:    s.float(),
+				}
+				for n := s.count(); n > 0; n-- {
+					rates.Interfaces = append(rates.Interfaces, collect.InterfaceRates{
+						Interface:   s.string(),
+						BytesSent:   s.float(),
+						BytesRecv:   s.float(),
+						PacketsSent: s.float(),
+						PacketsRecv: s.float(),
+						ErrorsIn:    s.float(),
+						ErrorsOut:   s.float(),
+						DropsIn:     s.float(),
+						DropsOut:    s.float(),
+					})
+				}
+				network.Rates = rates
+			}
+			sample.Network = network
+		case sectionStatus:
+			n := s.count()
+			sample.Status = make(map[string]collect.SectionStatus, n)
+			for ; n > 0; n-- {
+				name := s.string()
+				sample.Status[name] = collect.SectionStatus{
+					Status:      s.string(),
+					CollectedAt: time.Unix(0, s.varint()),
+					Message:     s.string(),
+				}
+			}
+		default:
+			// Unknown section from a newer encoder - skip it
+		}
+		if s.err != nil {
+			return sample, fmt.Errorf("codec: section %d: %w", id, s.err)
+		}
+	}
+
+	return sample, d.err
+}
+
+// appendSection appends a section header and payload
+func appendSection(b []byte, id byte, payload []byte) []byte {
+	b = append(b, id)
+	b = binary.AppendUvarint(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+// appendFloat appends a float64 as 8 little-endian bytes
+func appendFloat(b []byte, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// appendFloats appends a length-prefixed float64 slice
+func appendFloats(b []byte, vs []float64) []byte {
+	b = binary.AppendUvarint(b, uint64(len(vs)))
+	for _, v := range vs {
+		b = appendFloat(b, v)
+	}
+	return b
+}
+
+// appendString appends a length-prefixed string
+func appendString(b []byte, v string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// decoder reads values from a buffer. The first error sticks and makes
+// every later read return a zero value. Reading past the end of a section
+// payload returns zero values without an error, so older payloads decode
+// cleanly into newer structs.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) == 0 {
+		d.fail()
+		return 0
+	}
+	v := d.buf[0]
+	d.buf = d.buf[1:]
+	return v
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil || n < 0 || len(d.buf) < n {
+		d.fail()
+		return nil
+	}
+	v := d.buf[:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil || len(d.buf) == 0 {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil || len(d.buf) == 0 {
+		d.fail()
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// flag reads a presence byte; past the end of a payload it is false
+func (d *decoder) flag() bool {
+	if d.err != nil || len(d.buf) == 0 {
+		return false
+	}
+	return d.byte() != 0
+}
+
+func (d *decoder) float() float64 {
+	if d.err != nil || len(d.buf) == 0 {
+		return 0
+	}
+	if len(d.buf) < 8 {
+		d.fail()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) floats() []float64 {
+	n := d.uvarint()
+	if n == 0 || d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)/8) {
+		d.fail()
+		return nil
+	}
+	vs := make([]float64, n)
+	for i := range vs {
+		vs[i] = d.float()
+	}
+	return vs
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if n == 0 || d.err != nil {
+		return ""
+	}
+	return string(d.bytes(int(min(n, uint64(len(d.buf)+1)))))
+}
+
+// count reads a slice length, rejecting lengths that cannot possibly fit
+// in the remaining buffer (every element takes at least one byte)
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ErrShortBuffer
+	}
+}
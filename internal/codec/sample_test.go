@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// fullSample has every section and every optional part set
+func fullSample() collect.Sample {
+	at := time.Unix(1700000000, 123456789)
+	return collect.Sample{
+		Timestamp: at,
+		CPU: &collect.CPUMetric{
+			OverallPercent: 12.5,
+			PerCorePercent: []float64{10, 15},
+			LoadAverage:    []float64{0.5, 0.25, 0.125},
+		},
+		Memory: &collect.MemoryMetric{
+			TotalBytes: 8 << 30, AvailableBytes: 4 << 30, UsedBytes: 4 << 30, UsedPercent: 50,
+			SwapTotalBytes: 1 << 30, SwapUsedBytes: 1 << 20, SwapUsedPercent: 0.1,
+		},
+		Disk: &collect.DiskMetric{
+			TotalBytes: 100 << 30, FreeBytes: 60 << 30, UsedBytes: 40 << 30, UsedPercent: 40,
+			Mountpoints: []collect.MountpointUsage{
+				{Mountpoint: "/", TotalBytes: 100 << 30, FreeBytes: 60 << 30, UsedBytes: 40 << 30, UsedPercent: 40},
+				{Mountpoint: "/data", TotalBytes: 1 << 40, FreeBytes: 1 << 39, UsedBytes: 1 << 39, UsedPercent: 50},
+			},
+			ReadBytes: 1, WriteBytes: 2, ReadOps: 3, WriteOps: 4,
+			Rates: &collect.DiskRates{ReadBytes: 1.5, WriteBytes: 2.5, ReadOps: 3.5, WriteOps: 4.5},
+		},
+		Network: &collect.NetworkMetric{
+			BytesSent: 1<<64 - 1, BytesRecv: 2, PacketsSent: 3, PacketsRecv: 4,
+			ErrorsIn: 5, ErrorsOut: 6, DropsIn: 7, DropsOut: 8,
+			Interfaces: []collect.InterfaceMetric{{Interface: "eth0", BytesSent: 9, BytesRecv: 10}},
+			Rates: &collect.NetworkRates{
+				BytesSent: 100, BytesRecv: 200, ErrorsIn: 0.5,
+				Interfaces: []collect.InterfaceRates{{Interface: "eth0", BytesSent: 100}},
+			},
+		},
+		Status: map[string]collect.SectionStatus{
+			"cpu":  {Status: collect.StatusOK, CollectedAt: at.Add(-time.Second)},
+			"disk": {Status: collect.StatusOK, CollectedAt: at.Add(-2 * time.Second)},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := map[string]collect.Sample{
+		"full":        fullSample(),
+		"empty":       {Timestamp: time.Unix(0, 1)},
+		"memory only": {Timestamp: time.Unix(1700000000, 0), Memory: &collect.MemoryMetric{TotalBytes: 1}},
+		"no rates": func() collect.Sample {
+			s := fullSample()
+			s.Disk.Rates, s.Network.Rates = nil, nil
+			return s
+		}(),
+	}
+	for name, sample := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := UnmarshalSample(AppendSample(nil, sample))
+			if err != nil {
+				t.Fatalf("UnmarshalSample() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, sample) {
+				t.Errorf("UnmarshalSample() = %+v, want %+v", got, sample)
+			}
+		})
+	}
+}
+
+func TestUnknownSectionSkipped(t *testing.T) {
+	sample := fullSample()
+	data := AppendSample(nil, sample)
+	data = appendSection(data, 99, []byte{1, 2, 3})
+
+	got, err := UnmarshalSample(data)
+	if err != nil {
+		t.Fatalf("UnmarshalSample() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, sample) {
+		t.Errorf("UnmarshalSample() = %+v, want %+v", got, sample)
+	}
+}
+
+func TestOlderEncoderFieldsDefault(t *testing.T) {
+	// A disk section written before mountpoints and rates existed
+	var sec []byte
+	for _, v := range []uint64{100, 60, 40} {
+		sec = binary.AppendUvarint(sec, v)
+	}
+	sec = appendFloat(sec, 40)
+	data := binary.AppendVarint([]byte{Version}, 1)
+	data = appendSection(data, sectionDisk, sec)
+
+	got, err := UnmarshalSample(data)
+	if err != nil {
+		t.Fatalf("UnmarshalSample() error = %v", err)
+	}
+	want := &collect.DiskMetric{TotalBytes: 100, FreeBytes: 60, UsedBytes: 40, UsedPercent: 40}
+	if !reflect.DeepEqual(got.Disk, want) {
+		t.Errorf("Disk = %+v, want %+v", got.Disk, want)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := AppendSample(nil, fullSample())
+	for n := 0; n < len(data); n++ {
+		// Every prefix must decode without panicking; one that cuts a
+		// section short must fail
+		_, err := UnmarshalSample(data[:n])
+		if n == 0 && !errors.Is(err, ErrShortBuffer) {
+			t.Errorf("UnmarshalSample(empty) error = %v, want ErrShortBuffer", err)
+		}
+	}
+	if _, err := UnmarshalSample(data[:len(data)-1]); err == nil {
+		t.Error("UnmarshalSample() of a cut section succeeded")
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	data := AppendSample(nil, fullSample())
+	data[0] = Version + 1
+	if _, err := UnmarshalSample(data); err == nil {
+		t.Error("UnmarshalSample() accepted an unknown version")
+	}
+}
@@ -2,23 +2,33 @@ package collect
 
 import (
 	"context"
-	"log"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/load"
 )
 
+// CPUOptions configures the CPU collector
+type CPUOptions struct {
+	PerCore      bool          // Also collect per-core percentages
+	SampleWindow time.Duration // Time over which usage is measured
+}
+
 // CPUCollector collects CPU usage metrics
 type CPUCollector struct {
 	interval time.Duration // How often to collect metrics
+	opts     CPUOptions    // Collection options
 	output   chan<- Metric // Channel to send metrics to
 }
 
 // NewCPUCollector creates a new CPU collector
-func NewCPUCollector(interval time.Duration, output chan<- Metric) *CPUCollector {
+func NewCPUCollector(interval time.Duration, opts CPUOptions, output chan<- Metric) *CPUCollector {
+	if opts.SampleWindow <= 0 {
+		opts.SampleWindow = time.Second
+	}
 	return &CPUCollector{
 		interval: interval,
+		opts:     opts,
 		output:   output,
 	}
 }
@@ -30,56 +40,50 @@ func (c *CPUCollector) Start(ctx context.Context) {
 	ticker := time.NewTicker(c.interval)
 	defer ticker.Stop() // Clean up ticker when function exits
 
-	log.Printf("CPU collector started with interval %v", c.interval)
+	logger.Info("CPU collector started", "interval", c.interval.String())
 
 	// Infinite loop until context is cancelled
 	for {
 		select {
 		case <-ctx.Done():
 			// Context was cancelled - shut down gracefully
-			log.Println("CPU collector stopping...")
+			logger.Info("CPU collector stopping")
 			return
 		case <-ticker.C:
 			// Ticker fired - collect metrics
 			metric, err := c.collectCPUMetrics()
 			if err != nil {
-				log.Printf("Error collecting CPU metrics: %v", err)
+				sendError(c.output, "cpu", c.period(), err)
 				continue // Skip this collection cycle
 			}
 
-			// Try to send metric to output channel
-			select {
-			case c.output <- metric:
-				// Successfully sent
-			case <-ctx.Done():
-				// Context cancelled while trying to send
-				return
-			default:
-				// Channel is full - drop this metric to prevent blocking
-				log.Println("CPU metric dropped: output channel full")
-			}
+			// Send metric to output channel (non-blocking)
+			send(c.output, metric)
 		}
 	}
 }
 
 // collectCPUMetrics gathers CPU usage data using gopsutil
 func (c *CPUCollector) collectCPUMetrics() (Metric, error) {
-	// Get overall CPU percentage (1-second sampling)
-	overallPercent, err := cpu.Percent(time.Second, false)
+	// Get overall CPU percentage
+	overallPercent, err := cpu.Percent(c.opts.SampleWindow, false)
 	if err != nil {
 		return Metric{}, err
 	}
 
-	// Get per-core CPU percentages (1-second sampling)
-	perCorePercent, err := cpu.Percent(time.Second, true)
-	if err != nil {
-		return Metric{}, err
+	// Get per-core CPU percentages (if enabled)
+	var perCorePercent []float64
+	if c.opts.PerCore {
+		perCorePercent, err = cpu.Percent(c.opts.SampleWindow, true)
+		if err != nil {
+			return Metric{}, err
+		}
 	}
 
 	// Get load averages (1, 5, 15 minutes)
 	loadAvg, err := load.Avg()
 	if err != nil {
-		log.Printf("Warning: could not get load average: %v", err)
+		warnings.Warn("Could not get load average", "error", err)
 		// Don't fail completely if load average unavailable
 		loadAvg = &load.AvgStat{Load1: 0, Load5: 0, Load15: 0}
 	}
@@ -95,6 +99,21 @@ func (c *CPUCollector) collectCPUMetrics() (Metric, error) {
 	return Metric{
 		Type:      "cpu",
 		Timestamp: time.Now(),
+		Interval:  c.period(),
 		Data:      cpuMetric,
 	}, nil
 }
+
+// period returns how often metrics are reported. Measuring usage blocks for
+// the sample window (twice with per-core stats), so a window longer than the
+// interval makes the ticker skip ticks.
+func (c *CPUCollector) period() time.Duration {
+	busy := c.opts.SampleWindow
+	if c.opts.PerCore {
+		busy *= 2
+	}
+	if busy > c.interval {
+		return busy.Truncate(c.interval) + c.interval
+	}
+	return c.interval
+}
@@ -2,23 +2,46 @@ package collect
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/disk"
 )
 
+// diskUsageTimeout bounds a single usage call. statfs on a hung network
+// mount blocks indefinitely and can't be cancelled.
+const diskUsageTimeout = 5 * time.Second
+
+// DiskOptions configures the disk collector
+type DiskOptions struct {
+	Mountpoints []string // Mountpoints to report usage for; the first also fills the top-level fields
+}
+
 // DiskCollector collects disk usage and I/O metrics
 type DiskCollector struct {
 	interval time.Duration
+	opts     DiskOptions
 	output   chan<- Metric
+
+	// Mountpoints with a usage call that timed out and hasn't returned yet;
+	// they are skipped so hung calls don't pile up
+	mu      sync.Mutex
+	pending map[string]bool
 }
 
 // NewDiskCollector creates a new disk collector
-func NewDiskCollector(interval time.Duration, output chan<- Metric) *DiskCollector {
+func NewDiskCollector(interval time.Duration, opts DiskOptions, output chan<- Metric) *DiskCollector {
+	if len(opts.Mountpoints) == 0 {
+		opts.Mountpoints = []string{"/"}
+	}
 	return &DiskCollector{
 		interval: interval,
+		opts:     opts,
 		output:   output,
+		pending:  make(map[string]bool),
 	}
 }
 
@@ -33,7 +56,7 @@ func (d *DiskCollector) Start(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Disk collector stopping...")
+			logger.Info("Disk collector stopping")
 			return
 		case <-ticker.C:
 			d.collectAndSend()
@@ -43,17 +66,37 @@ func (d *DiskCollector) Start(ctx context.Context) {
 
 // collectAndSend gathers disk metrics and sends them through the channel
 func (d *DiskCollector) collectAndSend() {
-	// Get disk usage for root filesystem
-	usage, err := disk.Usage("/")
-	if err != nil {
-		log.Printf("Error collecting disk usage metrics: %v", err)
-		return
+	// Get disk usage for every configured mountpoint. The first one fills
+	// the top-level fields, so the section fails without it; any other that
+	// can't be read, e.g. a hung network mount, is left out and reported in
+	// the section's status message.
+	var mountpoints []MountpointUsage
+	var skipped []string
+	for i, path := range d.opts.Mountpoints {
+		usage, err := d.usage(path)
+		if err != nil {
+			err = fmt.Errorf("usage of %s: %w", path, err)
+			if i == 0 {
+				sendError(d.output, "disk", d.interval, err)
+				return
+			}
+			warnings.Warn("Skipping unreadable mountpoint", "mountpoint", path, "error", err)
+			skipped = append(skipped, err.Error())
+			continue
+		}
+		mountpoints = append(mountpoints, MountpointUsage{
+			Mountpoint:  path,
+			TotalBytes:  usage.Total,
+			FreeBytes:   usage.Free,
+			UsedBytes:   usage.Used,
+			UsedPercent: usage.UsedPercent,
+		})
 	}
 
 	// Get disk I/O statistics
 	ioCounters, err := disk.IOCounters()
 	if err != nil {
-		log.Printf("Error collecting disk I/O metrics: %v", err)
+		sendError(d.output, "disk", d.interval, fmt.Errorf("I/O counters: %w", err))
 		return
 	}
 
@@ -68,11 +111,12 @@ func (d *DiskCollector) collectAndSend() {
 
 	// Create disk metric
 	diskMetric := DiskMetric{
-		// Disk Usage
-		TotalBytes:  usage.Total,
-		FreeBytes:   usage.Free,
-		UsedBytes:   usage.Used,
-		UsedPercent: usage.UsedPercent,
+		// Disk Usage (first mountpoint)
+		TotalBytes:  mountpoints[0].TotalBytes,
+		FreeBytes:   mountpoints[0].FreeBytes,
+		UsedBytes:   mountpoints[0].UsedBytes,
+		UsedPercent: mountpoints[0].UsedPercent,
+		Mountpoints: mountpoints,
 
 		// Disk I/O
 		ReadBytes:  totalReadBytes,
@@ -81,19 +125,47 @@ func (d *DiskCollector) collectAndSend() {
 		WriteOps:   totalWriteOps,
 	}
 
-	// Create metric wrapper
-	metric := Metric{
+	// Send metric (non-blocking)
+	send(d.output, Metric{
 		Type:      "disk",
 		Timestamp: time.Now(),
+		Interval:  d.interval,
 		Data:      diskMetric,
+		Warning:   strings.Join(skipped, "; "),
+	})
+}
+
+// usage returns the usage of one mountpoint, giving up after
+// diskUsageTimeout. A call that times out keeps running in the background
+// and the mountpoint is skipped until it returns.
+func (d *DiskCollector) usage(path string) (*disk.UsageStat, error) {
+	d.mu.Lock()
+	if d.pending[path] {
+		d.mu.Unlock()
+		return nil, errors.New("an earlier call has not returned")
+	}
+	d.pending[path] = true
+	d.mu.Unlock()
+
+	type result struct {
+		usage *disk.UsageStat
+		err   error
 	}
+	done := make(chan result, 1)
+	go func() {
+		usage, err := disk.Usage(path)
+		d.mu.Lock()
+		delete(d.pending, path)
+		d.mu.Unlock()
+		done <- result{usage, err}
+	}()
 
-	// Try to send metric (non-blocking)
+	timer := time.NewTimer(diskUsageTimeout)
+	defer timer.Stop()
 	select {
-	case d.output <- metric:
-		// Successfully sent
-	default:
-		// Channel full, drop metric
-		log.Println("Disk metrics channel full, dropping metric")
+	case r := <-done:
+		return r.usage, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("timed out after %v", diskUsageTimeout)
 	}
 }
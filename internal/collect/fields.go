@@ -0,0 +1,155 @@
+package collect
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field is a single numeric value in a sample, named by its JSON path,
+// e.g. "memory.used_percent" or "cpu.per_core_percent" with core="3"
+type Field struct {
+	Name   string            // Dotted JSON path
+	Labels map[string]string // Labels for values inside slices, nil otherwise
+	Value  float64
+}
+
+// Key returns a unique series key for the field in Prometheus notation,
+// e.g. `cpu.per_core_percent{core="3"}`
+func (f Field) Key() string {
+	if len(f.Labels) == 0 {
+		return f.Name
+	}
+
+	keys := make([]string, 0, len(f.Labels))
+	for k := range f.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(f.Name)
+	sb.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteString(`="`)
+		sb.WriteString(f.Labels[k])
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// Fields flattens every numeric value in the sample. Field names follow
+// the JSON tags, and values inside slices get a label named by the slice's
+// `label` struct tag, e.g. `label:"core"`. The label value is the index,
+// the element's string field of the same name (`label:"mountpoint"`), or
+// one of a fixed list (`label:"period=1m,5m,15m"`).
+func (s Sample) Fields() []Field {
+	var fields []Field
+	v := reflect.ValueOf(s)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		fields = appendFields(fields, name, v.Field(i), t.Field(i).Tag.Get("label"), nil)
+	}
+	return fields
+}
+
+// appendFields walks v and appends every numeric value below it
+func appendFields(fields []Field, name string, v reflect.Value, labelTag string, labels map[string]string) []Field {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return append(fields, Field{Name: name, Labels: labels, Value: v.Float()})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return append(fields, Field{Name: name, Labels: labels, Value: float64(v.Int())})
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return append(fields, Field{Name: name, Labels: labels, Value: float64(v.Uint())})
+	case reflect.Pointer:
+		if v.IsNil() {
+			return fields
+		}
+		return appendFields(fields, name, v.Elem(), labelTag, labels)
+	case reflect.Slice:
+		key, values, _ := strings.Cut(labelTag, "=")
+		if key == "" {
+			key = "index"
+		}
+		fixed := strings.Split(values, ",")
+		for i := 0; i < v.Len(); i++ {
+			value := strconv.Itoa(i)
+			if values != "" && i < len(fixed) {
+				value = fixed[i]
+			} else if name, ok := labelValue(v.Index(i), key); ok {
+				value = name
+			}
+			fields = appendFields(fields, name, v.Index(i), "", withLabel(labels, key, value))
+		}
+		return fields
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return fields // Timestamps are not metric values
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sub := jsonName(t.Field(i))
+			if sub == "" {
+				continue
+			}
+			fields = appendFields(fields, name+"."+sub, v.Field(i), t.Field(i).Tag.Get("label"), labels)
+		}
+		return fields
+	}
+	return fields
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// labelValue returns the value of the string field named key (by JSON
+// name) when v is a struct, e.g. the "mountpoint" of a MountpointUsage
+func labelValue(v reflect.Value, key string) (string, bool) {
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == key && v.Field(i).Kind() == reflect.String {
+			return v.Field(i).String(), true
+		}
+	}
+	return "", false
+}
+
+// jsonName returns the JSON name of an exported struct field, or "" if the
+// field is unexported or not serialized
+func jsonName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return name
+}
+
+// withLabel returns a copy of labels with one more label
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	merged := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	merged[key] = value
+	return merged
+}
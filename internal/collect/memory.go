@@ -2,7 +2,7 @@ package collect
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/mem"
@@ -33,7 +33,7 @@ func (m *MemoryCollector) Start(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Memory collector stopping...")
+			logger.Info("Memory collector stopping")
 			return
 		case <-ticker.C:
 			m.collectAndSend()
@@ -46,14 +46,14 @@ func (m *MemoryCollector) collectAndSend() {
 	// Get virtual memory (RAM) statistics
 	vmem, err := mem.VirtualMemory()
 	if err != nil {
-		log.Printf("Error collecting virtual memory metrics: %v", err)
+		sendError(m.output, "memory", m.interval, fmt.Errorf("virtual memory: %w", err))
 		return
 	}
 
 	// Get swap memory statistics
 	swap, err := mem.SwapMemory()
 	if err != nil {
-		log.Printf("Error collecting swap memory metrics: %v", err)
+		sendError(m.output, "memory", m.interval, fmt.Errorf("swap memory: %w", err))
 		return
 	}
 
@@ -71,19 +71,11 @@ func (m *MemoryCollector) collectAndSend() {
 		SwapUsedPercent: swap.UsedPercent,
 	}
 
-	// Create metric wrapper
-	metric := Metric{
+	// Send metric (non-blocking)
+	send(m.output, Metric{
 		Type:      "memory",
 		Timestamp: time.Now(),
+		Interval:  m.interval,
 		Data:      memMetric,
-	}
-
-	// Try to send metric (non-blocking)
-	select {
-	case m.output <- metric:
-		// Successfully sent
-	default:
-		// Channel full, drop metric
-		log.Println("Memory metrics channel full, dropping metric")
-	}
+	})
 }
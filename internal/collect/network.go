@@ -2,22 +2,30 @@ package collect
 
 import (
 	"context"
-	"log"
+	"errors"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/net"
 )
 
+// NetworkOptions configures the network collector
+type NetworkOptions struct {
+	Interfaces   []string // Only include these interfaces (empty = all)
+	PerInterface bool     // Also report statistics per interface
+}
+
 // NetworkCollector collects network interface metrics
 type NetworkCollector struct {
 	interval time.Duration
+	opts     NetworkOptions
 	output   chan<- Metric
 }
 
 // NewNetworkCollector creates a new network collector
-func NewNetworkCollector(interval time.Duration, output chan<- Metric) *NetworkCollector {
+func NewNetworkCollector(interval time.Duration, opts NetworkOptions, output chan<- Metric) *NetworkCollector {
 	return &NetworkCollector{
 		interval: interval,
+		opts:     opts,
 		output:   output,
 	}
 }
@@ -33,7 +41,7 @@ func (n *NetworkCollector) Start(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Network collector stopping...")
+			logger.Info("Network collector stopping")
 			return
 		case <-ticker.C:
 			n.collectAndSend()
@@ -43,47 +51,68 @@ func (n *NetworkCollector) Start(ctx context.Context) {
 
 // collectAndSend gathers network metrics and sends them through the channel
 func (n *NetworkCollector) collectAndSend() {
-	// Get network I/O statistics for all interfaces
-	ioCounters, err := net.IOCounters(false) // false = aggregate all interfaces
+	// Get network I/O statistics per interface
+	ioCounters, err := net.IOCounters(true) // true = one entry per interface
 	if err != nil {
-		log.Printf("Error collecting network metrics: %v", err)
+		sendError(n.output, "network", n.interval, err)
 		return
 	}
 
-	// Since we passed false, we get one aggregated result
 	if len(ioCounters) == 0 {
-		log.Println("No network interfaces found")
+		sendError(n.output, "network", n.interval, errors.New("no network interfaces found"))
 		return
 	}
 
-	// Take the first (and only) aggregated result
-	netStats := ioCounters[0]
+	// Sum the selected interfaces
+	var networkMetric NetworkMetric
+	for _, netStats := range ioCounters {
+		if !n.includeInterface(netStats.Name) {
+			continue
+		}
 
-	// Create network metric
-	networkMetric := NetworkMetric{
-		BytesSent:   netStats.BytesSent,
-		BytesRecv:   netStats.BytesRecv,
-		PacketsSent: netStats.PacketsSent,
-		PacketsRecv: netStats.PacketsRecv,
-		ErrorsIn:    netStats.Errin,
-		ErrorsOut:   netStats.Errout,
-		DropsIn:     netStats.Dropin,
-		DropsOut:    netStats.Dropout,
+		networkMetric.BytesSent += netStats.BytesSent
+		networkMetric.BytesRecv += netStats.BytesRecv
+		networkMetric.PacketsSent += netStats.PacketsSent
+		networkMetric.PacketsRecv += netStats.PacketsRecv
+		networkMetric.ErrorsIn += netStats.Errin
+		networkMetric.ErrorsOut += netStats.Errout
+		networkMetric.DropsIn += netStats.Dropin
+		networkMetric.DropsOut += netStats.Dropout
+
+		networkMetric.Counters = append(networkMetric.Counters, InterfaceMetric{
+			Interface:   netStats.Name,
+			BytesSent:   netStats.BytesSent,
+			BytesRecv:   netStats.BytesRecv,
+			PacketsSent: netStats.PacketsSent,
+			PacketsRecv: netStats.PacketsRecv,
+			ErrorsIn:    netStats.Errin,
+			ErrorsOut:   netStats.Errout,
+			DropsIn:     netStats.Dropin,
+			DropsOut:    netStats.Dropout,
+		})
+	}
+	if n.opts.PerInterface {
+		networkMetric.Interfaces = networkMetric.Counters
 	}
 
-	// Create metric wrapper
-	metric := Metric{
+	// Send metric (non-blocking)
+	send(n.output, Metric{
 		Type:      "network",
 		Timestamp: time.Now(),
+		Interval:  n.interval,
 		Data:      networkMetric,
-	}
+	})
+}
 
-	// Try to send metric (non-blocking)
-	select {
-	case n.output <- metric:
-		// Successfully sent
-	default:
-		// Channel full, drop metric
-		log.Println("Network metrics channel full, dropping metric")
+// includeInterface reports whether an interface passes the configured filter
+func (n *NetworkCollector) includeInterface(name string) bool {
+	if len(n.opts.Interfaces) == 0 {
+		return true
+	}
+	for _, iface := range n.opts.Interfaces {
+		if iface == name {
+			return true
+		}
 	}
+	return false
 }
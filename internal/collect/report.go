@@ -0,0 +1,38 @@
+package collect
+
+import (
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/logging"
+)
+
+var (
+	logger = logging.For("collect")
+
+	// warnings rate limits problems that repeat on every collection
+	warnings = logging.NewLimited(logger, time.Minute)
+)
+
+// send hands a metric to the aggregator without blocking, dropping it if
+// the channel is full
+func send(output chan<- Metric, metric Metric) {
+	select {
+	case output <- metric:
+		// Successfully sent
+	default:
+		// Channel full, drop metric
+		warnings.Warn("Metrics channel full, dropping metric", "type", metric.Type)
+	}
+}
+
+// sendError reports a failed collection so the aggregator marks the
+// section as failed instead of keeping the previous values
+func sendError(output chan<- Metric, metricType string, interval time.Duration, err error) {
+	warnings.Error("Error collecting metrics", "type", metricType, "error", err)
+	send(output, Metric{
+		Type:      metricType,
+		Timestamp: time.Now(),
+		Interval:  interval,
+		Error:     err.Error(),
+	})
+}
@@ -2,18 +2,37 @@ package collect
 
 import "time"
 
-// Metric represents a single metric measurement with timestamp
+// Metric represents a single metric measurement with timestamp.
+// A Metric with neither Data nor Error tells the aggregator that the
+// collector was stopped.
 type Metric struct {
-	Type      string    `json:"type"`      // "cpu", "memory", "disk", "network"
-	Timestamp time.Time `json:"timestamp"` // When the metric was collected
-	Data      any       `json:"data"`      // The actual metric data (CPU, Memory, etc.)
+	Type      string        `json:"type"`              // "cpu", "memory", "disk", "network"
+	Timestamp time.Time     `json:"timestamp"`         // When the metric was collected
+	Interval  time.Duration `json:"interval"`          // How often the collector reports (0 = unknown)
+	Data      any           `json:"data"`              // The actual metric data (CPU, Memory, etc.)
+	Error     string        `json:"error,omitempty"`   // Set instead of Data when collection failed
+	Warning   string        `json:"warning,omitempty"` // Set alongside Data when part of the collection failed
+}
+
+// Section statuses
+const (
+	StatusOK    = "ok"    // Collected within the expected interval
+	StatusStale = "stale" // The collector has not reported for several intervals
+	StatusError = "error" // The last collection failed
+)
+
+// SectionStatus describes the state of one sample section
+type SectionStatus struct {
+	Status      string    `json:"status"`            // StatusOK, StatusStale or StatusError
+	CollectedAt time.Time `json:"collected_at"`      // When the collector last reported
+	Message     string    `json:"message,omitempty"` // Error message, reason for staleness, or what an ok section is missing
 }
 
 // CPUMetric represents CPU usage information
 type CPUMetric struct {
-	OverallPercent float64   `json:"overall_percent"`  // Overall CPU usage percentage
-	PerCorePercent []float64 `json:"per_core_percent"` // CPU usage per core
-	LoadAverage    []float64 `json:"load_average"`     // 1, 5, 15 minute load averages
+	OverallPercent float64   `json:"overall_percent"`                       // Overall CPU usage percentage
+	PerCorePercent []float64 `json:"per_core_percent" label:"core"`         // CPU usage per core
+	LoadAverage    []float64 `json:"load_average" label:"period=1m,5m,15m"` // 1, 5, 15 minute load averages
 }
 
 // MemoryMetric represents memory usage information
@@ -32,17 +51,41 @@ type MemoryMetric struct {
 
 // DiskMetric represents disk usage and I/O information
 type DiskMetric struct {
-	// Disk Usage (for root filesystem)
+	// Disk Usage (for the first configured mountpoint, "/" by default)
 	TotalBytes  uint64  `json:"total_bytes"`  // Total disk space in bytes
 	FreeBytes   uint64  `json:"free_bytes"`   // Free disk space in bytes
 	UsedBytes   uint64  `json:"used_bytes"`   // Used disk space in bytes
 	UsedPercent float64 `json:"used_percent"` // Used disk space percentage
 
+	// Usage of every configured mountpoint that could be read
+	Mountpoints []MountpointUsage `json:"mountpoints,omitempty" label:"mountpoint"`
+
 	// Disk I/O Statistics
 	ReadBytes  uint64 `json:"read_bytes"`  // Bytes read from disk
 	WriteBytes uint64 `json:"write_bytes"` // Bytes written to disk
 	ReadOps    uint64 `json:"read_ops"`    // Number of read operations
 	WriteOps   uint64 `json:"write_ops"`   // Number of write operations
+
+	// Per-second rates of the I/O counters, set by the aggregator from the
+	// previous collection; nil for the first one
+	Rates *DiskRates `json:"rates,omitempty"`
+}
+
+// DiskRates holds per-second rates of the disk I/O counters
+type DiskRates struct {
+	ReadBytes  float64 `json:"read_bytes"`  // Bytes read per second
+	WriteBytes float64 `json:"write_bytes"` // Bytes written per second
+	ReadOps    float64 `json:"read_ops"`    // Read operations per second
+	WriteOps   float64 `json:"write_ops"`   // Write operations per second
+}
+
+// MountpointUsage represents disk usage of a single mountpoint
+type MountpointUsage struct {
+	Mountpoint  string  `json:"mountpoint"`   // Mount path, e.g. "/" or "/data"
+	TotalBytes  uint64  `json:"total_bytes"`  // Total disk space in bytes
+	FreeBytes   uint64  `json:"free_bytes"`   // Free disk space in bytes
+	UsedBytes   uint64  `json:"used_bytes"`   // Used disk space in bytes
+	UsedPercent float64 `json:"used_percent"` // Used disk space percentage
 }
 
 // NetworkMetric represents network interface statistics
@@ -58,21 +101,86 @@ type NetworkMetric struct {
 	ErrorsOut uint64 `json:"errors_out"` // Output errors
 	DropsIn   uint64 `json:"drops_in"`   // Input packet drops
 	DropsOut  uint64 `json:"drops_out"`  // Output packet drops
+
+	// Per-interface statistics (only when enabled)
+	Interfaces []InterfaceMetric `json:"interfaces,omitempty" label:"interface"`
+
+	// Counters of every selected interface, whether or not they are
+	// reported, which the aggregator computes the rates from. It is cleared
+	// before the sample is published.
+	Counters []InterfaceMetric `json:"-"`
+
+	// Per-second rates of the counters, set by the aggregator from the
+	// previous collection; nil for the first one
+	Rates *NetworkRates `json:"rates,omitempty"`
+}
+
+// NetworkRates holds per-second rates of the network counters
+type NetworkRates struct {
+	BytesSent   float64 `json:"bytes_sent"`   // Bytes sent per second
+	BytesRecv   float64 `json:"bytes_recv"`   // Bytes received per second
+	PacketsSent float64 `json:"packets_sent"` // Packets sent per second
+	PacketsRecv float64 `json:"packets_recv"` // Packets received per second
+	ErrorsIn    float64 `json:"errors_in"`    // Input errors per second
+	ErrorsOut   float64 `json:"errors_out"`   // Output errors per second
+	DropsIn     float64 `json:"drops_in"`     // Input packet drops per second
+	DropsOut    float64 `json:"drops_out"`    // Output packet drops per second
+
+	// Interfaces present in both collections
+	Interfaces []InterfaceRates `json:"interfaces,omitempty" label:"interface"`
+}
+
+// InterfaceRates holds per-second rates of one interface's counters
+type InterfaceRates struct {
+	Interface   string  `json:"interface"`
+	BytesSent   float64 `json:"bytes_sent"`
+	BytesRecv   float64 `json:"bytes_recv"`
+	PacketsSent float64 `json:"packets_sent"`
+	PacketsRecv float64 `json:"packets_recv"`
+	ErrorsIn    float64 `json:"errors_in"`
+	ErrorsOut   float64 `json:"errors_out"`
+	DropsIn     float64 `json:"drops_in"`
+	DropsOut    float64 `json:"drops_out"`
+}
+
+// InterfaceMetric represents statistics of a single network interface
+type InterfaceMetric struct {
+	Interface   string `json:"interface"`    // Interface name, e.g. "eth0"
+	BytesSent   uint64 `json:"bytes_sent"`   // Bytes sent
+	BytesRecv   uint64 `json:"bytes_recv"`   // Bytes received
+	PacketsSent uint64 `json:"packets_sent"` // Packets sent
+	PacketsRecv uint64 `json:"packets_recv"` // Packets received
+	ErrorsIn    uint64 `json:"errors_in"`    // Input errors
+	ErrorsOut   uint64 `json:"errors_out"`   // Output errors
+	DropsIn     uint64 `json:"drops_in"`     // Input packet drops
+	DropsOut    uint64 `json:"drops_out"`    // Output packet drops
 }
 
 // Sample represents a complete snapshot of all metrics at a point in time
-// This is what gets sent to clients and stored as "latest"
+// This is what gets sent to clients and stored as "latest".
+// A section is nil (null in JSON) unless its status is ok: when its collector
+// is disabled, has not reported yet, failed or went stale.
 type Sample struct {
-	Timestamp time.Time     `json:"timestamp"` // When this sample was created
-	CPU       CPUMetric     `json:"cpu"`       // CPU metrics
-	Memory    MemoryMetric  `json:"memory"`    // Memory metrics
-	Disk      DiskMetric    `json:"disk"`      // Disk metrics
-	Network   NetworkMetric `json:"network"`   // Network metrics
+	Timestamp time.Time      `json:"timestamp"` // When this sample was created
+	CPU       *CPUMetric     `json:"cpu"`       // CPU metrics
+	Memory    *MemoryMetric  `json:"memory"`    // Memory metrics
+	Disk      *DiskMetric    `json:"disk"`      // Disk metrics
+	Network   *NetworkMetric `json:"network"`   // Network metrics
+
+	// Status of every enabled collector's section, keyed by metric type
+	Status map[string]SectionStatus `json:"status,omitempty"`
 }
 
-// IsComplete checks if a sample has all required metrics
+// IsComplete checks if a sample has all required metrics: it has a
+// timestamp and every enabled collector's section is ok
 func (s *Sample) IsComplete() bool {
-	// For now, we consider a sample complete if it has a timestamp
-	// Later we might add more sophisticated validation
-	return !s.Timestamp.IsZero()
+	if s.Timestamp.IsZero() {
+		return false
+	}
+	for _, status := range s.Status {
+		if status.Status != StatusOK {
+			return false
+		}
+	}
+	return true
 }
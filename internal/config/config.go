@@ -0,0 +1,756 @@
+// Package config loads GoMetrics configuration from a YAML file and
+// environment variables.
+//
+// Loading is strict: unknown keys, malformed values and invalid settings all
+// fail with an error naming the offending key, instead of falling back to
+// defaults.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dirshaye/GoMetrics/internal/query"
+)
+
+// Config is the complete GoMetrics configuration
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Collectors CollectorsConfig `yaml:"collectors"`
+	History    HistoryConfig    `yaml:"history"`
+	Sinks      SinksConfig      `yaml:"sinks"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Admin      AdminConfig      `yaml:"admin"`
+	Logging    LoggingConfig    `yaml:"logging"`
+	Hub        HubConfig        `yaml:"hub"`
+	Analysis   AnalysisConfig   `yaml:"analysis"`
+	Alerts     AlertsConfig     `yaml:"alerts"`
+}
+
+// AlertsConfig holds alert rules evaluated against the samples
+type AlertsConfig struct {
+	Interval Duration          `yaml:"interval"` // How often rules are evaluated
+	Rules    []AlertRuleConfig `yaml:"rules"`
+}
+
+// AlertRuleConfig is one alert rule. It fires for every element of its
+// expression's result, once that element has been present for For.
+type AlertRuleConfig struct {
+	Name        string            `yaml:"name"`        // e.g. "DiskAlmostFull"
+	Expr        string            `yaml:"expr"`        // Query, e.g. disk.mountpoints.used_percent > 90
+	For         Duration          `yaml:"for"`         // How long a result must persist before firing
+	Labels      map[string]string `yaml:"labels"`      // Added to the alert, e.g. {severity: page}
+	Annotations map[string]string `yaml:"annotations"` // Free text, e.g. {summary: ...}
+}
+
+// AnalysisConfig configures optional analyses of the samples
+type AnalysisConfig struct {
+	Anomaly  AnomalyConfig  `yaml:"anomaly"`
+	Forecast ForecastConfig `yaml:"forecast"`
+}
+
+// AnomalyConfig configures anomaly detection
+type AnomalyConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Fields    []string `yaml:"fields"`     // Fields or sections to learn baselines for
+	HalfLife  Duration `yaml:"half_life"`  // How fast the short-term baseline forgets
+	Threshold float64  `yaml:"threshold"`  // Score, in standard deviations, at which a value is anomalous
+	Warmup    Duration `yaml:"warmup"`     // A field is scored once its baseline is this old
+	Seasonal  bool     `yaml:"seasonal"`   // Also learn a baseline per hour of day
+	MaxEvents int      `yaml:"max_events"` // Events kept for /api/v1/anomalies
+	Expiry    Duration `yaml:"expiry"`     // Baselines of fields not seen for this long are dropped
+}
+
+// ForecastConfig configures time-until-full forecasts
+type ForecastConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Fields     []string `yaml:"fields"`      // Percentage fields or sections to forecast
+	Method     string   `yaml:"method"`      // "linear" or "theil_sen"
+	Lookback   Duration `yaml:"lookback"`    // History fitted
+	MinHistory Duration `yaml:"min_history"` // Fields with a shorter history are not forecast
+	Interval   Duration `yaml:"interval"`    // How often forecasts are recomputed
+}
+
+// HubConfig configures hub mode, where the server aggregates samples from
+// other GoMetrics agents
+type HubConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	Listen       string            `yaml:"listen"`        // host:port for agents pushing over TCP; empty disables
+	DownAfter    Duration          `yaml:"down_after"`    // A host is down when it has not reported for this long
+	HistorySize  int               `yaml:"history_size"`  // Samples kept per host
+	MaxHosts     int               `yaml:"max_hosts"`     // Pushes from further hosts are rejected
+	PullInterval Duration          `yaml:"pull_interval"` // How often targets are pulled
+	Targets      []HubTargetConfig `yaml:"targets"`
+}
+
+// HubTargetConfig is an agent the hub pulls /api/v1/metrics/latest from
+type HubTargetConfig struct {
+	ID     string            `yaml:"id"`
+	URL    string            `yaml:"url"`   // Base URL, e.g. http://web-1:8080
+	Token  string            `yaml:"token"` // Bearer token with the read scope
+	Labels map[string]string `yaml:"labels"`
+}
+
+// LoggingConfig configures log output
+type LoggingConfig struct {
+	Format string            `yaml:"format"` // "text" or "json"
+	Level  string            `yaml:"level"`  // "debug", "info", "warn" or "error"
+	Levels map[string]string `yaml:"levels"` // Per-subsystem levels, e.g. {agg: debug, http: warn}
+}
+
+// AdminConfig configures the admin/debug listener
+type AdminConfig struct {
+	Addr string `yaml:"addr"` // e.g. "localhost:6060"; empty disables the listener
+}
+
+// ServerConfig holds HTTP server and aggregation settings
+type ServerConfig struct {
+	Port           int       `yaml:"port"`
+	SampleInterval Duration  `yaml:"sample_interval"` // How often samples are created
+	BufferSize     int       `yaml:"buffer_size"`     // Collector -> aggregator channel size
+	SinkQueueSize  int       `yaml:"sink_queue_size"` // Per-sink queue size
+	HealthPort     int       `yaml:"health_port"`     // Plain HTTP port for /healthz and /readyz (0 = disabled)
+	TLS            TLSConfig `yaml:"tls"`
+
+	// Connection limits; these protect against slow clients (slowloris)
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout"` // Time to read request headers
+	ReadTimeout       Duration `yaml:"read_timeout"`        // Time to read the whole request
+	WriteTimeout      Duration `yaml:"write_timeout"`       // Time to write the response
+	IdleTimeout       Duration `yaml:"idle_timeout"`        // Keep-alive connections are closed after this
+	MaxHeaderBytes    int      `yaml:"max_header_bytes"`
+	ShutdownTimeout   Duration `yaml:"shutdown_timeout"` // Time allowed for draining requests and flushing sinks
+
+	Limits LimitsConfig `yaml:"limits"`
+}
+
+// LimitsConfig limits API requests per client
+type LimitsConfig struct {
+	Rate               float64 `yaml:"rate"`                // Requests per second per client IP (0 = unlimited)
+	Burst              int     `yaml:"burst"`               // Requests a client may make at once
+	HistoryConcurrency int     `yaml:"history_concurrency"` // Concurrent /metrics/history requests
+	MaxStreams         int     `yaml:"max_streams"`         // Concurrent /metrics/stream clients
+}
+
+// TLSConfig enables HTTPS on the main port
+type TLSConfig struct {
+	CertFile       string   `yaml:"cert_file"` // Empty serves plain HTTP
+	KeyFile        string   `yaml:"key_file"`
+	ClientCAFile   string   `yaml:"client_ca_file"`  // Verify client certificates against this bundle
+	ClientAuth     string   `yaml:"client_auth"`     // "none", "optional" or "require"
+	MinVersion     string   `yaml:"min_version"`     // "1.2" or "1.3"
+	CipherSuites   []string `yaml:"cipher_suites"`   // TLS 1.2 suites by IANA name (empty = Go defaults)
+	ReloadInterval Duration `yaml:"reload_interval"` // How often certificate files are checked for changes
+}
+
+// CollectorsConfig holds per-collector settings
+type CollectorsConfig struct {
+	CPU     CPUConfig     `yaml:"cpu"`
+	Memory  MemoryConfig  `yaml:"memory"`
+	Disk    DiskConfig    `yaml:"disk"`
+	Network NetworkConfig `yaml:"network"`
+}
+
+// CPUConfig configures the CPU collector
+type CPUConfig struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled"`
+	Interval     Duration `yaml:"interval" json:"interval"`
+	PerCore      bool     `yaml:"per_core" json:"per_core"`           // Collect per-core percentages
+	SampleWindow Duration `yaml:"sample_window" json:"sample_window"` // Time over which usage is measured
+}
+
+// MemoryConfig configures the memory collector
+type MemoryConfig struct {
+	Enabled  bool     `yaml:"enabled" json:"enabled"`
+	Interval Duration `yaml:"interval" json:"interval"`
+}
+
+// DiskConfig configures the disk collector
+type DiskConfig struct {
+	Enabled     bool     `yaml:"enabled" json:"enabled"`
+	Interval    Duration `yaml:"interval" json:"interval"`
+	Mountpoints []string `yaml:"mountpoints" json:"mountpoints"` // The first one fills the top-level usage fields
+}
+
+// NetworkConfig configures the network collector
+type NetworkConfig struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled"`
+	Interval     Duration `yaml:"interval" json:"interval"`
+	Interfaces   []string `yaml:"interfaces" json:"interfaces"`       // Only include these interfaces (empty = all)
+	PerInterface bool     `yaml:"per_interface" json:"per_interface"` // Report statistics per interface
+}
+
+// HistoryConfig holds rollup and persistent store settings
+type HistoryConfig struct {
+	RollupTiers []RollupTierConfig `yaml:"rollup_tiers"`
+	Store       StoreConfig        `yaml:"store"`
+	Stats       StatsConfig        `yaml:"stats"`
+}
+
+// StatsConfig configures sliding-window statistics
+type StatsConfig struct {
+	Windows    []Duration `yaml:"windows"`    // Empty disables the statistics
+	Fields     []string   `yaml:"fields"`     // Fields or sections to keep statistics for
+	Prometheus bool       `yaml:"prometheus"` // Also export them as gometrics_window_* gauges
+}
+
+// RollupTierConfig configures one rollup tier
+type RollupTierConfig struct {
+	Resolution Duration `yaml:"resolution"`
+	Retention  Duration `yaml:"retention"`
+}
+
+// StoreConfig configures the on-disk sample store
+type StoreConfig struct {
+	Dir             string   `yaml:"dir"` // Empty disables the store
+	SegmentDuration Duration `yaml:"segment_duration"`
+	Retention       Duration `yaml:"retention"`
+	MaxMB           int      `yaml:"max_mb"`
+}
+
+// SinksConfig holds settings for optional outputs
+type SinksConfig struct {
+	StatsD StatsDConfig `yaml:"statsd"`
+	Influx InfluxConfig `yaml:"influx"`
+	Push   PushConfig   `yaml:"push"`
+}
+
+// StatsDConfig configures the StatsD output
+type StatsDConfig struct {
+	Address       string   `yaml:"address"` // Empty disables the sink
+	Prefix        string   `yaml:"prefix"`
+	DogStatsD     bool     `yaml:"dogstatsd"`
+	Tags          []string `yaml:"tags"`
+	MaxPacketSize int      `yaml:"max_packet_size"`
+}
+
+// InfluxConfig configures the InfluxDB output
+type InfluxConfig struct {
+	URL           string   `yaml:"url"` // Empty disables the sink
+	Org           string   `yaml:"org"`
+	Bucket        string   `yaml:"bucket"`
+	Token         string   `yaml:"token"`
+	BatchSize     int      `yaml:"batch_size"`
+	FlushInterval Duration `yaml:"flush_interval"`
+	MaxRetries    int      `yaml:"max_retries"`
+	Gzip          bool     `yaml:"gzip"`
+}
+
+// PushConfig configures agent mode: pushing every sample to a hub
+type PushConfig struct {
+	Address    string            `yaml:"address"` // The hub's push listener, host:port; empty disables the sink
+	HostID     string            `yaml:"host_id"` // Defaults to the hostname
+	Labels     map[string]string `yaml:"labels"`
+	Token      string            `yaml:"token"` // Bearer token with the push scope
+	TLS        bool              `yaml:"tls"`
+	CAFile     string            `yaml:"ca_file"`     // Verify the hub against this bundle instead of the system roots
+	MaxPending int               `yaml:"max_pending"` // Samples kept while the hub is unreachable
+}
+
+// AuthConfig lists the credentials accepted by the HTTP API. With none
+// configured the API is open.
+type AuthConfig struct {
+	Tokens      []TokenConfig      `yaml:"tokens"`
+	Users       []UserConfig       `yaml:"users"`
+	ClientCerts []ClientCertConfig `yaml:"client_certs"`
+}
+
+// grants reports whether any credential has scope, directly or through
+// the admin scope
+func (a AuthConfig) grants(scope string) bool {
+	var lists [][]string
+	for _, t := range a.Tokens {
+		lists = append(lists, t.Scopes)
+	}
+	for _, u := range a.Users {
+		lists = append(lists, u.Scopes)
+	}
+	for _, c := range a.ClientCerts {
+		lists = append(lists, c.Scopes)
+	}
+	for _, scopes := range lists {
+		for _, s := range scopes {
+			if s == scope || s == "admin" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TokenConfig is a static bearer token
+type TokenConfig struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Scopes []string `yaml:"scopes"` // "read", "scrape", "push", "admin"
+}
+
+// UserConfig is an HTTP basic auth user
+type UserConfig struct {
+	Username     string   `yaml:"username"`
+	PasswordHash string   `yaml:"password_hash"` // bcrypt, see -hash-password
+	Scopes       []string `yaml:"scopes"`
+}
+
+// ClientCertConfig grants scopes to verified TLS client certificates
+type ClientCertConfig struct {
+	CommonName string   `yaml:"common_name"`
+	Scopes     []string `yaml:"scopes"`
+}
+
+// Default returns the built-in configuration
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:           8080,
+			SampleInterval: Duration(250 * time.Millisecond),
+			BufferSize:     100,
+			SinkQueueSize:  64,
+
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(15 * time.Second),
+			WriteTimeout:      Duration(30 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			MaxHeaderBytes:    64 << 10,
+			ShutdownTimeout:   Duration(10 * time.Second),
+			Limits:            LimitsConfig{Rate: 20, Burst: 40, HistoryConcurrency: 4, MaxStreams: 100},
+
+			TLS: TLSConfig{
+				MinVersion:     "1.2",
+				ReloadInterval: Duration(30 * time.Second),
+			},
+		},
+		Collectors: CollectorsConfig{
+			CPU:     CPUConfig{Enabled: true, Interval: Duration(5 * time.Second), PerCore: true, SampleWindow: Duration(time.Second)},
+			Memory:  MemoryConfig{Enabled: true, Interval: Duration(5 * time.Second)},
+			Disk:    DiskConfig{Enabled: true, Interval: Duration(5 * time.Second), Mountpoints: []string{"/"}},
+			Network: NetworkConfig{Enabled: true, Interval: Duration(5 * time.Second)},
+		},
+		History: HistoryConfig{
+			RollupTiers: []RollupTierConfig{
+				{Resolution: Duration(10 * time.Second), Retention: Duration(6 * time.Hour)},
+				{Resolution: Duration(time.Minute), Retention: Duration(48 * time.Hour)},
+				{Resolution: Duration(time.Hour), Retention: Duration(30 * 24 * time.Hour)},
+			},
+			Store: StoreConfig{
+				SegmentDuration: Duration(time.Hour),
+				Retention:       Duration(7 * 24 * time.Hour),
+				MaxMB:           1024,
+			},
+			Stats: StatsConfig{
+				Windows: []Duration{Duration(time.Minute), Duration(5 * time.Minute), Duration(15 * time.Minute)},
+				Fields: []string{
+					"cpu.overall_percent",
+					"memory.used_percent",
+					"disk.used_percent",
+					"disk.rates.read_ops",
+					"disk.rates.write_ops",
+					"network.rates.bytes_recv",
+					"network.rates.bytes_sent",
+				},
+			},
+		},
+		Sinks: SinksConfig{
+			StatsD: StatsDConfig{Prefix: "gometrics", MaxPacketSize: 1432},
+			Influx: InfluxConfig{BatchSize: 100, FlushInterval: Duration(10 * time.Second), MaxRetries: 3, Gzip: true},
+			Push:   PushConfig{MaxPending: 10000},
+		},
+		Logging: LoggingConfig{Format: "text", Level: "info"},
+		Hub: HubConfig{
+			DownAfter:    Duration(30 * time.Second),
+			HistorySize:  720,
+			MaxHosts:     1000,
+			PullInterval: Duration(10 * time.Second),
+		},
+		Alerts: AlertsConfig{Interval: Duration(15 * time.Second)},
+		Analysis: AnalysisConfig{
+			Anomaly: AnomalyConfig{
+				Fields: []string{
+					"cpu.overall_percent",
+					"cpu.load_average",
+					"memory.used_percent",
+					"memory.swap_used_percent",
+					"disk.mountpoints.used_percent",
+					"disk.rates",
+					"network.rates",
+				},
+				HalfLife:  Duration(10 * time.Minute),
+				Threshold: 4,
+				Warmup:    Duration(30 * time.Minute),
+				Seasonal:  true,
+				MaxEvents: 100,
+				Expiry:    Duration(time.Hour),
+			},
+			Forecast: ForecastConfig{
+				Fields:     []string{"disk.mountpoints.used_percent", "memory.used_percent", "memory.swap_used_percent"},
+				Method:     "theil_sen",
+				Lookback:   Duration(24 * time.Hour),
+				MinHistory: Duration(time.Hour),
+				Interval:   Duration(time.Minute),
+			},
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the YAML file at path (if
+// path is not empty) and environment variable overrides, then validates it
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("config: %w", err)
+		}
+		if err := decodeYAML(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg, os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// decodeYAML decodes data over cfg, rejecting unknown keys
+func decodeYAML(data []byte, cfg *Config) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Validate checks the configuration and returns every problem found
+func (c Config) Validate() error {
+	var errs []string
+	check := func(ok bool, key, msg string) {
+		if !ok {
+			errs = append(errs, key+": "+msg)
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port < 65536, "server.port", "must be between 1 and 65535")
+	check(c.Server.SampleInterval > 0, "server.sample_interval", "must be positive")
+	check(c.Server.BufferSize > 0, "server.buffer_size", "must be positive")
+	check(c.Server.SinkQueueSize > 0, "server.sink_queue_size", "must be positive")
+	check(c.Server.HealthPort >= 0 && c.Server.HealthPort < 65536, "server.health_port", "must be between 0 and 65535")
+	check(c.Server.HealthPort != c.Server.Port, "server.health_port", "must differ from server.port")
+	check(c.Server.ReadHeaderTimeout > 0, "server.read_header_timeout", "must be positive")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout", "must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout", "must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout", "must be positive")
+	check(c.Server.MaxHeaderBytes >= 4096, "server.max_header_bytes", "must be at least 4096")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+	check(c.Server.Limits.Rate >= 0, "server.limits.rate", "must not be negative")
+	check(c.Server.Limits.Rate == 0 || c.Server.Limits.Burst > 0, "server.limits.burst", "must be positive when rate is set")
+	check(c.Server.Limits.HistoryConcurrency > 0, "server.limits.history_concurrency", "must be positive")
+	check(c.Server.Limits.MaxStreams > 0, "server.limits.max_streams", "must be positive")
+
+	if t := c.Server.TLS; t.CertFile != "" || t.KeyFile != "" || t.ClientCAFile != "" {
+		check(t.CertFile != "", "server.tls.cert_file", "is required when TLS is configured")
+		check(t.KeyFile != "", "server.tls.key_file", "is required when TLS is configured")
+		check(t.ClientAuth == "" || t.ClientAuth == "none" || t.ClientAuth == "optional" || t.ClientAuth == "require",
+			"server.tls.client_auth", "must be none, optional or require")
+		check(t.ClientAuth == "" || t.ClientAuth == "none" || t.ClientCAFile != "",
+			"server.tls.client_ca_file", "is required when client_auth is optional or require")
+		check(t.MinVersion == "1.2" || t.MinVersion == "1.3", "server.tls.min_version", "must be 1.2 or 1.3")
+		check(t.ReloadInterval > 0, "server.tls.reload_interval", "must be positive")
+	}
+
+	// Settings of disabled collectors are not used
+	if cpu := c.Collectors.CPU; cpu.Enabled {
+		check(cpu.Interval > 0, "collectors.cpu.interval", "must be positive")
+		check(cpu.SampleWindow > 0, "collectors.cpu.sample_window", "must be positive")
+	}
+	if mem := c.Collectors.Memory; mem.Enabled {
+		check(mem.Interval > 0, "collectors.memory.interval", "must be positive")
+	}
+	if disk := c.Collectors.Disk; disk.Enabled {
+		check(disk.Interval > 0, "collectors.disk.interval", "must be positive")
+		check(len(disk.Mountpoints) > 0, "collectors.disk.mountpoints", "must list at least one mountpoint")
+		for i, mp := range disk.Mountpoints {
+			check(filepath.IsAbs(mp), fmt.Sprintf("collectors.disk.mountpoints[%d]", i), "must be an absolute path")
+		}
+	}
+	if network := c.Collectors.Network; network.Enabled {
+		check(network.Interval > 0, "collectors.network.interval", "must be positive")
+	}
+
+	for i, tier := range c.History.RollupTiers {
+		key := fmt.Sprintf("history.rollup_tiers[%d]", i)
+		check(tier.Resolution > 0, key+".resolution", "must be positive")
+		check(tier.Retention >= tier.Resolution, key+".retention", "must be at least the resolution")
+	}
+	if c.History.Store.Dir != "" {
+		check(c.History.Store.SegmentDuration > 0, "history.store.segment_duration", "must be positive")
+		check(c.History.Store.Retention >= 0, "history.store.retention", "must not be negative")
+		check(c.History.Store.MaxMB >= 0, "history.store.max_mb", "must not be negative")
+	}
+
+	if stats := c.History.Stats; len(stats.Windows) > 0 {
+		shortest, longest := stats.Windows[0], stats.Windows[0]
+		for i, window := range stats.Windows {
+			check(window >= Duration(time.Second), fmt.Sprintf("history.stats.windows[%d]", i), "must be at least 1s")
+			shortest, longest = min(shortest, window), max(longest, window)
+		}
+		check(shortest <= 0 || longest <= 360*shortest, "history.stats.windows", "the longest window must be at most 360 times the shortest")
+		check(len(stats.Fields) > 0, "history.stats.fields", "must not be empty when windows are set")
+	}
+
+	if anomaly := c.Analysis.Anomaly; anomaly.Enabled {
+		check(len(anomaly.Fields) > 0, "analysis.anomaly.fields", "must not be empty")
+		check(anomaly.HalfLife > 0, "analysis.anomaly.half_life", "must be positive")
+		check(anomaly.Threshold > 0, "analysis.anomaly.threshold", "must be positive")
+		check(anomaly.Warmup >= 0, "analysis.anomaly.warmup", "must not be negative")
+		check(anomaly.MaxEvents > 0, "analysis.anomaly.max_events", "must be positive")
+		check(anomaly.Expiry > 0, "analysis.anomaly.expiry", "must be positive")
+	}
+	if forecast := c.Analysis.Forecast; forecast.Enabled {
+		check(len(forecast.Fields) > 0, "analysis.forecast.fields", "must not be empty")
+		for i, field := range forecast.Fields {
+			check(strings.HasSuffix(field, "percent"), fmt.Sprintf("analysis.forecast.fields[%d]", i), "must be a percentage field")
+		}
+		check(forecast.Method == "linear" || forecast.Method == "theil_sen", "analysis.forecast.method", `must be "linear" or "theil_sen"`)
+		check(forecast.Lookback > 0, "analysis.forecast.lookback", "must be positive")
+		check(forecast.MinHistory >= 0 && forecast.MinHistory <= forecast.Lookback, "analysis.forecast.min_history", "must be between 0 and the lookback")
+		check(forecast.Interval > 0, "analysis.forecast.interval", "must be positive")
+		check(len(c.History.RollupTiers) > 0, "analysis.forecast.enabled", "requires history.rollup_tiers")
+	}
+
+	check(c.Alerts.Interval > 0, "alerts.interval", "must be positive")
+	names := make(map[string]bool)
+	for i, rule := range c.Alerts.Rules {
+		key := fmt.Sprintf("alerts.rules[%d]", i)
+		check(validRuleName(rule.Name), key+".name", "must be 1-128 letters, digits or '_', not starting with a digit")
+		check(!names[rule.Name], key+".name", fmt.Sprintf("duplicate rule %q", rule.Name))
+		names[rule.Name] = true
+		_, err := query.Parse(rule.Expr)
+		check(err == nil, key+".expr", fmt.Sprint(err))
+		check(rule.For >= 0, key+".for", "must not be negative")
+	}
+
+	if c.Sinks.StatsD.Address != "" {
+		check(c.Sinks.StatsD.MaxPacketSize >= 512 && c.Sinks.StatsD.MaxPacketSize <= 65507,
+			"sinks.statsd.max_packet_size", "must be between 512 and 65507")
+		for i, tag := range c.Sinks.StatsD.Tags {
+			check(!strings.ContainsAny(tag, "|,#"), fmt.Sprintf("sinks.statsd.tags[%d]", i), "must not contain '|', ',' or '#'")
+		}
+	}
+
+	if c.Sinks.Influx.URL != "" {
+		check(strings.HasPrefix(c.Sinks.Influx.URL, "http://") || strings.HasPrefix(c.Sinks.Influx.URL, "https://"),
+			"sinks.influx.url", "must be an http:// or https:// URL")
+		check(c.Sinks.Influx.Org != "", "sinks.influx.org", "is required")
+		check(c.Sinks.Influx.Bucket != "", "sinks.influx.bucket", "is required")
+		check(c.Sinks.Influx.BatchSize > 0, "sinks.influx.batch_size", "must be positive")
+		check(c.Sinks.Influx.FlushInterval > 0, "sinks.influx.flush_interval", "must be positive")
+		check(c.Sinks.Influx.MaxRetries >= 0, "sinks.influx.max_retries", "must not be negative")
+	}
+
+	if push := c.Sinks.Push; push.Address != "" {
+		_, port, err := net.SplitHostPort(push.Address)
+		check(err == nil && port != "", "sinks.push.address", "must be host:port, e.g. hub:9091")
+		check(push.HostID == "" || validHostID(push.HostID), "sinks.push.host_id", "must be 1-128 letters, digits, '.', '_' or '-'")
+		check(push.CAFile == "" || push.TLS, "sinks.push.ca_file", "requires tls")
+		check(push.MaxPending > 0, "sinks.push.max_pending", "must be positive")
+	}
+
+	if c.Admin.Addr != "" {
+		host, port, err := net.SplitHostPort(c.Admin.Addr)
+		check(err == nil && port != "", "admin.addr", "must be host:port, e.g. localhost:6060")
+		check(err != nil || isLoopback(host) || c.Auth.grants("admin"), "admin.addr",
+			"must be a loopback address such as localhost:6060 unless auth grants the admin scope")
+	}
+
+	validLevel := func(level string) bool {
+		switch strings.ToLower(level) {
+		case "debug", "info", "warn", "error":
+			return true
+		}
+		return false
+	}
+	check(c.Logging.Format == "text" || c.Logging.Format == "json", "logging.format", "must be text or json")
+	check(validLevel(c.Logging.Level), "logging.level", "must be debug, info, warn or error")
+	for name, level := range c.Logging.Levels {
+		check(validLevel(level), "logging.levels."+name, "must be debug, info, warn or error")
+	}
+
+	if c.Hub.Enabled {
+		if c.Hub.Listen != "" {
+			_, port, err := net.SplitHostPort(c.Hub.Listen)
+			check(err == nil && port != "", "hub.listen", "must be host:port, e.g. :9091")
+		}
+		check(c.Hub.DownAfter > 0, "hub.down_after", "must be positive")
+		check(c.Hub.HistorySize > 0, "hub.history_size", "must be positive")
+		check(c.Hub.MaxHosts > 0, "hub.max_hosts", "must be positive")
+		check(c.Hub.PullInterval > 0, "hub.pull_interval", "must be positive")
+		ids := make(map[string]bool)
+		for i, t := range c.Hub.Targets {
+			key := fmt.Sprintf("hub.targets[%d]", i)
+			check(validHostID(t.ID), key+".id", "must be 1-128 letters, digits, '.', '_' or '-'")
+			check(!ids[t.ID], key+".id", "is used by another target")
+			ids[t.ID] = true
+			check(strings.HasPrefix(t.URL, "http://") || strings.HasPrefix(t.URL, "https://"),
+				key+".url", "must be an http:// or https:// URL")
+		}
+	}
+
+	checkScopes := func(key string, scopes []string) {
+		check(len(scopes) > 0, key+".scopes", "must list at least one scope")
+		for i, scope := range scopes {
+			check(scope == "read" || scope == "scrape" || scope == "push" || scope == "admin",
+				fmt.Sprintf("%s.scopes[%d]", key, i), "must be read, scrape, push or admin")
+		}
+	}
+	tokens := make(map[string]bool)
+	for i, t := range c.Auth.Tokens {
+		key := fmt.Sprintf("auth.tokens[%d]", i)
+		check(t.Name != "", key+".name", "is required")
+		check(t.Token != "", key+".token", "is required")
+		check(!tokens[t.Token], key+".token", "is used by another token")
+		tokens[t.Token] = true
+		checkScopes(key, t.Scopes)
+	}
+	users := make(map[string]bool)
+	for i, u := range c.Auth.Users {
+		key := fmt.Sprintf("auth.users[%d]", i)
+		check(u.Username != "", key+".username", "is required")
+		check(!users[u.Username], key+".username", "is used by another user")
+		users[u.Username] = true
+		check(strings.HasPrefix(u.PasswordHash, "$2"), key+".password_hash", "must be a bcrypt hash")
+		checkScopes(key, u.Scopes)
+	}
+	for i, cert := range c.Auth.ClientCerts {
+		key := fmt.Sprintf("auth.client_certs[%d]", i)
+		check(cert.CommonName != "", key+".common_name", "is required")
+		checkScopes(key, cert.Scopes)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with secrets replaced,
+// safe to show on the admin endpoints
+func (c Config) Redacted() Config {
+	const redacted = "REDACTED"
+
+	if c.Sinks.Influx.Token != "" {
+		c.Sinks.Influx.Token = redacted
+	}
+	if c.Sinks.Push.Token != "" {
+		c.Sinks.Push.Token = redacted
+	}
+
+	tokens := make([]TokenConfig, len(c.Auth.Tokens))
+	for i, t := range c.Auth.Tokens {
+		t.Token = redacted
+		tokens[i] = t
+	}
+	c.Auth.Tokens = tokens
+
+	targets := make([]HubTargetConfig, len(c.Hub.Targets))
+	for i, t := range c.Hub.Targets {
+		if t.Token != "" {
+			t.Token = redacted
+		}
+		targets[i] = t
+	}
+	c.Hub.Targets = targets
+
+	users := make([]UserConfig, len(c.Auth.Users))
+	for i, u := range c.Auth.Users {
+		u.PasswordHash = redacted
+		users[i] = u
+	}
+	c.Auth.Users = users
+
+	return c
+}
+
+// validHostID reports whether id can identify a hub host; see hub.ValidID
+func validHostID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// isLoopback reports whether a listen host only accepts local connections.
+// An empty host listens on every interface.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validRuleName reports whether name can be an alert rule name, which is
+// also the value of the alertname label
+func validRuleName(name string) bool {
+	if name == "" || len(name) > 128 || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Duration is a time.Duration written as a string such as "5s" or "250ms"
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration string
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return fmt.Errorf("line %d: duration must be a string such as \"5s\"", node.Line)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", node.Line, s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalYAML writes the duration as a string
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// String formats the duration like time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
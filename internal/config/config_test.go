@@ -0,0 +1,184 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("default configuration is invalid: %v", err)
+	}
+}
+
+func TestDecodeUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string // Empty for success
+	}{
+		{"empty", "", ""},
+		{"known keys", "server:\n  port: 9000\ncollectors:\n  cpu:\n    per_core: false\n", ""},
+		{"unknown top-level key", "sever:\n  port: 9000\n", "field sever not found"},
+		{"unknown nested key", "server:\n  prot: 9000\n", "field prot not found"},
+		{"unknown collector key", "collectors:\n  disk:\n    mountpoint: /\n", "field mountpoint not found"},
+		{"wrong type", "server:\n  port: high\n", "cannot unmarshal"},
+		{"bad duration", "server:\n  sample_interval: soon\n", "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			err := decodeYAML([]byte(tt.yaml), &cfg)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("decodeYAML() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("decodeYAML() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCollectsEveryError(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Port = 0
+	cfg.Server.SampleInterval = 0
+	cfg.Collectors.Disk.Mountpoints = []string{"relative"}
+	cfg.Logging.Format = "xml"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() accepted an invalid configuration")
+	}
+	for _, key := range []string{"server.port", "server.sample_interval", "collectors.disk.mountpoints[0]", "logging.format"} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("Validate() error doesn't name %s:\n%v", key, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	adminToken := TokenConfig{Name: "ops", Token: "t", Scopes: []string{"admin"}}
+	readToken := TokenConfig{Name: "dash", Token: "r", Scopes: []string{"read"}}
+
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string // Key expected in the error; empty for success
+	}{
+		{"valid alert rule", func(c *Config) {
+			c.Alerts.Rules = []AlertRuleConfig{{Name: "DiskFull", Expr: "disk.used_percent > 90"}}
+		}, ""},
+		{"alert rule with invalid expr", func(c *Config) {
+			c.Alerts.Rules = []AlertRuleConfig{{Name: "DiskFull", Expr: "disk.used_percent >"}}
+		}, "alerts.rules[0].expr"},
+		{"alert rule with invalid name", func(c *Config) {
+			c.Alerts.Rules = []AlertRuleConfig{{Name: "1st", Expr: "1"}}
+		}, "alerts.rules[0].name"},
+		{"duplicate alert rules", func(c *Config) {
+			c.Alerts.Rules = []AlertRuleConfig{{Name: "A", Expr: "1"}, {Name: "A", Expr: "2"}}
+		}, "alerts.rules[1].name"},
+		{"negative alert for", func(c *Config) {
+			c.Alerts.Rules = []AlertRuleConfig{{Name: "A", Expr: "1", For: Duration(-time.Second)}}
+		}, "alerts.rules[0].for"},
+		{"admin on localhost", func(c *Config) { c.Admin.Addr = "localhost:6060" }, ""},
+		{"admin on IPv6 loopback", func(c *Config) { c.Admin.Addr = "[::1]:6060" }, ""},
+		{"admin on 127.0.0.1", func(c *Config) { c.Admin.Addr = "127.0.0.1:6060" }, ""},
+		{"admin on all interfaces without auth", func(c *Config) { c.Admin.Addr = ":6060" }, "admin.addr"},
+		{"admin on a public address without auth", func(c *Config) { c.Admin.Addr = "10.0.0.1:6060" }, "admin.addr"},
+		{"admin on all interfaces with only a read token", func(c *Config) {
+			c.Admin.Addr = "0.0.0.0:6060"
+			c.Auth.Tokens = []TokenConfig{readToken}
+		}, "admin.addr"},
+		{"admin on all interfaces with an admin token", func(c *Config) {
+			c.Admin.Addr = "0.0.0.0:6060"
+			c.Auth.Tokens = []TokenConfig{adminToken}
+		}, ""},
+		{"admin address without port", func(c *Config) { c.Admin.Addr = "localhost" }, "admin.addr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr+":")):
+				t.Errorf("Validate() error = %v, want one for %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg, err := Load(write("valid.yml", "server:\n  port: 9000\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.Port != 9000 {
+		t.Errorf("port = %d, want 9000", cfg.Server.Port)
+	}
+
+	if _, err := Load(write("unknown.yml", "server:\n  prot: 9000\n")); err == nil {
+		t.Error("Load() accepted an unknown key")
+	}
+	if _, err := Load(write("invalid.yml", "server:\n  port: 70000\n")); err == nil || !strings.Contains(err.Error(), "server.port") {
+		t.Errorf("Load() error = %v, want one for server.port", err)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.yml")); err == nil {
+		t.Error("Load() accepted a missing file")
+	}
+
+	// The environment overrides the file, and bad values fail
+	t.Setenv("PORT", "9100")
+	if cfg, err := Load(write("env.yml", "server:\n  port: 9000\n")); err != nil || cfg.Server.Port != 9100 {
+		t.Errorf("Load() = port %d, error %v, want port 9100 from PORT", cfg.Server.Port, err)
+	}
+	t.Setenv("SAMPLE_INTERVAL", "abc")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "SAMPLE_INTERVAL") {
+		t.Errorf("Load() error = %v, want one for SAMPLE_INTERVAL", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.Tokens = []TokenConfig{{Name: "ops", Token: "token-secret", Scopes: []string{"admin"}}}
+	cfg.Auth.Users = []UserConfig{{Username: "alice", PasswordHash: "$2a$10$hash-secret", Scopes: []string{"read"}}}
+	cfg.Sinks.Influx.Token = "influx-secret"
+	cfg.Sinks.Push.Token = "push-secret"
+	cfg.Hub.Targets = []HubTargetConfig{{ID: "web-1", URL: "http://web-1:8080", Token: "target-secret"}}
+
+	redacted := cfg.Redacted()
+	shown := strings.Join([]string{
+		redacted.Auth.Tokens[0].Token,
+		redacted.Auth.Users[0].PasswordHash,
+		redacted.Sinks.Influx.Token,
+		redacted.Sinks.Push.Token,
+		redacted.Hub.Targets[0].Token,
+	}, " ")
+	if strings.Contains(shown, "secret") {
+		t.Errorf("Redacted() shows secrets: %s", shown)
+	}
+	if redacted.Auth.Users[0].Username != "alice" || redacted.Hub.Targets[0].URL != "http://web-1:8080" {
+		t.Error("Redacted() changed values that are not secret")
+	}
+
+	// The original keeps its secrets
+	if cfg.Auth.Tokens[0].Token != "token-secret" || cfg.Auth.Users[0].PasswordHash != "$2a$10$hash-secret" ||
+		cfg.Hub.Targets[0].Token != "target-secret" {
+		t.Error("Redacted() modified the original configuration")
+	}
+}
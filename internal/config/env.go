@@ -0,0 +1,290 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+)
+
+// applyEnv overrides configuration values from environment variables.
+// Unlike the old getEnv helpers, a value that fails to parse is an error.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	e := envReader{lookup: lookup}
+
+	// Server
+	e.int("PORT", &cfg.Server.Port)
+	e.duration("SAMPLE_INTERVAL", &cfg.Server.SampleInterval)
+	e.int("BUFFER_SIZE", &cfg.Server.BufferSize)
+	e.int("SINK_QUEUE_SIZE", &cfg.Server.SinkQueueSize)
+	e.int("HEALTH_PORT", &cfg.Server.HealthPort)
+	e.duration("READ_HEADER_TIMEOUT", &cfg.Server.ReadHeaderTimeout)
+	e.duration("READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	e.duration("WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	e.duration("IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	e.int("MAX_HEADER_BYTES", &cfg.Server.MaxHeaderBytes)
+	e.duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	e.float("RATE_LIMIT", &cfg.Server.Limits.Rate)
+	e.int("RATE_LIMIT_BURST", &cfg.Server.Limits.Burst)
+	e.int("HISTORY_CONCURRENCY", &cfg.Server.Limits.HistoryConcurrency)
+	e.int("MAX_STREAMS", &cfg.Server.Limits.MaxStreams)
+
+	// TLS
+	e.string("TLS_CERT_FILE", &cfg.Server.TLS.CertFile)
+	e.string("TLS_KEY_FILE", &cfg.Server.TLS.KeyFile)
+	e.string("TLS_CLIENT_CA_FILE", &cfg.Server.TLS.ClientCAFile)
+	e.string("TLS_CLIENT_AUTH", &cfg.Server.TLS.ClientAuth)
+	e.string("TLS_MIN_VERSION", &cfg.Server.TLS.MinVersion)
+	e.list("TLS_CIPHER_SUITES", &cfg.Server.TLS.CipherSuites)
+
+	// COLLECTOR_INTERVAL sets every collector's interval; the per-collector
+	// variables below take precedence
+	var interval Duration
+	if e.duration("COLLECTOR_INTERVAL", &interval) {
+		cfg.Collectors.CPU.Interval = interval
+		cfg.Collectors.Memory.Interval = interval
+		cfg.Collectors.Disk.Interval = interval
+		cfg.Collectors.Network.Interval = interval
+	}
+
+	// Collectors
+	e.bool("CPU_ENABLED", &cfg.Collectors.CPU.Enabled)
+	e.duration("CPU_INTERVAL", &cfg.Collectors.CPU.Interval)
+	e.bool("MEMORY_ENABLED", &cfg.Collectors.Memory.Enabled)
+	e.duration("MEMORY_INTERVAL", &cfg.Collectors.Memory.Interval)
+	e.bool("DISK_ENABLED", &cfg.Collectors.Disk.Enabled)
+	e.duration("DISK_INTERVAL", &cfg.Collectors.Disk.Interval)
+	e.bool("NETWORK_ENABLED", &cfg.Collectors.Network.Enabled)
+	e.duration("NETWORK_INTERVAL", &cfg.Collectors.Network.Interval)
+
+	// History
+	if value, ok := lookup("ROLLUP_TIERS"); ok && value != "" {
+		tiers, err := agg.ParseRollupTiers(value)
+		if err != nil {
+			e.errs = append(e.errs, "ROLLUP_TIERS: "+err.Error())
+		} else {
+			cfg.History.RollupTiers = nil
+			for _, tier := range tiers {
+				cfg.History.RollupTiers = append(cfg.History.RollupTiers, RollupTierConfig{
+					Resolution: Duration(tier.Resolution),
+					Retention:  Duration(tier.Retention),
+				})
+			}
+		}
+	}
+	e.string("STORE_DIR", &cfg.History.Store.Dir)
+	e.duration("STORE_SEGMENT_DURATION", &cfg.History.Store.SegmentDuration)
+	e.duration("STORE_RETENTION", &cfg.History.Store.Retention)
+	e.int("STORE_MAX_MB", &cfg.History.Store.MaxMB)
+	e.durations("STATS_WINDOWS", &cfg.History.Stats.Windows)
+	e.list("STATS_FIELDS", &cfg.History.Stats.Fields)
+	e.bool("STATS_PROMETHEUS", &cfg.History.Stats.Prometheus)
+
+	// StatsD
+	e.string("STATSD_ADDRESS", &cfg.Sinks.StatsD.Address)
+	e.string("STATSD_PREFIX", &cfg.Sinks.StatsD.Prefix)
+	e.bool("STATSD_DOGSTATSD", &cfg.Sinks.StatsD.DogStatsD)
+	e.list("STATSD_TAGS", &cfg.Sinks.StatsD.Tags)
+	e.int("STATSD_MAX_PACKET_SIZE", &cfg.Sinks.StatsD.MaxPacketSize)
+
+	// InfluxDB
+	e.string("INFLUX_URL", &cfg.Sinks.Influx.URL)
+	e.string("INFLUX_ORG", &cfg.Sinks.Influx.Org)
+	e.string("INFLUX_BUCKET", &cfg.Sinks.Influx.Bucket)
+	e.string("INFLUX_TOKEN", &cfg.Sinks.Influx.Token)
+	e.int("INFLUX_BATCH_SIZE", &cfg.Sinks.Influx.BatchSize)
+	e.duration("INFLUX_FLUSH_INTERVAL", &cfg.Sinks.Influx.FlushInterval)
+	e.int("INFLUX_MAX_RETRIES", &cfg.Sinks.Influx.MaxRetries)
+	e.bool("INFLUX_GZIP", &cfg.Sinks.Influx.Gzip)
+
+	// Push to a hub; PUSH_LABELS takes name=value pairs such as "region=eu,role=db"
+	e.string("PUSH_ADDRESS", &cfg.Sinks.Push.Address)
+	e.string("PUSH_HOST_ID", &cfg.Sinks.Push.HostID)
+	e.pairs("PUSH_LABELS", "name=value", &cfg.Sinks.Push.Labels)
+	e.string("PUSH_TOKEN", &cfg.Sinks.Push.Token)
+	e.bool("PUSH_TLS", &cfg.Sinks.Push.TLS)
+	e.string("PUSH_CA_FILE", &cfg.Sinks.Push.CAFile)
+	e.int("PUSH_MAX_PENDING", &cfg.Sinks.Push.MaxPending)
+
+	// Logging; LOG_LEVELS takes subsystem=level pairs such as "agg=debug,http=warn"
+	e.string("LOG_FORMAT", &cfg.Logging.Format)
+	e.string("LOG_LEVEL", &cfg.Logging.Level)
+	e.pairs("LOG_LEVELS", "subsystem=level", &cfg.Logging.Levels)
+
+	// Hub; HUB_TARGETS takes id=url pairs such as "web-1=http://10.0.0.1:8080"
+	e.bool("HUB_ENABLED", &cfg.Hub.Enabled)
+	e.string("HUB_LISTEN", &cfg.Hub.Listen)
+	e.duration("HUB_DOWN_AFTER", &cfg.Hub.DownAfter)
+	e.int("HUB_HISTORY_SIZE", &cfg.Hub.HistorySize)
+	e.int("HUB_MAX_HOSTS", &cfg.Hub.MaxHosts)
+	e.duration("HUB_PULL_INTERVAL", &cfg.Hub.PullInterval)
+	e.targets("HUB_TARGETS", &cfg.Hub.Targets)
+
+	// Anomaly detection
+	e.bool("ANOMALY_ENABLED", &cfg.Analysis.Anomaly.Enabled)
+	e.list("ANOMALY_FIELDS", &cfg.Analysis.Anomaly.Fields)
+	e.duration("ANOMALY_HALF_LIFE", &cfg.Analysis.Anomaly.HalfLife)
+	e.float("ANOMALY_THRESHOLD", &cfg.Analysis.Anomaly.Threshold)
+	e.duration("ANOMALY_WARMUP", &cfg.Analysis.Anomaly.Warmup)
+	e.bool("ANOMALY_SEASONAL", &cfg.Analysis.Anomaly.Seasonal)
+	e.int("ANOMALY_MAX_EVENTS", &cfg.Analysis.Anomaly.MaxEvents)
+	e.duration("ANOMALY_EXPIRY", &cfg.Analysis.Anomaly.Expiry)
+
+	// Forecasts
+	e.bool("FORECAST_ENABLED", &cfg.Analysis.Forecast.Enabled)
+	e.list("FORECAST_FIELDS", &cfg.Analysis.Forecast.Fields)
+	e.string("FORECAST_METHOD", &cfg.Analysis.Forecast.Method)
+	e.duration("FORECAST_LOOKBACK", &cfg.Analysis.Forecast.Lookback)
+	e.duration("FORECAST_MIN_HISTORY", &cfg.Analysis.Forecast.MinHistory)
+	e.duration("FORECAST_INTERVAL", &cfg.Analysis.Forecast.Interval)
+
+	// Alert rules come from the file only
+	e.duration("ALERT_INTERVAL", &cfg.Alerts.Interval)
+
+	// Admin listener
+	e.string("ADMIN_ADDR", &cfg.Admin.Addr)
+
+	// Auth: one bearer token per scope, added to those from the file
+	for _, scope := range []string{"read", "scrape", "push", "admin"} {
+		var token string
+		e.string("AUTH_"+strings.ToUpper(scope)+"_TOKEN", &token)
+		if token != "" {
+			cfg.Auth.Tokens = append(cfg.Auth.Tokens, TokenConfig{Name: "env-" + scope, Token: token, Scopes: []string{scope}})
+		}
+	}
+
+	if len(e.errs) > 0 {
+		return fmt.Errorf("config: invalid environment variables:\n  %s", strings.Join(e.errs, "\n  "))
+	}
+	return nil
+}
+
+// envReader reads typed environment variables, collecting parse errors
+type envReader struct {
+	lookup func(string) (string, bool)
+	errs   []string
+}
+
+// get returns the value of a set, non-empty variable
+func (e *envReader) get(key string) (string, bool) {
+	value, ok := e.lookup(key)
+	return value, ok && value != ""
+}
+
+func (e *envReader) string(key string, dst *string) {
+	if value, ok := e.get(key); ok {
+		*dst = value
+	}
+}
+
+func (e *envReader) int(key string, dst *int) {
+	if value, ok := e.get(key); ok {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Sprintf("%s: %q is not an integer", key, value))
+			return
+		}
+		*dst = v
+	}
+}
+
+func (e *envReader) float(key string, dst *float64) {
+	if value, ok := e.get(key); ok {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Sprintf("%s: %q is not a number", key, value))
+			return
+		}
+		*dst = v
+	}
+}
+
+func (e *envReader) bool(key string, dst *bool) {
+	if value, ok := e.get(key); ok {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Sprintf("%s: %q is not a boolean", key, value))
+			return
+		}
+		*dst = v
+	}
+}
+
+// duration reports whether the variable was set and valid
+func (e *envReader) duration(key string, dst *Duration) bool {
+	if value, ok := e.get(key); ok {
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Sprintf("%s: %q is not a duration", key, value))
+			return false
+		}
+		*dst = Duration(v)
+		return true
+	}
+	return false
+}
+
+func (e *envReader) list(key string, dst *[]string) {
+	if value, ok := e.get(key); ok {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*dst = list
+	}
+}
+
+// durations parses a comma-separated list of durations
+func (e *envReader) durations(key string, dst *[]Duration) {
+	var items []string
+	e.list(key, &items)
+	if items == nil {
+		return
+	}
+	list := make([]Duration, 0, len(items))
+	for _, item := range items {
+		v, err := time.ParseDuration(item)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Sprintf("%s: %q is not a duration", key, item))
+			return
+		}
+		list = append(list, Duration(v))
+	}
+	*dst = list
+}
+
+// pairs merges name=value pairs into dst; form names the expected shape
+// in errors, e.g. "subsystem=level"
+func (e *envReader) pairs(key, form string, dst *map[string]string) {
+	var pairs []string
+	e.list(key, &pairs)
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			e.errs = append(e.errs, fmt.Sprintf("%s: %q is not %s", key, pair, form))
+			continue
+		}
+		if *dst == nil {
+			*dst = make(map[string]string)
+		}
+		(*dst)[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+}
+
+// targets appends id=url pairs to dst
+func (e *envReader) targets(key string, dst *[]HubTargetConfig) {
+	var pairs []string
+	e.list(key, &pairs)
+	for _, pair := range pairs {
+		id, url, ok := strings.Cut(pair, "=")
+		if !ok || id == "" || url == "" {
+			e.errs = append(e.errs, fmt.Sprintf("%s: %q is not id=url", key, pair))
+			continue
+		}
+		*dst = append(*dst, HubTargetConfig{ID: strings.TrimSpace(id), URL: strings.TrimSpace(url)})
+	}
+}
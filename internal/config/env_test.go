@@ -0,0 +1,71 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(Config) bool // Checked when no error is expected
+		wantErr []string          // Variables expected in the error
+	}{
+		{"port", map[string]string{"PORT": "9000"},
+			func(c Config) bool { return c.Server.Port == 9000 }, nil},
+		{"empty value keeps the default", map[string]string{"PORT": ""},
+			func(c Config) bool { return c.Server.Port == Default().Server.Port }, nil},
+		{"port not a number", map[string]string{"PORT": "http"}, nil, []string{"PORT"}},
+		{"sample interval not a duration", map[string]string{"SAMPLE_INTERVAL": "abc"}, nil, []string{"SAMPLE_INTERVAL"}},
+		{"sample interval without unit", map[string]string{"SAMPLE_INTERVAL": "250"}, nil, []string{"SAMPLE_INTERVAL"}},
+		{"bool", map[string]string{"CPU_ENABLED": "yes"}, nil, []string{"CPU_ENABLED"}},
+		{"rollup tiers", map[string]string{"ROLLUP_TIERS": "1m:1h,10s:10m"},
+			func(c Config) bool {
+				tiers := c.History.RollupTiers
+				return len(tiers) == 2 && tiers[0].Resolution == Duration(10*time.Second) && tiers[1].Retention == Duration(time.Hour)
+			}, nil},
+		{"rollup tier without retention", map[string]string{"ROLLUP_TIERS": "10s"}, nil, []string{"ROLLUP_TIERS"}},
+		{"rollup tier retention below resolution", map[string]string{"ROLLUP_TIERS": "1m:30s"}, nil, []string{"ROLLUP_TIERS"}},
+		{"collector interval with an override", map[string]string{"COLLECTOR_INTERVAL": "10s", "CPU_INTERVAL": "1s"},
+			func(c Config) bool {
+				return c.Collectors.CPU.Interval == Duration(time.Second) && c.Collectors.Disk.Interval == Duration(10*time.Second)
+			}, nil},
+		{"labels", map[string]string{"PUSH_LABELS": "region=eu, role=db"},
+			func(c Config) bool {
+				return c.Sinks.Push.Labels["region"] == "eu" && c.Sinks.Push.Labels["role"] == "db"
+			}, nil},
+		{"label without value", map[string]string{"PUSH_LABELS": "region"}, nil, []string{"PUSH_LABELS"}},
+		{"scope tokens", map[string]string{"AUTH_ADMIN_TOKEN": "secret"},
+			func(c Config) bool { return c.Auth.grants("admin") }, nil},
+		{"every bad value reported", map[string]string{"PORT": "x", "SAMPLE_INTERVAL": "abc", "STATS_WINDOWS": "1m,soon"},
+			nil, []string{"PORT", "SAMPLE_INTERVAL", "STATS_WINDOWS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			err := applyEnv(&cfg, func(key string) (string, bool) {
+				value, ok := tt.env[key]
+				return value, ok
+			})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("applyEnv() error = %v", err)
+				}
+				if !tt.check(cfg) {
+					t.Errorf("applyEnv() didn't apply %v", tt.env)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("applyEnv() accepted %v", tt.env)
+			}
+			for _, key := range tt.wantErr {
+				if !strings.Contains(err.Error(), key+":") {
+					t.Errorf("applyEnv() error doesn't name %s:\n%v", key, err)
+				}
+			}
+		})
+	}
+}
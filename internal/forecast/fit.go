@@ -0,0 +1,122 @@
+package forecast
+
+import (
+	"math"
+	"sort"
+)
+
+// z90 is the two-sided 90% quantile of the normal distribution. Fits use
+// at least minPoints points, where Student's t is close enough to it.
+const z90 = 1.645
+
+// point is one value at x seconds relative to the evaluation time
+type point struct {
+	x, y float64
+}
+
+// fit is a straight line through the points
+type fit struct {
+	intercept float64 // Value at x = 0
+	slope     float64 // Change per second
+
+	// 90% confidence interval of the slope
+	slopeLow, slopeHigh float64
+
+	r2 float64 // Fraction of the variance explained by the line
+}
+
+// linearFit fits a line by ordinary least squares
+func linearFit(points []point) fit {
+	n := float64(len(points))
+	var meanX, meanY float64
+	for _, p := range points {
+		meanX += p.x
+		meanY += p.y
+	}
+	meanX /= n
+	meanY /= n
+
+	var sxx, sxy float64
+	for _, p := range points {
+		sxx += (p.x - meanX) * (p.x - meanX)
+		sxy += (p.x - meanX) * (p.y - meanY)
+	}
+	if sxx == 0 {
+		return fit{intercept: meanY}
+	}
+	f := fit{slope: sxy / sxx}
+	f.intercept = meanY - f.slope*meanX
+
+	stderr := math.Sqrt(sse(points, f) / (n - 2) / sxx)
+	f.slopeLow, f.slopeHigh = f.slope-z90*stderr, f.slope+z90*stderr
+	f.r2 = r2(points, f, meanY)
+	return f
+}
+
+// theilSenFit fits a line through the median of the slopes between every
+// pair of points, which a minority of outliers such as a log file being
+// rotated doesn't move. The slope interval follows Sen (1968).
+func theilSenFit(points []point) fit {
+	slopes := make([]float64, 0, len(points)*(len(points)-1)/2)
+	for i := range points {
+		for j := i + 1; j < len(points); j++ {
+			if dx := points[j].x - points[i].x; dx != 0 {
+				slopes = append(slopes, (points[j].y-points[i].y)/dx)
+			}
+		}
+	}
+	if len(slopes) == 0 {
+		return fit{intercept: points[0].y}
+	}
+	sort.Float64s(slopes)
+
+	f := fit{slope: median(slopes)}
+	offsets := make([]float64, len(points))
+	var meanY float64
+	for i, p := range points {
+		offsets[i] = p.y - f.slope*p.x
+		meanY += p.y
+	}
+	sort.Float64s(offsets)
+	f.intercept = median(offsets)
+
+	n, count := float64(len(points)), float64(len(slopes))
+	c := z90 * math.Sqrt(n*(n-1)*(2*n+5)/18)
+	low := int(math.Max(math.Floor((count-c)/2), 0))
+	high := int(math.Min(math.Ceil((count+c)/2), count-1))
+	f.slopeLow, f.slopeHigh = slopes[low], slopes[high]
+	f.r2 = math.Max(r2(points, f, meanY/n), 0)
+	return f
+}
+
+// sse returns the sum of squared residuals of f
+func sse(points []point, f fit) float64 {
+	var sum float64
+	for _, p := range points {
+		r := p.y - (f.intercept + f.slope*p.x)
+		sum += r * r
+	}
+	return sum
+}
+
+// r2 returns the coefficient of determination of f; a flat series has
+// nothing to explain and gets 0
+func r2(points []point, f fit, meanY float64) float64 {
+	var sst float64
+	for _, p := range points {
+		sst += (p.y - meanY) * (p.y - meanY)
+	}
+	if sst == 0 {
+		return 0
+	}
+	return 1 - sse(points, f)/sst
+}
+
+// median returns the median of sorted values
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,96 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+)
+
+// line returns n points of y = intercept + slope*x, one a minute up to x = 0,
+// with noise added to each
+func line(n int, intercept, slope float64, noise func(i int) float64) []point {
+	points := make([]point, n)
+	for i := range points {
+		x := float64(i-n+1) * 60
+		points[i] = point{x: x, y: intercept + slope*x + noise(i)}
+	}
+	return points
+}
+
+func noNoise(int) float64 { return 0 }
+
+// wobble is deterministic noise of up to ±1
+func wobble(i int) float64 { return math.Sin(float64(i) * 1.7) }
+
+// outliers sets every tenth point to 100
+func outliers(intercept, slope float64) func(int) float64 {
+	return func(i int) float64 {
+		if i%10 == 5 {
+			return 100 - (intercept + slope*float64(i-29)*60)
+		}
+		return 0
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestFits(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    []point
+		fit       func([]point) fit
+		intercept float64
+		slope     float64
+		r2        float64
+	}{
+		{"linear", line(30, 40, 0.01, noNoise), linearFit, 40, 0.01, 1},
+		{"theil-sen", line(30, 40, 0.01, noNoise), theilSenFit, 40, 0.01, 1},
+		{"theil-sen with outliers", line(30, 40, 0.01, outliers(40, 0.01)), theilSenFit, 40, 0.01, 0},
+		{"linear flat", line(30, 40, 0, noNoise), linearFit, 40, 0, 0},
+		{"theil-sen flat", line(30, 40, 0, noNoise), theilSenFit, 40, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.fit(tt.points)
+			if !near(f.intercept, tt.intercept) || !near(f.slope, tt.slope) {
+				t.Errorf("intercept %v slope %v, want %v and %v", f.intercept, f.slope, tt.intercept, tt.slope)
+			}
+			if tt.r2 != 0 && !near(f.r2, tt.r2) {
+				t.Errorf("r2 = %v, want %v", f.r2, tt.r2)
+			}
+		})
+	}
+
+	// Least squares is pulled along by the outliers that Theil–Sen ignores
+	if f := linearFit(line(30, 40, 0.01, outliers(40, 0.01))); near(f.slope, 0.01) {
+		t.Errorf("linear slope with outliers = %v, expected it to be off", f.slope)
+	}
+}
+
+func TestSlopeInterval(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []point
+	}{
+		{"exact", line(30, 40, 0.01, noNoise)},
+		{"noisy", line(30, 40, 0.01, wobble)},
+		{"noisy falling", line(50, 40, -0.02, wobble)},
+		{"outliers", line(30, 40, 0.01, outliers(40, 0.01))},
+	}
+	for _, tt := range tests {
+		for method, fitFunc := range map[string]func([]point) fit{MethodLinear: linearFit, MethodTheilSen: theilSenFit} {
+			t.Run(tt.name+"/"+method, func(t *testing.T) {
+				f := fitFunc(tt.points)
+				if !(f.slopeLow <= f.slope && f.slope <= f.slopeHigh) {
+					t.Errorf("slope %v outside [%v, %v]", f.slope, f.slopeLow, f.slopeHigh)
+				}
+			})
+		}
+	}
+
+	// Noise widens the interval
+	if f := theilSenFit(line(30, 40, 0.01, wobble)); !(f.slopeLow < f.slope && f.slope < f.slopeHigh) {
+		t.Errorf("noisy slope %v has an empty interval [%v, %v]", f.slope, f.slopeLow, f.slopeHigh)
+	}
+}
@@ -0,0 +1,254 @@
+// Package forecast predicts when usage fields such as disk and memory
+// used percentages will reach 100%. It fits a line through each field's
+// rollup history and extrapolates it, with a 90% confidence interval from
+// the uncertainty of the slope.
+package forecast
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Fitting methods
+const (
+	MethodLinear   = "linear"    // Ordinary least squares
+	MethodTheilSen = "theil_sen" // Median of pairwise slopes, robust to outliers
+)
+
+// full is the value at which a percentage field is full
+const full = 100
+
+// minPoints is how many points a fit needs
+const minPoints = 20
+
+// maxBuckets bounds the rollup buckets read per forecast; maxFitPoints
+// bounds the points fitted, as Theil–Sen looks at every pair of them
+const (
+	maxBuckets   = 1500
+	maxFitPoints = 360
+)
+
+// Options configures a Forecaster
+type Options struct {
+	Fields     []string      // Percentage fields or sections, e.g. "disk.mountpoints.used_percent"
+	Method     string        // MethodLinear or MethodTheilSen
+	Lookback   time.Duration // History fitted
+	MinHistory time.Duration // Fields with a shorter history are not forecast
+	Interval   time.Duration // How often forecasts are recomputed
+}
+
+// Forecast is the prediction for one field
+type Forecast struct {
+	Field  string    `json:"field"`  // Field key, e.g. `disk.mountpoints.used_percent{mountpoint="/"}`
+	Value  float64   `json:"value"`  // Fitted value now
+	Slope  float64   `json:"slope"`  // Fitted change per second
+	R2     float64   `json:"r2"`     // Fraction of the variance the trend explains, 0 to 1
+	Points int       `json:"points"` // Points fitted
+	From   time.Time `json:"from"`   // Start of the history fitted
+
+	// Seconds until the field reaches 100%, at the fitted slope and at the
+	// bounds of its 90% confidence interval. Unset when the slope, or the
+	// bound, doesn't grow.
+	SecondsUntilFull      *float64 `json:"seconds_until_full"`
+	SecondsUntilFullLower *float64 `json:"seconds_until_full_lower"` // Earliest, at the steepest slope
+	SecondsUntilFullUpper *float64 `json:"seconds_until_full_upper"` // Latest, at the flattest slope
+}
+
+// Forecaster periodically forecasts fields from the rollups of an
+// aggregator. It implements prometheus.Collector.
+type Forecaster struct {
+	rollups *agg.Rollups
+	opts    Options
+
+	mu        sync.RWMutex
+	forecasts []Forecast
+	updated   time.Time
+}
+
+// New creates a forecaster reading rollups
+func New(rollups *agg.Rollups, opts Options) *Forecaster {
+	return &Forecaster{rollups: rollups, opts: opts}
+}
+
+// Method returns the fitting method
+func (f *Forecaster) Method() string {
+	return f.opts.Method
+}
+
+// Lookback returns the history fitted
+func (f *Forecaster) Lookback() time.Duration {
+	return f.opts.Lookback
+}
+
+// Run recomputes the forecasts every Interval until ctx is done
+func (f *Forecaster) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.opts.Interval)
+	defer ticker.Stop()
+	for {
+		f.Update(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Update recomputes the forecasts at now
+func (f *Forecaster) Update(now time.Time) {
+	from := now.Add(-f.opts.Lookback)
+	var forecasts []Forecast
+	if tier, ok := f.rollups.ChooseTier(from, now, maxBuckets); ok {
+		buckets, _ := f.rollups.Query(tier.Resolution, from, now)
+		for key, points := range f.points(buckets, now) {
+			if forecast, ok := f.forecast(key, points, now); ok {
+				forecasts = append(forecasts, forecast)
+			}
+		}
+	}
+	sort.Slice(forecasts, func(i, j int) bool { return forecasts[i].Field < forecasts[j].Field })
+
+	f.mu.Lock()
+	f.forecasts, f.updated = forecasts, now
+	f.mu.Unlock()
+}
+
+// points collects the bucket averages of every selected field, oldest
+// first, at seconds relative to now
+func (f *Forecaster) points(buckets []agg.RollupBucket, now time.Time) map[string][]point {
+	points := make(map[string][]point)
+	for _, bucket := range buckets {
+		x := bucket.Start.Sub(now).Seconds()
+		for key, stats := range bucket.Fields {
+			name, _, _ := strings.Cut(key, "{")
+			if f.selects(name) {
+				points[key] = append(points[key], point{x: x, y: stats.Avg})
+			}
+		}
+	}
+	return points
+}
+
+// selects reports whether a field name is forecast
+func (f *Forecaster) selects(name string) bool {
+	for _, field := range f.opts.Fields {
+		if name == field || strings.HasPrefix(name, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// forecast fits the points of one field. ok is false if its history is too
+// short.
+func (f *Forecaster) forecast(key string, points []point, now time.Time) (Forecast, bool) {
+	if len(points) < minPoints || points[len(points)-1].x-points[0].x < f.opts.MinHistory.Seconds() {
+		return Forecast{}, false
+	}
+	points = thin(points, maxFitPoints)
+
+	var line fit
+	if f.opts.Method == MethodLinear {
+		line = linearFit(points)
+	} else {
+		line = theilSenFit(points)
+	}
+
+	forecast := Forecast{
+		Field:  key,
+		Value:  line.intercept,
+		Slope:  line.slope,
+		R2:     line.r2,
+		Points: len(points),
+		From:   now.Add(time.Duration(points[0].x * float64(time.Second))),
+	}
+	forecast.SecondsUntilFull = secondsUntilFull(line.intercept, line.slope)
+	forecast.SecondsUntilFullLower = secondsUntilFull(line.intercept, line.slopeHigh)
+	forecast.SecondsUntilFullUpper = secondsUntilFull(line.intercept, line.slopeLow)
+	return forecast, true
+}
+
+// secondsUntilFull extrapolates value at slope to 100%; nil if it doesn't
+// grow
+func secondsUntilFull(value, slope float64) *float64 {
+	if value >= full {
+		seconds := 0.0
+		return &seconds
+	}
+	if slope <= 0 {
+		return nil
+	}
+	seconds := (full - value) / slope
+	return &seconds
+}
+
+// thin averages runs of consecutive points so at most max remain
+func thin(points []point, max int) []point {
+	if len(points) <= max {
+		return points
+	}
+	size := (len(points) + max - 1) / max
+	thinned := make([]point, 0, max)
+	for i := 0; i < len(points); i += size {
+		run := points[i:min(i+size, len(points))]
+		var p point
+		for _, q := range run {
+			p.x += q.x
+			p.y += q.y
+		}
+		p.x /= float64(len(run))
+		p.y /= float64(len(run))
+		thinned = append(thinned, p)
+	}
+	return thinned
+}
+
+// Forecasts returns the latest forecasts, sorted by field key, and when
+// they were computed
+func (f *Forecaster) Forecasts() ([]Forecast, time.Time) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.forecasts, f.updated
+}
+
+var (
+	untilFullDesc      = prometheus.NewDesc("gometrics_forecast_seconds_until_full", "Predicted seconds until a field reaches 100% (+Inf if it isn't growing)", []string{"field"}, nil)
+	untilFullLowerDesc = prometheus.NewDesc("gometrics_forecast_seconds_until_full_lower", "Lower bound of the 90% confidence interval of the seconds until full", []string{"field"}, nil)
+	untilFullUpperDesc = prometheus.NewDesc("gometrics_forecast_seconds_until_full_upper", "Upper bound of the 90% confidence interval of the seconds until full (+Inf if it may not grow)", []string{"field"}, nil)
+	slopeDesc          = prometheus.NewDesc("gometrics_forecast_slope_per_second", "Fitted change of a field per second", []string{"field"}, nil)
+	r2Desc             = prometheus.NewDesc("gometrics_forecast_r2", "Fraction of a field's variance explained by its trend", []string{"field"}, nil)
+)
+
+// Describe implements prometheus.Collector
+func (f *Forecaster) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{untilFullDesc, untilFullLowerDesc, untilFullUpperDesc, slopeDesc, r2Desc} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (f *Forecaster) Collect(ch chan<- prometheus.Metric) {
+	forecasts, _ := f.Forecasts()
+	for _, fc := range forecasts {
+		ch <- prometheus.MustNewConstMetric(untilFullDesc, prometheus.GaugeValue, orInf(fc.SecondsUntilFull), fc.Field)
+		ch <- prometheus.MustNewConstMetric(untilFullLowerDesc, prometheus.GaugeValue, orInf(fc.SecondsUntilFullLower), fc.Field)
+		ch <- prometheus.MustNewConstMetric(untilFullUpperDesc, prometheus.GaugeValue, orInf(fc.SecondsUntilFullUpper), fc.Field)
+		ch <- prometheus.MustNewConstMetric(slopeDesc, prometheus.GaugeValue, fc.Slope, fc.Field)
+		ch <- prometheus.MustNewConstMetric(r2Desc, prometheus.GaugeValue, fc.R2, fc.Field)
+	}
+}
+
+// orInf returns *v, or +Inf if v is nil
+func orInf(v *float64) float64 {
+	if v == nil {
+		return math.Inf(1)
+	}
+	return *v
+}
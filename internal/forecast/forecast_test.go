@@ -0,0 +1,169 @@
+package forecast
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSecondsUntilFull(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		slope float64
+		want  *float64
+	}{
+		{"growing", 40, 0.5, ptr(120)},
+		{"flat", 40, 0, nil},
+		{"falling", 40, -0.5, nil},
+		{"full", 100, 0.5, ptr(0)},
+		{"over full and falling", 105, -1, ptr(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := secondsUntilFull(tt.value, tt.slope)
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil || *got != *tt.want:
+				t.Errorf("secondsUntilFull(%v, %v) = %v, want %v", tt.value, tt.slope, show(got), show(tt.want))
+			}
+		})
+	}
+}
+
+func ptr(v float64) *float64 { return &v }
+
+func show(v *float64) any {
+	if v == nil {
+		return "nil"
+	}
+	return *v
+}
+
+func TestThin(t *testing.T) {
+	points := func(ys ...float64) []point {
+		p := make([]point, len(ys))
+		for i, y := range ys {
+			p[i] = point{x: float64(i), y: y}
+		}
+		return p
+	}
+	tests := []struct {
+		name   string
+		points []point
+		max    int
+		want   []point
+	}{
+		{"under max", points(1, 2, 3), 5, points(1, 2, 3)},
+		{"at max", points(1, 2, 3), 3, points(1, 2, 3)},
+		{"pairs", points(1, 3, 5, 7), 2, []point{{0.5, 2}, {2.5, 6}}},
+		{"short last run", points(1, 2, 3, 4, 5, 6, 7), 3, []point{{1, 2}, {4, 5}, {6, 7}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := thin(tt.points, tt.max)
+			if len(got) != len(tt.want) || len(got) > tt.max {
+				t.Fatalf("thin = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("thin = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestForecastGating(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	f := New(nil, Options{Method: MethodLinear, MinHistory: time.Hour})
+
+	tests := []struct {
+		name   string
+		points []point
+		ok     bool
+	}{
+		{"too few points", line(minPoints-1, 40, 0.01, noNoise), false},
+		{"too short", line(minPoints, 40, 0.01, noNoise), false}, // 19 minutes
+		{"enough", line(61, 40, 0.01, noNoise), true},            // An hour
+		{"too few points over long enough", spread(minPoints-1, 2*time.Hour), false},
+		{"minimum points over long enough", spread(minPoints, 2*time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forecast, ok := f.forecast("memory.used_percent", tt.points, now)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && forecast.Points != len(tt.points) {
+				t.Errorf("fitted %d points, want %d", forecast.Points, len(tt.points))
+			}
+		})
+	}
+}
+
+// spread returns n points of a growing line evenly spread over d up to x = 0
+func spread(n int, d time.Duration) []point {
+	points := make([]point, n)
+	step := d.Seconds() / float64(n-1)
+	for i := range points {
+		x := -d.Seconds() + float64(i)*step
+		points[i] = point{x: x, y: 50 + 0.001*x}
+	}
+	return points
+}
+
+func TestUpdate(t *testing.T) {
+	rollups := agg.NewRollups([]agg.RollupTier{{Resolution: time.Minute, Retention: 24 * time.Hour}})
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+	for i := 0; i <= 120; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		rollups.Add(collect.Sample{Timestamp: at, Memory: &collect.MemoryMetric{UsedPercent: 40 + 0.1*float64(i)}})
+	}
+	now := start.Add(120 * time.Minute)
+
+	f := New(rollups, Options{Fields: []string{"memory.used_percent"}, Method: MethodTheilSen, Lookback: 3 * time.Hour, MinHistory: time.Hour})
+	f.Update(now)
+	forecasts, updated := f.Forecasts()
+	if !updated.Equal(now) || len(forecasts) != 1 {
+		t.Fatalf("got %d forecasts at %v, want 1 at %v", len(forecasts), updated, now)
+	}
+	fc := forecasts[0]
+	// 52% at now, rising 0.1% a minute: full in 480 minutes
+	if fc.Field != "memory.used_percent" || fc.SecondsUntilFull == nil || math.Abs(*fc.SecondsUntilFull-480*60) > 60 {
+		t.Errorf("forecast %s full in %v, want memory.used_percent in %v", fc.Field, show(fc.SecondsUntilFull), 480*60)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	f := New(nil, Options{})
+	f.forecasts = []Forecast{
+		{Field: "flat", Slope: 0, R2: 0},
+		{Field: "growing", Slope: 0.5, R2: 0.9, SecondsUntilFull: ptr(120), SecondsUntilFullLower: ptr(100)},
+	}
+
+	want := `
+# HELP gometrics_forecast_seconds_until_full Predicted seconds until a field reaches 100% (+Inf if it isn't growing)
+# TYPE gometrics_forecast_seconds_until_full gauge
+gometrics_forecast_seconds_until_full{field="flat"} +Inf
+gometrics_forecast_seconds_until_full{field="growing"} 120
+# HELP gometrics_forecast_seconds_until_full_lower Lower bound of the 90% confidence interval of the seconds until full
+# TYPE gometrics_forecast_seconds_until_full_lower gauge
+gometrics_forecast_seconds_until_full_lower{field="flat"} +Inf
+gometrics_forecast_seconds_until_full_lower{field="growing"} 100
+# HELP gometrics_forecast_seconds_until_full_upper Upper bound of the 90% confidence interval of the seconds until full (+Inf if it may not grow)
+# TYPE gometrics_forecast_seconds_until_full_upper gauge
+gometrics_forecast_seconds_until_full_upper{field="flat"} +Inf
+gometrics_forecast_seconds_until_full_upper{field="growing"} +Inf
+`
+	if err := testutil.CollectAndCompare(f, strings.NewReader(want),
+		"gometrics_forecast_seconds_until_full", "gometrics_forecast_seconds_until_full_lower", "gometrics_forecast_seconds_until_full_upper"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,233 @@
+package hub
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// Selector chooses the hosts a fleet query covers
+type Selector struct {
+	Labels      map[string]string // Hosts must have every one of these labels
+	IncludeDown bool              // Also use the last sample of hosts that are down
+}
+
+// FleetStats summarizes one field across hosts
+type FleetStats struct {
+	Hosts int     `json:"hosts"` // Hosts reporting the field
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+// FleetGroup summarizes the hosts sharing the values of the group-by labels
+type FleetGroup struct {
+	Labels map[string]string     `json:"labels"` // Group-by label values; "" for hosts without the label
+	Hosts  []string              `json:"hosts"`  // Host IDs, sorted
+	Fields map[string]FleetStats `json:"fields"`
+}
+
+// FleetSummary summarizes fields across the selected hosts. Fields are keyed
+// by collect.Field.Key(), e.g. `disk.mountpoints.used_percent{mountpoint="/"}`.
+type FleetSummary struct {
+	Hosts  int                   `json:"hosts"` // Hosts selected
+	Fields map[string]FleetStats `json:"fields"`
+	Groups []FleetGroup          `json:"groups,omitempty"` // Only when grouping by labels
+}
+
+// HostValue is the value of a field on one host
+type HostValue struct {
+	ID        string            `json:"id"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     float64           `json:"value"`
+	Timestamp time.Time         `json:"timestamp"` // Of the sample the value is from
+}
+
+// snapshot is a selected host and its latest sample
+type snapshot struct {
+	info   HostInfo
+	latest collect.Sample
+}
+
+// selectHosts returns the hosts matching sel that have sent a sample,
+// sorted by ID
+func (h *Hub) selectHosts(sel Selector) []snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	now := time.Now()
+	var hosts []snapshot
+	for _, ht := range h.hosts {
+		if ht.latest.Timestamp.IsZero() {
+			continue
+		}
+		info := h.infoAt(ht, now)
+		if info.Status != StatusUp && !sel.IncludeDown {
+			continue
+		}
+		if !hasLabels(info.Labels, sel.Labels) {
+			continue
+		}
+		hosts = append(hosts, snapshot{info: info, latest: ht.latest})
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].info.ID < hosts[j].info.ID })
+	return hosts
+}
+
+// hasLabels reports whether labels contains every label of want
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Top returns the k hosts with the highest value of field, or the lowest
+// when ascending. field is a collect.Field key such as "cpu.overall_percent"
+// or `cpu.per_core_percent{core="0"}`. Hosts without the field are left out.
+func (h *Hub) Top(field string, k int, ascending bool, sel Selector) []HostValue {
+	values := []HostValue{}
+	for _, s := range h.selectHosts(sel) {
+		for _, f := range s.latest.Fields() {
+			if f.Key() == field {
+				values = append(values, HostValue{ID: s.info.ID, Labels: s.info.Labels, Value: f.Value, Timestamp: s.latest.Timestamp})
+				break
+			}
+		}
+	}
+
+	// Stable, so ties stay ordered by host ID
+	sort.SliceStable(values, func(i, j int) bool {
+		if ascending {
+			return values[i].Value < values[j].Value
+		}
+		return values[i].Value > values[j].Value
+	})
+	if len(values) > k {
+		values = values[:k]
+	}
+	return values
+}
+
+// Summary returns fleet-wide statistics of the fields matching fields, and
+// per group when groupBy names host labels. A field matches when it is
+// named exactly, by its key or name, or lies below a named section, e.g.
+// "memory" or "disk.mountpoints.used_percent". No fields selects every
+// field.
+func (h *Hub) Summary(fields, groupBy []string, sel Selector) FleetSummary {
+	hosts := h.selectHosts(sel)
+
+	fleet := make(map[string][]float64)
+	groups := make(map[string]*FleetGroup)
+	groupValues := make(map[string]map[string][]float64)
+	for _, s := range hosts {
+		var group map[string][]float64
+		if len(groupBy) > 0 {
+			labels := make(map[string]string, len(groupBy))
+			for _, name := range groupBy {
+				labels[name] = s.info.Labels[name]
+			}
+			key := groupKey(labels, groupBy)
+			g, ok := groups[key]
+			if !ok {
+				g = &FleetGroup{Labels: labels}
+				groups[key] = g
+				groupValues[key] = make(map[string][]float64)
+			}
+			g.Hosts = append(g.Hosts, s.info.ID)
+			group = groupValues[key]
+		}
+
+		for _, f := range s.latest.Fields() {
+			if !matchesField(f, fields) {
+				continue
+			}
+			key := f.Key()
+			fleet[key] = append(fleet[key], f.Value)
+			if group != nil {
+				group[key] = append(group[key], f.Value)
+			}
+		}
+	}
+
+	summary := FleetSummary{Hosts: len(hosts), Fields: summarize(fleet)}
+	if len(groupBy) > 0 {
+		keys := make([]string, 0, len(groups))
+		for key := range groups {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		summary.Groups = make([]FleetGroup, 0, len(keys))
+		for _, key := range keys {
+			g := groups[key]
+			g.Fields = summarize(groupValues[key])
+			summary.Groups = append(summary.Groups, *g)
+		}
+	}
+	return summary
+}
+
+// groupKey identifies the group of the label values in labels
+func groupKey(labels map[string]string, names []string) string {
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(labels[name])
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// matchesField reports whether f is selected by fields
+func matchesField(f collect.Field, fields []string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	for _, name := range fields {
+		if f.Name == name || strings.HasPrefix(f.Name, name+".") || (len(f.Labels) > 0 && f.Key() == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// summarize computes the statistics of every field's values
+func summarize(values map[string][]float64) map[string]FleetStats {
+	stats := make(map[string]FleetStats, len(values))
+	for key, v := range values {
+		sort.Float64s(v)
+		sum := 0.0
+		for _, x := range v {
+			sum += x
+		}
+		stats[key] = FleetStats{
+			Hosts: len(v),
+			Min:   v[0],
+			Max:   v[len(v)-1],
+			Avg:   sum / float64(len(v)),
+			P50:   percentile(v, 0.50),
+			P90:   percentile(v, 0.90),
+			P95:   percentile(v, 0.95),
+			P99:   percentile(v, 0.99),
+		}
+	}
+	return stats
+}
+
+// percentile returns the q-th quantile of sorted values, interpolating
+// linearly between the closest ranks. Fleets are small enough to keep
+// this exact rather than sketched.
+func percentile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
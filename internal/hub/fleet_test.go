@@ -0,0 +1,177 @@
+package hub
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// fleet returns a hub with three hosts that are up and one that is down
+func fleet(t *testing.T) *Hub {
+	t.Helper()
+	h := New(Options{DownAfter: time.Minute, HistorySize: 10, MaxHosts: 10})
+	hosts := []struct {
+		id     string
+		region string
+		cpu    float64
+		cores  []float64
+		memory float64
+	}{
+		{"a", "eu", 50, []float64{10, 20}, 10},
+		{"b", "eu", 70, nil, 30},
+		{"c", "us", 50, []float64{30, 40}, 20},
+		{"d", "", 90, []float64{50, 60}, 40},
+	}
+	for _, host := range hosts {
+		var labels map[string]string
+		if host.region != "" {
+			labels = map[string]string{"region": host.region}
+		}
+		sample := collect.Sample{
+			Timestamp: base,
+			CPU:       &collect.CPUMetric{OverallPercent: host.cpu, PerCorePercent: host.cores},
+			Memory:    &collect.MemoryMetric{UsedPercent: host.memory},
+		}
+		if _, err := h.Record(host.id, SourcePush, labels, sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.hosts["d"].info.LastSeen = time.Now().Add(-time.Hour)
+
+	// A configured target that never answered has no sample to use
+	h.Fail("e", nil, errors.New("connection refused"))
+	return h
+}
+
+func TestTop(t *testing.T) {
+	h := fleet(t)
+	eu := Selector{Labels: map[string]string{"region": "eu"}}
+
+	tests := []struct {
+		name      string
+		field     string
+		k         int
+		ascending bool
+		sel       Selector
+		want      []string
+	}{
+		{"highest, ties by ID", "cpu.overall_percent", 3, false, Selector{}, []string{"b", "a", "c"}},
+		{"lowest", "cpu.overall_percent", 2, true, Selector{}, []string{"a", "c"}},
+		{"k over hosts", "cpu.overall_percent", 10, false, Selector{}, []string{"b", "a", "c"}},
+		{"include down", "cpu.overall_percent", 2, false, Selector{IncludeDown: true}, []string{"d", "b"}},
+		{"by label", "cpu.overall_percent", 3, false, eu, []string{"b", "a"}},
+		{"labeled field", `cpu.per_core_percent{core="1"}`, 3, false, Selector{}, []string{"c", "a"}},
+		{"unknown field", "cpu.steal_percent", 3, false, Selector{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := h.Top(tt.field, tt.k, tt.ascending, tt.sel)
+			ids := []string{}
+			for _, v := range values {
+				ids = append(ids, v.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("Top = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	if values := h.Top("cpu.overall_percent", 1, false, Selector{}); values[0].Value != 70 ||
+		!values[0].Timestamp.Equal(base) || values[0].Labels["region"] != "eu" {
+		t.Errorf("top value = %+v", values[0])
+	}
+}
+
+func TestSummary(t *testing.T) {
+	h := fleet(t)
+
+	summary := h.Summary([]string{"memory.used_percent"}, nil, Selector{})
+	if summary.Hosts != 3 || len(summary.Fields) != 1 || summary.Groups != nil {
+		t.Fatalf("summary = %+v, want 3 hosts and one field", summary)
+	}
+	// Values 10, 20 and 30
+	want := FleetStats{Hosts: 3, Min: 10, Max: 30, Avg: 20, P50: 20, P90: 28, P95: 29, P99: 29.8}
+	if got := summary.Fields["memory.used_percent"]; !statsNear(got, want) {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+
+	withDown := h.Summary([]string{"memory.used_percent"}, nil, Selector{IncludeDown: true})
+	if stats := withDown.Fields["memory.used_percent"]; withDown.Hosts != 4 || stats.Max != 40 || stats.Avg != 25 {
+		t.Errorf("with down hosts = %+v", withDown)
+	}
+}
+
+func TestSummaryFields(t *testing.T) {
+	h := fleet(t)
+
+	tests := []struct {
+		name   string
+		fields []string
+		has    []string
+		hasNot []string
+	}{
+		{"section", []string{"memory"}, []string{"memory.used_percent", "memory.total_bytes"}, []string{"cpu.overall_percent"}},
+		{"name", []string{"cpu.per_core_percent"}, []string{`cpu.per_core_percent{core="0"}`, `cpu.per_core_percent{core="1"}`}, []string{"cpu.overall_percent"}},
+		{"key", []string{`cpu.per_core_percent{core="0"}`}, []string{`cpu.per_core_percent{core="0"}`}, []string{`cpu.per_core_percent{core="1"}`}},
+		{"prefix of a name", []string{"cpu.overall"}, nil, []string{"cpu.overall_percent"}},
+		{"every field", nil, []string{"cpu.overall_percent", "memory.used_percent"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := h.Summary(tt.fields, nil, Selector{}).Fields
+			for _, key := range tt.has {
+				if _, ok := fields[key]; !ok {
+					t.Errorf("%s missing", key)
+				}
+			}
+			for _, key := range tt.hasNot {
+				if _, ok := fields[key]; ok {
+					t.Errorf("%s selected", key)
+				}
+			}
+		})
+	}
+
+	// Hosts without the field aren't counted for it
+	if stats := h.Summary([]string{`cpu.per_core_percent{core="0"}`}, nil, Selector{}).Fields[`cpu.per_core_percent{core="0"}`]; stats.Hosts != 2 {
+		t.Errorf("core 0 reported by %d hosts, want 2", stats.Hosts)
+	}
+}
+
+func TestSummaryGroups(t *testing.T) {
+	h := fleet(t)
+
+	summary := h.Summary([]string{"cpu.overall_percent"}, []string{"region"}, Selector{IncludeDown: true})
+	want := []struct {
+		region string
+		hosts  []string
+		avg    float64
+	}{
+		{"", []string{"d"}, 90}, // Hosts without the label
+		{"eu", []string{"a", "b"}, 60},
+		{"us", []string{"c"}, 50},
+	}
+	if len(summary.Groups) != len(want) {
+		t.Fatalf("got %d groups, want %d", len(summary.Groups), len(want))
+	}
+	for i, w := range want {
+		g := summary.Groups[i]
+		if g.Labels["region"] != w.region || !reflect.DeepEqual(g.Hosts, w.hosts) || g.Fields["cpu.overall_percent"].Avg != w.avg {
+			t.Errorf("group %d = %v %v avg %v, want %q %v avg %v",
+				i, g.Labels, g.Hosts, g.Fields["cpu.overall_percent"].Avg, w.region, w.hosts, w.avg)
+		}
+	}
+	if stats := summary.Fields["cpu.overall_percent"]; summary.Hosts != 4 || stats.Hosts != 4 {
+		t.Errorf("fleet-wide stats over %d hosts, want 4", stats.Hosts)
+	}
+}
+
+func statsNear(a, b FleetStats) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.Hosts == b.Hosts && near(a.Min, b.Min) && near(a.Max, b.Max) && near(a.Avg, b.Avg) &&
+		near(a.P50, b.P50) && near(a.P90, b.P90) && near(a.P95, b.P95) && near(a.P99, b.P99)
+}
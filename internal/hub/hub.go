@@ -0,0 +1,295 @@
+// Package hub aggregates samples from many GoMetrics agents into one
+// server. Agents push their samples, or the hub pulls them; either way the
+// hub keeps the latest sample and recent history of every host, keyed by
+// the host's ID, and marks hosts down when they stop reporting.
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	logger   = logging.For("hub")
+	warnings = logging.NewLimited(logger, time.Minute)
+)
+
+// Host statuses
+const (
+	StatusUp   = "up"   // Reported within DownAfter
+	StatusDown = "down" // Has not reported for DownAfter
+)
+
+// Sample sources
+const (
+	SourcePush = "push"
+	SourcePull = "pull"
+)
+
+// Errors returned by Record
+var (
+	ErrInvalidID    = errors.New("host ID must be 1-128 letters, digits, '.', '_' or '-'")
+	ErrTooManyHosts = errors.New("too many hosts")
+)
+
+// seriesExpiry is how long a host that has gone down keeps its
+// gometrics_hub_host_up series
+const seriesExpiry = 24 * time.Hour
+
+// hostUp is 1 for hosts that are up and 0 for hosts that are down
+var hostUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "gometrics_hub_host_up",
+		Help: "Whether a federated host is reporting (1) or down (0)",
+	},
+	[]string{"host"},
+)
+
+func init() {
+	prometheus.MustRegister(hostUp)
+}
+
+// Options configures a Hub
+type Options struct {
+	DownAfter   time.Duration // A host is down when it has not reported for this long
+	HistorySize int           // Samples kept per host
+	MaxHosts    int           // New hosts beyond this are rejected
+}
+
+// HostInfo describes a host known to the hub
+type HostInfo struct {
+	ID         string            `json:"id"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Source     string            `json:"source"`          // SourcePush or SourcePull
+	Status     string            `json:"status"`          // StatusUp or StatusDown
+	LastSeen   time.Time         `json:"last_seen"`       // When the hub last received a sample, by the hub's clock
+	LastSample time.Time         `json:"last_sample"`     // Timestamp of the latest sample, by the host's clock
+	Samples    uint64            `json:"samples"`         // Samples received since the hub started
+	Error      string            `json:"error,omitempty"` // Last pull error, if any
+}
+
+// host is the state of one host
+type host struct {
+	info     HostInfo
+	latest   collect.Sample
+	recent   *agg.Recent
+	up       bool // Status last reported by check
+	exported bool // Has a gometrics_hub_host_up series
+}
+
+// Hub keeps the samples of every federated host
+type Hub struct {
+	opts Options
+
+	mu    sync.RWMutex
+	hosts map[string]*host
+}
+
+// New creates a hub
+func New(opts Options) *Hub {
+	return &Hub{
+		opts:  opts,
+		hosts: make(map[string]*host),
+	}
+}
+
+// ValidID reports whether id can identify a host
+func ValidID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Record stores samples received from a host, creating the host on first
+// contact. Labels replace the host's labels unless empty. Samples that are
+// not newer than the host's latest sample are skipped; Record returns how
+// many were stored.
+func (h *Hub) Record(id, source string, labels map[string]string, samples ...collect.Sample) (int, error) {
+	if !ValidID(id) {
+		return 0, ErrInvalidID
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ht, ok := h.hosts[id]
+	if !ok {
+		if len(h.hosts) >= h.opts.MaxHosts {
+			return 0, fmt.Errorf("%w: the hub keeps at most %d", ErrTooManyHosts, h.opts.MaxHosts)
+		}
+		ht = &host{
+			info:   HostInfo{ID: id, Source: source},
+			recent: agg.NewRecent(h.opts.HistorySize),
+		}
+		h.hosts[id] = ht
+	}
+	if len(labels) > 0 {
+		ht.info.Labels = labels
+	}
+	ht.info.Source = source
+	ht.info.LastSeen = time.Now()
+	ht.info.Error = ""
+
+	stored := 0
+	for _, sample := range samples {
+		if !sample.Timestamp.After(ht.latest.Timestamp) {
+			continue
+		}
+		ht.latest = sample
+		ht.recent.Add(sample)
+		ht.info.Samples++
+		stored++
+	}
+	ht.info.LastSample = ht.latest.Timestamp
+	h.setUp(ht, true)
+	return stored, nil
+}
+
+// Fail records a failed pull of a known or configured host. The host is
+// created if needed, so configured targets are listed before they first
+// answer.
+func (h *Hub) Fail(id string, labels map[string]string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ht, ok := h.hosts[id]
+	if !ok {
+		ht = &host{
+			info:   HostInfo{ID: id, Source: SourcePull, Labels: labels},
+			recent: agg.NewRecent(h.opts.HistorySize),
+		}
+		h.hosts[id] = ht
+		h.setUp(ht, false)
+	}
+	ht.info.Error = err.Error()
+}
+
+// Hosts returns every host, sorted by ID
+func (h *Hub) Hosts() []HostInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	now := time.Now()
+	hosts := make([]HostInfo, 0, len(h.hosts))
+	for _, ht := range h.hosts {
+		hosts = append(hosts, h.infoAt(ht, now))
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].ID < hosts[j].ID })
+	return hosts
+}
+
+// Host returns a host and its latest sample. ok is false for unknown hosts.
+func (h *Hub) Host(id string) (info HostInfo, latest collect.Sample, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ht, ok := h.hosts[id]
+	if !ok {
+		return HostInfo{}, collect.Sample{}, false
+	}
+	return h.infoAt(ht, time.Now()), ht.latest, true
+}
+
+// History returns the kept samples of a host with from <= timestamp <= to,
+// oldest first. ok is false for unknown hosts.
+func (h *Hub) History(id string, from, to time.Time) (samples []collect.Sample, ok bool) {
+	h.mu.RLock()
+	ht, ok := h.hosts[id]
+	h.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	samples = []collect.Sample{}
+	for _, sample := range ht.recent.Since(from.Add(-time.Nanosecond)) {
+		if sample.Timestamp.After(to) {
+			break
+		}
+		samples = append(samples, sample)
+	}
+	return samples, true
+}
+
+// infoAt returns the host's info with its status at now. Caller holds h.mu.
+func (h *Hub) infoAt(ht *host, now time.Time) HostInfo {
+	info := ht.info
+	info.Status = StatusDown
+	if !info.LastSeen.IsZero() && now.Sub(info.LastSeen) <= h.opts.DownAfter {
+		info.Status = StatusUp
+	}
+	return info
+}
+
+// Run marks hosts down when they stop reporting, until ctx is cancelled.
+// Statuses are always current in Hosts and Host; Run logs the changes and
+// updates the gometrics_hub_host_up gauge.
+func (h *Hub) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.opts.DownAfter / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.check(now)
+		}
+	}
+}
+
+// check updates the up state of every host. The series of a host that
+// has been down for seriesExpiry is deleted, until it reports again.
+func (h *Hub) check(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, ht := range h.hosts {
+		up := h.infoAt(ht, now).Status == StatusUp
+		if !up && !ht.up && !ht.info.LastSeen.IsZero() && now.Sub(ht.info.LastSeen) > seriesExpiry {
+			if ht.exported {
+				hostUp.DeleteLabelValues(ht.info.ID)
+				ht.exported = false
+				logger.Info("Host series expired", "host", ht.info.ID, "last_seen", ht.info.LastSeen)
+			}
+			continue
+		}
+		h.setUp(ht, up)
+	}
+}
+
+// setUp records a host's up state, logging changes. Caller holds h.mu.
+func (h *Hub) setUp(ht *host, up bool) {
+	value := 0.0
+	if up {
+		value = 1
+	}
+	hostUp.WithLabelValues(ht.info.ID).Set(value)
+	ht.exported = true
+
+	if up == ht.up {
+		return
+	}
+	ht.up = up
+	if up {
+		logger.Info("Host is up", "host", ht.info.ID, "source", ht.info.Source)
+	} else if !ht.info.LastSeen.IsZero() {
+		logger.Warn("Host is down", "host", ht.info.ID, "last_seen", ht.info.LastSeen)
+	}
+}
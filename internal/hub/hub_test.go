@@ -0,0 +1,200 @@
+package hub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var base = time.Unix(1700000000, 0)
+
+// memorySample returns a sample i seconds after base
+func memorySample(i int, used float64) collect.Sample {
+	return collect.Sample{
+		Timestamp: base.Add(time.Duration(i) * time.Second),
+		Memory:    &collect.MemoryMetric{UsedPercent: used},
+	}
+}
+
+func newHub() *Hub {
+	return New(Options{DownAfter: time.Minute, HistorySize: 10, MaxHosts: 2})
+}
+
+func TestRecord(t *testing.T) {
+	h := newHub()
+	labels := map[string]string{"region": "eu"}
+
+	tests := []struct {
+		name    string
+		id      string
+		labels  map[string]string
+		samples []collect.Sample
+		stored  int
+		err     error
+	}{
+		{"new host", "web-1", labels, []collect.Sample{memorySample(1, 10), memorySample(2, 20)}, 2, nil},
+		{"older and equal skipped", "web-1", nil, []collect.Sample{memorySample(1, 10), memorySample(2, 20), memorySample(3, 30)}, 1, nil},
+		{"second host", "web-2", nil, []collect.Sample{memorySample(1, 10)}, 1, nil},
+		{"over MaxHosts", "web-3", nil, []collect.Sample{memorySample(1, 10)}, 0, ErrTooManyHosts},
+		{"invalid ID", "web 1", nil, []collect.Sample{memorySample(1, 10)}, 0, ErrInvalidID},
+		{"known host over MaxHosts", "web-2", nil, []collect.Sample{memorySample(2, 10)}, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored, err := h.Record(tt.id, SourcePush, tt.labels, tt.samples...)
+			if stored != tt.stored || !errors.Is(err, tt.err) {
+				t.Errorf("Record = %d, %v, want %d, %v", stored, err, tt.stored, tt.err)
+			}
+		})
+	}
+
+	info, latest, ok := h.Host("web-1")
+	if !ok {
+		t.Fatal("web-1 not found")
+	}
+	if info.Samples != 3 || !info.LastSample.Equal(memorySample(3, 0).Timestamp) || latest.Memory.UsedPercent != 30 {
+		t.Errorf("web-1 has %d samples, latest at %v with %v, want 3 at %v with 30",
+			info.Samples, info.LastSample, latest.Memory.UsedPercent, memorySample(3, 0).Timestamp)
+	}
+	if info.Labels["region"] != "eu" || info.Status != StatusUp || info.Source != SourcePush {
+		t.Errorf("web-1 = %+v, want labels kept, up and pushed", info)
+	}
+	if _, _, ok := h.Host("web-3"); ok {
+		t.Error("rejected host web-3 was created")
+	}
+}
+
+func TestInfoAt(t *testing.T) {
+	h := newHub()
+	h.Record("web-1", SourcePush, nil, memorySample(1, 10))
+	ht := h.hosts["web-1"]
+	seen := ht.info.LastSeen
+
+	tests := []struct {
+		name  string
+		at    time.Duration // After the host was last seen
+		state string
+	}{
+		{"just seen", 0, StatusUp},
+		{"at DownAfter", time.Minute, StatusUp},
+		{"after DownAfter", time.Minute + time.Nanosecond, StatusDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.infoAt(ht, seen.Add(tt.at)).Status; got != tt.state {
+				t.Errorf("status = %s, want %s", got, tt.state)
+			}
+		})
+	}
+
+	// check exports the transitions, and the host comes back up when it
+	// reports again
+	up := hostUp.WithLabelValues("web-1")
+	h.check(seen.Add(2 * time.Minute))
+	if v := testutil.ToFloat64(up); v != 0 || ht.up {
+		t.Errorf("after DownAfter gauge = %v, up = %v, want 0 and false", v, ht.up)
+	}
+	h.Record("web-1", SourcePush, nil, memorySample(2, 10))
+	if v := testutil.ToFloat64(up); v != 1 || !ht.up {
+		t.Errorf("after reporting again gauge = %v, up = %v, want 1 and true", v, ht.up)
+	}
+}
+
+func TestSeriesExpiry(t *testing.T) {
+	hostUp.Reset()
+	h := newHub()
+	h.Record("gone-1", SourcePush, nil, memorySample(1, 10))
+	h.Fail("never-1", nil, errors.New("connection refused"))
+	seen := h.hosts["gone-1"].info.LastSeen
+
+	// The configured target that never answered keeps its series
+	tests := []struct {
+		name   string
+		action func()
+		series int
+	}{
+		{"down", func() { h.check(seen.Add(2 * time.Minute)) }, 2},
+		{"expired", func() { h.check(seen.Add(seriesExpiry + time.Minute)) }, 1},
+		{"still expired", func() { h.check(seen.Add(seriesExpiry + 2*time.Minute)) }, 1},
+		{"reported again", func() { h.Record("gone-1", SourcePush, nil, memorySample(2, 10)) }, 2},
+	}
+	for _, tt := range tests {
+		tt.action()
+		if n := testutil.CollectAndCount(hostUp); n != tt.series {
+			t.Errorf("%s: %d series, want %d", tt.name, n, tt.series)
+		}
+	}
+	if _, _, ok := h.Host("gone-1"); !ok {
+		t.Error("host no longer listed after its series expired")
+	}
+}
+
+func TestFail(t *testing.T) {
+	h := newHub()
+	labels := map[string]string{"region": "eu"}
+	h.Fail("web-1", labels, errors.New("connection refused"))
+
+	hosts := h.Hosts()
+	if len(hosts) != 1 {
+		t.Fatalf("got %d hosts, want 1", len(hosts))
+	}
+	info := hosts[0]
+	if info.ID != "web-1" || info.Status != StatusDown || info.Source != SourcePull ||
+		info.Error != "connection refused" || info.Labels["region"] != "eu" || !info.LastSeen.IsZero() {
+		t.Errorf("failed target = %+v", info)
+	}
+	if v := testutil.ToFloat64(hostUp.WithLabelValues("web-1")); v != 0 {
+		t.Errorf("gauge = %v, want 0", v)
+	}
+
+	// A successful pull clears the error
+	h.Record("web-1", SourcePull, labels, memorySample(1, 10))
+	if info, _, _ := h.Host("web-1"); info.Error != "" || info.Status != StatusUp {
+		t.Errorf("after a successful pull = %+v", info)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h := newHub()
+	for i := 1; i <= 15; i++ {
+		h.Record("web-1", SourcePush, nil, memorySample(i, float64(i)))
+	}
+
+	at := func(i int) time.Time { return memorySample(i, 0).Timestamp }
+	tests := []struct {
+		name        string
+		from, to    time.Time
+		first, last int // Seconds after base; 0 for none
+	}{
+		{"all kept", base, at(100), 6, 15},
+		{"bounds inclusive", at(8), at(10), 8, 10},
+		{"single", at(12), at(12), 12, 12},
+		{"between samples", at(8).Add(time.Millisecond), at(9).Add(-time.Millisecond), 0, 0},
+		{"after", at(16), at(20), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, ok := h.History("web-1", tt.from, tt.to)
+			if !ok || samples == nil {
+				t.Fatalf("History = %v, %v", samples, ok)
+			}
+			if tt.first == 0 {
+				if len(samples) != 0 {
+					t.Errorf("got %d samples, want none", len(samples))
+				}
+				return
+			}
+			if n := tt.last - tt.first + 1; len(samples) != n ||
+				!samples[0].Timestamp.Equal(at(tt.first)) || !samples[n-1].Timestamp.Equal(at(tt.last)) {
+				t.Errorf("got %d samples from %v, want %d from %v", len(samples), samples[0].Timestamp, n, at(tt.first))
+			}
+		})
+	}
+
+	if _, ok := h.History("web-2", base, at(100)); ok {
+		t.Error("History of an unknown host ok")
+	}
+}
@@ -0,0 +1,103 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// Target is an agent the hub pulls samples from
+type Target struct {
+	ID     string
+	URL    string // Base URL of the agent, e.g. http://web-1:8080
+	Token  string // Bearer token with the read scope; empty sends none
+	Labels map[string]string
+}
+
+// maxResponseBytes bounds the size of a pulled sample
+const maxResponseBytes = 4 << 20
+
+// Pull fetches the latest sample of every target each interval until ctx
+// is cancelled. Targets are pulled concurrently, each with a timeout of
+// one interval.
+func (h *Hub) Pull(ctx context.Context, targets []Target, interval time.Duration) {
+	client := &http.Client{Timeout: interval}
+
+	pullAll := func() {
+		var wg sync.WaitGroup
+		for _, target := range targets {
+			wg.Add(1)
+			go func(target Target) {
+				defer wg.Done()
+				sample, err := fetchLatest(ctx, client, target)
+				if err != nil {
+					if ctx.Err() == nil {
+						logger.Debug("Pull failed", "host", target.ID, "error", err)
+						h.Fail(target.ID, target.Labels, err)
+					}
+					return
+				}
+				if _, err := h.Record(target.ID, SourcePull, target.Labels, sample); err != nil {
+					warnings.Warn("Pulled sample not recorded", "host", target.ID, "error", err)
+				}
+			}(target)
+		}
+		wg.Wait()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pullAll()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pullAll()
+		}
+	}
+}
+
+// fetchLatest gets the latest sample from an agent's versioned API
+func fetchLatest(ctx context.Context, client *http.Client, target Target) (collect.Sample, error) {
+	url := strings.TrimSuffix(target.URL, "/") + "/api/v1/metrics/latest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return collect.Sample{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if target.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+target.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return collect.Sample{}, err
+	}
+	defer resp.Body.Close()
+
+	body := io.LimitReader(resp.Body, maxResponseBytes)
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(body).Decode(&e)
+		return collect.Sample{}, fmt.Errorf("%s: %s %s", url, resp.Status, e.Error)
+	}
+
+	var envelope struct {
+		Data collect.Sample `json:"data"`
+	}
+	if err := json.NewDecoder(body).Decode(&envelope); err != nil {
+		return collect.Sample{}, fmt.Errorf("%s: %w", url, err)
+	}
+	return envelope.Data, nil
+}
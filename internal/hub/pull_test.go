@@ -0,0 +1,92 @@
+package hub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchLatest(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		used    float64
+		wantErr string
+	}{
+		{"envelope", http.StatusOK, `{"data":{"timestamp":"2023-11-14T22:13:20Z","memory":{"used_percent":42}}}`, 42, ""},
+		{"error envelope", http.StatusServiceUnavailable, `{"error":"no sample yet"}`, 0, "503 Service Unavailable no sample yet"},
+		{"unauthorized", http.StatusUnauthorized, ``, 0, "401 Unauthorized"},
+		{"malformed", http.StatusOK, `{"data":`, 0, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, authorization string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, authorization = r.URL.Path, r.Header.Get("Authorization")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			target := Target{ID: "web-1", URL: server.URL + "/", Token: "secret"}
+			sample, err := fetchLatest(context.Background(), server.Client(), target)
+			if path != "/api/v1/metrics/latest" || authorization != "Bearer secret" {
+				t.Errorf("requested %s with %q", path, authorization)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sample.Memory == nil || sample.Memory.UsedPercent != tt.used || !sample.Timestamp.Equal(base) {
+				t.Errorf("sample = %+v, want %v at %v", sample, tt.used, base)
+			}
+		})
+	}
+}
+
+func TestPull(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"timestamp":"2023-11-14T22:13:20Z","memory":{"used_percent":42}}}`))
+	}))
+	defer server.Close()
+
+	h := New(Options{DownAfter: time.Minute, HistorySize: 10, MaxHosts: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Pull(ctx, []Target{
+			{ID: "web-1", URL: server.URL},
+			{ID: "web-2", URL: "http://127.0.0.1:1"},
+		}, time.Hour)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		hosts := h.Hosts()
+		if len(hosts) == 2 && hosts[0].Samples == 1 && hosts[1].Error != "" {
+			if hosts[0].Source != SourcePull || hosts[0].Status != StatusUp || hosts[1].Status != StatusDown {
+				t.Errorf("hosts = %+v", hosts)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hosts after the first pull = %+v", hosts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
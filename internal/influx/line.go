@@ -0,0 +1,183 @@
+package influx
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// field is a single key=value pair in a line protocol point
+type field struct {
+	key   string
+	value string
+}
+
+// floatField formats a float field
+func floatField(key string, v float64) field {
+	return field{key, strconv.FormatFloat(v, 'f', -1, 64)}
+}
+
+// uintField formats an unsigned integer field (line protocol "u" suffix,
+// InfluxDB 1.8 and later). Counters may exceed the int64 range of "i".
+func uintField(key string, v uint64) field {
+	return field{key, strconv.FormatUint(v, 10) + "u"}
+}
+
+// Encode renders a sample as InfluxDB line protocol with nanosecond timestamps.
+// tags are added to every point (e.g. host=web-1).
+func Encode(sample collect.Sample, tags map[string]string) []byte {
+	return AppendSample(nil, sample, tags)
+}
+
+// AppendSample appends the line protocol rendering of a sample to b
+func AppendSample(b []byte, sample collect.Sample, tags map[string]string) []byte {
+	ts := sample.Timestamp.UnixNano()
+	common := formatTags(tags)
+
+	// CPU
+	if cpu := sample.CPU; cpu != nil {
+		cpuFields := []field{floatField("usage_percent", cpu.OverallPercent)}
+		if len(cpu.LoadAverage) >= 3 {
+			cpuFields = append(cpuFields,
+				floatField("load1", cpu.LoadAverage[0]),
+				floatField("load5", cpu.LoadAverage[1]),
+				floatField("load15", cpu.LoadAverage[2]),
+			)
+		}
+		b = appendPoint(b, "cpu", common, cpuFields, ts)
+
+		for i, corePercent := range cpu.PerCorePercent {
+			coreTags := formatTags(withTag(tags, "core", strconv.Itoa(i)))
+			b = appendPoint(b, "cpu_core", coreTags, []field{floatField("usage_percent", corePercent)}, ts)
+		}
+	}
+
+	// Memory
+	if mem := sample.Memory; mem != nil {
+		b = appendPoint(b, "memory", common, []field{
+			uintField("total_bytes", mem.TotalBytes),
+			uintField("available_bytes", mem.AvailableBytes),
+			uintField("used_bytes", mem.UsedBytes),
+			floatField("used_percent", mem.UsedPercent),
+			uintField("swap_total_bytes", mem.SwapTotalBytes),
+			uintField("swap_used_bytes", mem.SwapUsedBytes),
+			floatField("swap_used_percent", mem.SwapUsedPercent),
+		}, ts)
+	}
+
+	// Disk usage per mountpoint and I/O
+	if disk := sample.Disk; disk != nil {
+		mountpoints := disk.Mountpoints
+		if len(mountpoints) == 0 {
+			// Older samples only carry root filesystem usage
+			mountpoints = []collect.MountpointUsage{{
+				Mountpoint:  "/",
+				TotalBytes:  disk.TotalBytes,
+				FreeBytes:   disk.FreeBytes,
+				UsedBytes:   disk.UsedBytes,
+				UsedPercent: disk.UsedPercent,
+			}}
+		}
+		for _, mp := range mountpoints {
+			b = appendPoint(b, "disk", formatTags(withTag(tags, "mountpoint", mp.Mountpoint)), []field{
+				uintField("total_bytes", mp.TotalBytes),
+				uintField("free_bytes", mp.FreeBytes),
+				uintField("used_bytes", mp.UsedBytes),
+				floatField("used_percent", mp.UsedPercent),
+			}, ts)
+		}
+		b = appendPoint(b, "diskio", common, []field{
+			uintField("read_bytes", disk.ReadBytes),
+			uintField("write_bytes", disk.WriteBytes),
+			uintField("read_ops", disk.ReadOps),
+			uintField("write_ops", disk.WriteOps),
+		}, ts)
+	}
+
+	// Network. The aggregate and per-interface points use separate
+	// measurements so that summing over "net" does not count traffic twice.
+	if network := sample.Network; network != nil {
+		b = appendPoint(b, "net", common, []field{
+			uintField("bytes_sent", network.BytesSent),
+			uintField("bytes_recv", network.BytesRecv),
+			uintField("packets_sent", network.PacketsSent),
+			uintField("packets_recv", network.PacketsRecv),
+			uintField("errors_in", network.ErrorsIn),
+			uintField("errors_out", network.ErrorsOut),
+			uintField("drops_in", network.DropsIn),
+			uintField("drops_out", network.DropsOut),
+		}, ts)
+
+		for _, iface := range network.Interfaces {
+			b = appendPoint(b, "net_interface", formatTags(withTag(tags, "interface", iface.Interface)), []field{
+				uintField("bytes_sent", iface.BytesSent),
+				uintField("bytes_recv", iface.BytesRecv),
+				uintField("packets_sent", iface.PacketsSent),
+				uintField("packets_recv", iface.PacketsRecv),
+				uintField("errors_in", iface.ErrorsIn),
+				uintField("errors_out", iface.ErrorsOut),
+				uintField("drops_in", iface.DropsIn),
+				uintField("drops_out", iface.DropsOut),
+			}, ts)
+		}
+	}
+
+	return b
+}
+
+// appendPoint appends one line: measurement,tags fields timestamp
+func appendPoint(b []byte, measurement, tags string, fields []field, ts int64) []byte {
+	b = append(b, measurementEscaper.Replace(measurement)...)
+	b = append(b, tags...)
+	b = append(b, ' ')
+	for i, f := range fields {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, tagEscaper.Replace(f.key)...)
+		b = append(b, '=')
+		b = append(b, f.value...)
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, ts, 10)
+	return append(b, '\n')
+}
+
+// formatTags renders tags sorted by key, each prefixed with a comma.
+// InfluxDB performs best when tags are sorted.
+func formatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		if tags[k] == "" {
+			continue // Empty tag values are not allowed
+		}
+		sb.WriteByte(',')
+		sb.WriteString(tagEscaper.Replace(k))
+		sb.WriteByte('=')
+		sb.WriteString(tagEscaper.Replace(tags[k]))
+	}
+	return sb.String()
+}
+
+// withTag returns a copy of tags with one extra tag
+func withTag(tags map[string]string, key, value string) map[string]string {
+	merged := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		merged[k] = v
+	}
+	merged[key] = value
+	return merged
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
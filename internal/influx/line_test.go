@@ -0,0 +1,41 @@
+package influx
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+func TestEncodeUnsignedCounters(t *testing.T) {
+	sample := collect.Sample{
+		Timestamp: time.Unix(1700000000, 0),
+		Network:   &collect.NetworkMetric{BytesSent: math.MaxUint64, BytesRecv: 42},
+	}
+	got := string(Encode(sample, map[string]string{"host": "web-1"}))
+
+	want := "net,host=web-1 bytes_sent=18446744073709551615u,bytes_recv=42u,"
+	if !strings.Contains(got, want) {
+		t.Errorf("Encode() = %q, want it to contain %q", got, want)
+	}
+}
+
+func TestEncodeInterfacesSeparateMeasurement(t *testing.T) {
+	sample := collect.Sample{
+		Timestamp: time.Unix(1700000000, 0),
+		Network: &collect.NetworkMetric{
+			BytesSent:  30,
+			Interfaces: []collect.InterfaceMetric{{Interface: "eth0", BytesSent: 30}},
+		},
+	}
+	got := string(Encode(sample, nil))
+
+	if n := strings.Count(got, "net "); n != 1 {
+		t.Errorf("got %d aggregate net points, want 1:\n%s", n, got)
+	}
+	if !strings.Contains(got, "net_interface,interface=eth0 bytes_sent=30u,") {
+		t.Errorf("Encode() = %q, want a net_interface point for eth0", got)
+	}
+}
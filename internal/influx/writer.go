@@ -0,0 +1,246 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/logging"
+)
+
+var (
+	logger = logging.For("influx")
+
+	// warnings rate limits write errors while InfluxDB is unreachable
+	warnings = logging.NewLimited(logger, time.Minute)
+)
+
+// WriterConfig holds InfluxDB v2 write settings
+type WriterConfig struct {
+	URL           string            // Base URL, e.g. "http://influxdb:8086"
+	Org           string            // Organization name or ID
+	Bucket        string            // Destination bucket
+	Token         string            // API token
+	Tags          map[string]string // Tags added to every point (e.g. host)
+	BatchSize     int               // Samples per write request
+	FlushInterval time.Duration     // Maximum time a sample waits before being written
+	MaxRetries    int               // Retries per batch after the first attempt
+	MaxPending    int               // Samples kept while InfluxDB is unreachable
+	Gzip          bool              // Compress request bodies
+	Timeout       time.Duration     // Per-request timeout
+}
+
+// Writer batches samples and writes them to InfluxDB's /api/v2/write endpoint
+type Writer struct {
+	cfg      WriterConfig
+	writeURL string
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending []collect.Sample
+	dropped int // Samples evicted from the front of pending
+
+	flushChan chan struct{}
+}
+
+// NewWriter creates a new InfluxDB writer
+func NewWriter(cfg WriterConfig) (*Writer, error) {
+	if cfg.URL == "" || cfg.Org == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("influx: url, org and bucket are required")
+	}
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 10 * time.Second
+	}
+	if cfg.MaxPending < cfg.BatchSize {
+		cfg.MaxPending = cfg.BatchSize * 10
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	query := url.Values{}
+	query.Set("org", cfg.Org)
+	query.Set("bucket", cfg.Bucket)
+	query.Set("precision", "ns")
+
+	return &Writer{
+		cfg:       cfg,
+		writeURL:  strings.TrimRight(cfg.URL, "/") + "/api/v2/write?" + query.Encode(),
+		client:    &http.Client{Timeout: cfg.Timeout},
+		flushChan: make(chan struct{}, 1),
+	}, nil
+}
+
+// Name identifies the InfluxDB sink
+func (w *Writer) Name() string {
+	return "influx"
+}
+
+// UpdateFromSample queues a sample for the next batch
+func (w *Writer) UpdateFromSample(sample collect.Sample) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Drop the oldest samples if InfluxDB has been unreachable for a while
+	if len(w.pending) >= w.cfg.MaxPending {
+		w.pending = w.pending[1:]
+		w.dropped++
+	}
+	w.pending = append(w.pending, sample)
+
+	// Wake up the flush loop once a full batch is ready
+	if len(w.pending) >= w.cfg.BatchSize {
+		select {
+		case w.flushChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Start flushes batches until the context is cancelled, then writes
+// whatever is still pending
+func (w *Writer) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	logger.Info("InfluxDB writer started", "bucket", w.cfg.Bucket, "flush_interval", w.cfg.FlushInterval.String())
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("InfluxDB writer stopping")
+			w.flush(context.Background())
+			return
+		case <-ticker.C:
+			w.flush(ctx)
+		case <-w.flushChan:
+			w.flush(ctx)
+		}
+	}
+}
+
+// flush writes pending samples in batches of BatchSize
+func (w *Writer) flush(ctx context.Context) {
+	for {
+		w.mu.Lock()
+		n := min(len(w.pending), w.cfg.BatchSize)
+		batch := w.pending[:n:n]
+		dropped := w.dropped
+		w.mu.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		if err := w.writeBatch(ctx, batch); err != nil {
+			warnings.Error("Error writing samples to InfluxDB", "samples", n, "error", err)
+			if isRetryable(err) {
+				return // Keep the batch for the next flush
+			}
+		}
+
+		// Written, or rejected permanently - either way drop it, minus
+		// anything already evicted while the request was in flight
+		w.mu.Lock()
+		done := max(0, n-(w.dropped-dropped))
+		w.pending = w.pending[done:]
+		w.mu.Unlock()
+	}
+}
+
+// writeBatch encodes and sends a batch, retrying transient failures
+func (w *Writer) writeBatch(ctx context.Context, batch []collect.Sample) error {
+	var body []byte
+	for _, sample := range batch {
+		body = AppendSample(body, sample, w.cfg.Tags)
+	}
+
+	if w.cfg.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(body); err != nil {
+			return fmt.Errorf("compressing batch: %w", err)
+		}
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("compressing batch: %w", err)
+		}
+		body = buf.Bytes()
+	}
+
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= w.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		if err = w.post(ctx, body); err == nil || !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// post sends one write request
+func (w *Writer) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.cfg.Token)
+	}
+	if w.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &writeError{retryable: true, err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &writeError{
+		// Server errors and rate limiting are worth retrying, bad requests are not
+		retryable: resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+		err:       fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg)),
+	}
+}
+
+// writeError records whether a failed write may succeed on retry
+type writeError struct {
+	retryable bool
+	err       error
+}
+
+func (e *writeError) Error() string { return e.err.Error() }
+func (e *writeError) Unwrap() error { return e.err }
+
+// isRetryable reports whether err is a transient write failure
+func isRetryable(err error) bool {
+	we, ok := err.(*writeError)
+	return ok && we.retryable
+}
@@ -0,0 +1,264 @@
+package influx
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+var base = time.Unix(1700000000, 0)
+
+// sampleAt returns a memory sample i seconds after base
+func sampleAt(i int) collect.Sample {
+	return collect.Sample{Timestamp: base.Add(time.Duration(i) * time.Second), Memory: &collect.MemoryMetric{UsedPercent: 50}}
+}
+
+// request is a write request received by influxServer
+type request struct {
+	at      time.Time
+	samples []int // Seconds after base of each point
+	gzip    bool
+}
+
+// influxServer records write requests and answers them with the next
+// status of statuses, then with 204
+type influxServer struct {
+	*httptest.Server
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	requests []request
+}
+
+func newInfluxServer(t *testing.T, statuses ...int) *influxServer {
+	s := &influxServer{t: t, statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *influxServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "metrics" || r.Header.Get("Authorization") != "Token secret" {
+		s.t.Errorf("request to %s with %q", r.URL, r.Header.Get("Authorization"))
+	}
+	var body io.Reader = r.Body
+	req := request{at: time.Now(), gzip: r.Header.Get("Content-Encoding") == "gzip"}
+	if req.gzip {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			s.t.Errorf("body is not gzipped: %v", err)
+			return
+		}
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		s.t.Errorf("reading body: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		ns, _ := strconv.ParseInt(line[strings.LastIndexByte(line, ' ')+1:], 10, 64)
+		req.samples = append(req.samples, int(time.Unix(0, ns).Sub(base)/time.Second))
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	s.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+// received returns the samples of every request, in order
+func (s *influxServer) received() [][]int {
+	var batches [][]int
+	for _, req := range s.all() {
+		batches = append(batches, req.samples)
+	}
+	return batches
+}
+
+// all returns every request so far
+func (s *influxServer) all() []request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]request(nil), s.requests...)
+}
+
+func newWriter(t *testing.T, url string, cfg WriterConfig) *Writer {
+	t.Helper()
+	cfg.URL, cfg.Org, cfg.Bucket, cfg.Token = url, "gometrics", "metrics", "secret"
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = time.Hour
+	}
+	w, err := NewWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func (w *Writer) pendingCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+func TestWriterBatches(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run("gzip "+strconv.FormatBool(compress), func(t *testing.T) {
+			s := newInfluxServer(t)
+			w := newWriter(t, s.URL, WriterConfig{BatchSize: 3, Gzip: compress})
+			for i := 1; i <= 7; i++ {
+				w.UpdateFromSample(sampleAt(i))
+			}
+			w.flush(context.Background())
+
+			want := [][]int{{1, 2, 3}, {4, 5, 6}, {7}}
+			if got := s.received(); !reflect.DeepEqual(got, want) {
+				t.Errorf("batches = %v, want %v", got, want)
+			}
+			for _, req := range s.all() {
+				if req.gzip != compress {
+					t.Errorf("request gzipped = %v, want %v", req.gzip, compress)
+				}
+			}
+			if n := w.pendingCount(); n != 0 {
+				t.Errorf("%d samples left pending", n)
+			}
+		})
+	}
+}
+
+func TestWriterRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int
+		pending  int // Samples kept for the next flush
+	}{
+		{"succeeds", nil, 2, 1, 0},
+		{"retried server error", []int{503}, 1, 2, 0},
+		{"retried rate limit", []int{429}, 1, 2, 0},
+		{"bad request dropped", []int{400}, 2, 1, 0},
+		{"retries exhausted", []int{503, 500}, 1, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newInfluxServer(t, tt.statuses...)
+			w := newWriter(t, s.URL, WriterConfig{BatchSize: 10, MaxRetries: tt.retries})
+			w.UpdateFromSample(sampleAt(1))
+			w.UpdateFromSample(sampleAt(2))
+			w.flush(context.Background())
+
+			got := s.received()
+			if len(got) != tt.requests {
+				t.Fatalf("got %d requests, want %d", len(got), tt.requests)
+			}
+			for _, batch := range got {
+				if !reflect.DeepEqual(batch, []int{1, 2}) {
+					t.Errorf("batch = %v, want the same batch every attempt", batch)
+				}
+			}
+			if n := w.pendingCount(); n != tt.pending {
+				t.Errorf("%d samples pending, want %d", n, tt.pending)
+			}
+		})
+	}
+}
+
+func TestWriterBackoff(t *testing.T) {
+	s := newInfluxServer(t, 503, 503, 503)
+	w := newWriter(t, s.URL, WriterConfig{BatchSize: 10, MaxRetries: 2})
+	w.UpdateFromSample(sampleAt(1))
+	w.flush(context.Background())
+
+	requests := s.all()
+	if len(requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(requests))
+	}
+	for i, want := range []time.Duration{500 * time.Millisecond, time.Second} {
+		if gap := requests[i+1].at.Sub(requests[i].at); gap < want {
+			t.Errorf("retry %d after %v, want at least %v", i+1, gap, want)
+		}
+	}
+}
+
+func TestWriterMaxPending(t *testing.T) {
+	s := newInfluxServer(t)
+	w := newWriter(t, s.URL, WriterConfig{BatchSize: 2, MaxPending: 4})
+	for i := 1; i <= 7; i++ {
+		w.UpdateFromSample(sampleAt(i))
+	}
+	if n := w.pendingCount(); n != 4 || w.dropped != 3 {
+		t.Fatalf("%d pending and %d dropped, want 4 and 3", n, w.dropped)
+	}
+	w.flush(context.Background())
+
+	// The oldest samples are evicted
+	want := [][]int{{4, 5}, {6, 7}}
+	if got := s.received(); !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestWriterFlushOnStop(t *testing.T) {
+	s := newInfluxServer(t)
+	w := newWriter(t, s.URL, WriterConfig{BatchSize: 100})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Start(ctx)
+	}()
+	w.UpdateFromSample(sampleAt(1))
+	w.UpdateFromSample(sampleAt(2))
+	cancel()
+	<-done
+
+	if got := s.received(); !reflect.DeepEqual(got, [][]int{{1, 2}}) {
+		t.Errorf("batches after stopping = %v, want [[1 2]]", got)
+	}
+}
+
+func TestWriterFullBatchWakesFlush(t *testing.T) {
+	s := newInfluxServer(t)
+	w := newWriter(t, s.URL, WriterConfig{BatchSize: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	w.UpdateFromSample(sampleAt(1))
+	w.UpdateFromSample(sampleAt(2))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.received()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("full batch not written before the flush interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := s.received(); !reflect.DeepEqual(got, [][]int{{1, 2}}) {
+		t.Errorf("batches = %v, want [[1 2]]", got)
+	}
+}
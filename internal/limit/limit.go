@@ -0,0 +1,135 @@
+// Package limit protects HTTP endpoints from abusive or runaway clients with
+// per-client rate limits and caps on concurrent expensive requests.
+package limit
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// idleTimeout is how long a client's bucket is kept after its last request
+const idleTimeout = 10 * time.Minute
+
+// RateLimiter is a token bucket per client IP: each client may make Burst
+// requests at once and Rate requests per second on average
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	clients   map[string]*bucket
+	lastPrune time.Time
+}
+
+// bucket is the token bucket of one client
+type bucket struct {
+	tokens float64
+	last   time.Time // When tokens was last updated
+}
+
+// NewRateLimiter creates a limiter allowing rate requests per second with
+// bursts of up to burst requests. A burst below 1 is raised to 1.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   math.Max(float64(burst), 1),
+		clients: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the client's bucket. If it is empty, Allow
+// returns false and how long until the next token is available.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune forgets clients that have been idle long enough for their bucket
+// to refill. Caller holds l.mu.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < idleTimeout {
+		return
+	}
+	l.lastPrune = now
+	for client, b := range l.clients {
+		if now.Sub(b.last) > idleTimeout {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// Middleware rejects requests over the client's rate with 429 Too Many
+// Requests. Clients are identified by the connection's IP address;
+// forwarding headers are ignored because any client can set them.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the connection
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Concurrency returns middleware that serves at most n requests at a time.
+// Further requests are rejected with 503 instead of queueing, so a burst of
+// expensive requests can't pile up goroutines and memory.
+func Concurrency(n int) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, n)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			default:
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, "overloaded", "too many concurrent requests")
+			}
+		})
+	}
+}
+
+// writeError sends a JSON error response like the REST handlers do
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}{msg, code})
+}
@@ -0,0 +1,154 @@
+// Package logging sets up structured logging with log/slog.
+//
+// Every subsystem logs through its own logger from For, which tags records
+// with subsystem=<name> and filters them by that subsystem's level. Loggers
+// can be created at package init; Configure may be called at any time (and
+// again on reload) to change the output format and levels.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Config configures log output
+type Config struct {
+	Format string            // "text" or "json"
+	Level  string            // Default level: "debug", "info", "warn" or "error"
+	Levels map[string]string // Per-subsystem levels, e.g. {"agg": "debug"}
+	Output io.Writer         // Defaults to os.Stderr
+}
+
+var (
+	// output is the handler every logger writes to
+	output atomic.Pointer[slog.Handler]
+
+	// levels holds the level of every subsystem seen so far
+	mu           sync.Mutex
+	levels       = make(map[string]*slog.LevelVar)
+	defaultLevel = new(slog.LevelVar)
+	overrides    = make(map[string]slog.Level)
+)
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	output.Store(&h)
+}
+
+// Configure applies cfg to every existing and future logger, and routes the
+// standard library logger (used by net/http) through slog
+func Configure(cfg Config) error {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	perSubsystem := make(map[string]slog.Level, len(cfg.Levels))
+	for name, value := range cfg.Levels {
+		l, err := ParseLevel(value)
+		if err != nil {
+			return fmt.Errorf("logging: subsystem %s: %w", name, err)
+		}
+		perSubsystem[name] = l
+	}
+
+	w := cfg.Output
+	if w == nil {
+		w = os.Stderr
+	}
+	// Filtering happens per subsystem, so the handler itself accepts everything
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch cfg.Format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("logging: unknown format %q (want text or json)", cfg.Format)
+	}
+	output.Store(&h)
+
+	mu.Lock()
+	defaultLevel.Set(level)
+	overrides = perSubsystem
+	for name, lv := range levels {
+		lv.Set(levelFor(name))
+	}
+	mu.Unlock()
+
+	slog.SetDefault(For("main")) // Also routes the standard library logger
+	return nil
+}
+
+// ParseLevel parses a level name; "" means info
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("logging: unknown level %q (want debug, info, warn or error)", s)
+	}
+	return l, nil
+}
+
+// levelFor returns the configured level of a subsystem. Caller holds mu.
+func levelFor(subsystem string) slog.Level {
+	if l, ok := overrides[subsystem]; ok {
+		return l
+	}
+	return defaultLevel.Level()
+}
+
+// For returns the logger of a subsystem
+func For(subsystem string) *slog.Logger {
+	mu.Lock()
+	lv, ok := levels[subsystem]
+	if !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(levelFor(subsystem))
+		levels[subsystem] = lv
+	}
+	mu.Unlock()
+
+	return slog.New(&handler{level: lv}).With("subsystem", subsystem)
+}
+
+// handler filters by a subsystem level and writes to the current output
+// handler, replaying attributes and groups added with With/WithGroup
+type handler struct {
+	level *slog.LevelVar
+	wrap  []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	out := *output.Load()
+	for _, w := range h.wrap {
+		out = w(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+func (h *handler) with(w func(slog.Handler) slog.Handler) *handler {
+	wrap := make([]func(slog.Handler) slog.Handler, len(h.wrap), len(h.wrap)+1)
+	copy(wrap, h.wrap)
+	return &handler{level: h.level, wrap: append(wrap, w)}
+}
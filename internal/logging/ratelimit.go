@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Limited logs each distinct record (message and attributes) at most once
+// per interval. Repeats in between are counted and reported as
+// "suppressed" with the next identical record.
+type Limited struct {
+	logger   *slog.Logger
+	interval time.Duration
+
+	mu   sync.Mutex
+	seen map[string]*limitState
+}
+
+// maxTracked bounds the number of distinct records remembered
+const maxTracked = 256
+
+// limitState tracks one record
+type limitState struct {
+	last       time.Time
+	suppressed int
+}
+
+// NewLimited wraps logger so repeated messages are rate limited
+func NewLimited(logger *slog.Logger, interval time.Duration) *Limited {
+	return &Limited{
+		logger:   logger,
+		interval: interval,
+		seen:     make(map[string]*limitState),
+	}
+}
+
+// Warn logs a warning unless the same message was logged within the interval
+func (l *Limited) Warn(msg string, args ...any) {
+	l.log(slog.LevelWarn, msg, args...)
+}
+
+// Error logs an error unless the same message was logged within the interval
+func (l *Limited) Error(msg string, args ...any) {
+	l.log(slog.LevelError, msg, args...)
+}
+
+func (l *Limited) log(level slog.Level, msg string, args ...any) {
+	if !l.logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	key := msg + fmt.Sprint(args...)
+	now := time.Now()
+
+	l.mu.Lock()
+	st, ok := l.seen[key]
+	if !ok {
+		if len(l.seen) >= maxTracked {
+			l.prune(now)
+		}
+		st = &limitState{}
+		l.seen[key] = st
+	}
+	if ok && now.Sub(st.last) < l.interval {
+		st.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := st.suppressed
+	st.last = now
+	st.suppressed = 0
+	l.mu.Unlock()
+
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	l.logger.Log(context.Background(), level, msg, args...)
+}
+
+// prune forgets records outside the interval, or all of them if that is
+// not enough. Caller holds l.mu.
+func (l *Limited) prune(now time.Time) {
+	for key, st := range l.seen {
+		if now.Sub(st.last) >= l.interval {
+			delete(l.seen, key)
+		}
+	}
+	if len(l.seen) >= maxTracked {
+		clear(l.seen)
+	}
+}
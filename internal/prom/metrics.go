@@ -15,21 +15,40 @@ type Metrics struct {
 
 	// Memory metrics
 	memoryUsageBytes   *prometheus.GaugeVec
-	memoryUsagePercent prometheus.Gauge
+	memoryUsagePercent *prometheus.GaugeVec
 	swapUsageBytes     *prometheus.GaugeVec
-	swapUsagePercent   prometheus.Gauge
+	swapUsagePercent   *prometheus.GaugeVec
 
 	// Disk metrics
 	diskUsageBytes   *prometheus.GaugeVec
-	diskUsagePercent prometheus.Gauge
+	diskUsagePercent *prometheus.GaugeVec
 	diskIOBytes      *prometheus.GaugeVec
 	diskIOOperations *prometheus.GaugeVec
 
+	// Per-mountpoint disk metrics
+	mountpointUsageBytes   *prometheus.GaugeVec
+	mountpointUsagePercent *prometheus.GaugeVec
+
 	// Network metrics
 	networkBytes   *prometheus.GaugeVec
 	networkPackets *prometheus.GaugeVec
 	networkErrors  *prometheus.GaugeVec
 	networkDrops   *prometheus.GaugeVec
+
+	// Per-interface network metrics
+	interfaceBytes   *prometheus.GaugeVec
+	interfacePackets *prometheus.GaugeVec
+	interfaceErrors  *prometheus.GaugeVec
+	interfaceDrops   *prometheus.GaugeVec
+
+	// Collector health
+	collectorUp *prometheus.GaugeVec
+
+	// Label values exported by the previous update, so series of cores,
+	// mountpoints and interfaces that disappear can be deleted
+	cores       map[string]bool
+	mountpoints map[string]bool
+	interfaces  map[string]bool
 }
 
 // NewMetrics creates and registers all Prometheus metrics
@@ -61,11 +80,12 @@ func NewMetrics() *Metrics {
 			[]string{"type"}, // "total", "used", "available"
 		),
 
-		memoryUsagePercent: prometheus.NewGauge(
+		memoryUsagePercent: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "gometrics_memory_usage_percent",
 				Help: "Memory usage percentage",
 			},
+			nil, // No labels; a vector so the series can be removed
 		),
 
 		swapUsageBytes: prometheus.NewGaugeVec(
@@ -76,11 +96,12 @@ func NewMetrics() *Metrics {
 			[]string{"type"}, // "total", "used"
 		),
 
-		swapUsagePercent: prometheus.NewGauge(
+		swapUsagePercent: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "gometrics_swap_usage_percent",
 				Help: "Swap usage percentage",
 			},
+			nil, // No labels; a vector so the series can be removed
 		),
 
 		// Disk metrics
@@ -92,11 +113,12 @@ func NewMetrics() *Metrics {
 			[]string{"type"}, // "total", "used", "free"
 		),
 
-		diskUsagePercent: prometheus.NewGauge(
+		diskUsagePercent: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "gometrics_disk_usage_percent",
 				Help: "Disk usage percentage",
 			},
+			nil, // No labels; a vector so the series can be removed
 		),
 
 		diskIOBytes: prometheus.NewGaugeVec(
@@ -115,6 +137,23 @@ func NewMetrics() *Metrics {
 			[]string{"direction"}, // "read", "write"
 		),
 
+		// Per-mountpoint disk metrics
+		mountpointUsageBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gometrics_disk_mountpoint_usage_bytes",
+				Help: "Disk usage in bytes per mountpoint",
+			},
+			[]string{"mountpoint", "type"}, // type: "total", "used", "free"
+		),
+
+		mountpointUsagePercent: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gometrics_disk_mountpoint_usage_percent",
+				Help: "Disk usage percentage per mountpoint",
+			},
+			[]string{"mountpoint"},
+		),
+
 		// Network metrics
 		networkBytes: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -147,6 +186,47 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"direction"}, // "in", "out"
 		),
+
+		// Per-interface network metrics
+		interfaceBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gometrics_network_interface_bytes_total",
+				Help: "Total network bytes per interface",
+			},
+			[]string{"interface", "direction"}, // direction: "sent", "received"
+		),
+
+		interfacePackets: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gometrics_network_interface_packets_total",
+				Help: "Total network packets per interface",
+			},
+			[]string{"interface", "direction"}, // direction: "sent", "received"
+		),
+
+		interfaceErrors: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gometrics_network_interface_errors_total",
+				Help: "Total network errors per interface",
+			},
+			[]string{"interface", "direction"}, // direction: "in", "out"
+		),
+
+		interfaceDrops: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gometrics_network_interface_drops_total",
+				Help: "Total network packet drops per interface",
+			},
+			[]string{"interface", "direction"}, // direction: "in", "out"
+		),
+
+		collectorUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gometrics_collector_up",
+				Help: "Whether the collector's last report is ok (1) or failed/stale (0)",
+			},
+			[]string{"collector"}, // "cpu", "memory", "disk", "network"
+		),
 	}
 
 	// Register all metrics with Prometheus
@@ -161,63 +241,167 @@ func NewMetrics() *Metrics {
 		m.diskUsagePercent,
 		m.diskIOBytes,
 		m.diskIOOperations,
+		m.mountpointUsageBytes,
+		m.mountpointUsagePercent,
 		m.networkBytes,
 		m.networkPackets,
 		m.networkErrors,
 		m.networkDrops,
+		m.interfaceBytes,
+		m.interfacePackets,
+		m.interfaceErrors,
+		m.interfaceDrops,
+		m.collectorUp,
 	)
 
 	return m
 }
 
+// Name identifies the Prometheus sink
+func (m *Metrics) Name() string {
+	return "prometheus"
+}
+
 // UpdateFromSample updates all Prometheus metrics from a Sample
 func (m *Metrics) UpdateFromSample(sample collect.Sample) {
 	// Update CPU metrics
-	m.cpuUsagePercent.WithLabelValues("overall").Set(sample.CPU.OverallPercent)
+	if cpu := sample.CPU; cpu != nil {
+		m.cpuUsagePercent.WithLabelValues("overall").Set(cpu.OverallPercent)
 
-	// Update per-core CPU usage
-	for i, corePercent := range sample.CPU.PerCorePercent {
-		label := fmt.Sprintf("core_%d", i)
-		m.cpuUsagePercent.WithLabelValues(label).Set(corePercent)
-	}
+		// Update per-core CPU usage
+		cores := make([]string, len(cpu.PerCorePercent))
+		for i, corePercent := range cpu.PerCorePercent {
+			cores[i] = fmt.Sprintf("core_%d", i)
+			m.cpuUsagePercent.WithLabelValues(cores[i]).Set(corePercent)
+		}
+		m.cores = pruneAbsent(m.cores, cores, "type", m.cpuUsagePercent)
 
-	// Update load averages (if available)
-	if len(sample.CPU.LoadAverage) >= 3 {
-		m.cpuLoadAverage.WithLabelValues("1m").Set(sample.CPU.LoadAverage[0])
-		m.cpuLoadAverage.WithLabelValues("5m").Set(sample.CPU.LoadAverage[1])
-		m.cpuLoadAverage.WithLabelValues("15m").Set(sample.CPU.LoadAverage[2])
+		// Update load averages (if available)
+		if len(cpu.LoadAverage) >= 3 {
+			m.cpuLoadAverage.WithLabelValues("1m").Set(cpu.LoadAverage[0])
+			m.cpuLoadAverage.WithLabelValues("5m").Set(cpu.LoadAverage[1])
+			m.cpuLoadAverage.WithLabelValues("15m").Set(cpu.LoadAverage[2])
+		} else {
+			m.cpuLoadAverage.Reset()
+		}
+	} else {
+		resetAll(m.cpuUsagePercent, m.cpuLoadAverage)
+		m.cores = nil
 	}
 
 	// Update memory metrics
-	m.memoryUsageBytes.WithLabelValues("total").Set(float64(sample.Memory.TotalBytes))
-	m.memoryUsageBytes.WithLabelValues("used").Set(float64(sample.Memory.UsedBytes))
-	m.memoryUsageBytes.WithLabelValues("available").Set(float64(sample.Memory.AvailableBytes))
-	m.memoryUsagePercent.Set(sample.Memory.UsedPercent)
+	if mem := sample.Memory; mem != nil {
+		m.memoryUsageBytes.WithLabelValues("total").Set(float64(mem.TotalBytes))
+		m.memoryUsageBytes.WithLabelValues("used").Set(float64(mem.UsedBytes))
+		m.memoryUsageBytes.WithLabelValues("available").Set(float64(mem.AvailableBytes))
+		m.memoryUsagePercent.WithLabelValues().Set(mem.UsedPercent)
 
-	// Update swap metrics
-	m.swapUsageBytes.WithLabelValues("total").Set(float64(sample.Memory.SwapTotalBytes))
-	m.swapUsageBytes.WithLabelValues("used").Set(float64(sample.Memory.SwapUsedBytes))
-	m.swapUsagePercent.Set(sample.Memory.SwapUsedPercent)
+		// Update swap metrics
+		m.swapUsageBytes.WithLabelValues("total").Set(float64(mem.SwapTotalBytes))
+		m.swapUsageBytes.WithLabelValues("used").Set(float64(mem.SwapUsedBytes))
+		m.swapUsagePercent.WithLabelValues().Set(mem.SwapUsedPercent)
+	} else {
+		resetAll(m.memoryUsageBytes, m.memoryUsagePercent, m.swapUsageBytes, m.swapUsagePercent)
+	}
 
 	// Update disk metrics
-	m.diskUsageBytes.WithLabelValues("total").Set(float64(sample.Disk.TotalBytes))
-	m.diskUsageBytes.WithLabelValues("used").Set(float64(sample.Disk.UsedBytes))
-	m.diskUsageBytes.WithLabelValues("free").Set(float64(sample.Disk.FreeBytes))
-	m.diskUsagePercent.Set(sample.Disk.UsedPercent)
+	if disk := sample.Disk; disk != nil {
+		m.diskUsageBytes.WithLabelValues("total").Set(float64(disk.TotalBytes))
+		m.diskUsageBytes.WithLabelValues("used").Set(float64(disk.UsedBytes))
+		m.diskUsageBytes.WithLabelValues("free").Set(float64(disk.FreeBytes))
+		m.diskUsagePercent.WithLabelValues().Set(disk.UsedPercent)
+
+		m.diskIOBytes.WithLabelValues("read").Set(float64(disk.ReadBytes))
+		m.diskIOBytes.WithLabelValues("write").Set(float64(disk.WriteBytes))
+		m.diskIOOperations.WithLabelValues("read").Set(float64(disk.ReadOps))
+		m.diskIOOperations.WithLabelValues("write").Set(float64(disk.WriteOps))
 
-	m.diskIOBytes.WithLabelValues("read").Set(float64(sample.Disk.ReadBytes))
-	m.diskIOBytes.WithLabelValues("write").Set(float64(sample.Disk.WriteBytes))
-	m.diskIOOperations.WithLabelValues("read").Set(float64(sample.Disk.ReadOps))
-	m.diskIOOperations.WithLabelValues("write").Set(float64(sample.Disk.WriteOps))
+		mountpoints := make([]string, len(disk.Mountpoints))
+		for i, mp := range disk.Mountpoints {
+			mountpoints[i] = mp.Mountpoint
+			m.mountpointUsageBytes.WithLabelValues(mp.Mountpoint, "total").Set(float64(mp.TotalBytes))
+			m.mountpointUsageBytes.WithLabelValues(mp.Mountpoint, "used").Set(float64(mp.UsedBytes))
+			m.mountpointUsageBytes.WithLabelValues(mp.Mountpoint, "free").Set(float64(mp.FreeBytes))
+			m.mountpointUsagePercent.WithLabelValues(mp.Mountpoint).Set(mp.UsedPercent)
+		}
+		m.mountpoints = pruneAbsent(m.mountpoints, mountpoints, "mountpoint",
+			m.mountpointUsageBytes, m.mountpointUsagePercent)
+	} else {
+		resetAll(m.diskUsageBytes, m.diskUsagePercent, m.diskIOBytes, m.diskIOOperations,
+			m.mountpointUsageBytes, m.mountpointUsagePercent)
+		m.mountpoints = nil
+	}
 
 	// Update network metrics
-	m.networkBytes.WithLabelValues("sent").Set(float64(sample.Network.BytesSent))
-	m.networkBytes.WithLabelValues("received").Set(float64(sample.Network.BytesRecv))
-	m.networkPackets.WithLabelValues("sent").Set(float64(sample.Network.PacketsSent))
-	m.networkPackets.WithLabelValues("received").Set(float64(sample.Network.PacketsRecv))
-
-	m.networkErrors.WithLabelValues("in").Set(float64(sample.Network.ErrorsIn))
-	m.networkErrors.WithLabelValues("out").Set(float64(sample.Network.ErrorsOut))
-	m.networkDrops.WithLabelValues("in").Set(float64(sample.Network.DropsIn))
-	m.networkDrops.WithLabelValues("out").Set(float64(sample.Network.DropsOut))
+	if network := sample.Network; network != nil {
+		m.networkBytes.WithLabelValues("sent").Set(float64(network.BytesSent))
+		m.networkBytes.WithLabelValues("received").Set(float64(network.BytesRecv))
+		m.networkPackets.WithLabelValues("sent").Set(float64(network.PacketsSent))
+		m.networkPackets.WithLabelValues("received").Set(float64(network.PacketsRecv))
+
+		m.networkErrors.WithLabelValues("in").Set(float64(network.ErrorsIn))
+		m.networkErrors.WithLabelValues("out").Set(float64(network.ErrorsOut))
+		m.networkDrops.WithLabelValues("in").Set(float64(network.DropsIn))
+		m.networkDrops.WithLabelValues("out").Set(float64(network.DropsOut))
+
+		interfaces := make([]string, len(network.Interfaces))
+		for i, iface := range network.Interfaces {
+			interfaces[i] = iface.Interface
+			m.interfaceBytes.WithLabelValues(iface.Interface, "sent").Set(float64(iface.BytesSent))
+			m.interfaceBytes.WithLabelValues(iface.Interface, "received").Set(float64(iface.BytesRecv))
+			m.interfacePackets.WithLabelValues(iface.Interface, "sent").Set(float64(iface.PacketsSent))
+			m.interfacePackets.WithLabelValues(iface.Interface, "received").Set(float64(iface.PacketsRecv))
+			m.interfaceErrors.WithLabelValues(iface.Interface, "in").Set(float64(iface.ErrorsIn))
+			m.interfaceErrors.WithLabelValues(iface.Interface, "out").Set(float64(iface.ErrorsOut))
+			m.interfaceDrops.WithLabelValues(iface.Interface, "in").Set(float64(iface.DropsIn))
+			m.interfaceDrops.WithLabelValues(iface.Interface, "out").Set(float64(iface.DropsOut))
+		}
+		m.interfaces = pruneAbsent(m.interfaces, interfaces, "interface",
+			m.interfaceBytes, m.interfacePackets, m.interfaceErrors, m.interfaceDrops)
+	} else {
+		resetAll(m.networkBytes, m.networkPackets, m.networkErrors, m.networkDrops,
+			m.interfaceBytes, m.interfacePackets, m.interfaceErrors, m.interfaceDrops)
+		m.interfaces = nil
+	}
+
+	// Collector health; disabled collectors have no series
+	for _, collector := range []string{"cpu", "memory", "disk", "network"} {
+		status, ok := sample.Status[collector]
+		switch {
+		case !ok:
+			m.collectorUp.DeleteLabelValues(collector)
+		case status.Status == collect.StatusOK:
+			m.collectorUp.WithLabelValues(collector).Set(1)
+		default:
+			m.collectorUp.WithLabelValues(collector).Set(0)
+		}
+	}
+}
+
+// pruneAbsent deletes the series of label values that were exported last
+// time but are missing from present, e.g. an unmounted filesystem or a
+// removed interface, so they don't keep their last value forever. It returns
+// the set to pass in on the next update.
+func pruneAbsent(prev map[string]bool, present []string, label string, vecs ...*prometheus.GaugeVec) map[string]bool {
+	cur := make(map[string]bool, len(present))
+	for _, v := range present {
+		cur[v] = true
+	}
+	for v := range prev {
+		if cur[v] {
+			continue
+		}
+		for _, vec := range vecs {
+			vec.DeletePartialMatch(prometheus.Labels{label: v})
+		}
+	}
+	return cur
+}
+
+// resetAll removes every series of the given vectors, so sections without
+// data disappear from the scrape instead of being reported as zero
+func resetAll(vecs ...*prometheus.GaugeVec) {
+	for _, vec := range vecs {
+		vec.Reset()
+	}
 }
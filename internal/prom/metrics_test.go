@@ -0,0 +1,44 @@
+package prom
+
+import (
+	"testing"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAbsentLabelsDeleted(t *testing.T) {
+	m := NewMetrics()
+
+	m.UpdateFromSample(collect.Sample{
+		CPU: &collect.CPUMetric{OverallPercent: 10, PerCorePercent: []float64{5, 15}},
+		Disk: &collect.DiskMetric{Mountpoints: []collect.MountpointUsage{
+			{Mountpoint: "/"}, {Mountpoint: "/mnt/nfs"},
+		}},
+		Network: &collect.NetworkMetric{Interfaces: []collect.InterfaceMetric{
+			{Interface: "eth0"}, {Interface: "veth1"},
+		}},
+	})
+	m.UpdateFromSample(collect.Sample{
+		CPU:     &collect.CPUMetric{OverallPercent: 10},
+		Disk:    &collect.DiskMetric{Mountpoints: []collect.MountpointUsage{{Mountpoint: "/"}}},
+		Network: &collect.NetworkMetric{Interfaces: []collect.InterfaceMetric{{Interface: "eth0"}}},
+	})
+
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"cpu usage", testutil.CollectAndCount(m.cpuUsagePercent), 1}, // overall only
+		{"mountpoint bytes", testutil.CollectAndCount(m.mountpointUsageBytes), 3},
+		{"mountpoint percent", testutil.CollectAndCount(m.mountpointUsagePercent), 1},
+		{"interface bytes", testutil.CollectAndCount(m.interfaceBytes), 2},
+		{"interface drops", testutil.CollectAndCount(m.interfaceDrops), 2},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: %d series, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/codec"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	logger = logging.For("push")
+
+	// warnings rate limits connection errors while the hub is unreachable
+	warnings = logging.NewLimited(logger, time.Minute)
+)
+
+const (
+	dialTimeout      = 10 * time.Second
+	handshakeTimeout = 10 * time.Second
+	writeTimeout     = 10 * time.Second
+
+	// ackTimeout is how long the agent waits for an acknowledgement of sent
+	// samples before it assumes the connection is dead and reconnects
+	ackTimeout = 30 * time.Second
+
+	// flushTimeout bounds how long Start waits for the hub to acknowledge
+	// the last samples after its context is cancelled
+	flushTimeout = 5 * time.Second
+
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	pendingSamples = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gometrics_push_pending_samples",
+		Help: "Samples waiting to be acknowledged by the hub",
+	})
+	droppedSamplesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gometrics_push_dropped_samples_total",
+		Help: "Samples dropped because the backfill buffer was full",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(pendingSamples, droppedSamplesTotal)
+}
+
+// AgentConfig holds push agent settings
+type AgentConfig struct {
+	Address    string            // host:port of the hub's push listener
+	HostID     string            // Identifies this host at the hub; defaults to the hostname
+	Labels     map[string]string // Sent to the hub, e.g. region and role
+	Token      string            // Bearer token with the push scope
+	TLS        *tls.Config       // nil connects without TLS
+	MaxPending int               // Samples kept while the hub is unreachable
+}
+
+// entry is a sample waiting for acknowledgement
+type entry struct {
+	seq    uint64
+	sample collect.Sample
+}
+
+// Agent pushes every sample to a hub. Samples are kept until the hub
+// acknowledges them, so they are backfilled after an outage; when more
+// than MaxPending are waiting the oldest are dropped.
+type Agent struct {
+	cfg       AgentConfig
+	sessionID uint64
+
+	mu      sync.Mutex
+	pending []entry // Oldest first
+	nextSeq uint64
+
+	wake chan struct{} // Signalled when there is something to send or pending shrank
+}
+
+// NewAgent creates a push agent; call Start to connect
+func NewAgent(cfg AgentConfig) (*Agent, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("push: address is required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("push: address must be host:port: %w", err)
+	}
+	if cfg.HostID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("push: host ID is required: %w", err)
+		}
+		cfg.HostID = hostname
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = 10000
+	}
+
+	var session [8]byte
+	if _, err := rand.Read(session[:]); err != nil {
+		return nil, fmt.Errorf("push: %w", err)
+	}
+
+	return &Agent{
+		cfg:       cfg,
+		sessionID: binary.BigEndian.Uint64(session[:]),
+		nextSeq:   1,
+		wake:      make(chan struct{}, 1),
+	}, nil
+}
+
+// Name implements agg.Sink
+func (a *Agent) Name() string {
+	return "push"
+}
+
+// UpdateFromSample queues a sample for the hub
+func (a *Agent) UpdateFromSample(sample collect.Sample) {
+	a.mu.Lock()
+	a.pending = append(a.pending, entry{seq: a.nextSeq, sample: sample})
+	a.nextSeq++
+	if over := len(a.pending) - a.cfg.MaxPending; over > 0 {
+		a.pending = append(a.pending[:0], a.pending[over:]...)
+		droppedSamplesTotal.Add(float64(over))
+		warnings.Warn("Backfill buffer full, dropping oldest samples", "max_pending", a.cfg.MaxPending)
+	}
+	pendingSamples.Set(float64(len(a.pending)))
+	a.mu.Unlock()
+
+	a.signal()
+}
+
+// signal wakes the sender without blocking
+func (a *Agent) signal() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ack forgets samples up to and including seq
+func (a *Agent) ack(seq uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	i := 0
+	for i < len(a.pending) && a.pending[i].seq <= seq {
+		i++
+	}
+	a.pending = append(a.pending[:0], a.pending[i:]...)
+	pendingSamples.Set(float64(len(a.pending)))
+}
+
+// unsent returns the pending samples after seq
+func (a *Agent) unsent(seq uint64) []entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, e := range a.pending {
+		if e.seq > seq {
+			return append([]entry(nil), a.pending[i:]...)
+		}
+	}
+	return nil
+}
+
+// outstanding reports whether any sample is waiting for acknowledgement
+func (a *Agent) outstanding() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.pending) > 0
+}
+
+// Start connects to the hub and pushes samples until ctx is cancelled,
+// reconnecting with exponential backoff. After cancellation it waits up to
+// flushTimeout for the hub to acknowledge what is pending.
+func (a *Agent) Start(ctx context.Context) {
+	backoff := minBackoff
+	for {
+		connected, err := a.session(ctx)
+		if ctx.Err() != nil {
+			if a.outstanding() {
+				logger.Warn("Samples left unacknowledged at shutdown", "error", err)
+			}
+			return
+		}
+		if connected {
+			backoff = minBackoff
+		}
+		warnings.Warn("Hub connection failed, retrying", "addr", a.cfg.Address, "error", err, "retry_in", backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// session runs one connection. connected reports whether the hub accepted
+// the hello.
+func (a *Agent) session(ctx context.Context) (connected bool, err error) {
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 15 * time.Second}
+	var conn net.Conn
+	if a.cfg.TLS != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: a.cfg.TLS}).DialContext(ctx, "tcp", a.cfg.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", a.cfg.Address)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+
+	// Handshake
+	var authorization string
+	if a.cfg.Token != "" {
+		authorization = "Bearer " + a.cfg.Token
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	writeFrame(bw, frameHello, appendHello(nil, hello{
+		Version:       ProtocolVersion,
+		HostID:        a.cfg.HostID,
+		Labels:        a.cfg.Labels,
+		Authorization: authorization,
+		Session:       a.sessionID,
+	}))
+	if err := bw.Flush(); err != nil {
+		return false, err
+	}
+	typ, payload, buf, err := readFrame(br, nil, maxHandshakeFrameSize)
+	if err != nil {
+		return false, err
+	}
+	switch typ {
+	case frameWelcome:
+	case frameError:
+		return false, fmt.Errorf("hub: %s", (&decoder{b: payload}).string())
+	default:
+		return false, errMalformed
+	}
+	sent, _, err := parseSeq(payload)
+	if err != nil {
+		return false, err
+	}
+	a.ack(sent)
+	conn.SetDeadline(time.Time{})
+	logger.Info("Connected to hub", "addr", a.cfg.Address, "host", a.cfg.HostID, "resume_after", sent)
+
+	// Acknowledgements arrive in their own goroutine. If samples are in
+	// flight and none is acknowledged within ackTimeout, the connection is
+	// assumed dead.
+	var acked, progress atomic.Int64 // Last acknowledged sequence number; when it last advanced
+	acked.Store(int64(sent))
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			typ, payload, b, err := readFrame(br, buf, maxFrameSize)
+			buf = b
+			if err != nil {
+				closed <- err
+				return
+			}
+			switch typ {
+			case frameAck:
+				seq, _, err := parseSeq(payload)
+				if err != nil {
+					closed <- err
+					return
+				}
+				a.ack(seq)
+				acked.Store(int64(seq))
+				progress.Store(time.Now().UnixNano())
+				a.signal()
+			case frameError:
+				closed <- fmt.Errorf("hub: %s", (&decoder{b: payload}).string())
+				return
+			default:
+				closed <- errMalformed
+				return
+			}
+		}
+	}()
+
+	check := time.NewTicker(ackTimeout / 3)
+	defer check.Stop()
+
+	done := ctx.Done()
+	var flushDeadline <-chan time.Time
+	var frame []byte
+	for {
+		if batch := a.unsent(sent); len(batch) > 0 {
+			if acked.Load() >= int64(sent) {
+				progress.Store(time.Now().UnixNano()) // Nothing was in flight
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			for _, e := range batch {
+				frame = binary.AppendUvarint(frame[:0], e.seq)
+				frame = codec.AppendSample(frame, e.sample)
+				writeFrame(bw, frameSample, frame)
+			}
+			if err := bw.Flush(); err != nil {
+				return true, err
+			}
+			sent = batch[len(batch)-1].seq
+		}
+		if flushDeadline != nil && !a.outstanding() {
+			return true, nil // Everything acknowledged
+		}
+
+		select {
+		case <-a.wake:
+		case err := <-closed:
+			return true, err
+		case <-check.C:
+			if acked.Load() < int64(sent) && time.Since(time.Unix(0, progress.Load())) > ackTimeout {
+				return true, errors.New("no acknowledgement from hub")
+			}
+		case <-done:
+			done = nil
+			flushDeadline = time.After(flushTimeout)
+		case <-flushDeadline:
+			return true, errors.New("hub did not acknowledge every sample in time")
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Package push streams samples from agents to a hub over a persistent TCP
+// connection.
+//
+// Every message is a frame: a 4-byte big-endian length, then a type byte
+// and the payload, which together are length bytes long. Strings are a
+// uvarint length followed by the bytes.
+//
+// The agent opens the connection with a hello frame carrying the host ID,
+// labels, credentials and a session ID chosen at agent start. The hub
+// answers with welcome, holding the last sequence number it has stored for
+// that session (0 for a new session), and the agent resends everything
+// after it. Each sample frame carries the next sequence number and a
+// codec-encoded sample; the hub acknowledges them cumulatively. Samples
+// the agent had to drop while disconnected show up at the hub as a gap in
+// the sequence numbers.
+package push
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ProtocolVersion is sent in the hello frame
+const ProtocolVersion = 1
+
+// maxFrameSize bounds a frame, so a corrupt length can't exhaust memory
+const maxFrameSize = 16 << 20
+
+// maxHandshakeFrameSize bounds the hello, welcome and error frames, which
+// are read before the peer is authenticated
+const maxHandshakeFrameSize = 8 << 10
+
+// Frame types
+const (
+	frameHello   = 1 // Agent to hub: version, host ID, labels, authorization, session
+	frameWelcome = 2 // Hub to agent: last stored sequence number of the session
+	frameSample  = 3 // Agent to hub: sequence number and codec-encoded sample
+	frameAck     = 4 // Hub to agent: every sample up to this sequence number is stored
+	frameError   = 5 // Hub to agent: why the connection is being closed
+)
+
+// errMalformed is returned for frames that can't be decoded
+var errMalformed = errors.New("push: malformed frame")
+
+// hello is the first frame an agent sends
+type hello struct {
+	Version       uint64
+	HostID        string
+	Labels        map[string]string
+	Authorization string // Value of an HTTP Authorization header, e.g. "Bearer <token>"
+	Session       uint64 // Random per agent process; sequence numbers restart with it
+}
+
+// writeFrame writes one frame to w
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)+1))
+	header[4] = typ
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads one frame of at most limit bytes from r. The payload is
+// only valid until the next call, since buf is reused.
+func readFrame(r *bufio.Reader, buf []byte, limit uint32) (typ byte, payload, newBuf []byte, err error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, buf, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n == 0 || n > limit {
+		return 0, nil, buf, fmt.Errorf("push: invalid frame length %d", n)
+	}
+	if cap(buf) < int(n) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, buf, err
+	}
+	return buf[0], buf[1:], buf, nil
+}
+
+// appendString appends a length-prefixed string
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// decoder reads values from a payload, remembering the first error
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.b)) {
+		d.err = errMalformed
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// appendHello encodes a hello frame payload
+func appendHello(b []byte, h hello) []byte {
+	b = binary.AppendUvarint(b, h.Version)
+	b = appendString(b, h.HostID)
+
+	// Sorted, so the encoding is stable
+	keys := make([]string, 0, len(h.Labels))
+	for k := range h.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = appendString(b, k)
+		b = appendString(b, h.Labels[k])
+	}
+
+	b = appendString(b, h.Authorization)
+	return binary.AppendUvarint(b, h.Session)
+}
+
+// parseHello decodes a hello frame payload
+func parseHello(payload []byte) (hello, error) {
+	d := decoder{b: payload}
+	h := hello{Version: d.uvarint(), HostID: d.string()}
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		return hello{}, errMalformed // Each label takes at least two bytes
+	}
+	if n > 0 {
+		h.Labels = make(map[string]string, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			k := d.string()
+			h.Labels[k] = d.string()
+		}
+	}
+	h.Authorization = d.string()
+	h.Session = d.uvarint()
+	return h, d.err
+}
+
+// parseSeq decodes the sequence number at the start of a welcome, sample
+// or ack payload and returns the rest
+func parseSeq(payload []byte) (uint64, []byte, error) {
+	d := decoder{b: payload}
+	seq := d.uvarint()
+	return seq, d.b, d.err
+}
@@ -0,0 +1,378 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/codec"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/hub"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testToken = "secret"
+
+var base = time.Unix(1700000000, 0)
+
+// sampleAt returns a sample i seconds after base
+func sampleAt(i int) collect.Sample {
+	return collect.Sample{
+		Timestamp: base.Add(time.Duration(i) * time.Second),
+		Memory:    &collect.MemoryMetric{UsedPercent: float64(i)},
+	}
+}
+
+// startServer serves a push server on a loopback port and returns it and
+// its address
+func startServer(t *testing.T, h *hub.Hub) (*Server, string) {
+	t.Helper()
+	s := NewServer(h, func(authorization string, _ *tls.ConnectionState) error {
+		if authorization != "Bearer "+testToken {
+			return errors.New("invalid token")
+		}
+		return nil
+	}, nil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func newHub(maxHosts int) *hub.Hub {
+	return hub.New(hub.Options{DownAfter: time.Minute, HistorySize: 100, MaxHosts: maxHosts})
+}
+
+// client speaks the protocol frame by frame
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, br: bufio.NewReader(conn), bw: bufio.NewWriter(conn)}
+}
+
+// hello sends a hello and returns the type and payload of the answer
+func (c *client) hello(id string, session uint64, token string) (byte, []byte) {
+	c.t.Helper()
+	writeFrame(c.bw, frameHello, appendHello(nil, hello{
+		Version:       ProtocolVersion,
+		HostID:        id,
+		Authorization: "Bearer " + token,
+		Session:       session,
+	}))
+	c.flush()
+	return c.read()
+}
+
+// welcome sends a hello and returns the sequence number of the welcome
+func (c *client) welcome(id string, session uint64) uint64 {
+	c.t.Helper()
+	typ, payload := c.hello(id, session, testToken)
+	if typ != frameWelcome {
+		c.t.Fatalf("got frame type %d (%q), want welcome", typ, payload)
+	}
+	seq, _, err := parseSeq(payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return seq
+}
+
+// send sends one sample frame and returns the type and payload of the answer
+func (c *client) send(seq uint64, sample collect.Sample) (byte, []byte) {
+	c.t.Helper()
+	writeFrame(c.bw, frameSample, codec.AppendSample(binary.AppendUvarint(nil, seq), sample))
+	c.flush()
+	return c.read()
+}
+
+// ack sends one sample and returns the acknowledged sequence number
+func (c *client) ack(seq uint64, sample collect.Sample) uint64 {
+	c.t.Helper()
+	typ, payload := c.send(seq, sample)
+	if typ != frameAck {
+		c.t.Fatalf("got frame type %d (%q) for seq %d, want ack", typ, payload, seq)
+	}
+	acked, _, err := parseSeq(payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return acked
+}
+
+func (c *client) flush() {
+	c.t.Helper()
+	if err := c.bw.Flush(); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() (byte, []byte) {
+	c.t.Helper()
+	typ, payload, _, err := readFrame(c.br, nil, maxFrameSize)
+	if err != nil {
+		c.t.Fatalf("reading frame: %v", err)
+	}
+	return typ, append([]byte(nil), payload...)
+}
+
+// closed reports whether the server closed the connection without
+// sending anything
+func (c *client) closed() bool {
+	_, err := c.br.ReadByte()
+	return errors.Is(err, io.EOF)
+}
+
+// stored returns how many samples the hub stored for a host
+func stored(h *hub.Hub, id string) uint64 {
+	info, _, ok := h.Host(id)
+	if !ok {
+		return 0
+	}
+	return info.Samples
+}
+
+func TestHelloRejected(t *testing.T) {
+	h := newHub(10)
+	_, addr := startServer(t, h)
+
+	tests := []struct {
+		name  string
+		id    string
+		token string
+		want  string
+	}{
+		{"wrong token", "web-1", "wrong", "unauthorized"},
+		{"invalid host ID", "web 1", testToken, "host ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t, addr)
+			typ, payload := c.hello(tt.id, 1, tt.token)
+			msg := (&decoder{b: payload}).string()
+			if typ != frameError || !strings.Contains(msg, tt.want) {
+				t.Errorf("got frame type %d %q, want an error containing %q", typ, msg, tt.want)
+			}
+			if !c.closed() {
+				t.Error("connection left open after rejection")
+			}
+		})
+	}
+	if hosts := h.Hosts(); len(hosts) != 0 {
+		t.Errorf("rejected agents created hosts %v", hosts)
+	}
+}
+
+func TestInvalidFrameLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		hello  bool // Send a valid hello first
+		length uint32
+	}{
+		{"zero-length hello", false, 0},
+		{"oversized hello", false, maxHandshakeFrameSize + 1},
+		{"zero-length sample", true, 0},
+		{"oversized sample", true, maxFrameSize + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := startServer(t, newHub(10))
+			c := dial(t, addr)
+			if tt.hello {
+				c.welcome("web-1", 1)
+			}
+			var header [4]byte
+			binary.BigEndian.PutUint32(header[:], tt.length)
+			c.bw.Write(header[:])
+			c.flush()
+			if !c.closed() {
+				t.Error("connection left open after an invalid frame length")
+			}
+		})
+	}
+}
+
+func TestWelcomeAndResume(t *testing.T) {
+	h := newHub(10)
+	_, addr := startServer(t, h)
+
+	c := dial(t, addr)
+	if seq := c.welcome("web-1", 1); seq != 0 {
+		t.Errorf("welcome of a new host = %d, want 0", seq)
+	}
+	for seq := uint64(1); seq <= 3; seq++ {
+		if acked := c.ack(seq, sampleAt(int(seq))); acked != seq {
+			t.Errorf("ack = %d, want %d", acked, seq)
+		}
+	}
+	c.conn.Close()
+
+	// The same session resumes after the last stored sample
+	c = dial(t, addr)
+	if seq := c.welcome("web-1", 1); seq != 3 {
+		t.Errorf("welcome after reconnect = %d, want 3", seq)
+	}
+	c.conn.Close()
+
+	// A restarted agent starts a new sequence
+	c = dial(t, addr)
+	if seq := c.welcome("web-1", 2); seq != 0 {
+		t.Errorf("welcome of a new session = %d, want 0", seq)
+	}
+	if stored(h, "web-1") != 3 {
+		t.Errorf("stored %d samples, want 3", stored(h, "web-1"))
+	}
+}
+
+func TestGapCounted(t *testing.T) {
+	_, addr := startServer(t, newHub(10))
+	missing := missingSamplesTotal.WithLabelValues("gap-host")
+	before := testutil.ToFloat64(missing)
+
+	c := dial(t, addr)
+	c.welcome("gap-host", 1)
+	c.ack(1, sampleAt(1))
+	if acked := c.ack(5, sampleAt(5)); acked != 5 {
+		t.Errorf("ack after a gap = %d, want 5", acked)
+	}
+	if got := testutil.ToFloat64(missing) - before; got != 3 {
+		t.Errorf("missing samples = %v, want 3", got)
+	}
+}
+
+func TestDuplicateNotRecorded(t *testing.T) {
+	h := newHub(10)
+	_, addr := startServer(t, h)
+
+	c := dial(t, addr)
+	c.welcome("web-1", 1)
+	c.ack(1, sampleAt(1))
+	c.ack(2, sampleAt(2))
+
+	// A resent seq 2 with a newer sample would be stored if it were recorded
+	if acked := c.ack(2, sampleAt(3)); acked != 2 {
+		t.Errorf("ack of a duplicate = %d, want 2", acked)
+	}
+	if n := stored(h, "web-1"); n != 2 {
+		t.Errorf("stored %d samples, want 2", n)
+	}
+}
+
+func TestRefusedHostNotAdvanced(t *testing.T) {
+	h := newHub(1)
+	s, addr := startServer(t, h)
+
+	c := dial(t, addr)
+	c.welcome("web-1", 1)
+	c.ack(1, sampleAt(1))
+
+	// The hub is full, so the second host's sample is refused
+	c = dial(t, addr)
+	c.welcome("web-2", 1)
+	typ, payload := c.send(1, sampleAt(1))
+	if msg := (&decoder{b: payload}).string(); typ != frameError || !strings.Contains(msg, "too many hosts") {
+		t.Fatalf("got frame type %d %q, want too many hosts", typ, msg)
+	}
+	if !c.closed() {
+		t.Fatal("connection left open after refusing the sample")
+	}
+
+	s.mu.Lock()
+	_, ok := s.streams["web-2"]
+	s.mu.Unlock()
+	if ok {
+		t.Error("stream of a refused host kept")
+	}
+	c = dial(t, addr)
+	if seq := c.welcome("web-2", 1); seq != 0 {
+		t.Errorf("welcome after a refused sample = %d, want 0", seq)
+	}
+}
+
+func TestAgentBackfill(t *testing.T) {
+	h := newHub(10)
+	s, addr := startServer(t, h)
+
+	agent, err := NewAgent(AgentConfig{Address: addr, HostID: "web-1", Token: testToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitStored := func(n uint64) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for stored(h, "web-1") < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("hub stored %d samples, want %d", stored(h, "web-1"), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		agent.UpdateFromSample(sampleAt(i))
+	}
+	waitStored(3)
+
+	// Drop the connection; samples taken meanwhile are sent after the agent
+	// reconnects
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	for i := 4; i <= 6; i++ {
+		agent.UpdateFromSample(sampleAt(i))
+	}
+	waitStored(6)
+
+	samples, _ := h.History("web-1", base, base.Add(time.Minute))
+	if len(samples) != 6 {
+		t.Fatalf("history has %d samples, want 6", len(samples))
+	}
+	for i, sample := range samples {
+		if !sample.Timestamp.Equal(sampleAt(i + 1).Timestamp) {
+			t.Errorf("sample %d at %v, want %v", i, sample.Timestamp, sampleAt(i+1).Timestamp)
+		}
+	}
+	// The last ack may still be in flight
+	deadline := time.Now().Add(5 * time.Second)
+	for agent.outstanding() {
+		if time.Now().After(deadline) {
+			t.Fatal("samples left pending after the hub stored them")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
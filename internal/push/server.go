@@ -0,0 +1,349 @@
+package push
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/codec"
+	"github.com/dirshaye/GoMetrics/internal/hub"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// readTimeout closes agent connections that have sent nothing for this long
+const readTimeout = 2 * time.Minute
+
+// maxUnacked is how many samples the server reads before acknowledging
+// them even if more are buffered
+const maxUnacked = 256
+
+// maxHandshakes bounds the connections that have not yet been
+// authenticated; further connections are closed right away
+const maxHandshakes = 64
+
+// missingSamplesTotal counts samples lost between an agent and the hub
+var missingSamplesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gometrics_push_missing_samples_total",
+		Help: "Samples missing from pushed sequences, e.g. dropped by a full agent buffer",
+	},
+	[]string{"host"},
+)
+
+func init() {
+	prometheus.MustRegister(missingSamplesTotal)
+}
+
+// AuthorizeFunc checks an agent's credentials: the Authorization value from
+// its hello and, for TLS connections, the connection state
+type AuthorizeFunc func(authorization string, state *tls.ConnectionState) error
+
+// stream is the sequence state of one host's agent
+type stream struct {
+	session uint64
+	lastSeq uint64   // Last sequence number stored
+	started bool     // lastSeq is known; false until the first sample of a new stream
+	conn    net.Conn // Current connection, if any
+}
+
+// Server accepts agent connections and records their samples in a hub
+type Server struct {
+	hub       *hub.Hub
+	authorize AuthorizeFunc
+	tlsConfig *tls.Config
+
+	handshakes chan struct{} // Holds a token per connection being authenticated
+
+	mu       sync.Mutex
+	streams  map[string]*stream // By host ID
+	conns    map[net.Conn]struct{}
+	listener net.Listener
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a push server storing samples in h. tlsConfig may be nil
+// to accept plain TCP.
+func NewServer(h *hub.Hub, authorize AuthorizeFunc, tlsConfig *tls.Config) *Server {
+	return &Server{
+		hub:        h,
+		authorize:  authorize,
+		tlsConfig:  tlsConfig,
+		handshakes: make(chan struct{}, maxHandshakes),
+		streams:    make(map[string]*stream),
+		conns:      make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on l until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	if s.tlsConfig != nil {
+		l = tls.NewListener(l, s.tlsConfig)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		select {
+		case s.handshakes <- struct{}{}:
+		default:
+			warnings.Warn("Too many agent handshakes in progress, closing connection",
+				"remote", conn.RemoteAddr().String(), "max", maxHandshakes)
+			conn.Close()
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			<-s.handshakes
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting connections, closes open ones and waits for their
+// handlers to return. Agents keep unacknowledged samples and resend them
+// after reconnecting.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// handle serves one agent connection. It holds a handshake token until
+// the agent is authenticated.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+
+	handshaking := true
+	defer func() {
+		if handshaking {
+			<-s.handshakes
+		}
+	}()
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	var state *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			logger.Debug("TLS handshake failed", "remote", remote, "error", err)
+			return
+		}
+		cs := tc.ConnectionState()
+		state = &cs
+	}
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	reject := func(msg string) {
+		logger.Warn("Rejected agent", "remote", remote, "reason", msg)
+		writeFrame(bw, frameError, appendString(nil, msg))
+		bw.Flush()
+	}
+
+	typ, payload, buf, err := readFrame(br, nil, maxHandshakeFrameSize)
+	if err != nil {
+		logger.Debug("Agent handshake failed", "remote", remote, "error", err)
+		return
+	}
+	if typ != frameHello {
+		reject("expected hello")
+		return
+	}
+	h, err := parseHello(payload)
+	switch {
+	case err != nil:
+		reject("malformed hello")
+		return
+	case h.Version != ProtocolVersion:
+		reject("unsupported protocol version")
+		return
+	case !hub.ValidID(h.HostID):
+		reject(hub.ErrInvalidID.Error())
+		return
+	}
+	if err := s.authorize(h.Authorization, state); err != nil {
+		reject("unauthorized: " + err.Error())
+		return
+	}
+	handshaking = false
+	<-s.handshakes
+
+	st, last := s.attach(h, conn)
+	defer s.detach(h.HostID, conn)
+
+	writeFrame(bw, frameWelcome, binary.AppendUvarint(nil, last))
+	if err := bw.Flush(); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	logger.Info("Agent connected", "host", h.HostID, "remote", remote, "resume_after", last)
+
+	unacked := 0
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		typ, payload, buf, err = readFrame(br, buf, maxFrameSize)
+		if err != nil {
+			logger.Info("Agent disconnected", "host", h.HostID, "remote", remote, "error", err)
+			return
+		}
+		if typ != frameSample {
+			reject("expected sample")
+			return
+		}
+		seq, rest, err := parseSeq(payload)
+		if err != nil {
+			reject("malformed sample")
+			return
+		}
+		sample, err := codec.UnmarshalSample(rest)
+		if err != nil {
+			reject("malformed sample: " + err.Error())
+			return
+		}
+
+		acked, fresh := s.expected(st, seq)
+		if fresh {
+			if _, err := s.hub.Record(h.HostID, hub.SourcePush, h.Labels, sample); err != nil {
+				reject(err.Error())
+				return
+			}
+			acked = s.advance(st, h.HostID, seq)
+		}
+
+		// Acknowledge once the agent's burst has been read
+		unacked++
+		if br.Buffered() == 0 || unacked >= maxUnacked {
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			writeFrame(bw, frameAck, binary.AppendUvarint(nil, acked))
+			if err := bw.Flush(); err != nil {
+				return
+			}
+			unacked = 0
+		}
+	}
+}
+
+// attach makes conn the current connection of the agent and returns its
+// stream and the last sequence number stored for its session. A previous
+// connection of the same host is closed.
+func (s *Server) attach(h hello, conn net.Conn) (*stream, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.streams[h.HostID]
+	switch {
+	case !ok:
+		// First contact since the hub started: take the first sequence
+		// number as it comes
+		st = &stream{session: h.Session}
+		s.streams[h.HostID] = st
+	case st.session != h.Session:
+		// The agent restarted; its sequence starts again at 1
+		logger.Info("Agent restarted", "host", h.HostID)
+		*st = stream{session: h.Session, started: true}
+	}
+	if st.conn != nil {
+		st.conn.Close() // Replaced by a reconnect the hub hasn't noticed yet
+	}
+	st.conn = conn
+	return st, st.lastSeq
+}
+
+// detach clears conn as the agent's current connection. A stream that
+// never stored a sample, e.g. of a host the hub refused, is forgotten.
+func (s *Server) detach(id string, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.streams[id]; ok && st.conn == conn {
+		st.conn = nil
+		if st.lastSeq == 0 {
+			delete(s.streams, id)
+		}
+	}
+}
+
+// expected returns whether seq is new for a stream, and otherwise the
+// sequence number to acknowledge for a duplicate of a stored sample
+func (s *Server) expected(st *stream, seq uint64) (acked uint64, fresh bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st.started && seq <= st.lastSeq {
+		return st.lastSeq, false
+	}
+	return 0, true
+}
+
+// advance records that seq from host id was stored and returns the
+// sequence number to acknowledge. Missing sequence numbers are logged and
+// counted.
+func (s *Server) advance(st *stream, id string, seq uint64) (acked uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !st.started:
+		st.started = true
+	case seq <= st.lastSeq:
+		return st.lastSeq // Stored meanwhile through a newer connection
+	case seq > st.lastSeq+1:
+		missing := seq - st.lastSeq - 1
+		missingSamplesTotal.WithLabelValues(id).Add(float64(missing))
+		logger.Warn("Gap in pushed samples", "host", id, "after", st.lastSeq, "missing", missing)
+	}
+	st.lastSeq = seq
+	return seq
+}
@@ -0,0 +1,552 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// Lookback is how far back an instant selector looks for the latest value
+const Lookback = 5 * time.Minute
+
+// MaxSamples bounds the samples read for one query, about seven hours at
+// the default 250ms sample interval
+const MaxSamples = 100000
+
+// MaxSteps bounds the evaluation steps of a range query
+const MaxSteps = 11000
+
+// ErrTooManySamples is returned when a query would read more than MaxSamples
+var ErrTooManySamples = fmt.Errorf("query: more than %d samples in range; narrow the range or use /api/v1/metrics/history", MaxSamples)
+
+// ErrSource wraps errors reading samples from the Source; other errors
+// are caused by the query
+var ErrSource = errors.New("query: reading samples failed")
+
+// Source provides the samples queries are evaluated against
+type Source interface {
+	// Scan calls fn for every sample with from <= timestamp <= to, in time
+	// order, until fn returns false
+	Scan(from, to time.Time, fn func(collect.Sample) bool) error
+}
+
+// Element is one value of a series at the evaluation time
+type Element struct {
+	Metric string            `json:"metric,omitempty"` // Field name; dropped by functions, aggregations and arithmetic
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Result is the value of a query at one time: a vector of elements, or a
+// scalar for queries without fields such as "1 + 2"
+type Result struct {
+	Type   string    `json:"type"` // "vector" or "scalar"
+	Vector []Element `json:"vector,omitempty"`
+	Scalar *float64  `json:"scalar,omitempty"`
+}
+
+// Point is the value of a series at one step of a range query
+type Point struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// Series is the values of one series over a range query
+type Series struct {
+	Metric string            `json:"metric,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Points []Point           `json:"points"`
+}
+
+// Query is a parsed expression
+type Query struct {
+	root   node
+	window time.Duration   // How far before the evaluation time samples are needed
+	fields map[string]bool // Field names the query reads
+}
+
+// Parse parses a query
+func Parse(input string) (*Query, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected input")
+	}
+
+	q := &Query{root: root, fields: make(map[string]bool)}
+	walk(root, func(n node) {
+		if sel, ok := n.(*selectorNode); ok {
+			q.fields[sel.name] = true
+			q.window = max(q.window, sel.rng, Lookback)
+		}
+	})
+	return q, nil
+}
+
+// walk calls fn for n and every node below it
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch n := n.(type) {
+	case *callNode:
+		walk(n.arg, fn)
+	case *aggregateNode:
+		walk(n.arg, fn)
+	case *binaryNode:
+		walk(n.left, fn)
+		walk(n.right, fn)
+	case *negNode:
+		walk(n.arg, fn)
+	}
+}
+
+// Instant evaluates the query at t
+func (q *Query) Instant(src Source, t time.Time) (Result, error) {
+	data, err := q.load(src, t, t)
+	if err != nil {
+		return Result{}, err
+	}
+	v, err := data.eval(q.root, t)
+	if err != nil {
+		return Result{}, err
+	}
+	if v.vector == nil {
+		if math.IsNaN(v.scalar) || math.IsInf(v.scalar, 0) {
+			return Result{}, errors.New("query: result is not a finite number")
+		}
+		return Result{Type: "scalar", Scalar: &v.scalar}, nil
+	}
+	return Result{Type: "vector", Vector: finite(v.vector)}, nil
+}
+
+// Range evaluates the query at every step from start to end
+func (q *Query) Range(src Source, start, end time.Time, step time.Duration) ([]Series, error) {
+	if step <= 0 {
+		return nil, errors.New("query: step must be positive")
+	}
+	if end.Before(start) {
+		return nil, errors.New("query: end must not be before start")
+	}
+	if end.Sub(start)/step >= MaxSteps {
+		return nil, fmt.Errorf("query: more than %d steps; increase step", MaxSteps)
+	}
+
+	data, err := q.load(src, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	bySeries := make(map[string]*Series)
+	var keys []string
+	for t := start; !t.After(end); t = t.Add(step) {
+		v, err := data.eval(q.root, t)
+		if err != nil {
+			return nil, err
+		}
+		elements := v.vector
+		if v.vector == nil {
+			elements = []Element{{Value: v.scalar}}
+		}
+		for _, e := range finite(elements) {
+			key := seriesKey(e.Metric, e.Labels)
+			s, ok := bySeries[key]
+			if !ok {
+				s = &Series{Metric: e.Metric, Labels: e.Labels}
+				bySeries[key] = s
+				keys = append(keys, key)
+			}
+			s.Points = append(s.Points, Point{Timestamp: t, Value: e.Value})
+		}
+	}
+
+	sort.Strings(keys)
+	series := make([]Series, len(keys))
+	for i, key := range keys {
+		series[i] = *bySeries[key]
+	}
+	return series, nil
+}
+
+// finite leaves out non-finite values, e.g. from division by zero, which
+// JSON can't represent
+func finite(elements []Element) []Element {
+	kept := make([]Element, 0, len(elements))
+	for _, e := range elements {
+		if !math.IsNaN(e.Value) && !math.IsInf(e.Value, 0) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// series is the stored values of one field
+type series struct {
+	metric string
+	labels map[string]string
+	times  []time.Time
+	values []float64
+}
+
+// dataset is the series a query reads, loaded once per evaluation
+type dataset struct {
+	series []*series // Sorted by key
+}
+
+// load reads the fields the query needs for evaluation between start and end
+func (q *Query) load(src Source, start, end time.Time) (*dataset, error) {
+	data := &dataset{}
+	if len(q.fields) == 0 {
+		return data, nil
+	}
+
+	byKey := make(map[string]*series)
+	count := 0
+	err := src.Scan(start.Add(-q.window), end, func(sample collect.Sample) bool {
+		count++
+		if count > MaxSamples {
+			return false
+		}
+		for _, f := range sample.Fields() {
+			if !q.fields[f.Name] {
+				continue
+			}
+			key := f.Key()
+			s, ok := byKey[key]
+			if !ok {
+				s = &series{metric: f.Name, labels: f.Labels}
+				byKey[key] = s
+				data.series = append(data.series, s)
+			}
+			s.times = append(s.times, sample.Timestamp)
+			s.values = append(s.values, f.Value)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSource, err)
+	}
+	if count > MaxSamples {
+		return nil, ErrTooManySamples
+	}
+
+	sort.Slice(data.series, func(i, j int) bool {
+		return seriesKey(data.series[i].metric, data.series[i].labels) < seriesKey(data.series[j].metric, data.series[j].labels)
+	})
+	return data, nil
+}
+
+// value is an evaluated expression: a vector, or a scalar when vector is nil
+type value struct {
+	vector []Element
+	scalar float64
+}
+
+func (d *dataset) eval(n node, t time.Time) (value, error) {
+	switch n := n.(type) {
+	case *numberNode:
+		return value{scalar: n.value}, nil
+	case *selectorNode:
+		vector := []Element{}
+		for _, s := range d.matching(n) {
+			i := sort.Search(len(s.times), func(i int) bool { return s.times[i].After(t) }) - 1
+			if i >= 0 && t.Sub(s.times[i]) <= Lookback {
+				vector = append(vector, Element{Metric: s.metric, Labels: s.labels, Value: s.values[i]})
+			}
+		}
+		return value{vector: vector}, nil
+	case *callNode:
+		vector := []Element{}
+		for _, s := range d.matching(n.arg) {
+			from := sort.Search(len(s.times), func(i int) bool { return s.times[i].After(t.Add(-n.arg.rng)) })
+			to := sort.Search(len(s.times), func(i int) bool { return s.times[i].After(t) })
+			if v, ok := applyRange(n.fn, s.times[from:to], s.values[from:to]); ok {
+				vector = append(vector, Element{Labels: s.labels, Value: v})
+			}
+		}
+		return value{vector: vector}, nil
+	case *aggregateNode:
+		arg, err := d.eval(n.arg, t)
+		if err != nil {
+			return value{}, err
+		}
+		if arg.vector == nil {
+			return value{}, fmt.Errorf("%s needs a field, not a number", n.op)
+		}
+		return value{vector: aggregate(n, arg.vector)}, nil
+	case *negNode:
+		arg, err := d.eval(n.arg, t)
+		if err != nil {
+			return value{}, err
+		}
+		if arg.vector == nil {
+			return value{scalar: -arg.scalar}, nil
+		}
+		vector := make([]Element, len(arg.vector))
+		for i, e := range arg.vector {
+			vector[i] = Element{Labels: e.Labels, Value: -e.Value}
+		}
+		return value{vector: vector}, nil
+	case *binaryNode:
+		left, err := d.eval(n.left, t)
+		if err != nil {
+			return value{}, err
+		}
+		right, err := d.eval(n.right, t)
+		if err != nil {
+			return value{}, err
+		}
+		return binary(n.op, left, right), nil
+	}
+	return value{}, fmt.Errorf("query: unknown expression %T", n)
+}
+
+// matching returns the series selected by sel
+func (d *dataset) matching(sel *selectorNode) []*series {
+	var matched []*series
+	for _, s := range d.series {
+		if s.metric != sel.name {
+			continue
+		}
+		ok := true
+		for _, m := range sel.matchers {
+			if (s.labels[m.label] == m.value) == m.negate {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, s)
+		}
+	}
+	return matched
+}
+
+// applyRange computes a range function over the points of one series.
+// ok is false when there are too few points.
+func applyRange(fn string, times []time.Time, values []float64) (v float64, ok bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+	switch fn {
+	case "avg_over_time":
+		sum := 0.0
+		for _, x := range values {
+			sum += x
+		}
+		return sum / float64(len(values)), true
+	case "min_over_time":
+		v = values[0]
+		for _, x := range values[1:] {
+			v = math.Min(v, x)
+		}
+		return v, true
+	case "max_over_time":
+		v = values[0]
+		for _, x := range values[1:] {
+			v = math.Max(v, x)
+		}
+		return v, true
+	case "sum_over_time":
+		for _, x := range values {
+			v += x
+		}
+		return v, true
+	case "count_over_time":
+		return float64(len(values)), true
+	case "last_over_time":
+		return values[len(values)-1], true
+	}
+
+	// The rest compare the first and last points
+	if len(values) < 2 {
+		return 0, false
+	}
+	switch fn {
+	case "delta":
+		return values[len(values)-1] - values[0], true
+	case "increase", "rate":
+		increase := 0.0
+		for i := 1; i < len(values); i++ {
+			if values[i] < values[i-1] {
+				increase += values[i] // Counter reset: it restarted from zero
+			} else {
+				increase += values[i] - values[i-1]
+			}
+		}
+		if fn == "increase" {
+			return increase, true
+		}
+		return increase / times[len(times)-1].Sub(times[0]).Seconds(), true
+	}
+	return 0, false
+}
+
+// aggregate combines the elements of a vector per group
+func aggregate(n *aggregateNode, vector []Element) []Element {
+	type group struct {
+		labels map[string]string
+		values []float64
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for _, e := range vector {
+		labels := make(map[string]string)
+		for k, v := range e.Labels {
+			if contains(n.labels, k) != n.without {
+				labels[k] = v
+			}
+		}
+		key := seriesKey("", labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.values = append(g.values, e.Value)
+	}
+
+	sort.Strings(keys)
+	result := make([]Element, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		v := g.values[0]
+		switch n.op {
+		case "sum", "avg":
+			v = 0
+			for _, x := range g.values {
+				v += x
+			}
+			if n.op == "avg" {
+				v /= float64(len(g.values))
+			}
+		case "min":
+			for _, x := range g.values[1:] {
+				v = math.Min(v, x)
+			}
+		case "max":
+			for _, x := range g.values[1:] {
+				v = math.Max(v, x)
+			}
+		case "count":
+			v = float64(len(g.values))
+		}
+		if len(g.labels) == 0 {
+			g.labels = nil
+		}
+		result = append(result, Element{Labels: g.labels, Value: v})
+	}
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// binary applies an arithmetic or comparison operator. Between vectors,
+// elements are paired by identical labels. Comparisons filter: they keep
+// the left element where the comparison holds, or give 1 or 0 between
+// numbers.
+func binary(op string, left, right value) value {
+	comparison := precedence[op] == 1
+	switch {
+	case left.vector == nil && right.vector == nil:
+		v := apply(op, left.scalar, right.scalar)
+		if comparison {
+			v = boolValue(v != 0)
+		}
+		return value{scalar: v}
+	case right.vector == nil:
+		return value{vector: combine(op, comparison, left.vector, func(Element) (float64, bool) { return right.scalar, true }, false)}
+	case left.vector == nil:
+		return value{vector: combine(op, comparison, right.vector, func(Element) (float64, bool) { return left.scalar, true }, true)}
+	}
+
+	byLabels := make(map[string]float64, len(right.vector))
+	for _, e := range right.vector {
+		byLabels[seriesKey("", e.Labels)] = e.Value
+	}
+	return value{vector: combine(op, comparison, left.vector, func(e Element) (float64, bool) {
+		v, ok := byLabels[seriesKey("", e.Labels)]
+		return v, ok
+	}, false)}
+}
+
+// combine applies op to every element of vector and its other operand.
+// swapped means the element is the right operand.
+func combine(op string, comparison bool, vector []Element, other func(Element) (float64, bool), swapped bool) []Element {
+	result := []Element{}
+	for _, e := range vector {
+		o, ok := other(e)
+		if !ok {
+			continue
+		}
+		l, r := e.Value, o
+		if swapped {
+			l, r = o, e.Value
+		}
+		v := apply(op, l, r)
+		if comparison {
+			if v != 0 {
+				result = append(result, e) // Filters keep the element as it is
+			}
+			continue
+		}
+		result = append(result, Element{Labels: e.Labels, Value: v})
+	}
+	return result
+}
+
+// apply computes l op r; comparisons give 1 or 0
+func apply(op string, l, r float64) float64 {
+	switch op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		return l / r
+	case "==":
+		return boolValue(l == r)
+	case "!=":
+		return boolValue(l != r)
+	case "<":
+		return boolValue(l < r)
+	case ">":
+		return boolValue(l > r)
+	case "<=":
+		return boolValue(l <= r)
+	case ">=":
+		return boolValue(l >= r)
+	}
+	return math.NaN()
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// seriesKey identifies a series by metric name and labels
+func seriesKey(metric string, labels map[string]string) string {
+	return collect.Field{Name: metric, Labels: labels}.Key()
+}
@@ -0,0 +1,281 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+// fakeSource serves samples from memory
+type fakeSource struct {
+	samples []collect.Sample
+	scans   int // Number of Scan calls
+	err     error
+}
+
+func (s *fakeSource) Scan(from, to time.Time, fn func(collect.Sample) bool) error {
+	s.scans++
+	if s.err != nil {
+		return s.err
+	}
+	for _, sample := range s.samples {
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		if !fn(sample) {
+			break
+		}
+	}
+	return nil
+}
+
+// at returns epoch plus n seconds
+func at(n int) time.Time {
+	return epoch.Add(time.Duration(n) * time.Second)
+}
+
+// counterSource has network.bytes_recv every 10s with a reset at 20s
+func counterSource() *fakeSource {
+	src := &fakeSource{}
+	for i, v := range []uint64{100, 200, 50, 150} {
+		src.samples = append(src.samples, collect.Sample{
+			Timestamp: at(10 * i),
+			Network:   &collect.NetworkMetric{BytesRecv: v},
+		})
+	}
+	return src
+}
+
+// hostSource has one sample at epoch with two cores and two mountpoints
+func hostSource() *fakeSource {
+	return &fakeSource{samples: []collect.Sample{{
+		Timestamp: epoch,
+		CPU:       &collect.CPUMetric{OverallPercent: 50, PerCorePercent: []float64{20, 80}},
+		Disk: &collect.DiskMetric{Mountpoints: []collect.MountpointUsage{
+			{Mountpoint: "/", TotalBytes: 200, UsedBytes: 50},
+			{Mountpoint: "/data", TotalBytes: 100, UsedBytes: 90},
+		}},
+	}}}
+}
+
+func instant(t *testing.T, src Source, input string, ts time.Time) Result {
+	t.Helper()
+	q, err := Parse(input)
+	if err != nil {
+		t.Fatalf("Parse(%q) error = %v", input, err)
+	}
+	result, err := q.Instant(src, ts)
+	if err != nil {
+		t.Fatalf("Instant(%q) error = %v", input, err)
+	}
+	return result
+}
+
+func TestInstantCounters(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		// 100 + 50 (restarted from zero) + 100
+		{"increase(network.bytes_recv[1m])", 250},
+		{"rate(network.bytes_recv[1m])", 250.0 / 30},
+		{"delta(network.bytes_recv[1m])", 50},
+		// Only the points after 5s: 200, 50, 150
+		{"increase(network.bytes_recv[25s])", 150},
+		{"count_over_time(network.bytes_recv[1m])", 4},
+		{"max_over_time(network.bytes_recv[1m])", 200},
+		{"last_over_time(network.bytes_recv[1m])", 150},
+	}
+	for _, tt := range tests {
+		result := instant(t, counterSource(), tt.input, at(30))
+		if len(result.Vector) != 1 || result.Vector[0].Value != tt.want {
+			t.Errorf("%s = %+v, want %v", tt.input, result.Vector, tt.want)
+		}
+		if len(result.Vector) == 1 && result.Vector[0].Metric != "" {
+			t.Errorf("%s kept metric name %q", tt.input, result.Vector[0].Metric)
+		}
+	}
+}
+
+func TestInstantTooFewPoints(t *testing.T) {
+	// One point in range: no rate
+	result := instant(t, counterSource(), "rate(network.bytes_recv[5s])", at(30))
+	if len(result.Vector) != 0 {
+		t.Errorf("rate over one point = %+v, want empty", result.Vector)
+	}
+}
+
+func TestInstantVectorMatching(t *testing.T) {
+	result := instant(t, hostSource(), "disk.mountpoints.used_bytes / disk.mountpoints.total_bytes * 100", epoch)
+	want := []Element{
+		{Labels: map[string]string{"mountpoint": "/"}, Value: 25},
+		{Labels: map[string]string{"mountpoint": "/data"}, Value: 90},
+	}
+	if !reflect.DeepEqual(result.Vector, want) {
+		t.Errorf("Vector = %+v, want %+v", result.Vector, want)
+	}
+
+	// Elements without a partner of identical labels are dropped
+	result = instant(t, hostSource(), "cpu.per_core_percent + disk.mountpoints.used_percent", epoch)
+	if len(result.Vector) != 0 {
+		t.Errorf("unmatched labels = %+v, want empty", result.Vector)
+	}
+	result = instant(t, hostSource(), `cpu.per_core_percent - cpu.per_core_percent{core="1"}`, epoch)
+	want = []Element{{Labels: map[string]string{"core": "1"}, Value: 0}}
+	if !reflect.DeepEqual(result.Vector, want) {
+		t.Errorf("Vector = %+v, want %+v", result.Vector, want)
+	}
+}
+
+func TestInstantComparisons(t *testing.T) {
+	// Filters keep matching elements unchanged, including the metric name
+	result := instant(t, hostSource(), "cpu.per_core_percent > 50", epoch)
+	want := []Element{{Metric: "cpu.per_core_percent", Labels: map[string]string{"core": "1"}, Value: 80}}
+	if !reflect.DeepEqual(result.Vector, want) {
+		t.Errorf("Vector = %+v, want %+v", result.Vector, want)
+	}
+
+	// Scalar on the left compares the other way round
+	result = instant(t, hostSource(), "50 > cpu.per_core_percent", epoch)
+	if len(result.Vector) != 1 || result.Vector[0].Value != 20 {
+		t.Errorf("50 > cpu.per_core_percent = %+v, want core 0", result.Vector)
+	}
+
+	// Between numbers, comparisons give 1 or 0
+	for input, want := range map[string]float64{"2 > 1": 1, "2 < 1": 0, "1 == 1": 1, "1 + 1 != 2": 0} {
+		result := instant(t, hostSource(), input, epoch)
+		if result.Type != "scalar" || *result.Scalar != want {
+			t.Errorf("%s = %+v, want scalar %v", input, result, want)
+		}
+	}
+}
+
+func TestInstantAggregations(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Element
+	}{
+		{"sum(disk.mountpoints.used_bytes)", []Element{{Value: 140}}},
+		{"avg(cpu.per_core_percent)", []Element{{Value: 50}}},
+		{"count(cpu.per_core_percent > 10)", []Element{{Value: 2}}},
+		{"max(disk.mountpoints.used_percent) by (mountpoint)", []Element{
+			{Labels: map[string]string{"mountpoint": "/"}},
+			{Labels: map[string]string{"mountpoint": "/data"}},
+		}},
+		{"min without (core) (cpu.per_core_percent)", []Element{{Value: 20}}},
+	}
+	for _, tt := range tests {
+		result := instant(t, hostSource(), tt.input, epoch)
+		if !reflect.DeepEqual(result.Vector, tt.want) {
+			t.Errorf("%s = %+v, want %+v", tt.input, result.Vector, tt.want)
+		}
+	}
+}
+
+func TestInstantLookback(t *testing.T) {
+	// The latest value up to Lookback before the evaluation time
+	result := instant(t, hostSource(), "cpu.overall_percent", epoch.Add(Lookback))
+	if len(result.Vector) != 1 || result.Vector[0].Value != 50 {
+		t.Errorf("at Lookback = %+v, want 50", result.Vector)
+	}
+	result = instant(t, hostSource(), "cpu.overall_percent", epoch.Add(Lookback+time.Second))
+	if len(result.Vector) != 0 {
+		t.Errorf("after Lookback = %+v, want empty", result.Vector)
+	}
+
+	// Samples after the evaluation time are not used
+	result = instant(t, counterSource(), "network.bytes_recv", at(15))
+	if len(result.Vector) != 1 || result.Vector[0].Value != 200 {
+		t.Errorf("at 15s = %+v, want 200", result.Vector)
+	}
+}
+
+func TestInstantScalar(t *testing.T) {
+	src := &fakeSource{}
+	result := instant(t, src, "-(1 + 2) * 3", epoch)
+	if result.Type != "scalar" || *result.Scalar != -9 {
+		t.Errorf("Result = %+v, want scalar -9", result)
+	}
+	if src.scans != 0 {
+		t.Errorf("%d scans for a query without fields, want 0", src.scans)
+	}
+
+	q, _ := Parse("1 / 0")
+	if _, err := q.Instant(src, epoch); err == nil {
+		t.Error("1 / 0: error = nil, want not finite")
+	}
+}
+
+func TestMaxSamples(t *testing.T) {
+	src := &fakeSource{samples: make([]collect.Sample, MaxSamples+1)}
+	for i := range src.samples {
+		src.samples[i].Timestamp = epoch.Add(time.Duration(i) * time.Millisecond)
+	}
+	q, _ := Parse("cpu.overall_percent")
+	if _, err := q.Instant(src, src.samples[MaxSamples].Timestamp); !errors.Is(err, ErrTooManySamples) {
+		t.Errorf("Instant() error = %v, want ErrTooManySamples", err)
+	}
+
+	src.samples = src.samples[:MaxSamples]
+	if _, err := q.Instant(src, src.samples[MaxSamples-1].Timestamp); err != nil {
+		t.Errorf("Instant() with MaxSamples samples error = %v", err)
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	q, _ := Parse("cpu.overall_percent")
+	if _, err := q.Instant(&fakeSource{err: errors.New("disk gone")}, epoch); !errors.Is(err, ErrSource) {
+		t.Errorf("Instant() error = %v, want ErrSource", err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	q, err := Parse("network.bytes_recv * 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err := q.Range(counterSource(), at(0), at(30), 10*time.Second)
+	if err != nil {
+		t.Fatalf("Range() error = %v", err)
+	}
+	want := []Series{{Points: []Point{
+		{at(0), 200}, {at(10), 400}, {at(20), 100}, {at(30), 300},
+	}}}
+	if !reflect.DeepEqual(series, want) {
+		t.Errorf("Range() = %+v, want %+v", series, want)
+	}
+
+	// Steps without a value leave gaps rather than zeros
+	q, _ = Parse("rate(network.bytes_recv[15s])")
+	series, err = q.Range(counterSource(), at(0), at(30), 10*time.Second)
+	if err != nil {
+		t.Fatalf("Range() error = %v", err)
+	}
+	want = []Series{{Points: []Point{{at(10), 10}, {at(20), 5}, {at(30), 10}}}}
+	if !reflect.DeepEqual(series, want) {
+		t.Errorf("Range() = %+v, want %+v", series, want)
+	}
+}
+
+func TestRangeLimits(t *testing.T) {
+	q, _ := Parse("1")
+	tests := []struct {
+		name       string
+		start, end time.Time
+		step       time.Duration
+	}{
+		{"zero step", at(0), at(10), 0},
+		{"end before start", at(10), at(0), time.Second},
+		{"too many steps", at(0), at(MaxSteps), time.Second},
+	}
+	for _, tt := range tests {
+		if _, err := q.Range(&fakeSource{}, tt.start, tt.end, tt.step); err == nil {
+			t.Errorf("%s: error = nil", tt.name)
+		}
+	}
+}
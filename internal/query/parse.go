@@ -0,0 +1,414 @@
+// Package query evaluates a small expression language over stored samples,
+// modelled on PromQL:
+//
+//	cpu.overall_percent                         latest value of a field
+//	disk.mountpoints.used_percent{mountpoint="/"}  with label matchers (= or !=)
+//	avg_over_time(cpu.overall_percent[5m])      function of a range of samples
+//	rate(network.bytes_recv[1m])                per-second increase of a counter
+//	max(disk.mountpoints.used_percent) by (mountpoint)
+//	memory.used_bytes / memory.total_bytes * 100 > 90
+//
+// Fields are named and labelled as in collect.Sample.Fields. Selectors
+// without a range take the latest value up to Lookback before the
+// evaluation time.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// node is an expression in the syntax tree
+type node interface{}
+
+type (
+	numberNode struct {
+		value float64
+	}
+	selectorNode struct {
+		name     string
+		matchers []matcher
+		rng      time.Duration // 0 for an instant selector
+	}
+	callNode struct {
+		fn  string
+		arg *selectorNode // Always a range selector
+	}
+	aggregateNode struct {
+		op      string
+		arg     node
+		labels  []string
+		without bool // labels are dropped rather than kept
+	}
+	binaryNode struct {
+		op          string
+		left, right node
+	}
+	negNode struct {
+		arg node
+	}
+)
+
+// matcher is a label matcher such as core="0" or mountpoint!="/boot"
+type matcher struct {
+	label, value string
+	negate       bool
+}
+
+// rangeFunctions take a range selector and return one value per series
+var rangeFunctions = map[string]bool{
+	"avg_over_time":   true,
+	"min_over_time":   true,
+	"max_over_time":   true,
+	"sum_over_time":   true,
+	"count_over_time": true,
+	"last_over_time":  true,
+	"delta":           true,
+	"increase":        true,
+	"rate":            true,
+}
+
+// aggregations combine series, optionally grouped by labels
+var aggregations = map[string]bool{
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"count": true,
+}
+
+// precedence of binary operators; higher binds tighter
+var precedence = map[string]int{
+	"==": 1, "!=": 1, "<": 1, ">": 1, "<=": 1, ">=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3,
+}
+
+// token kinds
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDuration
+	tokOp // Operators and punctuation
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// lex splits a query into tokens
+func lex(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isLetter(c):
+			for i < len(input) && (isLetter(input[i]) || isDigit(input[i]) || input[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, input[start:i], start})
+		case isDigit(c) || (c == '.' && i+1 < len(input) && isDigit(input[i+1])):
+			for i < len(input) && (isDigit(input[i]) || input[i] == '.' || input[i] == 'e' || input[i] == 'E' ||
+				((input[i] == '+' || input[i] == '-') && (input[i-1] == 'e' || input[i-1] == 'E'))) {
+				i++
+			}
+			// A unit directly after the number makes it a duration, e.g. 5m or 1h30m
+			kind := tokNumber
+			for i < len(input) && (isLetter(input[i]) || isDigit(input[i])) {
+				kind = tokDuration
+				i++
+			}
+			tokens = append(tokens, token{kind, input[start:i], start})
+		case c == '"':
+			i++
+			for i < len(input) && input[i] != '"' {
+				if input[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(input) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			value, err := strconv.Unquote(input[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d", start)
+			}
+			tokens = append(tokens, token{tokString, value, start})
+		case strings.ContainsRune("=!<>", rune(c)) && i+1 < len(input) && input[i+1] == '=':
+			i += 2
+			tokens = append(tokens, token{tokOp, input[start:i], start})
+		case strings.ContainsRune("+-*/<>(){}[],=", rune(c)):
+			i++
+			tokens = append(tokens, token{tokOp, input[start:i], start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, start)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(input)}), nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parser is a recursive descent parser over the tokens of one query
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator op
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return p.errorf("expected %q", op)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	t := p.peek()
+	found := strconv.Quote(t.text)
+	if t.kind == tokEOF {
+		found = "end of query"
+	}
+	return fmt.Errorf("%s at position %d, found %s", fmt.Sprintf(format, args...), t.pos, found)
+}
+
+// parseExpr parses binary operations whose operators bind at least as
+// tightly as minPrec
+func (p *parser) parseExpr(minPrec int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokOp || !ok || prec < minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseExpr(prec + 1) // Left associative
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("-") {
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negNode{arg: arg}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return &numberNode{value: v}, nil
+	case t.kind == tokOp && t.text == "(":
+		p.next()
+		inner, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case t.kind == tokIdent && aggregations[t.text]:
+		return p.parseAggregate()
+	case t.kind == tokIdent && rangeFunctions[t.text]:
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		if sel.rng == 0 {
+			return nil, fmt.Errorf("%s needs a range selector such as %s[5m]", t.text, sel.name)
+		}
+		return &callNode{fn: t.text, arg: sel}, p.expect(")")
+	case t.kind == tokIdent:
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		if sel.rng != 0 {
+			return nil, fmt.Errorf("range selector %s[...] must be the argument of a function such as avg_over_time", sel.name)
+		}
+		return sel, nil
+	}
+	return nil, p.errorf("expected a field, number, function or \"(\"")
+}
+
+// parseAggregate parses op(expr), op(expr) by (labels) or
+// op by (labels) (expr); without may replace by
+func (p *parser) parseAggregate() (node, error) {
+	agg := &aggregateNode{op: p.next().text}
+	grouped := false
+	if err := p.parseGrouping(agg, &grouped); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	arg, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	agg.arg = arg
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if !grouped {
+		if err := p.parseGrouping(agg, &grouped); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+// parseGrouping parses an optional by (...) or without (...) clause
+func (p *parser) parseGrouping(agg *aggregateNode, grouped *bool) error {
+	t := p.peek()
+	if t.kind != tokIdent || (t.text != "by" && t.text != "without") {
+		return nil
+	}
+	p.next()
+	agg.without = t.text == "without"
+	*grouped = true
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for !p.accept(")") {
+		if len(agg.labels) > 0 {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+		label := p.next()
+		if label.kind != tokIdent {
+			p.pos--
+			return p.errorf("expected a label name")
+		}
+		agg.labels = append(agg.labels, label.text)
+	}
+	return nil
+}
+
+// parseSelector parses name{label="value",...}[range]
+func (p *parser) parseSelector() (*selectorNode, error) {
+	name := p.next()
+	if name.kind != tokIdent {
+		p.pos--
+		return nil, p.errorf("expected a field name")
+	}
+	sel := &selectorNode{name: name.text}
+
+	if p.accept("{") {
+		for !p.accept("}") {
+			if len(sel.matchers) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			label := p.next()
+			if label.kind != tokIdent {
+				p.pos--
+				return nil, p.errorf("expected a label name")
+			}
+			m := matcher{label: label.text}
+			switch {
+			case p.accept("="):
+			case p.accept("!="):
+				m.negate = true
+			default:
+				return nil, p.errorf("expected \"=\" or \"!=\"")
+			}
+			value := p.next()
+			if value.kind != tokString {
+				p.pos--
+				return nil, p.errorf("expected a quoted label value")
+			}
+			m.value = value.text
+			sel.matchers = append(sel.matchers, m)
+		}
+	}
+
+	if p.accept("[") {
+		t := p.next()
+		if t.kind != tokDuration {
+			p.pos--
+			return nil, p.errorf("expected a duration such as 5m")
+		}
+		d, err := parseDuration(t.text)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q at position %d", t.text, t.pos)
+		}
+		sel.rng = d
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// parseDuration parses a Go duration, also accepting days, e.g. "1d"
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,157 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// format renders a syntax tree in prefix notation, so tests can check how
+// an expression was grouped
+func format(n node) string {
+	switch n := n.(type) {
+	case *numberNode:
+		return strconv.FormatFloat(n.value, 'g', -1, 64)
+	case *selectorNode:
+		var sb strings.Builder
+		sb.WriteString(n.name)
+		if len(n.matchers) > 0 {
+			sb.WriteByte('{')
+			for i, m := range n.matchers {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				op := "="
+				if m.negate {
+					op = "!="
+				}
+				fmt.Fprintf(&sb, "%s%s%q", m.label, op, m.value)
+			}
+			sb.WriteByte('}')
+		}
+		if n.rng > 0 {
+			fmt.Fprintf(&sb, "[%v]", n.rng)
+		}
+		return sb.String()
+	case *callNode:
+		return fmt.Sprintf("%s(%s)", n.fn, format(n.arg))
+	case *aggregateNode:
+		grouping := ""
+		if n.without {
+			grouping = fmt.Sprintf(" without(%s)", strings.Join(n.labels, ","))
+		} else if len(n.labels) > 0 {
+			grouping = fmt.Sprintf(" by(%s)", strings.Join(n.labels, ","))
+		}
+		return fmt.Sprintf("%s(%s)%s", n.op, format(n.arg), grouping)
+	case *binaryNode:
+		return fmt.Sprintf("(%s %s %s)", n.op, format(n.left), format(n.right))
+	case *negNode:
+		return fmt.Sprintf("(- %s)", format(n.arg))
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		// Precedence and associativity
+		{"1 + 2 * 3", "(+ 1 (* 2 3))"},
+		{"(1 + 2) * 3", "(* (+ 1 2) 3)"},
+		{"8 / 4 / 2", "(/ (/ 8 4) 2)"},
+		{"1 - 2 - 3", "(- (- 1 2) 3)"},
+		{"-2 * 3", "(* (- 2) 3)"},
+		{"a.b / a.c * 100 > 90", "(> (* (/ a.b a.c) 100) 90)"},
+		{"a.b + 1 >= a.c - 1", "(>= (+ a.b 1) (- a.c 1))"},
+		{"1.5e3 + .5", "(+ 1500 0.5)"},
+
+		// Selectors and matchers
+		{"cpu.overall_percent", "cpu.overall_percent"},
+		{`cpu.per_core_percent{core="0"}`, `cpu.per_core_percent{core="0"}`},
+		{`disk.mountpoints.used_percent{mountpoint!="/boot", mountpoint="/"}`, `disk.mountpoints.used_percent{mountpoint!="/boot",mountpoint="/"}`},
+		{`x{}`, "x"},
+
+		// Range functions
+		{"rate(network.bytes_recv[1m])", "rate(network.bytes_recv[1m0s])"},
+		{`avg_over_time(cpu.per_core_percent{core="1"}[1h30m])`, `avg_over_time(cpu.per_core_percent{core="1"}[1h30m0s])`},
+		{"max_over_time(x[2d])", "max_over_time(x[48h0m0s])"},
+
+		// Aggregations with by and without, before or after the argument
+		{"sum(x)", "sum(x)"},
+		{"max(x) by (mountpoint)", "max(x) by(mountpoint)"},
+		{"max by (mountpoint) (x)", "max(x) by(mountpoint)"},
+		{"avg(x) without (core, period)", "avg(x) without(core,period)"},
+		{"count without () (x)", "count(x) without()"},
+		{"sum(rate(x[5m])) by (interface) * 8", "(* sum(rate(x[5m0s])) by(interface) 8)"},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.input, err)
+			continue
+		}
+		if got := format(q.root); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // Substring of the error
+	}{
+		{"", "expected a field, number, function or \"(\" at position 0, found end of query"},
+		{"1 +", "at position 3, found end of query"},
+		{"(1 + 2", `expected ")" at position 6`},
+		{"x y", `unexpected input at position 2, found "y"`},
+		{"x # 1", `unexpected character '#' at position 2`},
+		{`x{core="0"`, "at position 10, found end of query"},
+		{`x{core=0}`, `expected a quoted label value at position 7, found "0"`},
+		{`x{core~"0"}`, `unexpected character '~' at position 6`},
+		{`x{core<"0"}`, `expected "=" or "!=" at position 6, found "<"`},
+		{`x{"core"="0"}`, `expected a label name at position 2`},
+		{`x{core="0`, "unterminated string at position 7"},
+		{"x[5]", "expected a duration such as 5m at position 2"},
+		{"x[5x]", `invalid duration "5x" at position 2`},
+		{"rate(x[5m]", `expected ")" at position 10`},
+		{"x[5m]", "must be the argument of a function"},
+		{"rate(x)", "rate needs a range selector"},
+		{"rate(1)", "expected a field name at position 5"},
+		{"sum by (1) (x)", "expected a label name at position 8"},
+		{"sum(x) by (a b)", `expected "," at position 13`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+		if err == nil {
+			t.Errorf("Parse(%q) error = nil, want %q", tt.input, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %q, want it to contain %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1 + 2", "0s"},
+		{"x", Lookback.String()},
+		{"rate(x[1m])", Lookback.String()}, // Never less than Lookback
+		{"avg_over_time(x[1h]) > y", "1h0m0s"},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.input)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.input, err)
+		}
+		if got := q.window.String(); got != tt.want {
+			t.Errorf("Parse(%q) window = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/alert"
+)
+
+// AlertsResponse is the body of /api/v1/alerts
+type AlertsResponse struct {
+	Data []alert.Alert `json:"data"` // Sorted by rule and labels
+	Meta AlertsMeta    `json:"meta"`
+}
+
+// AlertsMeta describes the evaluation the alerts come from
+type AlertsMeta struct {
+	Time time.Time `json:"time"` // When the rules were last evaluated
+}
+
+// SetAlertEvaluator enables the /api/v1/alerts handler
+func (h *Handlers) SetAlertEvaluator(evaluator *alert.Evaluator) {
+	h.alerts = evaluator
+}
+
+// AlertsHandler lists pending and firing alerts. state narrows them down to
+// one of the two.
+func (h *Handlers) AlertsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.alerts == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Alert rules are not enabled")
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	if state != "" && state != alert.StatePending && state != alert.StateFiring {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, `Invalid state: must be "pending" or "firing"`)
+		return
+	}
+
+	all, evaluated := h.alerts.Alerts()
+	alerts := []alert.Alert{}
+	for _, a := range all {
+		if state == "" || a.State == state {
+			alerts = append(alerts, a)
+		}
+	}
+	writeDocument(w, formatJSON, AlertsResponse{Data: alerts, Meta: AlertsMeta{Time: evaluated}}, false)
+}
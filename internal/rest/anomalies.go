@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/anomaly"
+)
+
+// AnomaliesResponse is the body of /api/v1/anomalies
+type AnomaliesResponse struct {
+	Data []anomaly.Event `json:"data"` // Newest first
+}
+
+// SetAnomalyDetector enables the /api/v1/anomalies handler
+func (h *Handlers) SetAnomalyDetector(detector *anomaly.Detector) {
+	h.anomalies = detector
+}
+
+// AnomaliesHandler lists detected anomalies. fields narrows them down to
+// some fields or sections, since to those starting at or after a time, and
+// active to ongoing ones.
+func (h *Handlers) AnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	if h.anomalies == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Anomaly detection is not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	var fields []string
+	for _, field := range splitList(params.Get("fields")) {
+		if err := checkFieldKey(field); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+			return
+		}
+		fields = append(fields, field)
+	}
+	since, err := parseTime(params.Get("since"), time.Time{})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid since: "+err.Error())
+		return
+	}
+	active, err := parseFlag(params, "active")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid active: "+err.Error())
+		return
+	}
+
+	events := []anomaly.Event{}
+	for _, e := range h.anomalies.Events() {
+		if selectsKey(fields, e.Field) && !e.Start.Before(since) && (!active || e.End == nil) {
+			events = append(events, e)
+		}
+	}
+	writeDocument(w, formatJSON, AnomaliesResponse{Data: events}, false)
+}
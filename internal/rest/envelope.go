@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// Error codes in the "code" field of error responses. They are part of
+// the API contract: clients match on them, never on messages.
+const (
+	CodeInvalidParameter = "invalid_parameter" // A query parameter or header is malformed
+	CodeNotFound         = "not_found"         // The resource is not available on this server
+	CodeNotAcceptable    = "not_acceptable"    // No offered format matches Accept
+	CodeNoData           = "no_data"           // No sample has been collected yet
+	CodeInternal         = "internal"          // Unexpected server error
+
+	// Written by the auth and limit middleware
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeRateLimited  = "rate_limited"
+	CodeOverloaded   = "overloaded"
+)
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error string `json:"error"` // Human readable message
+	Code  string `json:"code"`  // One of the Code constants
+}
+
+// SampleResponse is the v1 envelope of /api/v1/metrics/latest. With
+// fields selected, data holds only those fields.
+type SampleResponse struct {
+	Data collect.Sample `json:"data"`
+}
+
+// HistoryResponse is the v1 envelope of /api/v1/metrics/history
+type HistoryResponse struct {
+	Data HistoryData `json:"data"`
+	Meta HistoryMeta `json:"meta"`
+}
+
+// HistoryData holds raw samples or rollup buckets, depending on the resolution
+type HistoryData struct {
+	Samples []collect.Sample   `json:"samples,omitempty"`
+	Buckets []agg.RollupBucket `json:"buckets,omitempty"`
+}
+
+// HistoryMeta describes a history response
+type HistoryMeta struct {
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Resolution string    `json:"resolution"` // "raw" or a rollup tier such as "1m0s"
+	Count      int       `json:"count"`      // Number of samples or buckets
+}
+
+// v1Key marks requests served by the versioned API
+type v1Key struct{}
+
+// V1 is middleware for the /api/v1 routes: their JSON and MessagePack
+// responses are wrapped in an envelope
+func V1(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), v1Key{}, true)))
+	})
+}
+
+// isV1 reports whether r is served by the versioned API
+func isV1(r *http.Request) bool {
+	v, _ := r.Context().Value(v1Key{}).(bool)
+	return v
+}
+
+// Deprecated returns middleware for unversioned routes that marks their
+// responses as deprecated in favor of successor
+func Deprecated(successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// fieldTree is a set of selected JSON paths. A nil subtree selects the
+// whole value at that path.
+type fieldTree map[string]fieldTree
+
+// parseFields parses a comma separated list of dotted JSON paths such as
+// "cpu.overall_percent,memory". Paths are checked against collect.Sample.
+// An empty value selects everything and returns nil.
+func parseFields(value string) (fieldTree, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	tree := fieldTree{"timestamp": nil} // Always included
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		path := strings.Split(field, ".")
+		if !validPath(reflect.TypeOf(collect.Sample{}), path) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		tree.add(path)
+	}
+	return tree, nil
+}
+
+// add selects path. Selecting a value also selects everything below it.
+func (t fieldTree) add(path []string) {
+	name, rest := path[0], path[1:]
+	sub, seen := t[name]
+	switch {
+	case seen && sub == nil:
+		// Already selected as a whole
+	case len(rest) == 0:
+		t[name] = nil
+	default:
+		if sub == nil {
+			sub = fieldTree{}
+			t[name] = sub
+		}
+		sub.add(rest)
+	}
+}
+
+// includes reports whether the value at path is selected. A nil tree
+// selects everything.
+func (t fieldTree) includes(path []string) bool {
+	for _, name := range path {
+		if t == nil {
+			return true
+		}
+		sub, ok := t[name]
+		if !ok {
+			return false
+		}
+		t = sub
+	}
+	return true
+}
+
+// validPath reports whether path names a JSON field of typ. Map keys (such
+// as the collector names in status) are accepted as any name.
+func validPath(typ reflect.Type, path []string) bool {
+	for _, name := range path {
+		for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice {
+			typ = typ.Elem()
+		}
+		switch typ.Kind() {
+		case reflect.Map:
+			typ = typ.Elem()
+		case reflect.Struct:
+			field, ok := jsonField(typ, name)
+			if !ok {
+				return false
+			}
+			typ = field.Type
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// jsonField finds the struct field encoded as name
+func jsonField(typ reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// selectFields returns the selected parts of v as generic JSON values,
+// or v itself if nothing was selected
+func selectFields(v any, fields fieldTree) (any, error) {
+	if fields == nil {
+		return v, nil
+	}
+	generic, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+	return prune(generic, fields), nil
+}
+
+// toGeneric converts v to maps, slices and json.Numbers
+func toGeneric(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // Keep byte counters exact
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+// prune drops every part of a decoded JSON value that is not in fields.
+// Arrays are pruned element by element.
+func prune(v any, fields fieldTree) any {
+	if fields == nil {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(fields))
+		for name, sub := range fields {
+			if value, ok := v[name]; ok {
+				out[name] = prune(value, sub)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			out[i] = prune(elem, fields)
+		}
+		return out
+	default:
+		return v // null section or scalar
+	}
+}
@@ -0,0 +1,144 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/hub"
+)
+
+// FleetSummaryResponse is the body of /api/v1/fleet/summary
+type FleetSummaryResponse struct {
+	Data hub.FleetSummary `json:"data"`
+}
+
+// FleetTopResponse is the body of /api/v1/fleet/top
+type FleetTopResponse struct {
+	Data []hub.HostValue `json:"data"`
+	Meta FleetTopMeta    `json:"meta"`
+}
+
+// FleetTopMeta describes a top-K query
+type FleetTopMeta struct {
+	Field string `json:"field"`
+	Order string `json:"order"` // "desc" for the highest values first, "asc" for the lowest
+	K     int    `json:"k"`
+}
+
+// FleetSummaryHandler returns fleet-wide statistics of sample fields.
+// Query parameters: fields selects fields or sections (default: all),
+// group_by takes comma-separated host label names, and labels and
+// include_down select the hosts.
+func (h *Handlers) FleetSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sel, err := parseSelector(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	var fields []string
+	for _, field := range splitList(query.Get("fields")) {
+		if err := checkFieldKey(field); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+			return
+		}
+		fields = append(fields, field)
+	}
+
+	summary := h.hub.Summary(fields, splitList(query.Get("group_by")), sel)
+	writeDocument(w, formatJSON, FleetSummaryResponse{Data: summary}, false)
+}
+
+// FleetTopHandler returns the hosts with the highest values of a field.
+// Query parameters: field (required), k (default 10), order ("desc" or
+// "asc"), and labels and include_down to select the hosts.
+func (h *Handlers) FleetTopHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sel, err := parseSelector(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	field := strings.TrimSpace(query.Get("field"))
+	if field == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "field is required")
+		return
+	}
+	if err := checkFieldKey(field); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	k := 10
+	if value := query.Get("k"); value != "" {
+		k, err = strconv.Atoi(value)
+		if err != nil || k < 1 || k > 1000 {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, "k must be between 1 and 1000")
+			return
+		}
+	}
+
+	order := query.Get("order")
+	switch order {
+	case "":
+		order = "desc"
+	case "asc", "desc":
+	default:
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, `order must be "asc" or "desc"`)
+		return
+	}
+
+	top := h.hub.Top(field, k, order == "asc", sel)
+	writeDocument(w, formatJSON, FleetTopResponse{Data: top, Meta: FleetTopMeta{Field: field, Order: order, K: k}}, false)
+}
+
+// parseSelector reads the labels and include_down query parameters.
+// labels takes comma-separated name=value pairs, e.g. "region=eu,role=db".
+func parseSelector(query url.Values) (hub.Selector, error) {
+	var sel hub.Selector
+	for _, pair := range splitList(query.Get("labels")) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return hub.Selector{}, fmt.Errorf("invalid label %q, expected name=value", pair)
+		}
+		if sel.Labels == nil {
+			sel.Labels = make(map[string]string)
+		}
+		sel.Labels[name] = value
+	}
+
+	includeDown, err := parseFlag(query, "include_down")
+	if err != nil {
+		return hub.Selector{}, fmt.Errorf("invalid include_down: %w", err)
+	}
+	sel.IncludeDown = includeDown
+	return sel, nil
+}
+
+// checkFieldKey checks that a field key such as `cpu.per_core_percent{core="0"}`
+// names a field of collect.Sample; labels are not checked
+func checkFieldKey(key string) error {
+	name, _, _ := strings.Cut(key, "{")
+	if !validPath(reflect.TypeOf(collect.Sample{}), strings.Split(name, ".")) {
+		return fmt.Errorf("unknown field %q", key)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated parameter, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
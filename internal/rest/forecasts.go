@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/forecast"
+)
+
+// ForecastsResponse is the body of /api/v1/forecasts
+type ForecastsResponse struct {
+	Data []forecast.Forecast `json:"data"` // Sorted by field key
+	Meta ForecastsMeta       `json:"meta"`
+}
+
+// ForecastsMeta describes how the forecasts were computed
+type ForecastsMeta struct {
+	Time     time.Time `json:"time"`     // When they were computed
+	Method   string    `json:"method"`   // "linear" or "theil_sen"
+	Lookback string    `json:"lookback"` // History fitted
+}
+
+// SetForecaster enables the /api/v1/forecasts handler
+func (h *Handlers) SetForecaster(forecaster *forecast.Forecaster) {
+	h.forecaster = forecaster
+}
+
+// ForecastsHandler returns the latest forecasts. fields narrows them down
+// to some fields or sections.
+func (h *Handlers) ForecastsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.forecaster == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Forecasts are not enabled")
+		return
+	}
+
+	var fields []string
+	for _, field := range splitList(r.URL.Query().Get("fields")) {
+		if err := checkFieldKey(field); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+			return
+		}
+		fields = append(fields, field)
+	}
+
+	all, updated := h.forecaster.Forecasts()
+	forecasts := []forecast.Forecast{}
+	for _, f := range all {
+		if selectsKey(fields, f.Field) {
+			forecasts = append(forecasts, f)
+		}
+	}
+	meta := ForecastsMeta{Time: updated, Method: h.forecaster.Method(), Lookback: h.forecaster.Lookback().String()}
+	writeDocument(w, formatJSON, ForecastsResponse{Data: forecasts, Meta: meta}, false)
+}
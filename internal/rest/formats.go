@@ -0,0 +1,258 @@
+package rest
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// format is a response encoding
+type format string
+
+// Response formats
+const (
+	formatJSON     format = "json"
+	formatCSV      format = "csv"      // One row per sample or bucket, flattened columns
+	formatNDJSON   format = "ndjson"   // One JSON object per line
+	formatMsgpack  format = "msgpack"  // The JSON document as MessagePack
+	formatProtobuf format = "protobuf" // Messages from gometrics.proto
+	formatInflux   format = "influx"   // InfluxDB line protocol, latest sample only
+)
+
+// contentTypes maps formats to response content types
+var contentTypes = map[format]string{
+	formatJSON:     "application/json",
+	formatCSV:      "text/csv; charset=utf-8",
+	formatNDJSON:   "application/x-ndjson",
+	formatMsgpack:  "application/msgpack",
+	formatProtobuf: "application/x-protobuf",
+	formatInflux:   "text/plain; charset=utf-8",
+}
+
+// mediaTypes maps accepted media types, including common aliases, to formats
+var mediaTypes = map[string]format{
+	"application/json":        formatJSON,
+	"text/csv":                formatCSV,
+	"application/x-ndjson":    formatNDJSON,
+	"application/jsonl":       formatNDJSON,
+	"application/msgpack":     formatMsgpack,
+	"application/x-msgpack":   formatMsgpack,
+	"application/vnd.msgpack": formatMsgpack,
+	"application/x-protobuf":  formatProtobuf,
+	"application/protobuf":    formatProtobuf,
+}
+
+// errNotAcceptable means no offered format matches the Accept header
+var errNotAcceptable = errors.New("none of the accepted media types is available")
+
+// negotiate picks the response format from ?format= or, failing that, the
+// Accept header. JSON is used when neither asks for something specific.
+func negotiate(r *http.Request, offered ...format) (format, error) {
+	isOffered := func(f format) bool {
+		for _, o := range offered {
+			if o == f {
+				return true
+			}
+		}
+		return false
+	}
+
+	if value := r.URL.Query().Get("format"); value != "" {
+		if f := format(strings.ToLower(value)); isOffered(f) {
+			return f, nil
+		}
+		names := make([]string, len(offered))
+		for i, f := range offered {
+			names[i] = string(f)
+		}
+		return "", fmt.Errorf("unknown format %q (want %s)", value, strings.Join(names, ", "))
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, nil
+	}
+
+	// Media ranges in order of preference; equal weights keep header order
+	type mediaRange struct {
+		typ string
+		q   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{typ, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, mr := range ranges {
+		switch {
+		case mr.typ == "*/*" || mr.typ == "application/*":
+			return formatJSON, nil
+		case mr.typ == "text/*" && isOffered(formatCSV):
+			return formatCSV, nil
+		}
+		if f, ok := mediaTypes[mr.typ]; ok && isOffered(f) {
+			return f, nil
+		}
+	}
+	return "", errNotAcceptable
+}
+
+// formatError reports a failed negotiation as 400 or 406
+func formatError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotAcceptable) {
+		writeError(w, http.StatusNotAcceptable, CodeNotAcceptable, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+}
+
+// writeSamplesCSV writes one row per sample. Columns are the timestamp,
+// every numeric field keyed like collect.Field.Key (in order of first
+// appearance) and then the status of each section. Fields not selected by
+// fields are left out; units scales byte fields. Both may be nil.
+func writeSamplesCSV(w io.Writer, samples []collect.Sample, fields fieldTree, units *byteUnit) error {
+	var columns []string
+	index := make(map[string]int)
+	addColumn := func(name string) int {
+		i, ok := index[name]
+		if !ok {
+			i = len(columns)
+			index[name] = i
+			columns = append(columns, name)
+		}
+		return i
+	}
+	addColumn("timestamp")
+
+	rows := make([]map[int]string, len(samples))
+	statusSet := make(map[string]bool)
+	for i, sample := range samples {
+		row := map[int]string{0: sample.Timestamp.Format(time.RFC3339Nano)}
+		for _, field := range sample.Fields() {
+			if !fields.includes(strings.Split(field.Name, ".")) {
+				continue
+			}
+			value := field.Value
+			if units != nil {
+				if name, ok := units.renameLast(field.Name); ok {
+					field.Name = name
+					value /= units.scale
+				}
+			}
+			row[addColumn(field.Key())] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		for name := range sample.Status {
+			if fields.includes([]string{"status", name}) {
+				statusSet[name] = true
+			}
+		}
+		rows[i] = row
+	}
+
+	// Status columns go last, sorted, so numeric columns stay together
+	statuses := make([]string, 0, len(statusSet))
+	for name := range statusSet {
+		statuses = append(statuses, name)
+	}
+	sort.Strings(statuses)
+	for _, name := range statuses {
+		addColumn("status." + name)
+	}
+	for i, sample := range samples {
+		for name, status := range sample.Status {
+			if j, ok := index["status."+name]; ok {
+				rows[i][j] = status.Status
+			}
+		}
+	}
+
+	return writeCSV(w, columns, rows)
+}
+
+// writeBucketsCSV writes one row per rollup bucket with a column for every
+// statistic of every field, e.g. cpu.overall_percent.avg or
+// cpu.per_core_percent.p95{core="0"}
+func writeBucketsCSV(w io.Writer, buckets []agg.RollupBucket) error {
+	stats := []string{"min", "max", "avg", "last", "p95", "count"}
+
+	// Field keys in sorted order, so columns are stable across requests
+	keySet := make(map[string]bool)
+	for _, bucket := range buckets {
+		for key := range bucket.Fields {
+			keySet[key] = true
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	columns := []string{"start"}
+	for _, key := range keys {
+		name, labels, _ := strings.Cut(key, "{")
+		if labels != "" {
+			labels = "{" + labels
+		}
+		for _, stat := range stats {
+			columns = append(columns, name+"."+stat+labels)
+		}
+	}
+
+	rows := make([]map[int]string, len(buckets))
+	for i, bucket := range buckets {
+		row := map[int]string{0: bucket.Start.Format(time.RFC3339Nano)}
+		for k, key := range keys {
+			s, ok := bucket.Fields[key]
+			if !ok {
+				continue
+			}
+			col := 1 + k*len(stats)
+			for j, v := range []float64{s.Min, s.Max, s.Avg, s.Last, s.P95} {
+				row[col+j] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+			row[col+5] = strconv.FormatUint(s.Count, 10)
+		}
+		rows[i] = row
+	}
+
+	return writeCSV(w, columns, rows)
+}
+
+// writeCSV writes a header and rows of sparse cells; missing cells are empty
+func writeCSV(w io.Writer, columns []string, rows []map[int]string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i := range record {
+			record[i] = row[i]
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -2,21 +2,53 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/alert"
+	"github.com/dirshaye/GoMetrics/internal/anomaly"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/forecast"
+	"github.com/dirshaye/GoMetrics/internal/hub"
+	"github.com/dirshaye/GoMetrics/internal/influx"
+	"github.com/dirshaye/GoMetrics/internal/wire"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// HistoryReader provides stored samples for the history endpoint
+type HistoryReader interface {
+	// Scan calls fn for every sample with from <= timestamp <= to, in time
+	// order, until fn returns false
+	Scan(from, to time.Time, fn func(collect.Sample) bool) error
+}
+
 // Handlers holds the aggregator and provides HTTP handlers
 type Handlers struct {
 	aggregator *agg.Aggregator
+	history    HistoryReader        // nil when no store is configured
+	hub        *hub.Hub             // nil unless running as a hub
+	anomalies  *anomaly.Detector    // nil unless anomaly detection is enabled
+	forecaster *forecast.Forecaster // nil unless forecasts are enabled
+	alerts     *alert.Evaluator     // nil until SetAlertEvaluator
+
+	closing   chan struct{} // Closed by CloseStreams
+	closeOnce sync.Once
 }
 
-// NewHandlers creates new REST handlers
-func NewHandlers(aggregator *agg.Aggregator) *Handlers {
+// NewHandlers creates new REST handlers. history may be nil.
+func NewHandlers(aggregator *agg.Aggregator, history HistoryReader) *Handlers {
 	return &Handlers{
 		aggregator: aggregator,
+		history:    history,
+		closing:    make(chan struct{}),
 	}
 }
 
@@ -33,7 +65,17 @@ func (h *Handlers) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Ready"))
 }
 
-// MetricsLatestHandler returns the latest metrics sample
+// MetricsLatestHandler returns the latest metrics sample. Query parameters:
+//
+//	fields  sections and fields to include, e.g. cpu.overall_percent,memory
+//	units   bytes (default), kib, mib or gib; byte fields are renamed to match
+//	pretty  indent the JSON (default compact)
+//	format  json, csv, ndjson, msgpack, protobuf or influx (default: from
+//	        the Accept header, else json)
+//
+// Protobuf and influx always contain the whole sample in bytes.
+// The ETag changes with every new sample, so pollers that send
+// If-None-Match get 304 Not Modified until there is new data.
 func (h *Handlers) MetricsLatestHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the latest sample from aggregator
 	sample := h.aggregator.GetLatestSample()
@@ -43,20 +85,346 @@ func (h *Handlers) MetricsLatestHandler(w http.ResponseWriter, r *http.Request)
 
 	// Check if we have any data
 	if sample.Timestamp.IsZero() {
-		// No data yet
-		w.WriteHeader(http.StatusServiceUnavailable)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "No metrics data available yet",
-		})
+		writeError(w, http.StatusServiceUnavailable, CodeNoData, "No metrics data available yet")
+		return
+	}
+
+	query := r.URL.Query()
+	fields, err := parseFields(query.Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	units, err := parseUnits(query.Get("units"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	pretty, err := parseFlag(query, "pretty")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid pretty: "+err.Error())
+		return
+	}
+	f, err := negotiate(r, formatJSON, formatCSV, formatNDJSON, formatMsgpack, formatProtobuf, formatInflux)
+	if err != nil {
+		formatError(w, err)
+		return
+	}
+
+	// Every representation of a sample has its own tag
+	etag := sampleETag(sample, query, f)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Vary", "Accept")
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[f])
+	switch f {
+	case formatInflux:
+		w.WriteHeader(http.StatusOK)
+		w.Write(influx.Encode(sample, nil))
+		return
+	case formatProtobuf:
+		w.WriteHeader(http.StatusOK)
+		w.Write(wire.AppendSample(nil, sample))
+		return
+	case formatCSV:
+		w.WriteHeader(http.StatusOK)
+		writeSamplesCSV(w, []collect.Sample{sample}, fields, units)
+		return
+	}
+
+	body, err := selectFields(sample, fields)
+	if err == nil && units != nil {
+		if body, err = toGeneric(body); err == nil {
+			body = scaleBytes(body, units)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error encoding metrics")
+		return
+	}
+
+	// Return the sample as JSON (one line for NDJSON) or MessagePack;
+	// NDJSON lines are never wrapped
+	if isV1(r) && f != formatNDJSON {
+		body = map[string]any{"data": body} // SampleResponse, possibly with fields left out
+	}
+	writeDocument(w, f, body, pretty && f == formatJSON)
+}
+
+// writeDocument writes a JSON document as JSON, NDJSON or MessagePack
+func writeDocument(w http.ResponseWriter, f format, doc any, pretty bool) {
+	w.Header().Set("Content-Type", contentTypes[f])
+	if f == formatMsgpack {
+		generic, err := toGeneric(doc)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Error encoding response")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(wire.AppendMsgpack(nil, generic))
 		return
 	}
 
-	// Return the sample as JSON
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(sample); err != nil {
-		http.Error(w, "Error encoding metrics", http.StatusInternalServerError)
+	enc := json.NewEncoder(w)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(doc)
+}
+
+// parseFlag parses a boolean query parameter; a bare "?name" means true
+func parseFlag(query url.Values, name string) (bool, error) {
+	values, ok := query[name]
+	if !ok {
+		return false, nil
+	}
+	if values[0] == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(values[0])
+}
+
+// sampleETag identifies a sample rendered for a query in format f
+func sampleETag(sample collect.Sample, query url.Values, f format) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(query.Encode())) // Sorted by key
+	hash.Write([]byte(f))
+	return fmt.Sprintf(`"%x-%08x"`, sample.Timestamp.UnixNano(), hash.Sum32())
+}
+
+// matchesETag reports whether an If-None-Match header matches etag,
+// using the weak comparison RFC 9110 requires for If-None-Match
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// MetricsHistoryHandler returns history in a time range, either as raw
+// samples from the store or as buckets from a rollup tier.
+// Query parameters:
+//
+//	from, to    RFC3339 or Unix seconds (default: the last hour)
+//	limit       maximum number of points (default 1000)
+//	resolution  "raw", a rollup tier such as "1m", or "auto" (default) to use
+//	            raw samples when they fit in limit and otherwise the finest
+//	            rollup tier that covers the range
+//	format      json, csv, ndjson, msgpack or protobuf (default: from the
+//	            Accept header, else json); NDJSON has one sample or bucket
+//	            per line and raw samples are streamed as they are read
+func (h *Handlers) MetricsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept")
+
+	f, err := negotiate(r, formatJSON, formatCSV, formatNDJSON, formatMsgpack, formatProtobuf)
+	if err != nil {
+		formatError(w, err)
+		return
+	}
+
+	now := time.Now()
+	from, err := parseTime(r.URL.Query().Get("from"), now.Add(-time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid from: "+err.Error())
+		return
+	}
+	to, err := parseTime(r.URL.Query().Get("to"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid to: "+err.Error())
+		return
+	}
+	if to.Before(from) {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "to must not be before from")
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	// Pick the data source
+	resolution := r.URL.Query().Get("resolution")
+	if resolution == "" || resolution == "auto" {
+		rawPoints := to.Sub(from) / h.aggregator.GetSampleInterval()
+		tier, ok := h.aggregator.GetRollups().ChooseTier(from, to, limit)
+		switch {
+		case h.history != nil && (rawPoints <= time.Duration(limit) || !ok):
+			resolution = "raw"
+		case ok:
+			resolution = tier.Resolution.String()
+		default:
+			writeError(w, http.StatusNotFound, CodeNotFound, "History is not enabled")
+			return
+		}
+	}
+
+	if resolution == "raw" {
+		h.writeRawHistory(w, r, f, from, to, limit)
 		return
 	}
+
+	res, err := time.ParseDuration(resolution)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid resolution: "+resolution)
+		return
+	}
+	buckets, err := h.aggregator.GetRollups().Query(res, from, to)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	if len(buckets) > limit {
+		buckets = buckets[len(buckets)-limit:] // Keep the most recent
+	}
+
+	w.Header().Set("Content-Type", contentTypes[f])
+	switch f {
+	case formatCSV:
+		w.WriteHeader(http.StatusOK)
+		writeBucketsCSV(w, buckets)
+	case formatNDJSON:
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for _, bucket := range buckets {
+			enc.Encode(bucket)
+		}
+	case formatProtobuf:
+		w.WriteHeader(http.StatusOK)
+		w.Write(wire.AppendHistory(nil, from, to, res.String(), nil, buckets))
+	default:
+		meta := HistoryMeta{From: from, To: to, Resolution: res.String(), Count: len(buckets)}
+		writeHistory(w, r, f, meta, HistoryData{Buckets: buckets})
+	}
+}
+
+// writeRawHistory writes stored samples, keeping at most one sample per
+// step so large ranges stay within limit
+func (h *Handlers) writeRawHistory(w http.ResponseWriter, r *http.Request, f format, from, to time.Time, limit int) {
+	if h.history == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Raw history is not enabled")
+		return
+	}
+
+	step := to.Sub(from) / time.Duration(limit)
+	var next time.Time
+	var count int
+	scan := func(fn func(collect.Sample)) error {
+		return h.history.Scan(from, to, func(sample collect.Sample) bool {
+			if sample.Timestamp.Before(next) {
+				return true
+			}
+			fn(sample)
+			count++
+			next = sample.Timestamp.Add(step)
+			return count < limit
+		})
+	}
+
+	// NDJSON is written while scanning, so large ranges are not buffered.
+	// A read error after the first line can only cut the response short.
+	if f == formatNDJSON {
+		w.Header().Set("Content-Type", contentTypes[f])
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		scan(func(sample collect.Sample) { enc.Encode(sample) })
+		return
+	}
+
+	samples := []collect.Sample{}
+	if err := scan(func(sample collect.Sample) { samples = append(samples, sample) }); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading history")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[f])
+	switch f {
+	case formatCSV:
+		w.WriteHeader(http.StatusOK)
+		writeSamplesCSV(w, samples, nil, nil)
+	case formatProtobuf:
+		w.WriteHeader(http.StatusOK)
+		w.Write(wire.AppendHistory(nil, from, to, "raw", samples, nil))
+	default:
+		meta := HistoryMeta{From: from, To: to, Resolution: "raw", Count: len(samples)}
+		writeHistory(w, r, f, meta, HistoryData{Samples: samples})
+	}
+}
+
+// writeHistory writes a history document as JSON or MessagePack, in the
+// v1 envelope or the unversioned shape
+func writeHistory(w http.ResponseWriter, r *http.Request, f format, meta HistoryMeta, data HistoryData) {
+	if isV1(r) {
+		writeDocument(w, f, HistoryResponse{Data: data, Meta: meta}, false)
+		return
+	}
+
+	doc := map[string]any{
+		"from":       meta.From,
+		"to":         meta.To,
+		"resolution": meta.Resolution,
+	}
+	if meta.Resolution == "raw" {
+		doc["samples"] = data.Samples
+	} else {
+		doc["buckets"] = data.Buckets
+	}
+	writeDocument(w, f, doc, false)
+}
+
+// parseTime parses an RFC3339 timestamp or Unix seconds, returning def
+// for an empty value
+func parseTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseLimit parses the limit parameter of history requests, returning
+// 1000 for an empty value
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return 1000, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > 10000 {
+		return 0, errors.New("limit must be between 1 and 10000")
+	}
+	return limit, nil
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code})
+}
+
+// NotFoundHandler answers requests for unknown API paths with an error response
+func (h *Handlers) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, CodeNotFound, "No such endpoint: "+r.URL.Path)
+}
+
+// ProtoSchemaHandler serves the Protobuf schema of the API
+func (h *Handlers) ProtoSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(wire.Schema)
 }
 
 // PrometheusHandler returns the Prometheus metrics handler
@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/hub"
+)
+
+// maxPushBytes bounds the body of a push request
+const maxPushBytes = 8 << 20
+
+// HostsResponse is the body of /api/v1/hosts
+type HostsResponse struct {
+	Data []hub.HostInfo `json:"data"`
+}
+
+// HostSampleResponse is the body of /api/v1/hosts/{id}/latest. With fields
+// selected, data holds only those fields.
+type HostSampleResponse struct {
+	Data collect.Sample `json:"data"`
+	Meta hub.HostInfo   `json:"meta"`
+}
+
+// PushRequest is the body of POST /api/v1/hosts/{id}/samples
+type PushRequest struct {
+	Labels  map[string]string `json:"labels,omitempty"` // Replace the host's labels unless empty
+	Samples []collect.Sample  `json:"samples"`          // Oldest first
+}
+
+// PushResponse is the response to a push
+type PushResponse struct {
+	Data PushResult `json:"data"`
+}
+
+// PushResult counts the pushed samples
+type PushResult struct {
+	Stored  int `json:"stored"`
+	Skipped int `json:"skipped"` // Not newer than the host's latest sample
+}
+
+// SetHub enables the /api/v1/hosts handlers
+func (h *Handlers) SetHub(federation *hub.Hub) {
+	h.hub = federation
+}
+
+// HostsHandler lists the hosts known to the hub
+func (h *Handlers) HostsHandler(w http.ResponseWriter, r *http.Request) {
+	writeDocument(w, formatJSON, HostsResponse{Data: h.hub.Hosts()}, false)
+}
+
+// HostLatestHandler returns the latest sample of one host. Like
+// /metrics/latest it accepts fields to select sections and fields.
+func (h *Handlers) HostLatestHandler(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	info, sample, ok := h.hub.Host(chi.URLParam(r, "id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Unknown host: "+chi.URLParam(r, "id"))
+		return
+	}
+	if sample.Timestamp.IsZero() {
+		writeError(w, http.StatusServiceUnavailable, CodeNoData, "No sample from this host yet")
+		return
+	}
+
+	body, err := selectFields(sample, fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error encoding metrics")
+		return
+	}
+	writeDocument(w, formatJSON, map[string]any{"data": body, "meta": info}, false) // HostSampleResponse
+}
+
+// HostHistoryHandler returns the samples the hub keeps for one host.
+// Query parameters are from and to, as for /metrics/history, and limit,
+// which keeps the most recent samples.
+func (h *Handlers) HostHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := time.Now()
+	from, err := parseTime(query.Get("from"), now.Add(-time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid from: "+err.Error())
+		return
+	}
+	to, err := parseTime(query.Get("to"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid to: "+err.Error())
+		return
+	}
+	if to.Before(from) {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "to must not be before from")
+		return
+	}
+	limit, err := parseLimit(query.Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	samples, ok := h.hub.History(chi.URLParam(r, "id"), from, to)
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Unknown host: "+chi.URLParam(r, "id"))
+		return
+	}
+	if len(samples) > limit {
+		samples = samples[len(samples)-limit:]
+	}
+
+	meta := HistoryMeta{From: from, To: to, Resolution: "raw", Count: len(samples)}
+	writeDocument(w, formatJSON, HistoryResponse{Data: HistoryData{Samples: samples}, Meta: meta}, false)
+}
+
+// HostPushHandler stores samples pushed by an agent
+func (h *Handlers) HostPushHandler(w http.ResponseWriter, r *http.Request) {
+	var req PushRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushBytes))
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid push request: "+err.Error())
+		return
+	}
+
+	stored, err := h.hub.Record(chi.URLParam(r, "id"), hub.SourcePush, req.Labels, req.Samples...)
+	switch {
+	case errors.Is(err, hub.ErrInvalidID):
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	case errors.Is(err, hub.ErrTooManyHosts):
+		writeError(w, http.StatusServiceUnavailable, CodeOverloaded, err.Error())
+		return
+	}
+
+	writeDocument(w, formatJSON, PushResponse{Data: PushResult{Stored: stored, Skipped: len(req.Samples) - stored}}, false)
+}
@@ -0,0 +1,412 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// APIVersion is the version of the /api/v1 contract in the OpenAPI document
+const APIVersion = "1.0.0"
+
+// OpenAPI returns the OpenAPI 3.1 document of the /api/v1 routes. Response
+// schemas are generated from the types the handlers encode, so the document
+// changes whenever those types do; openapi_test.go checks it against the copy
+// committed in api/openapi.json and against the routes RouteV1 registers.
+func OpenAPI() map[string]any {
+	g := &schemaGen{components: make(map[string]any)}
+
+	errorResponses := func(statuses ...string) map[string]any {
+		descriptions := map[string]string{
+			"400": "Invalid parameter (code invalid_parameter)",
+			"401": "Missing or invalid credentials (code unauthorized)",
+			"403": "Credentials lack the required scope (code forbidden)",
+			"404": "Not enabled on this server or unknown host (code not_found)",
+			"406": "No offered format matches Accept (code not_acceptable)",
+			"429": "Rate limit exceeded; see Retry-After (code rate_limited)",
+			"503": "Overloaded (code overloaded) or no data yet (code no_data)",
+		}
+		responses := make(map[string]any)
+		for _, status := range statuses {
+			responses[status] = map[string]any{
+				"description": descriptions[status],
+				"content":     jsonContent(g.schema(reflect.TypeOf(ErrorResponse{}))),
+			}
+		}
+		return responses
+	}
+	withErrors := func(ok map[string]any, statuses ...string) map[string]any {
+		responses := errorResponses(statuses...)
+		responses["200"] = ok
+		return responses
+	}
+
+	formatParam := func(formats ...format) map[string]any {
+		names := make([]any, len(formats))
+		for i, f := range formats {
+			names[i] = string(f)
+		}
+		return queryParam("format", "Response format; overrides the Accept header", map[string]any{"type": "string", "enum": names})
+	}
+	fieldsParam := queryParam("fields", "Comma-separated sections and fields to include, e.g. cpu.overall_percent,memory", stringSchema)
+	hostParam := map[string]any{"name": "id", "in": "path", "required": true, "description": "Host ID", "schema": map[string]any{"type": "string", "pattern": "^[A-Za-z0-9._-]{1,128}$"}}
+	labelsParam := queryParam("labels", "Only hosts with these labels, as comma-separated name=value pairs", stringSchema)
+	includeDownParam := queryParam("include_down", "Also use the last sample of hosts that are down", map[string]any{"type": "boolean", "default": false})
+	queryExprParam := map[string]any{"name": "query", "in": "query", "required": true, "description": "Expression, e.g. avg_over_time(cpu.overall_percent[5m])", "schema": stringSchema}
+	binary := map[string]any{"type": "string", "format": "binary"}
+	text := map[string]any{"type": "string"}
+
+	sample := g.schema(reflect.TypeOf(SampleResponse{}))
+	history := g.schema(reflect.TypeOf(HistoryResponse{}))
+
+	paths := map[string]any{
+		"/api/v1/metrics/latest": map[string]any{"get": map[string]any{
+			"operationId": "getLatestSample",
+			"summary":     "Latest sample",
+			"description": "With fields, data holds only the selected fields. With units, byte fields are scaled and renamed, e.g. used_bytes to used_mib.",
+			"parameters": []any{
+				fieldsParam,
+				queryParam("units", "Unit of byte fields", map[string]any{"type": "string", "enum": []any{"bytes", "kib", "mib", "gib"}}),
+				queryParam("pretty", "Indent the JSON", map[string]any{"type": "boolean"}),
+				formatParam(formatJSON, formatCSV, formatNDJSON, formatMsgpack, formatProtobuf, formatInflux),
+				headerParam("If-None-Match", "ETag of a previous response"),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "The latest sample",
+				"headers":     map[string]any{"ETag": map[string]any{"schema": stringSchema}},
+				"content": map[string]any{
+					contentTypes[formatJSON]:     map[string]any{"schema": sample},
+					contentTypes[formatMsgpack]:  map[string]any{"schema": sample},
+					contentTypes[formatNDJSON]:   map[string]any{"schema": g.schema(reflect.TypeOf(SampleResponse{}.Data))},
+					contentTypes[formatCSV]:      map[string]any{"schema": text},
+					contentTypes[formatProtobuf]: map[string]any{"schema": binary},
+					contentTypes[formatInflux]:   map[string]any{"schema": text},
+				},
+			}, "400", "401", "403", "406", "429", "503"),
+		}},
+		"/api/v1/metrics/history": map[string]any{"get": map[string]any{
+			"operationId": "getHistory",
+			"summary":     "Stored samples or rollup buckets",
+			"parameters": []any{
+				queryParam("from", "RFC3339 or Unix seconds (default: an hour ago)", stringSchema),
+				queryParam("to", "RFC3339 or Unix seconds (default: now)", stringSchema),
+				queryParam("limit", "Maximum number of points", map[string]any{"type": "integer", "minimum": 1, "maximum": 10000, "default": 1000}),
+				queryParam("resolution", `"raw", a rollup tier such as "1m", or "auto"`, map[string]any{"type": "string", "default": "auto"}),
+				formatParam(formatJSON, formatCSV, formatNDJSON, formatMsgpack, formatProtobuf),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "Samples (resolution raw) or buckets (a rollup tier) in the range",
+				"content": map[string]any{
+					contentTypes[formatJSON]:     map[string]any{"schema": history},
+					contentTypes[formatMsgpack]:  map[string]any{"schema": history},
+					contentTypes[formatNDJSON]:   map[string]any{"schema": text},
+					contentTypes[formatCSV]:      map[string]any{"schema": text},
+					contentTypes[formatProtobuf]: map[string]any{"schema": binary},
+				},
+			}, "400", "401", "403", "404", "406", "429", "503"),
+		}},
+		"/api/v1/metrics/stream": map[string]any{"get": map[string]any{
+			"operationId": "streamSamples",
+			"summary":     "Server-Sent Events with one sample event per sample",
+			"description": "Each event has type sample, the sample timestamp in Unix nanoseconds as its ID and a Sample as JSON data.",
+			"parameters": []any{
+				fieldsParam,
+				queryParam("last_event_id", "Resume after this event", stringSchema),
+				headerParam("Last-Event-ID", "Resume after this event"),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "An event stream",
+				"content":     map[string]any{"text/event-stream": map[string]any{"schema": text}},
+			}, "400", "401", "403", "429", "503"),
+		}},
+		"/api/v1/query": map[string]any{"get": map[string]any{
+			"operationId": "query",
+			"summary":     "Evaluate an expression at one time",
+			"description": "Expressions select fields like cpu.overall_percent or disk.mountpoints.used_percent{mountpoint=\"/\"}, and combine them with range functions (avg_over_time, min_over_time, max_over_time, sum_over_time, count_over_time, last_over_time, delta, increase, rate), aggregations (sum, avg, min, max, count with by or without) and arithmetic and comparison operators.",
+			"parameters": []any{
+				queryExprParam,
+				queryParam("time", "RFC3339 or Unix seconds (default: now)", stringSchema),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "The value of the expression",
+				"content":     jsonContent(g.schema(reflect.TypeOf(QueryResponse{}))),
+			}, "400", "401", "403", "429", "503"),
+		}},
+		"/api/v1/query_range": map[string]any{"get": map[string]any{
+			"operationId": "queryRange",
+			"summary":     "Evaluate an expression at every step of a range",
+			"parameters": []any{
+				queryExprParam,
+				queryParam("start", "RFC3339 or Unix seconds (default: an hour ago)", stringSchema),
+				queryParam("end", "RFC3339 or Unix seconds (default: now)", stringSchema),
+				map[string]any{"name": "step", "in": "query", "required": true, "description": "Duration such as 15s, or seconds", "schema": stringSchema},
+			},
+			"responses": withErrors(map[string]any{
+				"description": "One series per result element",
+				"content":     jsonContent(g.schema(reflect.TypeOf(QueryRangeResponse{}))),
+			}, "400", "401", "403", "429", "503"),
+		}},
+		"/api/v1/stats": map[string]any{"get": map[string]any{
+			"operationId": "getStats",
+			"summary":     "Sliding-window statistics of selected fields",
+			"description": "Count, average, minimum, maximum, standard deviation and estimated p50/p90/p99 of each configured field over each window, e.g. the last 1, 5 and 15 minutes. Windows slide in steps of a twelfth of the shortest window.",
+			"parameters": []any{
+				queryParam("fields", "Comma-separated fields or sections to return (default: all configured)", stringSchema),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "Statistics by field key and window",
+				"content":     jsonContent(g.schema(reflect.TypeOf(StatsResponse{}))),
+			}, "400", "401", "403", "404", "429"),
+		}},
+		"/api/v1/anomalies": map[string]any{"get": map[string]any{
+			"operationId": "listAnomalies",
+			"summary":     "Detected anomalies, newest first",
+			"description": "An anomaly lasts from the first value of a field whose score, its distance from the field's baseline in standard deviations, reaches the threshold to the first value below it again. Ongoing anomalies have no end.",
+			"parameters": []any{
+				queryParam("fields", "Comma-separated fields or sections to return anomalies of (default: all)", stringSchema),
+				queryParam("since", "Only anomalies starting at or after this time, RFC3339 or Unix seconds", stringSchema),
+				queryParam("active", "Only ongoing anomalies", map[string]any{"type": "boolean", "default": false}),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "Anomalies kept in memory",
+				"content":     jsonContent(g.schema(reflect.TypeOf(AnomaliesResponse{}))),
+			}, "400", "401", "403", "404", "429"),
+		}},
+		"/api/v1/forecasts": map[string]any{"get": map[string]any{
+			"operationId": "listForecasts",
+			"summary":     "Predicted time until usage fields reach 100%",
+			"description": "A line is fitted through each configured percentage field's rollup history, by least squares or Theil-Sen, and extrapolated. The seconds until full are unset when the field isn't growing; the lower and upper bounds come from the 90% confidence interval of the slope.",
+			"parameters": []any{
+				queryParam("fields", "Comma-separated fields or sections to return (default: all configured)", stringSchema),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "Forecasts of fields with enough history",
+				"content":     jsonContent(g.schema(reflect.TypeOf(ForecastsResponse{}))),
+			}, "400", "401", "403", "404", "429"),
+		}},
+		"/api/v1/alerts": map[string]any{"get": map[string]any{
+			"operationId": "listAlerts",
+			"summary":     "Pending and firing alerts",
+			"description": "Every element of an alert rule's result is an alert. It is pending until it has been in the result for the rule's for duration, then firing until it drops out of the result.",
+			"parameters": []any{
+				queryParam("state", "Only alerts in this state", map[string]any{"type": "string", "enum": []any{"pending", "firing"}}),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "Alerts at the last evaluation",
+				"content":     jsonContent(g.schema(reflect.TypeOf(AlertsResponse{}))),
+			}, "400", "401", "403", "429"),
+		}},
+		"/api/v1/hosts": map[string]any{"get": map[string]any{
+			"operationId": "listHosts",
+			"summary":     "Hosts known to the hub (hub mode only)",
+			"responses": withErrors(map[string]any{
+				"description": "Every host, sorted by ID",
+				"content":     jsonContent(g.schema(reflect.TypeOf(HostsResponse{}))),
+			}, "401", "403", "404", "429"),
+		}},
+		"/api/v1/hosts/{id}/latest": map[string]any{"get": map[string]any{
+			"operationId": "getHostLatestSample",
+			"summary":     "Latest sample of a host (hub mode only)",
+			"description": "With fields, data holds only the selected fields.",
+			"parameters":  []any{hostParam, fieldsParam},
+			"responses": withErrors(map[string]any{
+				"description": "The latest sample and the host",
+				"content":     jsonContent(g.schema(reflect.TypeOf(HostSampleResponse{}))),
+			}, "400", "401", "403", "404", "429", "503"),
+		}},
+		"/api/v1/hosts/{id}/history": map[string]any{"get": map[string]any{
+			"operationId": "getHostHistory",
+			"summary":     "Samples the hub keeps for a host (hub mode only)",
+			"parameters": []any{
+				hostParam,
+				queryParam("from", "RFC3339 or Unix seconds (default: an hour ago)", stringSchema),
+				queryParam("to", "RFC3339 or Unix seconds (default: now)", stringSchema),
+				queryParam("limit", "Maximum number of samples; the most recent are kept", map[string]any{"type": "integer", "minimum": 1, "maximum": 10000, "default": 1000}),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "Raw samples in the range",
+				"content":     jsonContent(history),
+			}, "400", "401", "403", "404", "429", "503"),
+		}},
+		"/api/v1/hosts/{id}/samples": map[string]any{"post": map[string]any{
+			"operationId": "pushSamples",
+			"summary":     "Push samples of a host to the hub (hub mode only, push scope)",
+			"parameters":  []any{hostParam},
+			"requestBody": map[string]any{
+				"required": true,
+				"content":  jsonContent(g.schema(reflect.TypeOf(PushRequest{}))),
+			},
+			"responses": withErrors(map[string]any{
+				"description": "How many samples were stored",
+				"content":     jsonContent(g.schema(reflect.TypeOf(PushResponse{}))),
+			}, "400", "401", "403", "404", "429", "503"),
+		}},
+		"/api/v1/fleet/summary": map[string]any{"get": map[string]any{
+			"operationId": "getFleetSummary",
+			"summary":     "Statistics of fields across hosts (hub mode only)",
+			"description": "Min, max, average and percentiles of each field over the latest samples of the selected hosts, optionally per group of host labels. Fields are keyed like cpu.per_core_percent{core=\"0\"}.",
+			"parameters": []any{
+				queryParam("fields", "Comma-separated fields or sections, e.g. cpu.overall_percent,memory (default: all)", stringSchema),
+				queryParam("group_by", "Comma-separated host label names, e.g. region,role", stringSchema),
+				labelsParam,
+				includeDownParam,
+			},
+			"responses": withErrors(map[string]any{
+				"description": "Fleet-wide and per-group statistics",
+				"content":     jsonContent(g.schema(reflect.TypeOf(FleetSummaryResponse{}))),
+			}, "400", "401", "403", "404", "429"),
+		}},
+		"/api/v1/fleet/top": map[string]any{"get": map[string]any{
+			"operationId": "getFleetTop",
+			"summary":     "Hosts with the highest or lowest value of a field (hub mode only)",
+			"parameters": []any{
+				map[string]any{"name": "field", "in": "query", "required": true, "description": "Field key, e.g. cpu.overall_percent or disk.mountpoints.used_percent{mountpoint=\"/\"}", "schema": stringSchema},
+				queryParam("k", "Number of hosts", map[string]any{"type": "integer", "minimum": 1, "maximum": 1000, "default": 10}),
+				queryParam("order", "desc for the highest values first, asc for the lowest", map[string]any{"type": "string", "enum": []any{"desc", "asc"}, "default": "desc"}),
+				labelsParam,
+				includeDownParam,
+			},
+			"responses": withErrors(map[string]any{
+				"description": "Hosts ordered by the field's value",
+				"content":     jsonContent(g.schema(reflect.TypeOf(FleetTopResponse{}))),
+			}, "400", "401", "403", "404", "429"),
+		}},
+		"/api/v1/openapi.json": map[string]any{"get": map[string]any{
+			"operationId": "getOpenAPI",
+			"summary":     "This document",
+			"security":    []any{},
+			"responses": map[string]any{"200": map[string]any{
+				"description": "The OpenAPI document",
+				"content":     jsonContent(map[string]any{"type": "object"}),
+			}},
+		}},
+		"/api/v1/schema/gometrics.proto": map[string]any{"get": map[string]any{
+			"operationId": "getProtoSchema",
+			"summary":     "Protobuf schema of the protobuf format",
+			"security":    []any{},
+			"responses": map[string]any{"200": map[string]any{
+				"description": "The .proto file",
+				"content":     map[string]any{"text/plain": map[string]any{"schema": text}},
+			}},
+		}},
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "GoMetrics API",
+			"version": APIVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"basic":  map[string]any{"type": "http", "scheme": "basic"},
+			},
+		},
+		"security": []any{
+			map[string]any{"bearer": []any{}},
+			map[string]any{"basic": []any{}},
+		},
+	}
+}
+
+// OpenAPIHandler serves the OpenAPI document
+func (h *Handlers) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(OpenAPI())
+}
+
+var stringSchema = map[string]any{"type": "string"}
+
+// queryParam describes a query parameter
+func queryParam(name, description string, schema map[string]any) map[string]any {
+	return map[string]any{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+// headerParam describes a string request header
+func headerParam(name, description string) map[string]any {
+	return map[string]any{"name": name, "in": "header", "description": description, "schema": stringSchema}
+}
+
+// jsonContent describes a JSON response body
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaGen generates JSON schemas from Go types the way encoding/json
+// encodes them. Structs become components named after the type.
+type schemaGen struct {
+	components map[string]any
+}
+
+// schema returns the schema of t, or a reference to its component
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return map[string]any{"anyOf": []any{g.schema(t.Elem()), map[string]any{"type": "null"}}}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // Placeholder for recursive types
+			g.components[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{} // Any value
+}
+
+// structSchema returns the object schema of a struct type. Fields without
+// omitempty are required.
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := []any{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
@@ -0,0 +1,75 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOpenAPIDocumentUpToDate(t *testing.T) {
+	generated, err := json.MarshalIndent(OpenAPI(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated = append(generated, '\n')
+
+	committed, err := os.ReadFile("../../api/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(committed, generated) {
+		t.Error("api/openapi.json is out of date with the REST handlers; review the API change and run: go run ./cmd/openapi -w")
+	}
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	r := chi.NewRouter()
+	pass := func(next http.Handler) http.Handler { return next }
+	NewHandlers(nil, nil).RouteV1(r, V1Middleware{Read: pass, Push: pass, HistoryLimit: pass, StreamLimit: pass}, true)
+
+	served := make(map[string]bool)
+	err := chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		served[strings.ToLower(method)+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(served) == 0 {
+		t.Fatal("no routes registered")
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range OpenAPI()["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			documented[method+" "+path] = true
+		}
+	}
+
+	for _, route := range sortedKeys(served) {
+		if !documented[route] {
+			t.Errorf("%s is served but missing from the OpenAPI document", route)
+		}
+	}
+	for _, route := range sortedKeys(documented) {
+		if !served[route] {
+			t.Errorf("%s is documented but not served", route)
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in order, for stable test output
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
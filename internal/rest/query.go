@@ -0,0 +1,178 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/query"
+)
+
+// QueryResponse is the body of /api/v1/query
+type QueryResponse struct {
+	Data query.Result `json:"data"`
+	Meta QueryMeta    `json:"meta"`
+}
+
+// QueryMeta describes an instant query
+type QueryMeta struct {
+	Query string    `json:"query"`
+	Time  time.Time `json:"time"`
+}
+
+// QueryRangeResponse is the body of /api/v1/query_range
+type QueryRangeResponse struct {
+	Data []query.Series `json:"data"`
+	Meta QueryRangeMeta `json:"meta"`
+}
+
+// QueryRangeMeta describes a range query
+type QueryRangeMeta struct {
+	Query string    `json:"query"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Step  string    `json:"step"`
+}
+
+// sampleSource serves queries from the store for samples older than the
+// in-memory buffer and from the buffer for the rest
+type sampleSource struct {
+	history HistoryReader // nil when no store is configured
+	recent  *agg.Recent
+}
+
+// Scan implements query.Source
+func (s sampleSource) Scan(from, to time.Time, fn func(collect.Sample) bool) error {
+	oldest := s.recent.Oldest()
+	if s.history != nil && (oldest.IsZero() || from.Before(oldest)) {
+		end := to
+		if !oldest.IsZero() && !end.Before(oldest) {
+			end = oldest.Add(-time.Nanosecond)
+		}
+		more := true
+		err := s.history.Scan(from, end, func(sample collect.Sample) bool {
+			more = fn(sample)
+			return more
+		})
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	for _, sample := range s.recent.Since(from.Add(-time.Nanosecond)) {
+		if sample.Timestamp.After(to) || !fn(sample) {
+			break
+		}
+	}
+	return nil
+}
+
+// QueryHandler evaluates a query at one time. Query parameters are query
+// and time (default: now).
+func (h *Handlers) QueryHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseQuery(w, r)
+	if !ok {
+		return
+	}
+	t, err := parseTime(r.URL.Query().Get("time"), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid time: "+err.Error())
+		return
+	}
+
+	result, err := q.Instant(h.QuerySource(), t)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeDocument(w, formatJSON, QueryResponse{Data: result, Meta: QueryMeta{Query: r.URL.Query().Get("query"), Time: t}}, false)
+}
+
+// QueryRangeHandler evaluates a query at every step of a range. Query
+// parameters are query, start (default: an hour ago), end (default: now)
+// and step, a duration such as 15s or a number of seconds.
+func (h *Handlers) QueryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseQuery(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+	now := time.Now()
+	start, err := parseTime(params.Get("start"), now.Add(-time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid start: "+err.Error())
+		return
+	}
+	end, err := parseTime(params.Get("end"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid end: "+err.Error())
+		return
+	}
+	step, err := parseStep(params.Get("step"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid step: "+err.Error())
+		return
+	}
+
+	series, err := q.Range(h.QuerySource(), start, end, step)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	meta := QueryRangeMeta{Query: params.Get("query"), Start: start, End: end, Step: step.String()}
+	writeDocument(w, formatJSON, QueryRangeResponse{Data: series, Meta: meta}, false)
+}
+
+// QuerySource returns the samples queries, including alert rules, are
+// evaluated against
+func (h *Handlers) QuerySource() query.Source {
+	return sampleSource{history: h.history, recent: h.aggregator.GetRecent()}
+}
+
+// parseQuery parses the query parameter, writing an error if it is invalid
+func parseQuery(w http.ResponseWriter, r *http.Request) (*query.Query, bool) {
+	input := r.URL.Query().Get("query")
+	if strings.TrimSpace(input) == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "query is required")
+		return nil, false
+	}
+	q, err := query.Parse(input)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid query: "+err.Error())
+		return nil, false
+	}
+	return q, true
+}
+
+// writeQueryError writes an evaluation error; only storage failures are
+// the server's fault
+func writeQueryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, query.ErrSource) {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Error reading history")
+		return
+	}
+	writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+}
+
+// parseStep parses a duration such as "15s" or a number of seconds
+func parseStep(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, errors.New("step is required")
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		value = fmt.Sprintf("%gs", seconds)
+	}
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if step <= 0 {
+		return 0, errors.New("step must be positive")
+	}
+	return step, nil
+}
@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Middleware wraps a handler, e.g. to check credentials or limit concurrency
+type Middleware = func(http.Handler) http.Handler
+
+// V1Middleware is the middleware the caller applies to /api/v1 routes
+type V1Middleware struct {
+	Read         Middleware // Requires the read scope
+	Push         Middleware // Requires the push scope
+	HistoryLimit Middleware // Bounds concurrent history scans
+	StreamLimit  Middleware // Bounds open streams
+}
+
+// RouteV1 registers every /api/v1 route on r. Hub routes are only added
+// when hub is set. Routes are registered here rather than by the caller so
+// the OpenAPI tests see exactly the routes that are served.
+func (h *Handlers) RouteV1(r chi.Router, mw V1Middleware, hub bool) {
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(V1)
+		r.NotFound(h.NotFoundHandler)
+		r.With(mw.Read).Get("/metrics/latest", h.MetricsLatestHandler)                    // Latest sample
+		r.With(mw.Read, mw.HistoryLimit).Get("/metrics/history", h.MetricsHistoryHandler) // Stored samples
+		r.With(mw.Read, mw.StreamLimit).Get("/metrics/stream", h.MetricsStreamHandler)    // Server-Sent Events
+		r.With(mw.Read, mw.HistoryLimit).Get("/query", h.QueryHandler)                    // Expression at one time
+		r.With(mw.Read, mw.HistoryLimit).Get("/query_range", h.QueryRangeHandler)         // Expression over a range
+		r.With(mw.Read).Get("/stats", h.StatsHandler)                                     // Sliding-window statistics
+		r.With(mw.Read).Get("/anomalies", h.AnomaliesHandler)                             // Detected anomalies
+		r.With(mw.Read).Get("/forecasts", h.ForecastsHandler)                             // Time-until-full forecasts
+		r.With(mw.Read).Get("/alerts", h.AlertsHandler)                                   // Pending and firing alerts
+		r.Get("/openapi.json", h.OpenAPIHandler)                                          // API description
+		r.Get("/schema/gometrics.proto", h.ProtoSchemaHandler)                            // Protobuf schema
+
+		if hub {
+			r.With(mw.Read).Get("/hosts", h.HostsHandler)                                     // Federated hosts
+			r.With(mw.Read).Get("/hosts/{id}/latest", h.HostLatestHandler)                    // Latest sample of a host
+			r.With(mw.Read, mw.HistoryLimit).Get("/hosts/{id}/history", h.HostHistoryHandler) // Recent samples of a host
+			r.With(mw.Push).Post("/hosts/{id}/samples", h.HostPushHandler)                    // Samples pushed by an agent
+			r.With(mw.Read).Get("/fleet/summary", h.FleetSummaryHandler)                      // Statistics across hosts
+			r.With(mw.Read).Get("/fleet/top", h.FleetTopHandler)                              // Hosts with the highest values
+		}
+	})
+}
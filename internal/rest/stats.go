@@ -0,0 +1,75 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+)
+
+// StatsResponse is the body of /api/v1/stats
+type StatsResponse struct {
+	Data map[string]map[string]agg.WindowStats `json:"data"` // By field key, then by window such as "5m"
+	Meta StatsMeta                             `json:"meta"`
+}
+
+// StatsMeta describes the sliding windows
+type StatsMeta struct {
+	Time    time.Time `json:"time"`
+	Windows []string  `json:"windows"` // Shortest first
+}
+
+// StatsHandler returns sliding-window statistics of the configured
+// fields. fields narrows them down to some fields or sections.
+func (h *Handlers) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	windows := h.aggregator.GetWindows()
+	if windows == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Sliding-window statistics are not enabled")
+		return
+	}
+
+	var fields []string
+	for _, field := range splitList(r.URL.Query().Get("fields")) {
+		if err := checkFieldKey(field); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+			return
+		}
+		fields = append(fields, field)
+	}
+
+	now := time.Now()
+	data := make(map[string]map[string]agg.WindowStats)
+	for key, byWindow := range windows.Stats(now) {
+		if !selectsKey(fields, key) {
+			continue
+		}
+		stats := make(map[string]agg.WindowStats, len(byWindow))
+		for window, s := range byWindow {
+			stats[agg.FormatWindow(window)] = s
+		}
+		data[key] = stats
+	}
+
+	meta := StatsMeta{Time: now}
+	for _, window := range windows.Windows() {
+		meta.Windows = append(meta.Windows, agg.FormatWindow(window))
+	}
+	writeDocument(w, formatJSON, StatsResponse{Data: data, Meta: meta}, false)
+}
+
+// selectsKey reports whether a field key such as `cpu.per_core_percent{core="0"}`
+// is named by fields, by its key, its name or a section above it. No fields
+// selects every key.
+func selectsKey(fields []string, key string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	name, _, _ := strings.Cut(key, "{")
+	for _, field := range fields {
+		if key == field || name == field || strings.HasPrefix(name, field+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+const (
+	// heartbeatInterval is how often an idle stream sends a comment, well
+	// below the idle timeouts of common proxies
+	heartbeatInterval = 15 * time.Second
+
+	// streamWriteTimeout bounds each write, so a client that stops reading
+	// is disconnected instead of holding the stream open
+	streamWriteTimeout = 10 * time.Second
+
+	// streamBuffer is how many samples a client may fall behind before it
+	// is disconnected; it resumes with Last-Event-ID when it reconnects
+	streamBuffer = 64
+
+	// maxResumeSamples limits how many missed samples are replayed
+	maxResumeSamples = 10000
+
+	// retryMillis is the reconnection delay suggested to EventSource clients
+	retryMillis = 2000
+)
+
+// MetricsStreamHandler streams samples as Server-Sent Events. Each event
+// has type "sample", the sample timestamp in Unix nanoseconds as its ID and
+// the sample as JSON data. Query parameters:
+//
+//	fields         sections and fields to include, e.g. cpu.overall_percent,memory
+//	last_event_id  resume after this event (same as the Last-Event-ID header)
+//
+// On resume, missed samples are replayed from the in-memory buffer or, for
+// older ones, from the store.
+func (h *Handlers) MetricsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	var after time.Time
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" {
+		ns, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidParameter, "Invalid Last-Event-ID: "+lastID)
+			return
+		}
+		after = time.Unix(0, ns)
+	}
+
+	// Subscribe before reading the backlog so no sample falls in between
+	recent := h.aggregator.GetRecent()
+	sub := recent.Subscribe(streamBuffer)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+
+	var last time.Time // Timestamp of the last sample sent
+	send := func(sample collect.Sample) bool {
+		if !sample.Timestamp.After(last) {
+			return true // Already sent from the backlog
+		}
+		last = sample.Timestamp
+
+		data, err := selectFields(sample, fields)
+		if err != nil {
+			return false
+		}
+		body, err := json.Marshal(data)
+		if err != nil {
+			return false
+		}
+		return h.writeEvent(w, rc, fmt.Sprintf("id: %d\nevent: sample\ndata: %s\n\n", sample.Timestamp.UnixNano(), body))
+	}
+
+	if !h.writeEvent(w, rc, fmt.Sprintf("retry: %d\n\n", retryMillis)) {
+		return
+	}
+
+	// Replay what the client missed
+	if !after.IsZero() {
+		for _, sample := range h.missed(after, recent.Oldest()) {
+			if !send(sample) {
+				return
+			}
+		}
+		for _, sample := range recent.Since(after) {
+			if !send(sample) {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.closing:
+			return // Server shutting down; the client reconnects elsewhere
+		case sample, ok := <-sub.C:
+			if !ok {
+				return // Fell behind; the client resumes with Last-Event-ID
+			}
+			if !send(sample) {
+				return
+			}
+		case <-heartbeat.C:
+			if !h.writeEvent(w, rc, ": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// missed returns stored samples after t and before the oldest buffered
+// sample, if a store is configured
+func (h *Handlers) missed(t, oldest time.Time) []collect.Sample {
+	if h.history == nil || !t.Before(oldest) {
+		return nil
+	}
+
+	var samples []collect.Sample
+	err := h.history.Scan(t.Add(time.Nanosecond), oldest.Add(-time.Nanosecond), func(sample collect.Sample) bool {
+		samples = append(samples, sample)
+		return len(samples) < maxResumeSamples
+	})
+	if err != nil {
+		return nil // Resume from the buffer only
+	}
+	return samples
+}
+
+// writeEvent writes and flushes one event within streamWriteTimeout.
+// Returns false if the client is gone.
+func (h *Handlers) writeEvent(w http.ResponseWriter, rc *http.ResponseController, event string) bool {
+	rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if _, err := w.Write([]byte(event)); err != nil {
+		return false
+	}
+	return rc.Flush() == nil
+}
+
+// CloseStreams ends every open stream. Register it with
+// http.Server.RegisterOnShutdown, since Shutdown does not wait for
+// long-lived responses to end on their own.
+func (h *Handlers) CloseStreams() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
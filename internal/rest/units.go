@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// byteUnit scales byte counts for display
+type byteUnit struct {
+	name  string  // Replaces "bytes" in field names, e.g. "mib"
+	scale float64 // Bytes per unit
+}
+
+// byteUnits are the accepted values of the units query parameter
+var byteUnits = map[string]byteUnit{
+	"kib": {"kib", 1 << 10},
+	"mib": {"mib", 1 << 20},
+	"gib": {"gib", 1 << 30},
+}
+
+// parseUnits parses the units query parameter. "" and "bytes" leave
+// values unchanged and return nil.
+func parseUnits(value string) (*byteUnit, error) {
+	value = strings.ToLower(value)
+	if value == "" || value == "bytes" {
+		return nil, nil
+	}
+	unit, ok := byteUnits[value]
+	if !ok {
+		return nil, fmt.Errorf("unknown units %q (want bytes, kib, mib or gib)", value)
+	}
+	return &unit, nil
+}
+
+// scaleBytes converts every byte count in a generic JSON value to unit.
+// Byte fields are recognized by name ("total_bytes", "bytes_sent") and
+// renamed to match ("total_mib", "mib_sent").
+func scaleBytes(v any, unit *byteUnit) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for name, value := range v {
+			if renamed, ok := unit.rename(name); ok {
+				if n, ok := value.(json.Number); ok {
+					if f, err := n.Float64(); err == nil {
+						out[renamed] = f / unit.scale
+						continue
+					}
+				}
+			}
+			out[name] = scaleBytes(value, unit)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			out[i] = scaleBytes(elem, unit)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// rename returns the name of a byte field in this unit
+func (u *byteUnit) rename(name string) (string, bool) {
+	switch {
+	case strings.HasSuffix(name, "_bytes"):
+		return strings.TrimSuffix(name, "bytes") + u.name, true
+	case strings.HasPrefix(name, "bytes_"):
+		return u.name + strings.TrimPrefix(name, "bytes"), true
+	}
+	return "", false
+}
+
+// renameLast renames the last part of a dotted path if it is a byte field
+func (u *byteUnit) renameLast(path string) (string, bool) {
+	i := strings.LastIndexByte(path, '.')
+	name, ok := u.rename(path[i+1:])
+	if !ok {
+		return "", false
+	}
+	return path[:i+1] + name, true
+}
@@ -0,0 +1,270 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// warnings rate limits send errors, which repeat on every sample while
+// the StatsD server is unreachable
+var warnings = logging.NewLimited(logging.For("statsd"), time.Minute)
+
+// DefaultMaxPacketSize fits a StatsD packet into a standard 1500 byte
+// Ethernet MTU after IP and UDP headers
+const DefaultMaxPacketSize = 1432
+
+// sendErrorsTotal counts failed packet writes across all emitters
+var sendErrorsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "gometrics_statsd_send_errors_total",
+		Help: "Total StatsD packets that failed to send",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(sendErrorsTotal)
+}
+
+// Config holds StatsD emitter settings
+type Config struct {
+	Address       string   // "udp://host:port", "unixgram:///path" or plain "host:port"
+	Prefix        string   // Prepended to every metric name, e.g. "gometrics"
+	DogStatsD     bool     // Use DogStatsD tags instead of encoding labels in names
+	Tags          []string // Constant tags added to every metric ("key:value")
+	MaxPacketSize int      // Upper bound for a single datagram in bytes
+}
+
+// Emitter sends every sample to a StatsD server as gauges
+type Emitter struct {
+	cfg  Config
+	conn net.Conn
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	// Errors seen while sending the current flush
+	flushErrors int
+}
+
+// NewEmitter creates a new StatsD emitter and opens its datagram socket
+func NewEmitter(cfg Config) (*Emitter, error) {
+	network, address, err := parseAddress(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: dial %s %s: %w", network, address, err)
+	}
+
+	if cfg.MaxPacketSize <= 0 {
+		cfg.MaxPacketSize = DefaultMaxPacketSize
+	}
+
+	return &Emitter{
+		cfg:  cfg,
+		conn: conn,
+	}, nil
+}
+
+// parseAddress splits an address into the network and address for net.Dial
+func parseAddress(addr string) (string, string, error) {
+	switch {
+	case addr == "":
+		return "", "", fmt.Errorf("statsd: empty address")
+	case strings.HasPrefix(addr, "udp://"):
+		return "udp", strings.TrimPrefix(addr, "udp://"), nil
+	case strings.HasPrefix(addr, "unixgram://"):
+		return "unixgram", strings.TrimPrefix(addr, "unixgram://"), nil
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("statsd: unsupported address scheme in %q", addr)
+	default:
+		return "udp", addr, nil
+	}
+}
+
+// Close closes the underlying socket
+func (e *Emitter) Close() error {
+	return e.conn.Close()
+}
+
+// Name identifies the StatsD sink
+func (e *Emitter) Name() string {
+	return "statsd"
+}
+
+// UpdateFromSample sends every field of the sample as a gauge
+func (e *Emitter) UpdateFromSample(sample collect.Sample) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.flushErrors = 0
+
+	// CPU metrics
+	if cpu := sample.CPU; cpu != nil {
+		e.gauge("cpu.overall_percent", cpu.OverallPercent)
+		for i, corePercent := range cpu.PerCorePercent {
+			e.labeledGauge("cpu.core_percent", "core", strconv.Itoa(i), corePercent)
+		}
+		if len(cpu.LoadAverage) >= 3 {
+			e.gauge("cpu.load_average.1m", cpu.LoadAverage[0])
+			e.gauge("cpu.load_average.5m", cpu.LoadAverage[1])
+			e.gauge("cpu.load_average.15m", cpu.LoadAverage[2])
+		}
+	}
+
+	// Memory metrics
+	if mem := sample.Memory; mem != nil {
+		e.gauge("memory.total_bytes", float64(mem.TotalBytes))
+		e.gauge("memory.available_bytes", float64(mem.AvailableBytes))
+		e.gauge("memory.used_bytes", float64(mem.UsedBytes))
+		e.gauge("memory.used_percent", mem.UsedPercent)
+		e.gauge("memory.swap_total_bytes", float64(mem.SwapTotalBytes))
+		e.gauge("memory.swap_used_bytes", float64(mem.SwapUsedBytes))
+		e.gauge("memory.swap_used_percent", mem.SwapUsedPercent)
+	}
+
+	// Disk usage per mountpoint
+	if disk := sample.Disk; disk != nil {
+		for _, mp := range mountpoints(disk) {
+			e.labeledGauge("disk.total_bytes", "mountpoint", mp.Mountpoint, float64(mp.TotalBytes))
+			e.labeledGauge("disk.free_bytes", "mountpoint", mp.Mountpoint, float64(mp.FreeBytes))
+			e.labeledGauge("disk.used_bytes", "mountpoint", mp.Mountpoint, float64(mp.UsedBytes))
+			e.labeledGauge("disk.used_percent", "mountpoint", mp.Mountpoint, mp.UsedPercent)
+		}
+		e.gauge("disk.read_bytes", float64(disk.ReadBytes))
+		e.gauge("disk.write_bytes", float64(disk.WriteBytes))
+		e.gauge("disk.read_ops", float64(disk.ReadOps))
+		e.gauge("disk.write_ops", float64(disk.WriteOps))
+	}
+
+	// Network metrics (aggregated across interfaces)
+	if network := sample.Network; network != nil {
+		e.gauge("network.bytes_sent", float64(network.BytesSent))
+		e.gauge("network.bytes_recv", float64(network.BytesRecv))
+		e.gauge("network.packets_sent", float64(network.PacketsSent))
+		e.gauge("network.packets_recv", float64(network.PacketsRecv))
+		e.gauge("network.errors_in", float64(network.ErrorsIn))
+		e.gauge("network.errors_out", float64(network.ErrorsOut))
+		e.gauge("network.drops_in", float64(network.DropsIn))
+		e.gauge("network.drops_out", float64(network.DropsOut))
+
+		// Per-interface network metrics (if enabled)
+		for _, iface := range network.Interfaces {
+			e.labeledGauge("network.bytes_sent", "interface", iface.Interface, float64(iface.BytesSent))
+			e.labeledGauge("network.bytes_recv", "interface", iface.Interface, float64(iface.BytesRecv))
+			e.labeledGauge("network.packets_sent", "interface", iface.Interface, float64(iface.PacketsSent))
+			e.labeledGauge("network.packets_recv", "interface", iface.Interface, float64(iface.PacketsRecv))
+			e.labeledGauge("network.errors_in", "interface", iface.Interface, float64(iface.ErrorsIn))
+			e.labeledGauge("network.errors_out", "interface", iface.Interface, float64(iface.ErrorsOut))
+			e.labeledGauge("network.drops_in", "interface", iface.Interface, float64(iface.DropsIn))
+			e.labeledGauge("network.drops_out", "interface", iface.Interface, float64(iface.DropsOut))
+		}
+	}
+
+	e.flush()
+
+	if e.flushErrors > 0 {
+		warnings.Warn("StatsD flush had send errors", "errors", e.flushErrors)
+	}
+}
+
+// mountpoints returns per-mountpoint usage, falling back to the top-level
+// fields for the root filesystem in samples without a mountpoint list
+func mountpoints(d *collect.DiskMetric) []collect.MountpointUsage {
+	if len(d.Mountpoints) > 0 {
+		return d.Mountpoints
+	}
+	return []collect.MountpointUsage{{
+		Mountpoint:  "/",
+		TotalBytes:  d.TotalBytes,
+		FreeBytes:   d.FreeBytes,
+		UsedBytes:   d.UsedBytes,
+		UsedPercent: d.UsedPercent,
+	}}
+}
+
+// gauge queues a gauge without labels
+func (e *Emitter) gauge(name string, value float64) {
+	e.write(e.line(name, value, nil))
+}
+
+// labeledGauge queues a gauge with a single label. DogStatsD receives the
+// label as a tag, plain StatsD gets it folded into the metric name.
+func (e *Emitter) labeledGauge(name, key, value string, v float64) {
+	if e.cfg.DogStatsD {
+		e.write(e.line(name, v, []string{key + ":" + value}))
+		return
+	}
+
+	// "cpu.core_percent" + core 3 -> "cpu.core_percent.core_3"
+	e.write(e.line(name+"."+key+"_"+sanitize(value), v, nil))
+}
+
+// line formats a single StatsD gauge line
+func (e *Emitter) line(name string, value float64, tags []string) []byte {
+	var b []byte
+	if e.cfg.Prefix != "" {
+		b = append(b, e.cfg.Prefix...)
+		b = append(b, '.')
+	}
+	b = append(b, name...)
+	b = append(b, ':')
+	b = strconv.AppendFloat(b, value, 'f', -1, 64)
+	b = append(b, "|g"...)
+
+	if e.cfg.DogStatsD && (len(tags) > 0 || len(e.cfg.Tags) > 0) {
+		b = append(b, "|#"...)
+		b = append(b, strings.Join(append(tags, e.cfg.Tags...), ",")...)
+	}
+	return b
+}
+
+// write appends a line to the packet buffer, sending the buffer first if
+// the line would push it over the maximum packet size
+func (e *Emitter) write(line []byte) {
+	if e.buf.Len() > 0 && e.buf.Len()+1+len(line) > e.cfg.MaxPacketSize {
+		e.flush()
+	}
+	if e.buf.Len() > 0 {
+		e.buf.WriteByte('\n')
+	}
+	e.buf.Write(line)
+}
+
+// flush sends the buffered packet
+func (e *Emitter) flush() {
+	if e.buf.Len() == 0 {
+		return
+	}
+	if _, err := e.conn.Write(e.buf.Bytes()); err != nil {
+		e.flushErrors++
+		sendErrorsTotal.Inc()
+	}
+	e.buf.Reset()
+}
+
+// sanitize replaces characters that have meaning in the StatsD protocol
+// or in dotted metric names
+func sanitize(s string) string {
+	if s == "/" {
+		return "root"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '.', '/', ' ':
+			return '_'
+		}
+		return r
+	}, strings.Trim(s, "/"))
+}
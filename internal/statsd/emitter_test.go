@@ -0,0 +1,182 @@
+package statsd
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// listen opens a local UDP socket and returns it and its address
+func listen(t *testing.T) (net.PacketConn, string) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc, "udp://" + pc.LocalAddr().String()
+}
+
+// packets reads datagrams until none arrives for a moment
+func packets(t *testing.T, pc net.PacketConn) []string {
+	t.Helper()
+	var got []string
+	buf := make([]byte, 65536)
+	for {
+		pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return got
+		}
+		got = append(got, string(buf[:n]))
+	}
+}
+
+// lines returns the lines of every packet
+func lines(packets []string) []string {
+	var all []string
+	for _, p := range packets {
+		all = append(all, strings.Split(p, "\n")...)
+	}
+	return all
+}
+
+func newEmitter(t *testing.T, cfg Config) *Emitter {
+	t.Helper()
+	e, err := NewEmitter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestFormat(t *testing.T) {
+	sample := collect.Sample{
+		Timestamp: time.Unix(1700000000, 0),
+		CPU:       &collect.CPUMetric{OverallPercent: 12.5, PerCorePercent: []float64{1, 2, 3, 4.25}},
+		Disk: &collect.DiskMetric{Mountpoints: []collect.MountpointUsage{
+			{Mountpoint: "/", UsedPercent: 40},
+			{Mountpoint: "/var/log", UsedPercent: 60},
+		}},
+	}
+
+	tests := []struct {
+		name      string
+		dogstatsd bool
+		tags      []string
+		want      []string
+	}{
+		{"plain", false, []string{"env:prod"}, []string{
+			"gm.cpu.overall_percent:12.5|g",
+			"gm.cpu.core_percent.core_3:4.25|g",
+			"gm.disk.used_percent.mountpoint_root:40|g",
+			"gm.disk.used_percent.mountpoint_var_log:60|g",
+		}},
+		{"dogstatsd", true, []string{"env:prod"}, []string{
+			"gm.cpu.overall_percent:12.5|g|#env:prod",
+			"gm.cpu.core_percent:4.25|g|#core:3,env:prod",
+			"gm.disk.used_percent:40|g|#mountpoint:/,env:prod",
+			"gm.disk.used_percent:60|g|#mountpoint:/var/log,env:prod",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc, addr := listen(t)
+			e := newEmitter(t, Config{Address: addr, Prefix: "gm", DogStatsD: tt.dogstatsd, Tags: tt.tags})
+			e.UpdateFromSample(sample)
+
+			got := lines(packets(t, pc))
+			if !tt.dogstatsd && strings.Contains(strings.Join(got, "\n"), "|#") {
+				t.Error("plain StatsD got tags")
+			}
+			for _, want := range tt.want {
+				found := false
+				for _, line := range got {
+					found = found || line == want
+				}
+				if !found {
+					t.Errorf("line %q not sent; got:\n%s", want, strings.Join(got, "\n"))
+				}
+			}
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/", "root"},
+		{"/var/log", "var_log"},
+		{"/mnt/my disk/", "mnt_my_disk"},
+		{"eth0.100", "eth0_100"},
+		{"a:b|c@d#e,f", "a_b_c_d_e_f"},
+		{"3", "3"},
+	}
+	for _, tt := range tests {
+		if got := sanitize(tt.in); got != tt.want {
+			t.Errorf("sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPacketSize(t *testing.T) {
+	sample := collect.Sample{
+		Timestamp: time.Unix(1700000000, 0),
+		CPU:       &collect.CPUMetric{OverallPercent: 12.5, PerCorePercent: make([]float64, 32)},
+		Memory:    &collect.MemoryMetric{TotalBytes: 1 << 34, UsedPercent: 50},
+	}
+
+	// Everything in one packet when it fits, split when it doesn't
+	pc, addr := listen(t)
+	newEmitter(t, Config{Address: addr, Prefix: "gometrics"}).UpdateFromSample(sample)
+	whole := packets(t, pc)
+
+	for _, size := range []int{DefaultMaxPacketSize, 200, 64} {
+		pc, addr := listen(t)
+		newEmitter(t, Config{Address: addr, Prefix: "gometrics", MaxPacketSize: size}).UpdateFromSample(sample)
+		got := packets(t, pc)
+
+		for _, p := range got {
+			if len(p) > size {
+				t.Errorf("max %d: sent a %d byte packet", size, len(p))
+			}
+		}
+		if !reflect.DeepEqual(lines(got), lines(whole)) {
+			t.Errorf("max %d: lines differ from an unsplit send", size)
+		}
+		if size < len(whole[0]) && len(got) < 2 {
+			t.Errorf("max %d: sent %d packets, want a split", size, len(got))
+		}
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statsd.sock")
+	pc, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	e := newEmitter(t, Config{Address: "unixgram://" + path})
+
+	sample := collect.Sample{Timestamp: time.Unix(1700000000, 0), Memory: &collect.MemoryMetric{UsedPercent: 50}}
+	before := testutil.ToFloat64(sendErrorsTotal)
+	e.UpdateFromSample(sample)
+	if got := testutil.ToFloat64(sendErrorsTotal) - before; got != 0 {
+		t.Fatalf("%v send errors while the server listens", got)
+	}
+
+	// The server goes away: its socket rejects writes
+	pc.Close()
+	e.UpdateFromSample(sample)
+	if got := testutil.ToFloat64(sendErrorsTotal) - before; got != 1 {
+		t.Errorf("%v send errors, want 1", got)
+	}
+}
@@ -0,0 +1,413 @@
+// Package store persists samples in an append-only, segment-based log on
+// local disk so history survives restarts and crashes.
+//
+// Each segment file is named after the Unix nanosecond timestamp of its first
+// sample and holds a sequence of records:
+//
+//	[uint32 payload length][uint32 CRC-32C of payload][payload]
+//
+// where the payload is a codec-encoded sample. Only the newest segment is
+// ever written to. A crash can leave a partial record at its tail, which
+// Open detects and truncates away.
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/codec"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+	"github.com/dirshaye/GoMetrics/internal/logging"
+)
+
+var (
+	logger = logging.For("store")
+
+	// warnings rate limits append errors, e.g. while the disk is full
+	warnings = logging.NewLimited(logger, time.Minute)
+)
+
+const (
+	segmentExt   = ".seg"
+	headerSize   = 8
+	maxRecordLen = 1 << 20 // Anything larger is treated as corruption
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Config holds store settings
+type Config struct {
+	Dir             string        // Directory holding segment files
+	SegmentDuration time.Duration // Start a new segment after this much time
+	SegmentBytes    int64         // ... or once the current segment reaches this size
+	Retention       time.Duration // Delete segments whose newest sample is older than this (0 = keep)
+	MaxBytes        int64         // Delete oldest segments while the store is larger than this (0 = unlimited)
+	SyncInterval    time.Duration // How often appended data is fsynced
+}
+
+// segment describes one segment file
+type segment struct {
+	path  string
+	first time.Time // Timestamp in the file name
+	last  time.Time // Newest sample in the segment
+	size  int64
+}
+
+// segmentFile is the open active segment (an interface so tests can make
+// writes fail)
+type segmentFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+	Truncate(size int64) error
+}
+
+// Store is an append-only on-disk sample store
+type Store struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	segments []*segment // Sorted oldest first; the last one is active
+	active   segmentFile
+	lastSync time.Time
+	buf      []byte
+	closed   bool
+}
+
+// Open opens (or creates) a store in cfg.Dir, recovering from any partial
+// write left by a crash
+func Open(cfg Config) (*Store, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("store: directory is required")
+	}
+	if cfg.SegmentDuration <= 0 {
+		cfg.SegmentDuration = time.Hour
+	}
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = 64 << 20
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = time.Second
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+
+	s := &Store{cfg: cfg}
+	if err := s.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	// Reopen the newest segment for appending
+	if n := len(s.segments); n > 0 {
+		f, err := os.OpenFile(s.segments[n-1].path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		s.active = f
+	}
+
+	s.applyRetention(time.Now())
+
+	logger.Info("Store opened", "dir", cfg.Dir, "segments", len(s.segments))
+	return s, nil
+}
+
+// loadSegments scans the directory, building the segment index and
+// truncating a torn record at the end of the newest segment
+func (s *Store) loadSegments() error {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		ns, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue // Not one of ours
+		}
+		s.segments = append(s.segments, &segment{
+			path:  filepath.Join(s.cfg.Dir, name),
+			first: time.Unix(0, ns),
+		})
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].first.Before(s.segments[j].first)
+	})
+
+	for i, seg := range s.segments {
+		validSize, last, err := scanSegment(seg.path, nil)
+		if err != nil {
+			return err
+		}
+		seg.last = last
+		seg.size = validSize
+
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+
+		// Only the newest segment can have a torn tail from a crash
+		if i == len(s.segments)-1 && info.Size() > validSize {
+			logger.Warn("Truncating partial data left by a crash",
+				"bytes", info.Size()-validSize, "segment", filepath.Base(seg.path))
+			if err := os.Truncate(seg.path, validSize); err != nil {
+				return fmt.Errorf("store: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// scanSegment reads records from a segment until EOF or the first invalid
+// record. It returns the size of the valid prefix and the newest timestamp,
+// calling fn (if non-nil) for each decoded sample until fn returns false.
+func scanSegment(path string, fn func(collect.Sample) bool) (int64, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("store: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		offset int64
+		last   time.Time
+		header [headerSize]byte
+		buf    []byte
+	)
+	for {
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			return offset, last, nil // EOF or torn header
+		}
+		n := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		if n == 0 || n > maxRecordLen {
+			return offset, last, nil
+		}
+
+		if cap(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return offset, last, nil // Torn payload
+		}
+		if crc32.Checksum(buf, crcTable) != sum {
+			return offset, last, nil
+		}
+
+		sample, err := codec.UnmarshalSample(buf)
+		if err != nil {
+			return offset, last, nil
+		}
+
+		offset += headerSize + int64(n)
+		last = sample.Timestamp
+		if fn != nil && !fn(sample) {
+			return offset, last, nil
+		}
+	}
+}
+
+// Name identifies the store sink
+func (s *Store) Name() string {
+	return "store"
+}
+
+// UpdateFromSample appends a sample, logging any error
+func (s *Store) UpdateFromSample(sample collect.Sample) {
+	if err := s.Append(sample); err != nil {
+		warnings.Error("Error appending sample to store", "error", err)
+	}
+}
+
+// Append writes a sample to the active segment, rolling over to a new
+// segment when the current one is old or large enough
+func (s *Store) Append(sample collect.Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("store: closed")
+	}
+	if err := s.maybeRollover(sample.Timestamp); err != nil {
+		return err
+	}
+
+	// Build header and payload in one buffer so each record is a single write
+	s.buf = append(s.buf[:0], make([]byte, headerSize)...)
+	s.buf = codec.AppendSample(s.buf, sample)
+	payload := s.buf[headerSize:]
+	binary.LittleEndian.PutUint32(s.buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(s.buf[4:8], crc32.Checksum(payload, crcTable))
+
+	seg := s.segments[len(s.segments)-1]
+	if _, err := s.active.Write(s.buf); err != nil {
+		s.rollback(seg)
+		return fmt.Errorf("store: %w", err)
+	}
+
+	seg.size += int64(len(s.buf))
+	seg.last = sample.Timestamp
+
+	if time.Since(s.lastSync) >= s.cfg.SyncInterval {
+		s.lastSync = time.Now()
+		if err := s.active.Sync(); err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+	}
+	return nil
+}
+
+// rollback removes a partially written record (e.g. after ENOSPC) from the
+// end of the active segment, so later appends don't land behind torn bytes
+// that would hide them from scans. If that fails too, the segment is closed
+// and the next append starts a new one. Caller holds s.mu.
+func (s *Store) rollback(seg *segment) {
+	// The file is opened with O_APPEND, so writes follow the truncated end
+	err := s.active.Truncate(seg.size)
+	if err == nil {
+		return
+	}
+
+	logger.Error("Error removing partial record; starting a new segment",
+		"segment", filepath.Base(seg.path), "error", err)
+	s.active.Close()
+	s.active = nil
+}
+
+// maybeRollover starts a new segment if there is none yet or the active
+// one has reached its age or size limit. Caller holds s.mu.
+func (s *Store) maybeRollover(ts time.Time) error {
+	if s.active != nil {
+		seg := s.segments[len(s.segments)-1]
+		if ts.Sub(seg.first) < s.cfg.SegmentDuration && seg.size < s.cfg.SegmentBytes {
+			return nil
+		}
+		s.active.Sync()
+		s.active.Close()
+		s.active = nil
+	}
+
+	path := filepath.Join(s.cfg.Dir, fmt.Sprintf("%020d%s", ts.UnixNano(), segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	s.active = f
+	s.segments = append(s.segments, &segment{path: path, first: ts})
+
+	s.applyRetention(ts)
+	return nil
+}
+
+// applyRetention deletes the oldest segments that fall outside the age or
+// size limits. The active segment is never deleted. Caller holds s.mu.
+func (s *Store) applyRetention(now time.Time) {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		tooOld := s.cfg.Retention > 0 && now.Sub(oldest.last) > s.cfg.Retention
+		tooBig := s.cfg.MaxBytes > 0 && total > s.cfg.MaxBytes
+		if !tooOld && !tooBig {
+			return
+		}
+
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			logger.Error("Error removing segment", "segment", oldest.path, "error", err)
+			return
+		}
+		total -= oldest.size
+		s.segments = s.segments[1:]
+	}
+}
+
+// Scan calls fn for every stored sample with from <= timestamp <= to, in
+// time order, until fn returns false
+func (s *Store) Scan(from, to time.Time, fn func(collect.Sample) bool) error {
+	// Snapshot the segments that overlap the range
+	s.mu.RLock()
+	var paths []string
+	for i, seg := range s.segments {
+		// A segment ends where the next one begins
+		end := seg.last
+		if i+1 < len(s.segments) {
+			end = s.segments[i+1].first
+		}
+		if seg.first.After(to) || (!end.IsZero() && end.Before(from)) {
+			continue
+		}
+		paths = append(paths, seg.path)
+	}
+	s.mu.RUnlock()
+
+	done := false
+	for _, path := range paths {
+		_, _, err := scanSegment(path, func(sample collect.Sample) bool {
+			if sample.Timestamp.Before(from) {
+				return true
+			}
+			if sample.Timestamp.After(to) {
+				done = true
+				return false
+			}
+			if !fn(sample) {
+				done = true
+				return false
+			}
+			return true
+		})
+		if err != nil && !os.IsNotExist(errors.Unwrap(err)) {
+			return err // A segment deleted by retention mid-scan is fine
+		}
+		if done {
+			break
+		}
+	}
+	return nil
+}
+
+// Query returns every stored sample with from <= timestamp <= to
+func (s *Store) Query(from, to time.Time) ([]collect.Sample, error) {
+	var samples []collect.Sample
+	err := s.Scan(from, to, func(sample collect.Sample) bool {
+		samples = append(samples, sample)
+		return true
+	})
+	return samples, err
+}
+
+// Close syncs and closes the active segment
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.active == nil {
+		return nil
+	}
+	s.active.Sync()
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
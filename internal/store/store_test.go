@@ -0,0 +1,288 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+var epoch = time.Unix(1700000000, 0)
+
+// sampleAt returns a small sample at epoch + offset
+func sampleAt(offset time.Duration) collect.Sample {
+	return collect.Sample{
+		Timestamp: epoch.Add(offset),
+		Memory:    &collect.MemoryMetric{TotalBytes: 1 << 30, UsedPercent: float64(offset / time.Second)},
+	}
+}
+
+func openStore(t *testing.T, cfg Config) *Store {
+	t.Helper()
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func appendAll(t *testing.T, s *Store, samples ...collect.Sample) {
+	t.Helper()
+	for _, sample := range samples {
+		if err := s.Append(sample); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+}
+
+func queryAll(t *testing.T, s *Store) []collect.Sample {
+	t.Helper()
+	samples, err := s.Query(epoch.Add(-time.Hour), epoch.Add(1000*time.Hour))
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	return samples
+}
+
+// segmentFiles returns the segment files in dir, oldest first
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRoundTripAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	want := []collect.Sample{sampleAt(0), sampleAt(time.Second), sampleAt(2 * time.Second)}
+
+	s := openStore(t, Config{Dir: dir})
+	appendAll(t, s, want...)
+	s.Close()
+
+	s = openStore(t, Config{Dir: dir})
+	if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() = %+v, want %+v", got, want)
+	}
+
+	// Appends continue in the reopened segment
+	appendAll(t, s, sampleAt(3*time.Second))
+	if got := len(queryAll(t, s)); got != 4 {
+		t.Errorf("Query() returned %d samples, want 4", got)
+	}
+	if files := segmentFiles(t, dir); len(files) != 1 {
+		t.Errorf("%d segment files, want 1", len(files))
+	}
+}
+
+func TestQueryRange(t *testing.T) {
+	s := openStore(t, Config{Dir: t.TempDir()})
+	for i := 0; i < 10; i++ {
+		appendAll(t, s, sampleAt(time.Duration(i)*time.Second))
+	}
+
+	got, err := s.Query(epoch.Add(3*time.Second), epoch.Add(5*time.Second))
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	want := []collect.Sample{sampleAt(3 * time.Second), sampleAt(4 * time.Second), sampleAt(5 * time.Second)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTruncatedTailRecovered(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir})
+	appendAll(t, s, sampleAt(0), sampleAt(time.Second))
+	s.Close()
+
+	// A crash in the middle of writing the next record
+	path := segmentFiles(t, dir)[0]
+	info, _ := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{40, 0, 0, 0, 1, 2, 3, 4, 1, 2})
+	f.Close()
+
+	s = openStore(t, Config{Dir: dir})
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Errorf("segment size after recovery = %d, want %d", after.Size(), info.Size())
+	}
+	appendAll(t, s, sampleAt(2*time.Second))
+	want := []collect.Sample{sampleAt(0), sampleAt(time.Second), sampleAt(2 * time.Second)}
+	if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCRCMismatch(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir})
+	appendAll(t, s, sampleAt(0), sampleAt(time.Second), sampleAt(2*time.Second))
+	s.Close()
+
+	// Corrupt the last byte of the last record's payload
+	path := segmentFiles(t, dir)[0]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, Config{Dir: dir})
+	want := []collect.Sample{sampleAt(0), sampleAt(time.Second)}
+	if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSegmentRollover(t *testing.T) {
+	t.Run("by age", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openStore(t, Config{Dir: dir, SegmentDuration: time.Minute})
+		var want []collect.Sample
+		for i := 0; i < 6; i++ {
+			want = append(want, sampleAt(time.Duration(i)*30*time.Second))
+		}
+		appendAll(t, s, want...)
+
+		if files := segmentFiles(t, dir); len(files) != 3 {
+			t.Errorf("%d segment files, want 3", len(files))
+		}
+		if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("Query() = %+v, want %+v", got, want)
+		}
+		// A range inside the second segment
+		got, _ := s.Query(epoch.Add(70*time.Second), epoch.Add(100*time.Second))
+		if !reflect.DeepEqual(got, want[3:4]) {
+			t.Errorf("Query() = %+v, want %+v", got, want[3:4])
+		}
+	})
+
+	t.Run("by size", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openStore(t, Config{Dir: dir, SegmentBytes: 1})
+		appendAll(t, s, sampleAt(0), sampleAt(time.Second), sampleAt(2*time.Second))
+
+		if files := segmentFiles(t, dir); len(files) != 3 {
+			t.Errorf("%d segment files, want 3", len(files))
+		}
+	})
+}
+
+func TestRetention(t *testing.T) {
+	t.Run("by age", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openStore(t, Config{Dir: dir, SegmentDuration: time.Hour, Retention: 2 * time.Hour})
+		appendAll(t, s, sampleAt(0), sampleAt(time.Hour), sampleAt(2*time.Hour))
+		if files := segmentFiles(t, dir); len(files) != 3 {
+			t.Fatalf("%d segment files, want 3", len(files))
+		}
+
+		// The first segment's newest sample is now more than 2h old
+		appendAll(t, s, sampleAt(3*time.Hour))
+		want := []collect.Sample{sampleAt(time.Hour), sampleAt(2 * time.Hour), sampleAt(3 * time.Hour)}
+		if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("Query() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("by size", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openStore(t, Config{Dir: dir, SegmentBytes: 1, MaxBytes: 1})
+		appendAll(t, s, sampleAt(0), sampleAt(time.Second), sampleAt(2*time.Second))
+
+		// Only the active segment survives
+		want := []collect.Sample{sampleAt(2 * time.Second)}
+		if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("Query() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+// shortWriteFile writes only half of the next record and then fails, like
+// a write that runs out of disk space
+type shortWriteFile struct {
+	*os.File
+	fail bool
+}
+
+func (f *shortWriteFile) Write(p []byte) (int, error) {
+	if !f.fail {
+		return f.File.Write(p)
+	}
+	f.fail = false
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, syscall.ENOSPC
+}
+
+func TestFailedWriteRolledBack(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir})
+	appendAll(t, s, sampleAt(0))
+
+	s.active = &shortWriteFile{File: s.active.(*os.File), fail: true}
+	if err := s.Append(sampleAt(time.Second)); err == nil {
+		t.Fatal("Append() error = nil, want ENOSPC")
+	}
+	appendAll(t, s, sampleAt(2*time.Second))
+
+	want := []collect.Sample{sampleAt(0), sampleAt(2 * time.Second)}
+	if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() = %+v, want %+v", got, want)
+	}
+
+	// Nothing is lost across a reopen either
+	s.Close()
+	s = openStore(t, Config{Dir: dir})
+	if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() after reopen = %+v, want %+v", got, want)
+	}
+}
+
+// brokenFile fails every write and cannot be truncated
+type brokenFile struct {
+	*os.File
+}
+
+func (f brokenFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, syscall.EIO
+}
+
+func (f brokenFile) Truncate(int64) error {
+	return syscall.EIO
+}
+
+func TestFailedRollbackStartsNewSegment(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, Config{Dir: dir})
+	appendAll(t, s, sampleAt(0))
+
+	s.active = brokenFile{s.active.(*os.File)}
+	if err := s.Append(sampleAt(time.Second)); err == nil {
+		t.Fatal("Append() error = nil, want EIO")
+	}
+	appendAll(t, s, sampleAt(2*time.Second))
+
+	if files := segmentFiles(t, dir); len(files) != 2 {
+		t.Errorf("%d segment files, want 2", len(files))
+	}
+	want := []collect.Sample{sampleAt(0), sampleAt(2 * time.Second)}
+	if got := queryAll(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,228 @@
+// Package tlsconf builds the TLS configuration of the HTTP server and keeps
+// certificates current: rotated certificate, key and client CA files are
+// picked up without a restart.
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dirshaye/GoMetrics/internal/logging"
+)
+
+var logger = logging.For("tls")
+
+// DefaultReloadInterval is how often files are checked for changes
+const DefaultReloadInterval = 30 * time.Second
+
+// Client certificate modes
+const (
+	ClientAuthNone     = "none"     // Don't ask for client certificates
+	ClientAuthOptional = "optional" // Verify a certificate if the client sends one
+	ClientAuthRequire  = "require"  // Reject clients without a valid certificate
+)
+
+// Config configures TLS serving
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string   // PEM bundle used to verify client certificates
+	ClientAuth   string   // ClientAuthNone, ClientAuthOptional or ClientAuthRequire
+	MinVersion   string   // "1.2" or "1.3" (default "1.2")
+	CipherSuites []string // IANA names; only applies to TLS 1.2 (empty = Go defaults)
+}
+
+// Reloader serves the current certificate and client CA pool
+type Reloader struct {
+	cfg  Config
+	base *tls.Config
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime map[string]time.Time // Modification time of every loaded file
+}
+
+// New loads the files in cfg and checks the TLS settings
+func New(cfg Config) (*Reloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls: cert_file and key_file are required")
+	}
+
+	base := &tls.Config{}
+
+	switch cfg.MinVersion {
+	case "", "1.2":
+		base.MinVersion = tls.VersionTLS12
+	case "1.3":
+		base.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("tls: unsupported min_version %q (want 1.2 or 1.3)", cfg.MinVersion)
+	}
+
+	suites, err := cipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	base.CipherSuites = suites
+
+	switch cfg.ClientAuth {
+	case "", ClientAuthNone:
+		if cfg.ClientCAFile != "" && cfg.ClientAuth == "" {
+			base.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	case ClientAuthOptional:
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("tls: unknown client_auth %q", cfg.ClientAuth)
+	}
+	if base.ClientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, errors.New("tls: client_auth requires client_ca_file")
+	}
+
+	r := &Reloader{cfg: cfg, base: base}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// cipherSuites maps suite names to ids, refusing insecure suites
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// nextProtos are the ALPN protocols offered to clients. http.Server adds
+// "h2" only to its own config, not to the one GetConfigForClient returns,
+// so without them HTTPS never negotiates HTTP/2.
+var nextProtos = []string{"h2", "http/1.1"}
+
+// TLSConfig returns the configuration for http.Server.TLSConfig. Every
+// handshake uses the most recently loaded certificate and client CA pool.
+func (r *Reloader) TLSConfig() *tls.Config {
+	cfg := r.base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		c := r.base.Clone()
+		c.Certificates = []tls.Certificate{*r.cert}
+		c.ClientCAs = r.pool
+		c.NextProtos = nextProtos
+		return c, nil
+	}
+	return cfg
+}
+
+// Reload reads the certificate, key and client CA files again. On error the
+// previously loaded ones stay in use.
+func (r *Reloader) Reload() error {
+	modTime := make(map[string]time.Time)
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		modTime[path] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: %s: no certificates found", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// files returns every file the configuration is built from
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// changed reports whether any file was modified since it was loaded
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue // Mid-rotation; check again next time
+		}
+		if !info.ModTime().Equal(r.modTime[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the files whenever they change until ctx is cancelled
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				logger.Error("Failed to reload TLS certificates, keeping current ones", "error", err)
+				continue
+			}
+			logger.Info("Reloaded TLS certificates")
+		}
+	}
+}
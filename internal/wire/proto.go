@@ -0,0 +1,220 @@
+package wire
+
+import (
+	_ "embed"
+	"math"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/dirshaye/GoMetrics/internal/agg"
+	"github.com/dirshaye/GoMetrics/internal/collect"
+)
+
+// Schema is the Protobuf schema of the messages encoded here
+//
+//go:embed gometrics.proto
+var Schema []byte
+
+// AppendSample appends sample as a gometrics.v1.Sample message
+func AppendSample(b []byte, sample collect.Sample) []byte {
+	b = appendInt(b, 1, sample.Timestamp.UnixNano())
+	if cpu := sample.CPU; cpu != nil {
+		b = appendMessage(b, 2, func(b []byte) []byte {
+			b = appendDouble(b, 1, cpu.OverallPercent)
+			b = appendDoubles(b, 2, cpu.PerCorePercent)
+			return appendDoubles(b, 3, cpu.LoadAverage)
+		})
+	}
+	if mem := sample.Memory; mem != nil {
+		b = appendMessage(b, 3, func(b []byte) []byte {
+			b = appendUint(b, 1, mem.TotalBytes)
+			b = appendUint(b, 2, mem.AvailableBytes)
+			b = appendUint(b, 3, mem.UsedBytes)
+			b = appendDouble(b, 4, mem.UsedPercent)
+			b = appendUint(b, 5, mem.SwapTotalBytes)
+			b = appendUint(b, 6, mem.SwapUsedBytes)
+			return appendDouble(b, 7, mem.SwapUsedPercent)
+		})
+	}
+	if disk := sample.Disk; disk != nil {
+		b = appendMessage(b, 4, func(b []byte) []byte {
+			b = appendUint(b, 1, disk.TotalBytes)
+			b = appendUint(b, 2, disk.FreeBytes)
+			b = appendUint(b, 3, disk.UsedBytes)
+			b = appendDouble(b, 4, disk.UsedPercent)
+			for _, mp := range disk.Mountpoints {
+				b = appendMessage(b, 5, func(b []byte) []byte {
+					b = appendString(b, 1, mp.Mountpoint)
+					b = appendUint(b, 2, mp.TotalBytes)
+					b = appendUint(b, 3, mp.FreeBytes)
+					b = appendUint(b, 4, mp.UsedBytes)
+					return appendDouble(b, 5, mp.UsedPercent)
+				})
+			}
+			b = appendUint(b, 6, disk.ReadBytes)
+			b = appendUint(b, 7, disk.WriteBytes)
+			b = appendUint(b, 8, disk.ReadOps)
+			b = appendUint(b, 9, disk.WriteOps)
+			if rates := disk.Rates; rates != nil {
+				b = appendMessage(b, 10, func(b []byte) []byte {
+					b = appendDouble(b, 1, rates.ReadBytes)
+					b = appendDouble(b, 2, rates.WriteBytes)
+					b = appendDouble(b, 3, rates.ReadOps)
+					return appendDouble(b, 4, rates.WriteOps)
+				})
+			}
+			return b
+		})
+	}
+	if network := sample.Network; network != nil {
+		b = appendMessage(b, 5, func(b []byte) []byte {
+			b = appendUint(b, 1, network.BytesSent)
+			b = appendUint(b, 2, network.BytesRecv)
+			b = appendUint(b, 3, network.PacketsSent)
+			b = appendUint(b, 4, network.PacketsRecv)
+			b = appendUint(b, 5, network.ErrorsIn)
+			b = appendUint(b, 6, network.ErrorsOut)
+			b = appendUint(b, 7, network.DropsIn)
+			b = appendUint(b, 8, network.DropsOut)
+			for _, iface := range network.Interfaces {
+				b = appendMessage(b, 9, func(b []byte) []byte {
+					b = appendString(b, 1, iface.Interface)
+					b = appendUint(b, 2, iface.BytesSent)
+					b = appendUint(b, 3, iface.BytesRecv)
+					b = appendUint(b, 4, iface.PacketsSent)
+					b = appendUint(b, 5, iface.PacketsRecv)
+					b = appendUint(b, 6, iface.ErrorsIn)
+					b = appendUint(b, 7, iface.ErrorsOut)
+					b = appendUint(b, 8, iface.DropsIn)
+					return appendUint(b, 9, iface.DropsOut)
+				})
+			}
+			if rates := network.Rates; rates != nil {
+				b = appendMessage(b, 10, func(b []byte) []byte {
+					b = appendDouble(b, 1, rates.BytesSent)
+					b = appendDouble(b, 2, rates.BytesRecv)
+					b = appendDouble(b, 3, rates.PacketsSent)
+					b = appendDouble(b, 4, rates.PacketsRecv)
+					b = appendDouble(b, 5, rates.ErrorsIn)
+					b = appendDouble(b, 6, rates.ErrorsOut)
+					b = appendDouble(b, 7, rates.DropsIn)
+					b = appendDouble(b, 8, rates.DropsOut)
+					for _, iface := range rates.Interfaces {
+						b = appendMessage(b, 9, func(b []byte) []byte {
+							b = appendString(b, 1, iface.Interface)
+							b = appendDouble(b, 2, iface.BytesSent)
+							b = appendDouble(b, 3, iface.BytesRecv)
+							b = appendDouble(b, 4, iface.PacketsSent)
+							b = appendDouble(b, 5, iface.PacketsRecv)
+							b = appendDouble(b, 6, iface.ErrorsIn)
+							b = appendDouble(b, 7, iface.ErrorsOut)
+							b = appendDouble(b, 8, iface.DropsIn)
+							return appendDouble(b, 9, iface.DropsOut)
+						})
+					}
+					return b
+				})
+			}
+			return b
+		})
+	}
+	for _, name := range sortedKeys(sample.Status) {
+		status := sample.Status[name]
+		b = appendMapEntry(b, 6, name, func(b []byte) []byte {
+			b = appendString(b, 1, status.Status)
+			b = appendInt(b, 2, status.CollectedAt.UnixNano())
+			return appendString(b, 3, status.Message)
+		})
+	}
+	return b
+}
+
+// AppendHistory appends a gometrics.v1.History message holding either
+// samples or buckets
+func AppendHistory(b []byte, from, to time.Time, resolution string, samples []collect.Sample, buckets []agg.RollupBucket) []byte {
+	b = appendInt(b, 1, from.UnixNano())
+	b = appendInt(b, 2, to.UnixNano())
+	b = appendString(b, 3, resolution)
+	for _, sample := range samples {
+		b = appendMessage(b, 4, func(b []byte) []byte { return AppendSample(b, sample) })
+	}
+	for _, bucket := range buckets {
+		b = appendMessage(b, 5, func(b []byte) []byte {
+			b = appendInt(b, 1, bucket.Start.UnixNano())
+			for _, key := range sortedKeys(bucket.Fields) {
+				stats := bucket.Fields[key]
+				b = appendMapEntry(b, 2, key, func(b []byte) []byte {
+					b = appendDouble(b, 1, stats.Min)
+					b = appendDouble(b, 2, stats.Max)
+					b = appendDouble(b, 3, stats.Avg)
+					b = appendDouble(b, 4, stats.Last)
+					b = appendDouble(b, 5, stats.P95)
+					return appendUint(b, 6, stats.Count)
+				})
+			}
+			return b
+		})
+	}
+	return b
+}
+
+// Scalars are written even when zero; proto3 decoders accept that
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendUint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// appendDoubles appends a packed repeated double field
+func appendDoubles(b []byte, num protowire.Number, vs []float64) []byte {
+	if len(vs) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(8*len(vs)))
+	for _, v := range vs {
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendMessage appends an embedded message written by fn
+func appendMessage(b []byte, num protowire.Number, fn func([]byte) []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, fn(nil))
+}
+
+// appendMapEntry appends one entry of a map<string, Message> field
+func appendMapEntry(b []byte, num protowire.Number, key string, value func([]byte) []byte) []byte {
+	return appendMessage(b, num, func(b []byte) []byte {
+		b = appendString(b, 1, key)
+		return appendMessage(b, 2, value)
+	})
+}
+
+// sortedKeys returns the keys of m in order, so output is deterministic
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}